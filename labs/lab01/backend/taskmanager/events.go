@@ -0,0 +1,59 @@
+package taskmanager
+
+// EventType describes what happened to a task
+type EventType string
+
+// Task event types
+const (
+	TaskCreated EventType = "created"
+	TaskUpdated EventType = "updated"
+	TaskDeleted EventType = "deleted"
+)
+
+// TaskEvent is emitted to subscribers after a task changes
+type TaskEvent struct {
+	Type EventType
+	Task Task // state after the change, or the last known state for deletions
+}
+
+// Subscribe registers a new listener for task events, buffer sets the channel capacity.
+// Events are delivered in order but never block the manager: when a subscriber's
+// buffer is full it is unsubscribed and its channel closed after the buffered events,
+// so it can subscribe again and reload the tasks instead of missing changes. The
+// returned function unsubscribes and closes the channel.
+func (tm *TaskManager) Subscribe(buffer int) (<-chan TaskEvent, func()) {
+	if buffer < 1 {
+		buffer = 1
+	}
+	ch := make(chan TaskEvent, buffer)
+
+	tm.mutex.Lock()
+	id := tm.nextSubID
+	tm.nextSubID++
+	tm.subscribers[id] = ch
+	tm.mutex.Unlock()
+
+	unsubscribe := func() {
+		tm.mutex.Lock()
+		defer tm.mutex.Unlock()
+		if sub, ok := tm.subscribers[id]; ok {
+			delete(tm.subscribers, id)
+			close(sub)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// publish sends an event to every subscriber and drops those that fell behind,
+// the caller must hold the write lock
+func (tm *TaskManager) publish(eventType EventType, task Task) {
+	event := TaskEvent{Type: eventType, Task: task}
+	for id, ch := range tm.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(tm.subscribers, id)
+			close(ch)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package taskmanager
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	tm := NewTaskManager()
+	events, unsubscribe := tm.Subscribe(10)
+
+	task, _ := tm.AddTask("Task", "Description")
+	tm.UpdateTask(task.ID, task.Version, "Updated", "Description", true)
+	tm.DeleteTask(task.ID)
+
+	expected := []EventType{TaskCreated, TaskUpdated, TaskDeleted}
+	for _, want := range expected {
+		event := <-events
+		if event.Type != want {
+			t.Errorf("Expected event %s, got %s", want, event.Type)
+		}
+		if event.Task.ID != task.ID {
+			t.Errorf("Expected task ID %d, got %d", task.ID, event.Task.ID)
+		}
+	}
+
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Error("Expected channel to be closed after unsubscribe")
+	}
+	unsubscribe()
+}
+
+func TestSubscribeSlowConsumer(t *testing.T) {
+	tm := NewTaskManager()
+	events, unsubscribe := tm.Subscribe(1)
+	defer unsubscribe()
+
+	for i := 0; i < 5; i++ {
+		if _, err := tm.AddTask("Task", ""); err != nil {
+			t.Fatalf("AddTask blocked or failed: %v", err)
+		}
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected 1 buffered event, got %d", len(events))
+	}
+	// The subscriber missed events, so its channel is closed after the buffered one
+	if event := <-events; event.Type != TaskCreated || event.Task.ID != 1 {
+		t.Errorf("Expected the first event, got %+v", event)
+	}
+	if _, ok := <-events; ok {
+		t.Error("Expected channel to be closed after missing events")
+	}
+}
+
+func TestUpdateTaskStaleVersion(t *testing.T) {
+	tm := NewTaskManager()
+	task, _ := tm.AddTask("Task", "Description")
+
+	updated, err := tm.UpdateTask(task.ID, task.Version, "Updated", "Description", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if updated.Version != task.Version+1 {
+		t.Errorf("Expected version %d, got %d", task.Version+1, updated.Version)
+	}
+
+	_, err = tm.UpdateTask(task.ID, task.Version, "Stale", "Description", false)
+	if err != ErrVersionConflict {
+		t.Errorf("Expected ErrVersionConflict, got %v", err)
+	}
+
+	_, err = tm.UpdateTask(999, 1, "Missing", "", false)
+	if err != ErrTaskNotFound {
+		t.Errorf("Expected ErrTaskNotFound, got %v", err)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	tm := NewTaskManager()
+	n := 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task, err := tm.AddTask("Task", "")
+			if err != nil {
+				t.Errorf("AddTask failed: %v", err)
+				return
+			}
+			tm.UpdateTask(task.ID, task.Version, "Task", "", true)
+			tm.ListTasks(nil)
+		}()
+	}
+	wg.Wait()
+
+	if got := len(tm.ListTasks(nil)); got != n {
+		t.Errorf("Expected %d tasks, got %d", n, got)
+	}
+}
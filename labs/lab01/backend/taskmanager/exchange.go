@@ -0,0 +1,397 @@
+package taskmanager
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is a task import/export format
+type Format string
+
+// Supported formats
+const (
+	FormatICal Format = "ical"
+	FormatCSV  Format = "csv"
+	FormatJSON Format = "json"
+)
+
+// ErrUnknownFormat is returned for formats other than the ones listed above
+var ErrUnknownFormat = errors.New("unknown task format")
+
+// ImportResult reports what an import changed
+type ImportResult struct {
+	Created int
+	Updated int
+	Skipped int // tasks that already existed with the same content
+}
+
+// taskRecord is the portable representation of a task
+type taskRecord struct {
+	UID         string    `json:"uid"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Done        bool      `json:"done"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+var csvHeader = []string{"uid", "title", "description", "done", "created_at"}
+
+const icalTimeLayout = "20060102T150405Z"
+
+// Export writes all tasks to w in the given format
+func (tm *TaskManager) Export(w io.Writer, format Format) error {
+	tasks := tm.ListTasks(nil)
+	records := make([]taskRecord, len(tasks))
+	for i, task := range tasks {
+		records[i] = taskRecord{
+			UID:         task.UID,
+			Title:       task.Title,
+			Description: task.Description,
+			Done:        task.Done,
+			CreatedAt:   task.CreatedAt,
+		}
+	}
+
+	switch format {
+	case FormatICal:
+		return writeICal(w, records)
+	case FormatCSV:
+		return writeCSV(w, records)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+// Import reads tasks from r in the given format. Tasks whose UID is already known
+// update the existing task instead of creating a duplicate. Nothing is imported
+// if any record is invalid.
+func (tm *TaskManager) Import(r io.Reader, format Format) (ImportResult, error) {
+	var records []taskRecord
+	var err error
+	switch format {
+	case FormatICal:
+		records, err = readICal(r)
+	case FormatCSV:
+		records, err = readCSV(r)
+	case FormatJSON:
+		err = json.NewDecoder(r).Decode(&records)
+	default:
+		return ImportResult{}, ErrUnknownFormat
+	}
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for i, rec := range records {
+		if rec.Title == "" {
+			return ImportResult{}, fmt.Errorf("record %d: %w", i+1, ErrEmptyTitle)
+		}
+	}
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	byUID := make(map[string]int, len(tm.tasks))
+	for id, task := range tm.tasks {
+		byUID[task.UID] = id
+	}
+
+	var result ImportResult
+	for _, rec := range records {
+		if id, ok := byUID[rec.UID]; ok && rec.UID != "" {
+			task := tm.tasks[id]
+			if task.Title == rec.Title && task.Description == rec.Description && task.Done == rec.Done {
+				result.Skipped++
+				continue
+			}
+			task.Title = rec.Title
+			task.Description = rec.Description
+			task.Done = rec.Done
+			task.Version++
+			tm.tasks[id] = task
+			tm.publish(TaskUpdated, task)
+			result.Updated++
+			continue
+		}
+
+		task := Task{
+			ID:          tm.nextID,
+			UID:         rec.UID,
+			Title:       rec.Title,
+			Description: rec.Description,
+			Done:        rec.Done,
+			CreatedAt:   rec.CreatedAt,
+			Version:     1,
+		}
+		if task.UID == "" {
+			task.UID = newUID()
+		}
+		if task.CreatedAt.IsZero() {
+			task.CreatedAt = time.Now()
+		}
+		tm.tasks[task.ID] = task
+		tm.nextID++
+		byUID[task.UID] = task.ID
+		tm.publish(TaskCreated, task)
+		result.Created++
+	}
+	return result, nil
+}
+
+func writeCSV(w io.Writer, records []taskRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		row := []string{
+			rec.UID,
+			rec.Title,
+			rec.Description,
+			strconv.FormatBool(rec.Done),
+			rec.CreatedAt.UTC().Format(time.RFC3339Nano),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func readCSV(r io.Reader) ([]taskRecord, error) {
+	cr := csv.NewReader(r)
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, errors.New("csv: missing title column")
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	records := make([]taskRecord, 0, len(rows)-1)
+	for n, row := range rows[1:] {
+		rec := taskRecord{
+			UID:         field(row, "uid"),
+			Title:       field(row, "title"),
+			Description: field(row, "description"),
+		}
+		if v := field(row, "done"); v != "" {
+			done, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("csv row %d: invalid done value %q", n+2, v)
+			}
+			rec.Done = done
+		}
+		if v := field(row, "created_at"); v != "" {
+			created, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, fmt.Errorf("csv row %d: invalid created_at value %q", n+2, v)
+			}
+			rec.CreatedAt = created
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func writeICal(w io.Writer, records []taskRecord) error {
+	bw := bufio.NewWriter(w)
+	line := func(s string) {
+		bw.WriteString(foldICalLine(s))
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//lab01//taskmanager//EN")
+	stamp := time.Now().UTC().Format(icalTimeLayout)
+	for _, rec := range records {
+		status := "NEEDS-ACTION"
+		if rec.Done {
+			status = "COMPLETED"
+		}
+		line("BEGIN:VTODO")
+		line("UID:" + escapeICalText(rec.UID))
+		line("DTSTAMP:" + stamp)
+		line("CREATED:" + rec.CreatedAt.UTC().Format(icalTimeLayout))
+		line("X-CREATED-NANO:" + strconv.FormatInt(rec.CreatedAt.UnixNano(), 10))
+		line("SUMMARY:" + escapeICalText(rec.Title))
+		if rec.Description != "" {
+			line("DESCRIPTION:" + escapeICalText(rec.Description))
+		}
+		line("STATUS:" + status)
+		line("END:VTODO")
+	}
+	line("END:VCALENDAR")
+	return bw.Flush()
+}
+
+func readICal(r io.Reader) ([]taskRecord, error) {
+	lines, err := unfoldICalLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []taskRecord
+	var current *taskRecord
+	var createdNano string
+	for _, l := range lines {
+		name, value, ok := strings.Cut(l, ":")
+		if !ok {
+			continue
+		}
+		// Drop property parameters such as DESCRIPTION;LANGUAGE=en
+		name, _, _ = strings.Cut(strings.ToUpper(name), ";")
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VTODO"):
+			current = &taskRecord{}
+			createdNano = ""
+		case name == "END" && strings.EqualFold(value, "VTODO"):
+			if current == nil {
+				return nil, errors.New("ical: END:VTODO without BEGIN")
+			}
+			current.CreatedAt = preciseCreated(current.CreatedAt, createdNano)
+			records = append(records, *current)
+			current = nil
+		case current == nil:
+			continue
+		case name == "UID":
+			current.UID = unescapeICalText(value)
+		case name == "SUMMARY":
+			current.Title = unescapeICalText(value)
+		case name == "DESCRIPTION":
+			current.Description = unescapeICalText(value)
+		case name == "STATUS":
+			current.Done = strings.EqualFold(value, "COMPLETED")
+		case name == "COMPLETED":
+			current.Done = true
+		case name == "CREATED":
+			created, err := parseICalTime(value)
+			if err != nil {
+				return nil, err
+			}
+			current.CreatedAt = created
+		case name == "X-CREATED-NANO":
+			createdNano = value
+		}
+	}
+	if current != nil {
+		return nil, errors.New("ical: unterminated VTODO")
+	}
+	return records, nil
+}
+
+// preciseCreated returns the time of X-CREATED-NANO, which Export writes
+// because CREATED has only second precision. It is ignored unless it falls
+// within the second of CREATED, e.g. when another application edited CREATED.
+func preciseCreated(created time.Time, nanos string) time.Time {
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return created
+	}
+	if precise := time.Unix(0, n).UTC(); precise.Truncate(time.Second).Equal(created) {
+		return precise
+	}
+	return created
+}
+
+// parseICalTime reads a UTC date-time, or a floating date-time or date
+// (without "Z"), which RFC 5545 defines as the same wall clock in any time
+// zone. Floating values are read in the local time zone of the server.
+func parseICalTime(value string) (time.Time, error) {
+	if t, err := time.Parse(icalTimeLayout, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"20060102T150405", "20060102"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("ical: invalid date-time %q", value)
+}
+
+// unfoldICalLines joins continuation lines (RFC 5545 section 3.1)
+func unfoldICalLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		l := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// foldICalLine splits a content line into CRLF terminated chunks of at most 75 octets
+// without breaking UTF-8 sequences
+func foldICalLine(s string) string {
+	var b strings.Builder
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		limit = 74 // the leading space counts towards the limit
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escapeICalText(s string) string {
+	return icalEscaper.Replace(s)
+}
+
+func unescapeICalText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
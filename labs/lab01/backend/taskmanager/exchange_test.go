@@ -0,0 +1,162 @@
+package taskmanager
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	formats := []Format{FormatICal, FormatCSV, FormatJSON}
+
+	for _, format := range formats {
+		t.Run(string(format), func(t *testing.T) {
+			src := NewTaskManager()
+			src.AddTask("Buy milk", "2% fat, from the store; not the kiosk\nsecond line")
+			b, _ := src.AddTask(strings.Repeat("Long title ", 12), "")
+			src.UpdateTask(b.ID, b.Version, b.Title, b.Description, true)
+
+			var buf bytes.Buffer
+			if err := src.Export(&buf, format); err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+
+			dst := NewTaskManager()
+			result, err := dst.Import(bytes.NewReader(buf.Bytes()), format)
+			if err != nil {
+				t.Fatalf("Import failed: %v", err)
+			}
+			if result.Created != 2 {
+				t.Errorf("Expected 2 created tasks, got %d", result.Created)
+			}
+
+			want := src.ListTasks(nil)
+			got := dst.ListTasks(nil)
+			if len(got) != len(want) {
+				t.Fatalf("Expected %d tasks, got %d", len(want), len(got))
+			}
+			for i := range want {
+				if got[i].UID != want[i].UID || got[i].Title != want[i].Title ||
+					got[i].Description != want[i].Description || got[i].Done != want[i].Done {
+					t.Errorf("Task mismatch:\nwant %+v\ngot  %+v", want[i], got[i])
+				}
+				if !got[i].CreatedAt.Equal(want[i].CreatedAt) {
+					t.Errorf("CreatedAt mismatch: want %v, got %v", want[i].CreatedAt, got[i].CreatedAt)
+				}
+			}
+
+			// Importing the same data again must not create duplicates
+			result, err = dst.Import(bytes.NewReader(buf.Bytes()), format)
+			if err != nil {
+				t.Fatalf("Second import failed: %v", err)
+			}
+			if result.Created != 0 || result.Skipped != 2 {
+				t.Errorf("Expected 0 created and 2 skipped, got %+v", result)
+			}
+		})
+	}
+}
+
+func TestImportUpdatesByUID(t *testing.T) {
+	tm := NewTaskManager()
+	task, _ := tm.AddTask("Old title", "")
+
+	csvData := "uid,title,done\n" + task.UID + ",New title,true\n,Fresh task,false\n"
+	result, err := tm.Import(strings.NewReader(csvData), FormatCSV)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result.Updated != 1 || result.Created != 1 {
+		t.Errorf("Expected 1 updated and 1 created, got %+v", result)
+	}
+
+	updated, _ := tm.GetTask(task.ID)
+	if updated.Title != "New title" || !updated.Done {
+		t.Errorf("Task was not updated: %+v", updated)
+	}
+}
+
+func TestImportICalFromCalendarApp(t *testing.T) {
+	data := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\nUID:event-1\r\nSUMMARY:Not a task\r\nEND:VEVENT\r\n" +
+		"BEGIN:VTODO\r\n" +
+		"UID:todo-1@example.com\r\n" +
+		"CREATED:20250102T030405Z\r\n" +
+		"SUMMARY;LANGUAGE=en:Write\\, test\r\n" +
+		"DESCRIPTION:first line\\nand a folded\r\n  continuation\r\n" +
+		"STATUS:COMPLETED\r\n" +
+		"END:VTODO\r\n" +
+		"END:VCALENDAR\r\n"
+
+	tm := NewTaskManager()
+	if _, err := tm.Import(strings.NewReader(data), FormatICal); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	tasks := tm.ListTasks(nil)
+	if len(tasks) != 1 {
+		t.Fatalf("Expected 1 task, got %d", len(tasks))
+	}
+	task := tasks[0]
+	if task.Title != "Write, test" {
+		t.Errorf("Unexpected title %q", task.Title)
+	}
+	if task.Description != "first line\nand a folded continuation" {
+		t.Errorf("Unexpected description %q", task.Description)
+	}
+	if !task.Done {
+		t.Error("Expected task to be done")
+	}
+	if want := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC); !task.CreatedAt.Equal(want) {
+		t.Errorf("Expected CreatedAt %v, got %v", want, task.CreatedAt)
+	}
+}
+
+func TestImportICalCreatedTimes(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	defer func() { time.Local = local }()
+
+	todo := func(props string) string {
+		return "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nUID:todo-1\r\nSUMMARY:Task\r\n" + props + "END:VTODO\r\nEND:VCALENDAR\r\n"
+	}
+	tests := []struct {
+		name  string
+		props string
+		want  time.Time
+	}{
+		{"utc", "CREATED:20250102T030405Z\r\n", time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"floating date-time is local", "CREATED:20250102T030405\r\n", time.Date(2025, 1, 2, 1, 4, 5, 0, time.UTC)},
+		{"floating date is local midnight", "CREATED:20250102\r\n", time.Date(2025, 1, 1, 22, 0, 0, 0, time.UTC)},
+		{"nanoseconds", "X-CREATED-NANO:1735787045123456789\r\nCREATED:20250102T030405Z\r\n", time.Date(2025, 1, 2, 3, 4, 5, 123456789, time.UTC)},
+		{"nanoseconds of another second", "CREATED:20250102T030406Z\r\nX-CREATED-NANO:1735787045123456789\r\n", time.Date(2025, 1, 2, 3, 4, 6, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTaskManager()
+			if _, err := tm.Import(strings.NewReader(todo(tt.props)), FormatICal); err != nil {
+				t.Fatalf("Import failed: %v", err)
+			}
+			if got := tm.ListTasks(nil)[0].CreatedAt; !got.Equal(tt.want) {
+				t.Errorf("Expected CreatedAt %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	tm := NewTaskManager()
+
+	_, err := tm.Import(strings.NewReader(`[{"uid":"1","title":"ok"},{"uid":"2","title":""}]`), FormatJSON)
+	if err == nil {
+		t.Error("Expected error for empty title")
+	}
+	if len(tm.ListTasks(nil)) != 0 {
+		t.Error("No tasks should be imported when a record is invalid")
+	}
+
+	if _, err := tm.Import(strings.NewReader(""), Format("xml")); err != ErrUnknownFormat {
+		t.Errorf("Expected ErrUnknownFormat, got %v", err)
+	}
+}
@@ -1,63 +1,147 @@
 package taskmanager
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"sort"
+	"sync"
 	"time"
 )
 
 // Predefined errors
 var (
-	ErrTaskNotFound = errors.New("task not found")
-	ErrEmptyTitle   = errors.New("title cannot be empty")
+	ErrTaskNotFound    = errors.New("task not found")
+	ErrEmptyTitle      = errors.New("title cannot be empty")
+	ErrVersionConflict = errors.New("task was modified concurrently")
 )
 
 // Task represents a single task
 type Task struct {
 	ID          int
+	UID         string // stable identifier used for import/export
 	Title       string
 	Description string
 	Done        bool
 	CreatedAt   time.Time
+	Version     int // incremented on every successful update
 }
 
-// TaskManager manages a collection of tasks
+// TaskManager manages a collection of tasks, it is safe for concurrent use
 type TaskManager struct {
-	tasks  map[int]Task
-	nextID int
+	mutex       sync.RWMutex
+	tasks       map[int]Task
+	nextID      int
+	subscribers map[int]chan TaskEvent
+	nextSubID   int
 }
 
 // NewTaskManager creates a new task manager
 func NewTaskManager() *TaskManager {
-	// TODO: Implement this function
-	return nil
+	return &TaskManager{
+		tasks:       make(map[int]Task),
+		nextID:      1,
+		subscribers: make(map[int]chan TaskEvent),
+		nextSubID:   1,
+	}
 }
 
 // AddTask adds a new task to the manager, returns an error if the title is empty, and increments the nextID
 func (tm *TaskManager) AddTask(title, description string) (Task, error) {
-	// TODO: Implement this function
-	return Task{}, nil
+	if title == "" {
+		return Task{}, ErrEmptyTitle
+	}
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	task := Task{
+		ID:          tm.nextID,
+		UID:         newUID(),
+		Title:       title,
+		Description: description,
+		CreatedAt:   time.Now(),
+		Version:     1,
+	}
+	tm.tasks[task.ID] = task
+	tm.nextID++
+	tm.publish(TaskCreated, task)
+	return task, nil
 }
 
-// UpdateTask updates an existing task, returns an error if the title is empty or the task is not found
-func (tm *TaskManager) UpdateTask(id int, title, description string, done bool) error {
-	// TODO: Implement this function
-	return nil
+// UpdateTask updates an existing task if its current version equals version, returns
+// ErrVersionConflict if the task was changed since the caller read it, or an error if the
+// title is empty or the task is not found
+func (tm *TaskManager) UpdateTask(id, version int, title, description string, done bool) (Task, error) {
+	if title == "" {
+		return Task{}, ErrEmptyTitle
+	}
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	task, exists := tm.tasks[id]
+	if !exists {
+		return Task{}, ErrTaskNotFound
+	}
+	if task.Version != version {
+		return Task{}, ErrVersionConflict
+	}
+
+	task.Title = title
+	task.Description = description
+	task.Done = done
+	task.Version++
+	tm.tasks[id] = task
+	tm.publish(TaskUpdated, task)
+	return task, nil
 }
 
 // DeleteTask removes a task from the manager, returns an error if the task is not found
 func (tm *TaskManager) DeleteTask(id int) error {
-	// TODO: Implement this function
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	task, exists := tm.tasks[id]
+	if !exists {
+		return ErrTaskNotFound
+	}
+	delete(tm.tasks, id)
+	tm.publish(TaskDeleted, task)
 	return nil
 }
 
 // GetTask retrieves a task by ID, returns an error if the task is not found
 func (tm *TaskManager) GetTask(id int) (Task, error) {
-	// TODO: Implement this function
-	return Task{}, nil
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	task, exists := tm.tasks[id]
+	if !exists {
+		return Task{}, ErrTaskNotFound
+	}
+	return task, nil
 }
 
 // ListTasks returns all tasks, optionally filtered by done status, returns an empty slice if no tasks are found
 func (tm *TaskManager) ListTasks(filterDone *bool) []Task {
-	// TODO: Implement this function
-	return nil
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	result := make([]Task, 0, len(tm.tasks))
+	for _, task := range tm.tasks {
+		if filterDone != nil && task.Done != *filterDone {
+			continue
+		}
+		result = append(result, task)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// newUID generates a random identifier that stays the same across exports
+func newUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b) + "@taskmanager"
 }
@@ -134,6 +134,7 @@ func TestUpdateTask(t *testing.T) {
 	tests := []struct {
 		name        string
 		id          int
+		version     int
 		title       string
 		description string
 		done        bool
@@ -142,6 +143,7 @@ func TestUpdateTask(t *testing.T) {
 		{
 			name:        "valid update",
 			id:          task.ID,
+			version:     task.Version,
 			title:       "Updated Task",
 			description: "Updated Description",
 			done:        true,
@@ -150,6 +152,7 @@ func TestUpdateTask(t *testing.T) {
 		{
 			name:        "non-existent task",
 			id:          999,
+			version:     1,
 			title:       "Updated Task",
 			description: "Updated Description",
 			done:        true,
@@ -158,16 +161,26 @@ func TestUpdateTask(t *testing.T) {
 		{
 			name:        "empty title",
 			id:          task.ID,
+			version:     task.Version + 1,
 			title:       "",
 			description: "Updated Description",
 			done:        true,
 			expectError: true,
 		},
+		{
+			name:        "stale version",
+			id:          task.ID,
+			version:     task.Version,
+			title:       "Stale Task",
+			description: "Updated Description",
+			done:        false,
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := tm.UpdateTask(tt.id, tt.title, tt.description, tt.done)
+			_, err := tm.UpdateTask(tt.id, tt.version, tt.title, tt.description, tt.done)
 
 			if tt.expectError {
 				if err == nil {
@@ -262,7 +275,7 @@ func TestListTasks(t *testing.T) {
 	_, _ = tm.AddTask("Task 3", "Description 3")
 
 	// Mark one task as done
-	tm.UpdateTask(task2.ID, task2.Title, task2.Description, true)
+	tm.UpdateTask(task2.ID, task2.Version, task2.Title, task2.Description, true)
 
 	tests := []struct {
 		name     string
@@ -0,0 +1,149 @@
+package user
+
+import (
+	"net/netip"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// Length limits from RFC 5321 section 4.5.3.1
+const (
+	maxLocalPartLength = 64
+	maxDomainLength    = 253
+	maxEmailLength     = 254
+)
+
+// NormalizeEmail validates an RFC 5322 addr-spec and returns it with the domain
+// converted to lower-case ASCII (IDN domains are encoded as punycode).
+// Comments, folding whitespace and obsolete syntax are not accepted, and hostnames
+// must be fully qualified. UTF-8 local parts are allowed as in RFC 6532.
+func NormalizeEmail(email string) (string, error) {
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 || at == len(email)-1 || !utf8.ValidString(email) {
+		return "", ErrInvalidEmail
+	}
+	local, domain := email[:at], email[at+1:]
+
+	if len(local) > maxLocalPartLength || !validLocalPart(local) {
+		return "", ErrInvalidEmail
+	}
+
+	if strings.HasPrefix(domain, "[") {
+		if !validDomainLiteral(domain) {
+			return "", ErrInvalidEmail
+		}
+	} else {
+		ascii, err := toASCIIDomain(domain)
+		if err != nil || !validHostname(ascii) {
+			return "", ErrInvalidEmail
+		}
+		domain = ascii
+	}
+
+	normalized := local + "@" + domain
+	if len(normalized) > maxEmailLength {
+		return "", ErrInvalidEmail
+	}
+	return normalized, nil
+}
+
+func toASCIIDomain(domain string) (string, error) {
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(ascii), nil
+}
+
+// validLocalPart accepts a dot-atom or a quoted-string
+func validLocalPart(local string) bool {
+	if strings.HasPrefix(local, `"`) {
+		return validQuotedString(local)
+	}
+	for _, atom := range strings.Split(local, ".") {
+		if atom == "" {
+			return false
+		}
+		for _, r := range atom {
+			if !isAtext(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isAtext(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	case strings.ContainsRune("!#$%&'*+-/=?^_`{|}~", r):
+		return true
+	default:
+		return r >= utf8.RuneSelf // UTF8-non-ascii
+	}
+}
+
+func validQuotedString(s string) bool {
+	if len(s) < 2 || !strings.HasSuffix(s, `"`) {
+		return false
+	}
+	inner := s[1 : len(s)-1]
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case c == '\\':
+			// quoted-pair: backslash followed by VCHAR or WSP
+			i++
+			if i == len(inner) || (inner[i] < ' ' && inner[i] != '\t') || inner[i] == 0x7f {
+				return false
+			}
+		case c == '"':
+			return false
+		case c < ' ' && c != '\t', c == 0x7f:
+			return false
+		}
+	}
+	return true
+}
+
+// validHostname checks LDH labels of a fully qualified ASCII domain
+func validHostname(domain string) bool {
+	if domain == "" || len(domain) > maxDomainLength {
+		return false
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	// The top-level domain is never all-numeric
+	tld := labels[len(labels)-1]
+	return strings.Trim(tld, "0123456789") != ""
+}
+
+// validDomainLiteral accepts [192.0.2.1] and [IPv6:2001:db8::1]
+func validDomainLiteral(domain string) bool {
+	if !strings.HasSuffix(domain, "]") {
+		return false
+	}
+	literal := domain[1 : len(domain)-1]
+	if v6, ok := strings.CutPrefix(literal, "IPv6:"); ok {
+		addr, err := netip.ParseAddr(v6)
+		return err == nil && addr.Is6() && addr.Zone() == ""
+	}
+	addr, err := netip.ParseAddr(literal)
+	return err == nil && addr.Is4()
+}
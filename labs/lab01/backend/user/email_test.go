@@ -0,0 +1,50 @@
+package user
+
+import "testing"
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email    string
+		expected string
+		valid    bool
+	}{
+		{"john@example.com", "john@example.com", true},
+		{"John.Doe@Example.COM", "John.Doe@example.com", true},
+		{"user+tag@sub.example.co.uk", "user+tag@sub.example.co.uk", true},
+		{"o'brien@example.ie", "o'brien@example.ie", true},
+		{`"john doe"@example.com`, `"john doe"@example.com`, true},
+		{`"a\"b"@example.com`, `"a\"b"@example.com`, true},
+		{"user@bücher.example", "user@xn--bcher-kva.example", true},
+		{"почта@пример.рф", "почта@xn--e1afmkfd.xn--p1ai", true},
+		{"user@[192.0.2.1]", "user@[192.0.2.1]", true},
+		{"user@[IPv6:2001:db8::1]", "user@[IPv6:2001:db8::1]", true},
+		{"invalid-email@", "", false},
+		{"johnnotvalid", "", false},
+		{"john@notvalid", "", false},
+		{"@example.com", "", false},
+		{"john..doe@example.com", "", false},
+		{".john@example.com", "", false},
+		{"john doe@example.com", "", false},
+		{`"unterminated@example.com`, "", false},
+		{"john@-example.com", "", false},
+		{"john@example..com", "", false},
+		{"john@example.123", "", false},
+		{"john@[300.1.1.1]", "", false},
+		{"john@exa_mple.com", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			got, err := NormalizeEmail(tt.email)
+			if tt.valid != (err == nil) {
+				t.Fatalf("NormalizeEmail(%q) error = %v, want valid = %v", tt.email, err, tt.valid)
+			}
+			if got != tt.expected {
+				t.Errorf("NormalizeEmail(%q) = %q, want %q", tt.email, got, tt.expected)
+			}
+			if IsValidEmail(tt.email) != tt.valid {
+				t.Errorf("IsValidEmail(%q) = %v, want %v", tt.email, !tt.valid, tt.valid)
+			}
+		})
+	}
+}
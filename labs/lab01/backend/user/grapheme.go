@@ -0,0 +1,71 @@
+package user
+
+import "unicode"
+
+const (
+	zeroWidthNonJoiner = '\u200c'
+	zeroWidthJoiner    = '\u200d'
+)
+
+// graphemeCount returns the number of user-perceived characters in s.
+// It implements the subset of the UAX #29 rules that matter for names:
+// combining marks, spacing marks, variation selectors, emoji modifiers,
+// ZWJ sequences, regional indicator pairs, Hangul jamo and CR LF.
+func graphemeCount(s string) int {
+	count := 0
+	var prev rune
+	riRun := 0 // regional indicators in the current run
+	for i, r := range s {
+		if i == 0 {
+			count = 1
+			prev = r
+			if isRegionalIndicator(r) {
+				riRun = 1
+			}
+			continue
+		}
+
+		join := false
+		switch {
+		case prev == '\r' && r == '\n':
+			join = true
+		case isGraphemeExtend(r), r == zeroWidthJoiner:
+			join = true
+		case prev == zeroWidthJoiner:
+			join = true
+		case isRegionalIndicator(r) && isRegionalIndicator(prev) && riRun%2 == 1:
+			join = true
+		case isHangulJamo(prev) && isHangulJamo(r) && !isHangulLeading(r):
+			join = true
+		}
+
+		if isRegionalIndicator(r) {
+			riRun++
+		} else {
+			riRun = 0
+		}
+		if !join {
+			count++
+		}
+		prev = r
+	}
+	return count
+}
+
+func isGraphemeExtend(r rune) bool {
+	return unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) || unicode.Is(unicode.Mc, r) ||
+		r >= 0x1F3FB && r <= 0x1F3FF || // emoji skin tone modifiers
+		r >= 0xE0020 && r <= 0xE007F // emoji tag sequences
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+func isHangulJamo(r rune) bool {
+	return r >= 0x1100 && r <= 0x11FF
+}
+
+func isHangulLeading(r rune) bool {
+	return r >= 0x1100 && r <= 0x115F
+}
@@ -0,0 +1,171 @@
+package user
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Limits of DefaultPolicy
+const (
+	defaultMinAge        = 0
+	defaultMaxAge        = 150
+	defaultMaxNameLength = 30
+)
+
+// Policy configures user validation limits. A zero MaxAge or MaxNameLength
+// means the default limit, so the zero Policy is DefaultPolicy.
+type Policy struct {
+	MinAge         int
+	MaxAge         int
+	MaxNameLength  int      // in user-perceived characters (grapheme clusters)
+	AllowedDomains []string // if not empty, the email domain must match one of these or be a subdomain
+}
+
+// DefaultPolicy returns the limits used by Validate and NewUser
+func DefaultPolicy() Policy {
+	return Policy{
+		MinAge:        defaultMinAge,
+		MaxAge:        defaultMaxAge,
+		MaxNameLength: defaultMaxNameLength,
+	}
+}
+
+// withDefaults fills in the limits left at zero
+func (p Policy) withDefaults() Policy {
+	if p.MaxAge == 0 {
+		p.MaxAge = defaultMaxAge
+	}
+	if p.MaxNameLength == 0 {
+		p.MaxNameLength = defaultMaxNameLength
+	}
+	return p
+}
+
+// limitError is a field error stating the limits of a non-default policy,
+// errors.Is matches the error of the same field under DefaultPolicy
+type limitError struct {
+	msg  string
+	base error
+}
+
+func (e *limitError) Error() string {
+	return e.msg
+}
+
+func (e *limitError) Is(target error) bool {
+	return target == e.base
+}
+
+func nameLimitMessage(maxLength int) string {
+	return fmt.Sprintf("invalid name: must be between 1 and %d characters", maxLength)
+}
+
+func ageLimitMessage(minAge, maxAge int) string {
+	return fmt.Sprintf("invalid age: must be between %d and %d", minAge, maxAge)
+}
+
+// nameError returns the error for a name outside the policy's limits
+func (p Policy) nameError() error {
+	if p.MaxNameLength == defaultMaxNameLength {
+		return ErrInvalidName
+	}
+	return &limitError{msg: nameLimitMessage(p.MaxNameLength), base: ErrInvalidName}
+}
+
+// ageError returns the error for an age outside the policy's limits
+func (p Policy) ageError() error {
+	if p.MinAge == defaultMinAge && p.MaxAge == defaultMaxAge {
+		return ErrInvalidAge
+	}
+	return &limitError{msg: ageLimitMessage(p.MinAge, p.MaxAge), base: ErrInvalidAge}
+}
+
+// FieldError describes a single invalid field
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors collects all field errors of a user, errors.Is matches any of them
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, fe := range e {
+		errs[i] = fe
+	}
+	return errs
+}
+
+// Fields returns the error for each invalid field keyed by field name
+func (e ValidationErrors) Fields() map[string]error {
+	fields := make(map[string]error, len(e))
+	for _, fe := range e {
+		fields[fe.Field] = fe.Err
+	}
+	return fields
+}
+
+// validName accepts names in any script made of letters, marks, spaces and
+// common name punctuation, limited by grapheme count
+func (p Policy) validName(name string) bool {
+	if strings.TrimSpace(name) == "" {
+		return false
+	}
+	if n := graphemeCount(name); n > p.MaxNameLength {
+		return false
+	}
+
+	hasLetter := false
+	for _, r := range name {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsMark(r), r == ' ', r == '\'', r == '’', r == '-', r == '.', r == ',':
+		case r == zeroWidthJoiner, r == zeroWidthNonJoiner:
+		default:
+			return false
+		}
+	}
+	return hasLetter
+}
+
+// checkEmail validates the address and the domain allow list
+func (p Policy) checkEmail(email string) error {
+	normalized, err := NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	if len(p.AllowedDomains) == 0 {
+		return nil
+	}
+
+	domain := normalized[strings.LastIndexByte(normalized, '@')+1:]
+	for _, allowed := range p.AllowedDomains {
+		allowed, err := toASCIIDomain(allowed)
+		if err != nil {
+			continue
+		}
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return nil
+		}
+	}
+	return ErrEmailDomainNotAllowed
+}
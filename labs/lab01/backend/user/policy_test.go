@@ -0,0 +1,156 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateAllReportsEveryField(t *testing.T) {
+	u := User{Name: "", Age: 200, Email: "nope"}
+	err := u.ValidateAll(DefaultPolicy())
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ValidationErrors, got %T", err)
+	}
+	fields := errs.Fields()
+	if len(fields) != 3 {
+		t.Fatalf("Expected 3 field errors, got %d: %v", len(fields), err)
+	}
+	if fields["name"] != ErrInvalidName || fields["age"] != ErrInvalidAge || fields["email"] != ErrInvalidEmail {
+		t.Errorf("Unexpected field errors: %v", fields)
+	}
+	if !errors.Is(err, ErrInvalidAge) {
+		t.Error("errors.Is should match a wrapped field error")
+	}
+
+	valid := User{Name: "Jane", Age: 30, Email: "jane@example.com"}
+	if err := valid.ValidateAll(DefaultPolicy()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestValidateAllPolicy(t *testing.T) {
+	policy := Policy{
+		MinAge:         18,
+		MaxAge:         99,
+		MaxNameLength:  10,
+		AllowedDomains: []string{"example.com", "bücher.example"},
+	}
+
+	tests := []struct {
+		name   string
+		user   User
+		fields []string
+	}{
+		{"valid", User{Name: "Ann", Age: 18, Email: "ann@example.com"}, nil},
+		{"subdomain", User{Name: "Ann", Age: 18, Email: "ann@mail.example.com"}, nil},
+		{"idn domain", User{Name: "Ann", Age: 18, Email: "ann@xn--bcher-kva.example"}, nil},
+		{"too young", User{Name: "Ann", Age: 17, Email: "ann@example.com"}, []string{"age"}},
+		{"name too long", User{Name: "Annabelle Lee", Age: 30, Email: "ann@example.com"}, []string{"name"}},
+		{"domain not allowed", User{Name: "Ann", Age: 30, Email: "ann@other.com"}, []string{"email"}},
+		{"lookalike domain", User{Name: "Ann", Age: 30, Email: "ann@badexample.com"}, []string{"email"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.user.ValidateAll(policy)
+			if tt.fields == nil {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			errs, ok := err.(ValidationErrors)
+			if !ok || len(errs) != len(tt.fields) {
+				t.Fatalf("Expected errors for %v, got %v", tt.fields, err)
+			}
+			for i, field := range tt.fields {
+				if errs[i].Field != field {
+					t.Errorf("Expected error for %s, got %s", field, errs[i].Field)
+				}
+			}
+		})
+	}
+
+	u := User{Name: "Ann", Age: 30, Email: "ann@other.com"}
+	err := u.ValidateAll(policy)
+	if !errors.Is(err, ErrEmailDomainNotAllowed) {
+		t.Errorf("Expected ErrEmailDomainNotAllowed, got %v", err)
+	}
+	// Errors state the policy's own limits and still match the sentinels
+	u = User{Name: "Annabelle Lee", Age: 17, Email: "ann@example.com"}
+	fields := u.ValidateAll(policy).(ValidationErrors).Fields()
+	if fields["name"].Error() != "invalid name: must be between 1 and 10 characters" || !errors.Is(fields["name"], ErrInvalidName) {
+		t.Errorf("Unexpected name error %v", fields["name"])
+	}
+	if fields["age"].Error() != "invalid age: must be between 18 and 99" || !errors.Is(fields["age"], ErrInvalidAge) {
+		t.Errorf("Unexpected age error %v", fields["age"])
+	}
+}
+
+func TestZeroPolicyIsDefault(t *testing.T) {
+	valid := User{Name: "Jane", Age: 150, Email: "jane@example.com"}
+	if err := valid.ValidateAll(Policy{}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	invalid := User{Name: strings.Repeat("a", 31), Age: 151, Email: "jane@example.com"}
+	fields := invalid.ValidateAll(Policy{}).(ValidationErrors).Fields()
+	if fields["name"] != ErrInvalidName || fields["age"] != ErrInvalidAge {
+		t.Errorf("Expected the default errors, got %v", fields)
+	}
+	if ErrInvalidAge.Error() != "invalid age: must be between 0 and 150" {
+		t.Errorf("Unexpected message %q", ErrInvalidAge)
+	}
+}
+
+func TestUnicodeNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"José María", true},
+		{"Nguyễn Văn An", true}, // decomposed diacritics
+		{"李小龍", true},
+		{"Анна-Мария", true},
+		{"D'Artagnan", true},
+		{strings.Repeat("é", 30), true},
+		{strings.Repeat("é", 30), true}, // 30 graphemes, 60 runes
+		{strings.Repeat("é", 31), false},
+		{"   ", false},
+		{"John3", false},
+		{"John\x00Doe", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsValidName(tt.name); got != tt.valid {
+				t.Errorf("IsValidName(%q) = %v, want %v", tt.name, got, tt.valid)
+			}
+		})
+	}
+}
+
+func TestGraphemeCount(t *testing.T) {
+	tests := []struct {
+		s     string
+		count int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"é", 1},
+		{"\r\n", 1},
+		{"👍🏽", 1},
+		{"👩\u200d👩\u200d👧", 1},
+		{"🇺🇦🇵🇱", 2},
+		{"🇺🇦🇵", 2},
+		{"각", 1}, // Hangul jamo L V T
+	}
+
+	for _, tt := range tests {
+		if got := graphemeCount(tt.s); got != tt.count {
+			t.Errorf("graphemeCount(%q) = %d, want %d", tt.s, got, tt.count)
+		}
+	}
+}
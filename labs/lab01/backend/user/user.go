@@ -2,13 +2,17 @@ package user
 
 import (
 	"errors"
+	"fmt"
 )
 
-// Predefined errors
+// Predefined errors. ErrInvalidName and ErrInvalidAge describe the limits of
+// DefaultPolicy; the errors for other policies state their own limits and
+// match these with errors.Is.
 var (
-	ErrInvalidName  = errors.New("invalid name: must be between 1 and 30 characters")
-	ErrInvalidAge   = errors.New("invalid age: must be between 0 and 150")
-	ErrInvalidEmail = errors.New("invalid email format")
+	ErrInvalidName           = errors.New(nameLimitMessage(defaultMaxNameLength))
+	ErrInvalidAge            = errors.New(ageLimitMessage(defaultMinAge, defaultMaxAge))
+	ErrInvalidEmail          = errors.New("invalid email format")
+	ErrEmailDomainNotAllowed = errors.New("email domain is not allowed")
 )
 
 // User represents a user in the system
@@ -18,50 +22,65 @@ type User struct {
 	Email string
 }
 
-// Validate checks if the user data is valid, returns an error for each invalid field
+// Validate checks if the user data is valid against the default policy,
+// returns the error of the first invalid field. Use ValidateAll to get every field error.
 func (u *User) Validate() error {
-	if !IsValidName(u.Name) {
-		return ErrInvalidName
+	err := u.ValidateAll(DefaultPolicy())
+	if errs, ok := err.(ValidationErrors); ok {
+		return errs[0].Err
 	}
+	return err
+}
 
-	if !IsValidAge(u.Age) {
-		return ErrInvalidAge
-	}
+// ValidateAll checks every field against the policy and returns ValidationErrors
+// listing all invalid fields, or nil if the user is valid
+func (u *User) ValidateAll(p Policy) error {
+	var errs ValidationErrors
 
-	if !IsValidEmail(u.Email) {
-		return ErrInvalidEmail
+	p = p.withDefaults()
+	if !p.validName(u.Name) {
+		errs = append(errs, FieldError{Field: "name", Err: p.nameError()})
+	}
+	if u.Age < p.MinAge || u.Age > p.MaxAge {
+		errs = append(errs, FieldError{Field: "age", Err: p.ageError()})
+	}
+	if err := p.checkEmail(u.Email); err != nil {
+		errs = append(errs, FieldError{Field: "email", Err: err})
 	}
 
+	if len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
 
 // String returns a string representation of the user, formatted as "Name: <name>, Age: <age>, Email: <email>"
 func (u *User) String() string {
-	// TODO: Implement this function
-	return ""
+	return fmt.Sprintf("Name: %s, Age: %d, Email: %s", u.Name, u.Age, u.Email)
 }
 
 // NewUser creates a new user with validation, returns an error if the user is not valid
 func NewUser(name string, age int, email string) (*User, error) {
-	// TODO: Implement this function
-	return nil, nil
+	u := &User{Name: name, Age: age, Email: email}
+	if err := u.Validate(); err != nil {
+		return nil, err
+	}
+	return u, nil
 }
 
-// IsValidEmail checks if the email format is valid
-// You can use regexp.MustCompile to compile the email regex
+// IsValidEmail checks if the email is a valid RFC 5322 address with a fully qualified domain
 func IsValidEmail(email string) bool {
-	// TODO: Implement this function
-	return false
+	_, err := NormalizeEmail(email)
+	return err == nil
 }
 
 // IsValidName checks if the name is valid, returns false if the name is empty or longer than 30 characters
 func IsValidName(name string) bool {
-	// TODO: Implement this function
-	return false
+	return DefaultPolicy().validName(name)
 }
 
 // IsValidAge checks if the age is valid, returns false if the age is not between 0 and 150
 func IsValidAge(age int) bool {
-	// TODO: Implement this function
-	return false
-}
\ No newline at end of file
+	p := DefaultPolicy()
+	return age >= p.MinAge && age <= p.MaxAge
+}
@@ -0,0 +1,183 @@
+package blob
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func pngBytes(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestPutDeduplicates(t *testing.T) {
+	store, err := Open(t.TempDir(), DefaultLimits)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	first, err := store.Put(strings.NewReader("hello world"), "../../etc/notes.txt")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if first.MIMEType != "text/plain" || first.Size != 11 || first.Name != "notes.txt" {
+		t.Errorf("unexpected attachment: %+v", first)
+	}
+	second, err := store.Put(strings.NewReader("hello world"), "other.txt")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if second.ID != first.ID || second.Name != "notes.txt" {
+		t.Errorf("expected the stored attachment back, got %+v", second)
+	}
+
+	f, att, err := store.Open(first.ID)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	if string(data) != "hello world" || att.ID != first.ID {
+		t.Errorf("unexpected content %q", data)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	store, err := Open(t.TempDir(), Limits{MaxSize: 16, AllowedTypes: []string{"text/plain", "image/"}})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := store.Put(strings.NewReader(strings.Repeat("a", 17)), "big.txt"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+	if _, err := store.Put(strings.NewReader("%PDF-1.4 fake"), "doc.pdf"); !errors.Is(err, ErrTypeNotAllowed) {
+		t.Errorf("expected ErrTypeNotAllowed, got %v", err)
+	}
+	if _, err := store.Put(strings.NewReader(""), "empty.txt"); !errors.Is(err, ErrEmptyAttachment) {
+		t.Errorf("expected ErrEmptyAttachment, got %v", err)
+	}
+	if _, err := store.Put(strings.NewReader(strings.Repeat("a", 16)), "ok.txt"); err != nil {
+		t.Errorf("expected upload at the limit to succeed, got %v", err)
+	}
+	if _, err := store.Attachment("../../../etc/passwd"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an invalid id, got %v", err)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	store, err := Open(t.TempDir(), DefaultLimits)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	att, err := store.Put(bytes.NewReader(pngBytes(t, 600, 300)), "photo.png")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if att.MIMEType != "image/png" || att.Width != 600 || att.Height != 300 || att.ThumbnailID == "" {
+		t.Fatalf("unexpected attachment: %+v", att)
+	}
+
+	f, thumb, err := store.Open(att.ThumbnailID)
+	if err != nil {
+		t.Fatalf("Open thumbnail failed: %v", err)
+	}
+	defer f.Close()
+	cfg, err := png.DecodeConfig(f)
+	if err != nil {
+		t.Fatalf("thumbnail is not a PNG: %v", err)
+	}
+	if cfg.Width != ThumbnailSize || cfg.Height != ThumbnailSize/2 || thumb.MIMEType != "image/png" {
+		t.Errorf("unexpected thumbnail %dx%d %+v", cfg.Width, cfg.Height, thumb)
+	}
+
+	// A broken image is stored without a thumbnail
+	broken := append(pngBytes(t, 4, 4)[:40], 0, 1, 2)
+	att, err = store.Put(bytes.NewReader(broken), "broken.png")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if att.ThumbnailID != "" {
+		t.Errorf("expected no thumbnail for a broken image, got %+v", att)
+	}
+}
+
+func TestServe(t *testing.T) {
+	store, err := Open(t.TempDir(), DefaultLimits)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	att, err := store.Put(strings.NewReader("hello world"), "notes.txt")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Range", "bytes=0-4")
+	rec := httptest.NewRecorder()
+	if err := store.Serve(rec, req, att.ID); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "hello" {
+		t.Errorf("unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Content-Type") != "text/plain" || rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("unexpected headers %v", rec.Header())
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment") {
+		t.Errorf("expected text to download as an attachment, got %q", rec.Header().Get("Content-Disposition"))
+	}
+}
+
+func TestSigner(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer := NewSigner([]byte("secret"))
+	signer.now = func() time.Time { return now }
+
+	link, expires := signer.URL("/api/attachments", "abc")
+	if !expires.Equal(now.Add(DefaultURLTTL)) {
+		t.Errorf("unexpected expiry %v", expires)
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Path != "/api/attachments/abc" {
+		t.Fatalf("unexpected URL %q: %v", link, err)
+	}
+	if err := signer.Verify("abc", u.Query()); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+	if err := signer.Verify("abd", u.Query()); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for another id, got %v", err)
+	}
+	tampered := u.Query()
+	tampered.Set("expires", "9999999999")
+	if err := signer.Verify("abc", tampered); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for a changed expiry, got %v", err)
+	}
+	if err := NewSigner([]byte("other")).Verify("abc", u.Query()); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for another key, got %v", err)
+	}
+
+	now = now.Add(DefaultURLTTL + time.Second)
+	if err := signer.Verify("abc", u.Query()); !errors.Is(err, ErrURLExpired) {
+		t.Errorf("expected ErrURLExpired, got %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+// Package blob stores chat attachments on the local filesystem, addressed by
+// the SHA-256 of their content, and signs expiring download URLs for them.
+package blob
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Store errors
+var (
+	ErrNotFound        = errors.New("attachment not found")
+	ErrTooLarge        = errors.New("attachment is too large")
+	ErrTypeNotAllowed  = errors.New("attachment type is not allowed")
+	ErrEmptyAttachment = errors.New("attachment is empty")
+)
+
+// sniffLen is how much content http.DetectContentType looks at
+const sniffLen = 512
+
+// Limits restricts what can be uploaded
+type Limits struct {
+	MaxSize      int64    // bytes, zero means no limit
+	AllowedTypes []string // MIME types, entries ending in "/" match a whole family such as "image/"
+}
+
+// DefaultLimits allows common images, PDFs and plain text up to 10 MiB
+var DefaultLimits = Limits{
+	MaxSize:      10 << 20,
+	AllowedTypes: []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain"},
+}
+
+// Attachment describes a stored blob
+type Attachment struct {
+	ID          string    `json:"id"` // hex SHA-256 of the content
+	Name        string    `json:"name"`
+	MIMEType    string    `json:"mime_type"`
+	Size        int64     `json:"size"`
+	Width       int       `json:"width,omitempty"` // images only
+	Height      int       `json:"height,omitempty"`
+	ThumbnailID string    `json:"thumbnail_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Store is a content-addressed blob store rooted at a directory. Blobs live
+// at <dir>/<first two hex digits>/<id> with their metadata next to them in
+// <id>.json, so identical uploads are stored once.
+type Store struct {
+	dir    string
+	limits Limits
+}
+
+// Open creates the store directory if needed
+func Open(dir string, limits Limits) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, limits: limits}, nil
+}
+
+// Put stores content read from r. The type is detected from the content,
+// never trusted from the client, and checked before the rest is read.
+// Images get a thumbnail. Uploading content that is already stored returns
+// the existing attachment.
+func (s *Store) Put(r io.Reader, name string) (Attachment, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Attachment{}, err
+	}
+	if n == 0 {
+		return Attachment{}, ErrEmptyAttachment
+	}
+	head = head[:n]
+	mimeType := detectType(head)
+	if !s.allowed(mimeType) {
+		return Attachment{}, ErrTypeNotAllowed
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "tmp"), "upload-*")
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := s.copyLimited(io.MultiWriter(tmp, hash), io.MultiReader(bytes.NewReader(head), r))
+	if err != nil {
+		return Attachment{}, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return Attachment{}, err
+	}
+
+	att := Attachment{
+		ID:        hex.EncodeToString(hash.Sum(nil)),
+		Name:      cleanName(name),
+		MIMEType:  mimeType,
+		Size:      size,
+		CreatedAt: time.Now().UTC(),
+	}
+	if existing, err := s.Attachment(att.ID); err == nil {
+		return existing, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path(att.ID)), 0o755); err != nil {
+		return Attachment{}, err
+	}
+	if err := os.Rename(tmp.Name(), s.path(att.ID)); err != nil {
+		return Attachment{}, err
+	}
+
+	if isImage(mimeType) {
+		// Content that only looks like an image is kept without a thumbnail
+		if thumb, width, height, err := s.thumbnail(att.ID); err == nil {
+			att.Width, att.Height, att.ThumbnailID = width, height, thumb.ID
+		}
+	}
+	return att, s.writeMeta(att)
+}
+
+// Attachment returns the metadata of a stored blob
+func (s *Store) Attachment(id string) (Attachment, error) {
+	if !validID(id) {
+		return Attachment{}, ErrNotFound
+	}
+	data, err := os.ReadFile(s.path(id) + ".json")
+	if os.IsNotExist(err) {
+		return Attachment{}, ErrNotFound
+	}
+	if err != nil {
+		return Attachment{}, err
+	}
+	var att Attachment
+	if err := json.Unmarshal(data, &att); err != nil {
+		return Attachment{}, err
+	}
+	return att, nil
+}
+
+// Open returns the content of a stored blob
+func (s *Store) Open(id string) (*os.File, Attachment, error) {
+	att, err := s.Attachment(id)
+	if err != nil {
+		return nil, Attachment{}, err
+	}
+	f, err := os.Open(s.path(id))
+	if err != nil {
+		return nil, Attachment{}, err
+	}
+	return f, att, nil
+}
+
+// Serve writes a blob as an HTTP response with range and caching support.
+// Content is always sent with its detected type and nosniff, and only
+// images are shown inline.
+func (s *Store) Serve(w http.ResponseWriter, r *http.Request, id string) error {
+	f, att, err := s.Open(id)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	disposition := "attachment"
+	if isImage(att.MIMEType) {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", att.MIMEType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": att.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("ETag", `"`+att.ID+`"`)
+	http.ServeContent(w, r, "", att.CreatedAt, f)
+	return nil
+}
+
+// copyLimited copies r to w, failing with ErrTooLarge past the size limit
+func (s *Store) copyLimited(w io.Writer, r io.Reader) (int64, error) {
+	if s.limits.MaxSize <= 0 {
+		return io.Copy(w, r)
+	}
+	n, err := io.Copy(w, io.LimitReader(r, s.limits.MaxSize+1))
+	if err == nil && n > s.limits.MaxSize {
+		err = ErrTooLarge
+	}
+	return n, err
+}
+
+// putBytes stores generated content such as thumbnails, bypassing the limits
+func (s *Store) putBytes(data []byte, name, mimeType string) (Attachment, error) {
+	sum := sha256.Sum256(data)
+	att := Attachment{
+		ID:        hex.EncodeToString(sum[:]),
+		Name:      name,
+		MIMEType:  mimeType,
+		Size:      int64(len(data)),
+		CreatedAt: time.Now().UTC(),
+	}
+	if existing, err := s.Attachment(att.ID); err == nil {
+		return existing, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path(att.ID)), 0o755); err != nil {
+		return Attachment{}, err
+	}
+	if err := writeFileAtomic(s.path(att.ID), data, filepath.Join(s.dir, "tmp")); err != nil {
+		return Attachment{}, err
+	}
+	return att, s.writeMeta(att)
+}
+
+// writeMeta stores metadata last, so a blob only becomes visible once complete
+func (s *Store) writeMeta(att Attachment) error {
+	data, err := json.Marshal(att)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(att.ID)+".json", data, filepath.Join(s.dir, "tmp"))
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id[:2], id)
+}
+
+func (s *Store) allowed(mimeType string) bool {
+	if len(s.limits.AllowedTypes) == 0 {
+		return true
+	}
+	for _, t := range s.limits.AllowedTypes {
+		if t == mimeType || (strings.HasSuffix(t, "/") && strings.HasPrefix(mimeType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFileAtomic writes through a temporary file so readers never see a partial file
+func writeFileAtomic(path string, data []byte, tmpDir string) error {
+	tmp, err := os.CreateTemp(tmpDir, "write-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// detectType sniffs the MIME type without parameters such as charset
+func detectType(head []byte) string {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+func isImage(mimeType string) bool {
+	return mimeType == "image/png" || mimeType == "image/jpeg" || mimeType == "image/gif"
+}
+
+// validID reports whether id is a hex SHA-256, which also keeps it from
+// escaping the store directory
+func validID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// cleanName keeps only the base name of an uploaded file
+func cleanName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		return "attachment"
+	}
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
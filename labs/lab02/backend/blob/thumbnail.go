@@ -0,0 +1,91 @@
+package blob
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif" // register decoders for thumbnailing
+	_ "image/jpeg"
+	"image/png"
+	"os"
+)
+
+// Thumbnail settings
+const (
+	ThumbnailSize = 256              // longest edge in pixels
+	maxPixels     = 40 * 1000 * 1000 // refuse to decode larger images
+)
+
+// ErrImageTooLarge is returned for images whose dimensions exceed maxPixels
+var ErrImageTooLarge = errors.New("image dimensions are too large")
+
+// thumbnail decodes a stored image and stores a PNG scaled to fit within
+// ThumbnailSize. It returns the original dimensions as well.
+func (s *Store) thumbnail(id string) (Attachment, int, int, error) {
+	f, err := os.Open(s.path(id))
+	if err != nil {
+		return Attachment{}, 0, 0, err
+	}
+	defer f.Close()
+
+	// Check the header first so a tiny file cannot decode into gigabytes
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return Attachment{}, 0, 0, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return Attachment{}, 0, 0, ErrImageTooLarge
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return Attachment{}, 0, 0, err
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return Attachment{}, 0, 0, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scale(src, ThumbnailSize)); err != nil {
+		return Attachment{}, 0, 0, err
+	}
+	thumb, err := s.putBytes(buf.Bytes(), "thumbnail.png", "image/png")
+	return thumb, cfg.Width, cfg.Height, err
+}
+
+// scale shrinks img to fit within size x size keeping its aspect ratio.
+// Each target pixel averages a grid of up to 4x4 samples from its source
+// area, which is cheap and avoids the worst aliasing of nearest-neighbour.
+func scale(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	tw, th := size, size
+	if w > h {
+		th = max(1, h*size/w)
+	} else {
+		tw = max(1, w*size/h)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy += max(1, (y1-y0)/4) {
+				for sx := x0; sx < x1; sx += max(1, (x1-x0)/4) {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+cr, g+cg, bl+cb, a+ca, n+1
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
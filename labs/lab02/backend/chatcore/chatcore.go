@@ -3,18 +3,29 @@ package chatcore
 import (
 	"context"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"lab02/blob"
+	"lab02/moderation"
 )
 
 // Message represents a chat message
 // Sender, Recipient, Content, Broadcast, Timestamp
-// TODO: Add more fields if needed
 
 type Message struct {
+	ID        uint64 // assigned by SendMessage, unique per Origin
+	Origin    string // node that accepted the message when a transport is set
+	Kind      MessageKind
 	Sender    string
 	Recipient string
+	Room      string // set to deliver to every member of a room
 	Content   string
 	Broadcast bool
 	Timestamp int64
+	Ref       MessageRef // message a read receipt refers to
+
+	Attachments []blob.Attachment // files stored in a blob.Store
 }
 
 // Broker handles message routing between users
@@ -22,41 +33,250 @@ type Message struct {
 
 type Broker struct {
 	ctx        context.Context
-	input      chan Message            // Incoming messages
-	users      map[string]chan Message // userID -> receiving channel
-	usersMutex sync.RWMutex            // Protects users map
-	done       chan struct{}           // For shutdown
-	// TODO: Add more fields if needed
+	input      chan Message           // Incoming messages
+	users      map[string]*subscriber // userID -> delivery queue
+	usersMutex sync.RWMutex           // Protects users map
+	done       chan struct{}          // For shutdown
+	rooms      map[string]*room       // room name -> room
+	roomsMutex sync.RWMutex           // Protects rooms map and room state
+	offline    OfflineStore           // Optional store for direct messages
+	moderator  *moderation.Pipeline   // Optional content moderation
+	lastID     atomic.Uint64          // Last assigned message ID
+
+	// Presence and read receipts
+	presence      map[string]Presence
+	receipts      map[MessageRef][]ReadReceipt
+	receiptOrder  []MessageRef // oldest tracked message first
+	presenceMutex sync.RWMutex // Protects presence and receipts
+
+	// Cluster state, only used with a transport
+	transport   Transport
+	nodeID      string
+	bootID      string                     // distinguishes restarts of nodeID
+	remoteUsers map[string]map[string]bool // userID -> nodes the user is registered on
+	remoteMutex sync.RWMutex               // Protects remoteUsers
+
+	// Counters of subscribers that are no longer registered
+	retiredDelivered atomic.Uint64
+	retiredDropped   atomic.Uint64
+	disconnected     atomic.Uint64
 }
 
 // NewBroker creates a new message broker
 func NewBroker(ctx context.Context) *Broker {
-	// TODO: Initialize broker fields
 	return &Broker{
 		ctx:   ctx,
 		input: make(chan Message, 100),
-		users: make(map[string]chan Message),
+		users: make(map[string]*subscriber),
 		done:  make(chan struct{}),
+		rooms: make(map[string]*room),
+
+		presence: make(map[string]Presence),
+		receipts: make(map[MessageRef][]ReadReceipt),
 	}
 }
 
 // Run starts the broker event loop (goroutine)
 func (b *Broker) Run() {
-	// TODO: Implement event loop (fan-in/fan-out pattern)
+	defer close(b.done)
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case msg := <-b.input:
+			b.route(msg)
+			if b.transport != nil && msg.Origin == b.nodeID {
+				b.publish(envelope{Kind: eventMessage, Message: &msg})
+			}
+		}
+	}
 }
 
-// SendMessage sends a message to the broker
+// Done is closed once Run has returned
+func (b *Broker) Done() <-chan struct{} {
+	return b.done
+}
+
+// SendMessage sends a message to the broker. With an offline store, a direct
+// message is stored before SendMessage returns and an error storing it is
+// returned instead of sending the message.
 func (b *Broker) SendMessage(msg Message) error {
-	// TODO: Send message to appropriate channel/queue
-	return nil
+	if err := b.ctx.Err(); err != nil {
+		return err
+	}
+	if msg.Timestamp == 0 {
+		msg.Timestamp = time.Now().Unix()
+	}
+	if msg.ID == 0 {
+		msg.ID = b.lastID.Add(1)
+	}
+	if msg.Origin == "" {
+		msg.Origin = b.nodeID
+	}
+	if msg.Kind == KindEncrypted && (msg.Recipient == "" || msg.Room != "" || msg.Broadcast) {
+		return ErrEncryptedNotDirect
+	}
+	if msg.Room != "" {
+		if err := b.checkRoomSender(msg.Room, msg.Sender); err != nil {
+			return err
+		}
+	}
+	if b.moderator != nil && msg.Kind == KindChat {
+		content, err := b.moderator.Enforce(msg.Key().String(), moderation.Message{
+			UserID:  msg.Sender,
+			Content: msg.Content,
+			At:      time.Unix(msg.Timestamp, 0),
+		})
+		if err != nil {
+			return err
+		}
+		msg.Content = content
+	}
+	// Store before routing: a user registering in between finds the message
+	// in its backlog and skips the queued copy
+	if b.offline != nil && msg.Origin == b.nodeID && msg.isDirect() && msg.Kind != KindTyping {
+		if err := b.offline.Put(msg.Recipient, msg); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case b.input <- msg:
+		return nil
+	case <-b.ctx.Done():
+		return b.ctx.Err()
+	}
 }
 
-// RegisterUser adds a user to the broker
+// RegisterUser adds a user to the broker with DefaultSubscriberOptions
 func (b *Broker) RegisterUser(userID string, recv chan Message) {
-	// TODO: Register user and their receiving channel
+	b.RegisterUserWithOptions(userID, recv, DefaultSubscriberOptions)
 }
 
-// UnregisterUser removes a user from the broker
+// RegisterUserWithOptions adds a user to the broker with its own queue size
+// and slow-consumer policy, registering again replaces the previous channel.
+// Unacknowledged direct messages are delivered first.
+func (b *Broker) RegisterUserWithOptions(userID string, recv chan Message, opts SubscriberOptions) {
+	sub := newSubscriber(userID, recv, opts)
+
+	b.usersMutex.Lock()
+	if old, ok := b.users[userID]; ok {
+		b.retireLocked(old)
+	}
+	b.users[userID] = sub
+	b.usersMutex.Unlock()
+
+	// Load the backlog once registered, so every message stored after this
+	// point is also queued. The forwarder skips queued copies of the backlog.
+	sub.setBacklog(b.pendingFor(userID))
+	go sub.forward(b.ctx.Done())
+	b.publishPresence(userID, true)
+	b.announcePresence(b.updatePresence(userID, StatusOnline, time.Now().Unix()))
+}
+
+// UnregisterUser removes a user from the broker, queued messages are discarded
 func (b *Broker) UnregisterUser(userID string) {
-	// TODO: Remove user from registry
+	b.usersMutex.Lock()
+	sub, ok := b.users[userID]
+	if ok {
+		b.retireLocked(sub)
+	}
+	b.usersMutex.Unlock()
+
+	if ok {
+		b.publishPresence(userID, false)
+		b.announcePresence(b.updatePresence(userID, StatusOffline, time.Now().Unix()))
+	}
+}
+
+// retireLocked stops a subscriber and keeps its counters, the caller must hold usersMutex
+func (b *Broker) retireLocked(sub *subscriber) {
+	sub.close()
+	delete(b.users, sub.userID)
+	b.retiredDelivered.Add(sub.delivered.Load())
+	b.retiredDropped.Add(sub.dropped.Load())
+}
+
+// Stats returns delivery counters for the broker and every registered user
+func (b *Broker) Stats() BrokerStats {
+	b.usersMutex.RLock()
+	defer b.usersMutex.RUnlock()
+
+	stats := BrokerStats{
+		Delivered:    b.retiredDelivered.Load(),
+		Dropped:      b.retiredDropped.Load(),
+		Disconnected: b.disconnected.Load(),
+		Users:        make(map[string]SubscriberStats, len(b.users)),
+	}
+	for userID, sub := range b.users {
+		s := sub.stats()
+		stats.Users[userID] = s
+		stats.Delivered += s.Delivered
+		stats.Dropped += s.Dropped
+	}
+	return stats
+}
+
+// route fans a message out to its recipients
+func (b *Broker) route(msg Message) {
+	switch msg.Kind {
+	case KindPresence:
+		b.applyPresence(msg)
+	case KindReadReceipt:
+		b.recordReceipt(msg)
+	}
+
+	var recipients []*subscriber
+	b.usersMutex.RLock()
+	switch {
+	case msg.Kind == KindPresence:
+		for _, sub := range b.users {
+			if sub.opts.PresenceEvents && sub.userID != msg.Sender {
+				recipients = append(recipients, sub)
+			}
+		}
+	case msg.Room != "":
+		for _, userID := range b.recordRoomMessage(msg) {
+			if sub, ok := b.users[userID]; ok && !(msg.Kind == KindTyping && userID == msg.Sender) {
+				recipients = append(recipients, sub)
+			}
+		}
+	case msg.Broadcast:
+		for _, sub := range b.users {
+			recipients = append(recipients, sub)
+		}
+	default:
+		if sub, ok := b.users[msg.Recipient]; ok {
+			recipients = append(recipients, sub)
+		}
+	}
+	b.usersMutex.RUnlock()
+
+	for _, sub := range recipients {
+		b.deliver(sub, msg)
+	}
+}
+
+// isDirect reports whether a message is addressed to its recipient alone
+func (msg Message) isDirect() bool {
+	return msg.Room == "" && !msg.Broadcast && msg.Kind != KindPresence
+}
+
+// deliver queues a message for a registered user according to its policy
+func (b *Broker) deliver(sub *subscriber, msg Message) {
+	if sub.enqueue(msg) {
+		return
+	}
+	b.usersMutex.Lock()
+	current := b.users[sub.userID] == sub
+	if current {
+		b.retireLocked(sub)
+		b.disconnected.Add(1)
+	}
+	b.usersMutex.Unlock()
+
+	if current {
+		b.publishPresence(sub.userID, false)
+		b.announcePresence(b.updatePresence(sub.userID, StatusOffline, time.Now().Unix()))
+	}
 }
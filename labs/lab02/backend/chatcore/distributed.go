@@ -0,0 +1,207 @@
+package chatcore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// TransportTopic is the pub/sub topic brokers exchange events on
+const TransportTopic = "chatcore.events"
+
+// seenCapacity is the number of remote message keys remembered for de-duplication
+const seenCapacity = 4096
+
+// Transport connects broker instances through a pub/sub backend,
+// see the pubsub package for implementations
+type Transport interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	Subscribe(ctx context.Context, topic string) (<-chan []byte, error)
+}
+
+// Event kinds exchanged between nodes
+const (
+	eventMessage      = "message"
+	eventPresence     = "presence"
+	eventPresenceSync = "presence-sync" // asks every node to announce its users
+)
+
+// envelope is the wire format of events between nodes
+type envelope struct {
+	Kind    string   `json:"kind"`
+	Node    string   `json:"node"`
+	Boot    string   `json:"boot"` // random per start of the node, message IDs restart with it
+	Message *Message `json:"message,omitempty"`
+	UserID  string   `json:"user_id,omitempty"`
+	Online  bool     `json:"online,omitempty"`
+}
+
+// seenSet remembers the most recent message keys
+type seenSet struct {
+	keys map[string]struct{}
+	ring []string
+	next int
+}
+
+func newSeenSet(capacity int) *seenSet {
+	return &seenSet{keys: make(map[string]struct{}, capacity), ring: make([]string, capacity)}
+}
+
+// add records key and reports whether it was new
+func (s *seenSet) add(key string) bool {
+	if _, ok := s.keys[key]; ok {
+		return false
+	}
+	if old := s.ring[s.next]; old != "" {
+		delete(s.keys, old)
+	}
+	s.ring[s.next] = key
+	s.next = (s.next + 1) % len(s.ring)
+	s.keys[key] = struct{}{}
+	return true
+}
+
+// SetTransport connects the broker to other nodes, it must be called before Run.
+// Messages sent through this broker are published to the other nodes, which
+// deliver them to their local users, and user registrations are announced so
+// OnlineUsers covers the whole cluster. nodeID must be unique per broker.
+// Room membership is not replicated: a room message reaches the members of a
+// room with the same name on every node. With more than one node the offline
+// store must be shared, only the node that accepted a message stores it and
+// any node can acknowledge it by its Key.
+func (b *Broker) SetTransport(t Transport, nodeID string) error {
+	events, err := t.Subscribe(b.ctx, TransportTopic)
+	if err != nil {
+		return err
+	}
+	b.transport = t
+	b.nodeID = nodeID
+	b.bootID = newBootID()
+	b.remoteUsers = make(map[string]map[string]bool)
+
+	go b.receiveRemote(events)
+	b.publish(envelope{Kind: eventPresenceSync})
+	return nil
+}
+
+// OnlineUsers lists users registered on this node or, with a transport, on any node
+func (b *Broker) OnlineUsers() []string {
+	online := make(map[string]bool)
+	b.usersMutex.RLock()
+	for userID := range b.users {
+		online[userID] = true
+	}
+	b.usersMutex.RUnlock()
+
+	b.remoteMutex.RLock()
+	for userID := range b.remoteUsers {
+		online[userID] = true
+	}
+	b.remoteMutex.RUnlock()
+
+	users := make([]string, 0, len(online))
+	for userID := range online {
+		users = append(users, userID)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// IsOnline reports whether userID is registered on any known node
+func (b *Broker) IsOnline(userID string) bool {
+	b.usersMutex.RLock()
+	_, ok := b.users[userID]
+	b.usersMutex.RUnlock()
+	if ok {
+		return true
+	}
+
+	b.remoteMutex.RLock()
+	defer b.remoteMutex.RUnlock()
+	return len(b.remoteUsers[userID]) > 0
+}
+
+// publish sends an event to the other nodes, errors are dropped because
+// delivery to local users must not depend on the backend
+func (b *Broker) publish(env envelope) {
+	if b.transport == nil {
+		return
+	}
+	env.Node = b.nodeID
+	env.Boot = b.bootID
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return
+	}
+	b.transport.Publish(b.ctx, TransportTopic, payload)
+}
+
+// publishPresence announces a local registration change
+func (b *Broker) publishPresence(userID string, online bool) {
+	b.publish(envelope{Kind: eventPresence, UserID: userID, Online: online})
+}
+
+// receiveRemote applies events from other nodes until the subscription ends
+func (b *Broker) receiveRemote(events <-chan []byte) {
+	seen := newSeenSet(seenCapacity)
+	for payload := range events {
+		var env envelope
+		if err := json.Unmarshal(payload, &env); err != nil || env.Node == b.nodeID {
+			continue
+		}
+
+		switch env.Kind {
+		case eventMessage:
+			// Only the origin publishes a message, so its boot ID is env.Boot
+			if env.Message == nil || !seen.add(env.Message.Origin+"/"+env.Boot+"/"+strconv.FormatUint(env.Message.ID, 10)) {
+				continue
+			}
+			select {
+			case b.input <- *env.Message:
+			case <-b.ctx.Done():
+				return
+			}
+		case eventPresence:
+			b.setRemotePresence(env.Node, env.UserID, env.Online)
+		case eventPresenceSync:
+			b.usersMutex.RLock()
+			local := make([]string, 0, len(b.users))
+			for userID := range b.users {
+				local = append(local, userID)
+			}
+			b.usersMutex.RUnlock()
+			for _, userID := range local {
+				b.publishPresence(userID, true)
+			}
+		}
+	}
+}
+
+// newBootID returns a random ID that tells apart runs of the same node
+func newBootID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (b *Broker) setRemotePresence(node, userID string, online bool) {
+	b.remoteMutex.Lock()
+	defer b.remoteMutex.Unlock()
+
+	nodes := b.remoteUsers[userID]
+	if online {
+		if nodes == nil {
+			nodes = make(map[string]bool)
+			b.remoteUsers[userID] = nodes
+		}
+		nodes[node] = true
+		return
+	}
+	delete(nodes, node)
+	if len(nodes) == 0 {
+		delete(b.remoteUsers, userID)
+	}
+}
@@ -0,0 +1,148 @@
+package chatcore
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"lab02/pubsub"
+)
+
+func newNode(t *testing.T, ctx context.Context, transport Transport, nodeID string) *Broker {
+	t.Helper()
+	broker := NewBroker(ctx)
+	if err := broker.SetTransport(transport, nodeID); err != nil {
+		t.Fatalf("SetTransport failed: %v", err)
+	}
+	go broker.Run()
+	return broker
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDistributedBrokers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := pubsub.NewMemoryBus()
+	defer bus.Close()
+
+	node1 := newNode(t, ctx, bus, "node1")
+	a := newTestUser("A")
+	node1.RegisterUser(a.ID, a.Recv)
+
+	// node2 joins later and learns about A through a presence sync
+	node2 := newNode(t, ctx, bus, "node2")
+	b := newTestUser("B")
+	node2.RegisterUser(b.ID, b.Recv)
+
+	waitFor(t, func() bool { return node2.IsOnline("A") && node1.IsOnline("B") })
+	if users := node1.OnlineUsers(); len(users) != 2 {
+		t.Errorf("Expected 2 online users, got %v", users)
+	}
+
+	if err := node1.SendMessage(Message{Sender: a.ID, Content: "hello cluster", Broadcast: true}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	for _, u := range []*testUser{a, b} {
+		if m := receive(t, u); m.Content != "hello cluster" || m.Origin != "node1" {
+			t.Errorf("%s got wrong message: %+v", u.ID, m)
+		}
+	}
+
+	if err := node2.SendMessage(Message{Sender: b.ID, Recipient: a.ID, Content: "hi A"}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if m := receive(t, a); m.Content != "hi A" {
+		t.Errorf("A got wrong message: %+v", m)
+	}
+
+	node2.UnregisterUser(b.ID)
+	waitFor(t, func() bool { return !node1.IsOnline("B") })
+}
+
+func TestDistributedDeduplication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := pubsub.NewMemoryBus()
+	defer bus.Close()
+
+	node := newNode(t, ctx, bus, "node1")
+	a := newTestUser("A")
+	node.RegisterUser(a.ID, a.Recv)
+
+	// The backend delivers the same event twice
+	payload, _ := json.Marshal(envelope{
+		Kind:    eventMessage,
+		Node:    "node2",
+		Message: &Message{ID: 7, Origin: "node2", Sender: "B", Content: "once", Broadcast: true},
+	})
+	bus.Publish(ctx, TransportTopic, payload)
+	bus.Publish(ctx, TransportTopic, payload)
+
+	if m := receive(t, a); m.Content != "once" {
+		t.Errorf("A got wrong message: %+v", m)
+	}
+	select {
+	case m := <-a.Recv:
+		t.Errorf("Duplicate message delivered: %+v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// node2 restarted, its message IDs start over
+	payload, _ = json.Marshal(envelope{
+		Kind:    eventMessage,
+		Node:    "node2",
+		Boot:    "second-run",
+		Message: &Message{ID: 7, Origin: "node2", Sender: "B", Content: "after restart", Broadcast: true},
+	})
+	bus.Publish(ctx, TransportTopic, payload)
+	if m := receive(t, a); m.Content != "after restart" {
+		t.Errorf("A got wrong message: %+v", m)
+	}
+}
+
+func TestDistributedNodeRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := pubsub.NewMemoryBus()
+	defer bus.Close()
+
+	node1 := newNode(t, ctx, bus, "node1")
+	a := newTestUser("A")
+	node1.RegisterUser(a.ID, a.Recv)
+
+	firstCtx, stopFirst := context.WithCancel(ctx)
+	node2 := newNode(t, firstCtx, bus, "node2")
+	node2.SendMessage(Message{Sender: "B", Recipient: a.ID, Content: "first run"})
+	if m := receive(t, a); m.Content != "first run" {
+		t.Fatalf("A got wrong message: %+v", m)
+	}
+	stopFirst()
+
+	node2 = newNode(t, ctx, bus, "node2")
+	node2.SendMessage(Message{Sender: "B", Recipient: a.ID, Content: "second run"})
+	if m := receive(t, a); m.Content != "second run" || m.ID != 1 {
+		t.Errorf("Expected the restarted node's first message, got %+v", m)
+	}
+}
+
+func TestSeenSetEviction(t *testing.T) {
+	seen := newSeenSet(2)
+	if !seen.add("a") || !seen.add("b") || seen.add("a") {
+		t.Fatal("unexpected de-duplication result")
+	}
+	seen.add("c")
+	if !seen.add("a") {
+		t.Error("expected oldest key to be evicted")
+	}
+}
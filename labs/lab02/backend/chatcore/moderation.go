@@ -0,0 +1,13 @@
+package chatcore
+
+import "lab02/moderation"
+
+// SetModerator runs every chat message passed to SendMessage through the
+// pipeline, it must be called before Run. Rejected messages make SendMessage
+// return a *moderation.RejectedError, masked messages are delivered with the
+// rewritten content, and flagged messages are delivered and queued for review
+// under their MessageRef. Presence, typing, read receipts and encrypted
+// messages are not moderated.
+func (b *Broker) SetModerator(p *moderation.Pipeline) {
+	b.moderator = p
+}
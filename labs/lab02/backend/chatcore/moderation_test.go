@@ -0,0 +1,48 @@
+package chatcore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"lab02/moderation"
+)
+
+func TestModeration(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	pipeline := moderation.NewPipeline(
+		moderation.WordList(moderation.Mask, "darn"),
+		moderation.LinkPolicy(moderation.Flag),
+	)
+	broker.SetModerator(pipeline)
+	go broker.Run()
+
+	a, b := newTestUser("A"), newTestUser("B")
+	broker.RegisterUser(a.ID, a.Recv)
+	broker.RegisterUser(b.ID, b.Recv)
+
+	broker.SendMessage(Message{Sender: a.ID, Recipient: b.ID, Content: "darn printer"})
+	if m := receive(t, b); m.Content != "**** printer" {
+		t.Errorf("Expected masked content, got %q", m.Content)
+	}
+
+	broker.SendMessage(Message{Sender: a.ID, Recipient: b.ID, Content: "see www.example.com"})
+	flagged := receive(t, b)
+	pending := pipeline.Queue().List(moderation.StatusPending)
+	if len(pending) != 1 || pending[0].Ref != flagged.Key().String() {
+		t.Errorf("Expected flagged message %s in review queue, got %+v", flagged.Key(), pending)
+	}
+
+	pipeline.Restrictions().Ban(a.ID)
+	err := broker.SendMessage(Message{Sender: a.ID, Recipient: b.ID, Content: "hello"})
+	if !errors.Is(err, moderation.ErrRejected) {
+		t.Errorf("Expected rejection for banned user, got %v", err)
+	}
+
+	// Typing indicators skip moderation
+	if err := broker.SendTyping(a.ID, b.ID, ""); err != nil {
+		t.Errorf("SendTyping failed: %v", err)
+	}
+}
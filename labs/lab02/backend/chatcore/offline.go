@@ -0,0 +1,152 @@
+package chatcore
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrMessageNotPending is returned when acknowledging an unknown message
+var ErrMessageNotPending = errors.New("message is not pending for this user")
+
+// OfflineStore keeps direct messages until their recipient acknowledges them.
+// Messages are identified by their Key, IDs alone are only unique per origin
+// node and a store may be shared by several nodes. Implementations must be
+// safe for concurrent use.
+type OfflineStore interface {
+	// Put stores a message for its recipient, a message already pending is kept once
+	Put(recipient string, msg Message) error
+	// Pending returns the unacknowledged messages of a recipient in send order
+	Pending(recipient string) ([]Message, error)
+	// Ack removes a delivered message
+	Ack(recipient string, ref MessageRef) error
+}
+
+// MemoryOfflineStore is an OfflineStore bounded per recipient by size and age
+type MemoryOfflineStore struct {
+	mutex      sync.Mutex
+	queues     map[string][]Message
+	maxPerUser int
+	ttl        time.Duration
+	now        func() time.Time
+}
+
+// NewMemoryOfflineStore creates a store keeping at most maxPerUser messages per
+// recipient for at most ttl, zero values mean unlimited. When a queue is full
+// the oldest message is discarded.
+func NewMemoryOfflineStore(maxPerUser int, ttl time.Duration) *MemoryOfflineStore {
+	return &MemoryOfflineStore{
+		queues:     make(map[string][]Message),
+		maxPerUser: maxPerUser,
+		ttl:        ttl,
+		now:        time.Now,
+	}
+}
+
+// Put stores a message for its recipient, a message already pending is kept once
+func (s *MemoryOfflineStore) Put(recipient string, msg Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	queue := s.expireLocked(recipient)
+	if indexOf(queue, msg.Key()) >= 0 {
+		return nil
+	}
+	queue = append(queue, msg)
+	if s.maxPerUser > 0 && len(queue) > s.maxPerUser {
+		queue = append([]Message(nil), queue[len(queue)-s.maxPerUser:]...)
+	}
+	s.queues[recipient] = queue
+	return nil
+}
+
+// Pending returns the unacknowledged messages of a recipient in send order
+func (s *MemoryOfflineStore) Pending(recipient string) ([]Message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Message(nil), s.expireLocked(recipient)...), nil
+}
+
+// Ack removes a delivered message
+func (s *MemoryOfflineStore) Ack(recipient string, ref MessageRef) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	queue := s.queues[recipient]
+	i := indexOf(queue, ref)
+	if i < 0 {
+		return ErrMessageNotPending
+	}
+	queue = append(queue[:i:i], queue[i+1:]...)
+	if len(queue) == 0 {
+		delete(s.queues, recipient)
+	} else {
+		s.queues[recipient] = queue
+	}
+	return nil
+}
+
+// indexOf returns the position of a message in queue, or -1
+func indexOf(queue []Message, ref MessageRef) int {
+	for i, msg := range queue {
+		if msg.Key() == ref {
+			return i
+		}
+	}
+	return -1
+}
+
+// expireLocked drops messages older than the TTL and returns the remaining queue
+func (s *MemoryOfflineStore) expireLocked(recipient string) []Message {
+	queue := s.queues[recipient]
+	if s.ttl <= 0 {
+		return queue
+	}
+	cutoff := s.now().Add(-s.ttl).Unix()
+	i := 0
+	for i < len(queue) && queue[i].Timestamp < cutoff {
+		i++
+	}
+	if i == len(queue) {
+		delete(s.queues, recipient)
+		return nil
+	}
+	queue = queue[i:]
+	s.queues[recipient] = queue
+	return queue
+}
+
+// SetOfflineStore enables store-and-forward of direct messages, it must be
+// called before Run. Direct messages stay in the store until the recipient
+// calls Ack and are delivered again, in order, each time the recipient registers,
+// so clients must ignore message keys they have already seen.
+//
+// A store that survives restarts may implement LastID() uint64, message IDs
+// then continue after the highest one it has seen.
+func (b *Broker) SetOfflineStore(store OfflineStore) {
+	b.offline = store
+	if s, ok := store.(interface{ LastID() uint64 }); ok && s.LastID() > b.lastID.Load() {
+		b.lastID.Store(s.LastID())
+	}
+}
+
+// Ack confirms that userID has received a direct message, identified by its
+// Key since IDs from different nodes may be equal
+func (b *Broker) Ack(userID string, ref MessageRef) error {
+	if b.offline == nil {
+		return nil
+	}
+	return b.offline.Ack(userID, ref)
+}
+
+// pendingFor loads the messages to replay when a user registers
+func (b *Broker) pendingFor(userID string) []Message {
+	if b.offline == nil {
+		return nil
+	}
+	pending, err := b.offline.Pending(userID)
+	if err != nil {
+		return nil
+	}
+	return pending
+}
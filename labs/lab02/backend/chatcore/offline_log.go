@@ -0,0 +1,154 @@
+package chatcore
+
+import (
+	"sync"
+	"time"
+
+	"lab02/recordlog"
+)
+
+// defaultOfflineCompactThreshold is the minimum number of records in the log
+// beyond the pending messages before it gets rewritten
+const defaultOfflineCompactThreshold = 1024
+
+// offlineRecord is one entry of the offline log
+type offlineRecord struct {
+	Op        string   `json:"op"` // "put", "ack" or "last_id"
+	Recipient string   `json:"recipient,omitempty"`
+	Message   *Message `json:"message,omitempty"`
+	Origin    string   `json:"origin,omitempty"` // of the acknowledged message
+	ID        uint64   `json:"id,omitempty"`
+}
+
+// FileOfflineStore is an OfflineStore that survives restarts. Every Put and
+// Ack is appended to a log and synced before it returns, the log is replayed
+// on open and rewritten once most of it is acknowledged.
+type FileOfflineStore struct {
+	mem *MemoryOfflineStore
+
+	mutex            sync.Mutex // serializes writes to the log
+	log              *recordlog.Log[offlineRecord]
+	nextCompact      int    // records at which compaction is next considered
+	lastID           uint64 // highest message ID ever stored
+	compactThreshold int
+}
+
+// OpenFileOfflineStore opens the log at path, creating it if needed. The
+// limits are those of NewMemoryOfflineStore. A record torn by a crash is
+// discarded.
+func OpenFileOfflineStore(path string, maxPerUser int, ttl time.Duration) (*FileOfflineStore, error) {
+	s := &FileOfflineStore{
+		mem:              NewMemoryOfflineStore(maxPerUser, ttl),
+		compactThreshold: defaultOfflineCompactThreshold,
+	}
+	log, err := recordlog.Open(path, s.apply)
+	if err != nil {
+		return nil, err
+	}
+	s.log = log
+	return s, nil
+}
+
+// apply replays one record into memory
+func (s *FileOfflineStore) apply(rec offlineRecord) {
+	switch rec.Op {
+	case "put":
+		if rec.Message != nil {
+			s.mem.Put(rec.Recipient, *rec.Message)
+			s.lastID = max(s.lastID, rec.Message.ID)
+		}
+	case "ack":
+		s.mem.Ack(rec.Recipient, MessageRef{Origin: rec.Origin, ID: rec.ID})
+	case "last_id":
+		s.lastID = max(s.lastID, rec.ID)
+	}
+}
+
+// Put stores a message for its recipient, a message already pending is kept once
+func (s *FileOfflineStore) Put(recipient string, msg Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.mem.isPending(recipient, msg.Key()) {
+		return nil
+	}
+	if err := s.log.Append(offlineRecord{Op: "put", Recipient: recipient, Message: &msg}); err != nil {
+		return err
+	}
+	s.lastID = max(s.lastID, msg.ID)
+	s.mem.Put(recipient, msg)
+	s.compactIfNeeded()
+	return nil
+}
+
+// Pending returns the unacknowledged messages of a recipient in send order
+func (s *FileOfflineStore) Pending(recipient string) ([]Message, error) {
+	return s.mem.Pending(recipient)
+}
+
+// Ack removes a delivered message. If the ack cannot be written the message
+// is delivered again after a restart.
+func (s *FileOfflineStore) Ack(recipient string, ref MessageRef) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.mem.Ack(recipient, ref); err != nil {
+		return err
+	}
+	if err := s.log.Append(offlineRecord{Op: "ack", Recipient: recipient, Origin: ref.Origin, ID: ref.ID}); err != nil {
+		return err
+	}
+	s.compactIfNeeded()
+	return nil
+}
+
+// LastID returns the highest message ID ever stored, the broker continues
+// numbering after it so a restarted node does not reuse the key of a pending
+// message
+func (s *FileOfflineStore) LastID() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastID
+}
+
+// Close releases the log file
+func (s *FileOfflineStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.log.Close()
+}
+
+// compactIfNeeded rewrites the log with only the pending messages once enough
+// records are obsolete. Failures are ignored, the old log is still valid.
+// The caller must hold mutex.
+func (s *FileOfflineStore) compactIfNeeded() {
+	records := s.log.Records()
+	if records < s.nextCompact {
+		return
+	}
+	if pending := s.mem.snapshot(); records-len(pending) >= s.compactThreshold {
+		s.log.Rewrite(append([]offlineRecord{{Op: "last_id", ID: s.lastID}}, pending...))
+	}
+	s.nextCompact = s.log.Records() + s.compactThreshold
+}
+
+// isPending reports whether a message is stored for recipient
+func (s *MemoryOfflineStore) isPending(recipient string, ref MessageRef) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return indexOf(s.expireLocked(recipient), ref) >= 0
+}
+
+// snapshot returns a put record for every pending message
+func (s *MemoryOfflineStore) snapshot() []offlineRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var recs []offlineRecord
+	for recipient := range s.queues {
+		for _, msg := range s.expireLocked(recipient) {
+			recs = append(recs, offlineRecord{Op: "put", Recipient: recipient, Message: &msg})
+		}
+	}
+	return recs
+}
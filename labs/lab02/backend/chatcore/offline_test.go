@@ -0,0 +1,323 @@
+package chatcore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lab02/pubsub"
+)
+
+func receive(t *testing.T, u *testUser) Message {
+	t.Helper()
+	select {
+	case m := <-u.Recv:
+		return m
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("%s did not receive a message", u.ID)
+		return Message{}
+	}
+}
+
+func TestOfflineDelivery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	broker.SetOfflineStore(NewMemoryOfflineStore(10, time.Hour))
+	go broker.Run()
+
+	for _, content := range []string{"first", "second", "third"} {
+		if err := broker.SendMessage(Message{Sender: "A", Recipient: "B", Content: content}); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	b := newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+	var got []Message
+	for i := 0; i < 3; i++ {
+		got = append(got, receive(t, b))
+	}
+	if got[0].Content != "first" || got[1].Content != "second" || got[2].Content != "third" {
+		t.Fatalf("Messages out of order: %+v", got)
+	}
+
+	// Only acknowledged messages are removed
+	broker.Ack(b.ID, got[0].Key())
+	broker.Ack(b.ID, got[1].Key())
+	if err := broker.Ack(b.ID, got[1].Key()); err != ErrMessageNotPending {
+		t.Errorf("Expected ErrMessageNotPending, got %v", err)
+	}
+
+	broker.UnregisterUser(b.ID)
+	b = newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+	if m := receive(t, b); m.ID != got[2].ID {
+		t.Errorf("Expected redelivery of unacknowledged message, got %+v", m)
+	}
+
+	// Online delivery keeps the message pending until acknowledged as well
+	broker.SendMessage(Message{Sender: "A", Recipient: "B", Content: "live"})
+	live := receive(t, b)
+	if live.Content != "live" {
+		t.Errorf("Expected live message, got %+v", live)
+	}
+	if err := broker.Ack(b.ID, live.Key()); err != nil {
+		t.Errorf("Ack of live message failed: %v", err)
+	}
+}
+
+func TestMemoryOfflineStoreLimits(t *testing.T) {
+	store := NewMemoryOfflineStore(2, time.Minute)
+	now := time.Unix(1000, 0)
+	store.now = func() time.Time { return now }
+
+	store.Put("B", Message{ID: 1, Timestamp: 900})
+	store.Put("B", Message{ID: 2, Timestamp: 950})
+	store.Put("B", Message{ID: 3, Timestamp: 960})
+	store.Put("B", Message{ID: 4, Timestamp: 1010})
+
+	pending, _ := store.Pending("B")
+	if len(pending) != 2 || pending[0].ID != 3 || pending[1].ID != 4 {
+		t.Fatalf("Expected messages 3 and 4, got %+v", pending)
+	}
+
+	now = now.Add(time.Minute)
+	pending, _ = store.Pending("B")
+	if len(pending) != 1 || pending[0].ID != 4 {
+		t.Fatalf("Expected expired messages to be removed, got %+v", pending)
+	}
+}
+
+func TestFileOfflineStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offline.log")
+	ctx, cancel := context.WithCancel(context.Background())
+	store, err := OpenFileOfflineStore(path, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenFileOfflineStore failed: %v", err)
+	}
+	broker := NewBroker(ctx)
+	broker.SetOfflineStore(store)
+	go broker.Run()
+
+	for _, content := range []string{"first", "second"} {
+		broker.SendMessage(Message{Sender: "A", Recipient: "B", Content: content})
+	}
+	b := newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+	first := receive(t, b)
+	receive(t, b)
+	broker.Ack(b.ID, first.Key())
+	cancel()
+	<-broker.Done()
+	store.Close()
+
+	// After a restart only the unacknowledged message is pending, and new
+	// messages do not reuse its ID
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	store, err = OpenFileOfflineStore(path, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer store.Close()
+	broker = NewBroker(ctx)
+	broker.SetOfflineStore(store)
+	go broker.Run()
+
+	broker.SendMessage(Message{Sender: "A", Recipient: "B", Content: "third"})
+	time.Sleep(50 * time.Millisecond)
+	b = newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+	second, third := receive(t, b), receive(t, b)
+	if second.Content != "second" || third.Content != "third" || third.ID <= second.ID {
+		t.Fatalf("Unexpected messages after restart: %+v %+v", second, third)
+	}
+	select {
+	case m := <-b.Recv:
+		t.Errorf("Unexpected duplicate %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFileOfflineStoreRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offline.log")
+	store, _ := OpenFileOfflineStore(path, 0, 0)
+	store.compactThreshold = 4
+	for id := uint64(1); id <= 10; id++ {
+		store.Put("B", Message{ID: id, Content: "msg"})
+		if id > 1 {
+			store.Ack("B", MessageRef{ID: id - 1})
+		}
+	}
+	if store.log.Records() > 2+4+1 {
+		t.Errorf("Expected the log to be compacted, has %d records", store.log.Records())
+	}
+	store.Put("B", Message{ID: 11, Content: "torn"})
+	store.Close()
+
+	// A crash in the middle of the last record loses only that record
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenFileOfflineStore(path, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenFileOfflineStore failed: %v", err)
+	}
+	pending, _ := store.Pending("B")
+	if len(pending) != 1 || pending[0].ID != 10 || store.LastID() != 10 {
+		t.Fatalf("Expected message 10 to be pending, got %+v (last ID %d)", pending, store.LastID())
+	}
+	store.Put("B", Message{ID: 11, Content: "after crash"})
+	store.Close()
+	store, _ = OpenFileOfflineStore(path, 0, 0)
+	defer store.Close()
+	if pending, _ := store.Pending("B"); len(pending) != 2 || pending[1].Content != "after crash" {
+		t.Errorf("Unexpected messages %+v", pending)
+	}
+
+	// Damage before the last record is not mistaken for a crash
+	data, _ := os.ReadFile(path)
+	data[10] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+	if _, err := OpenFileOfflineStore(path, 0, 0); err == nil {
+		t.Errorf("Expected a corrupt log to be rejected")
+	}
+}
+
+func TestSharedOfflineStoreAcrossNodes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := pubsub.NewMemoryBus()
+	defer bus.Close()
+	path := filepath.Join(t.TempDir(), "offline.log")
+	store, err := OpenFileOfflineStore(path, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenFileOfflineStore failed: %v", err)
+	}
+
+	var nodes []*Broker
+	for _, nodeID := range []string{"node1", "node2"} {
+		broker := NewBroker(ctx)
+		broker.SetOfflineStore(store)
+		if err := broker.SetTransport(bus, nodeID); err != nil {
+			t.Fatalf("SetTransport failed: %v", err)
+		}
+		go broker.Run()
+		nodes = append(nodes, broker)
+	}
+	// Both nodes number their messages from 1
+	for i, content := range []string{"via node1", "via node2"} {
+		if err := nodes[i].SendMessage(Message{Sender: "A", Recipient: "B", Content: content}); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	b := newTestUser("B")
+	nodes[0].RegisterUser(b.ID, b.Recv)
+	first, second := receive(t, b), receive(t, b)
+	if first.ID != second.ID || first.Origin != "node1" || second.Origin != "node2" {
+		t.Fatalf("Expected the same ID from both nodes, got %+v and %+v", first, second)
+	}
+	select {
+	case m := <-b.Recv:
+		t.Errorf("Unexpected duplicate %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Acknowledging one message leaves the other with the same ID pending
+	if err := nodes[0].Ack(b.ID, first.Key()); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	if err := nodes[1].Ack(b.ID, first.Key()); err != ErrMessageNotPending {
+		t.Errorf("Expected ErrMessageNotPending, got %v", err)
+	}
+	store.Close()
+	store, err = OpenFileOfflineStore(path, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer store.Close()
+	if pending, _ := store.Pending("B"); len(pending) != 1 || pending[0].Key() != second.Key() {
+		t.Errorf("Expected only the message via node2 to be pending, got %+v", pending)
+	}
+}
+
+// blockingOfflineStore holds every Put until released
+type blockingOfflineStore struct {
+	*MemoryOfflineStore
+	release chan struct{}
+}
+
+func (s *blockingOfflineStore) Put(recipient string, msg Message) error {
+	<-s.release
+	return s.MemoryOfflineStore.Put(recipient, msg)
+}
+
+func TestSlowOfflineStoreDoesNotBlockRegistration(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := &blockingOfflineStore{NewMemoryOfflineStore(10, time.Hour), make(chan struct{})}
+	broker := NewBroker(ctx)
+	broker.SetOfflineStore(store)
+	go broker.Run()
+
+	go broker.SendMessage(Message{Sender: "A", Recipient: "B", Content: "slow"})
+	time.Sleep(20 * time.Millisecond)
+
+	registered := make(chan struct{})
+	go func() {
+		c := newTestUser("C")
+		broker.RegisterUser(c.ID, c.Recv)
+		broker.Stats()
+		close(registered)
+	}()
+	select {
+	case <-registered:
+	case <-time.After(time.Second):
+		t.Fatal("RegisterUser waited for the offline store")
+	}
+	close(store.release)
+
+	b := newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+	if m := receive(t, b); m.Content != "slow" {
+		t.Errorf("Unexpected message %+v", m)
+	}
+}
+
+// failingOfflineStore fails every Put
+type failingOfflineStore struct {
+	*MemoryOfflineStore
+}
+
+func (s failingOfflineStore) Put(recipient string, msg Message) error {
+	return errors.New("disk full")
+}
+
+func TestSendMessageReportsOfflineStoreError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	broker.SetOfflineStore(failingOfflineStore{NewMemoryOfflineStore(10, time.Hour)})
+	go broker.Run()
+
+	b := newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+	if err := broker.SendMessage(Message{Sender: "A", Recipient: "B", Content: "lost"}); err == nil || err.Error() != "disk full" {
+		t.Fatalf("Expected the store error, got %v", err)
+	}
+	// Messages that are not stored are delivered as before
+	if err := broker.SendMessage(Message{Sender: "A", Broadcast: true, Content: "to all"}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if m := receive(t, b); m.Content != "to all" {
+		t.Errorf("Expected only the broadcast, got %+v", m)
+	}
+}
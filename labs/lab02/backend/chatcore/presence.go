@@ -0,0 +1,197 @@
+package chatcore
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MessageKind distinguishes chat messages from ephemeral and status events
+type MessageKind string
+
+// Message kinds
+const (
+	KindChat        MessageKind = ""
+	KindPresence    MessageKind = "presence" // Sender changed status, Content holds the Status
+	KindTyping      MessageKind = "typing"   // Sender is typing to Recipient or Room, never stored
+	KindReadReceipt MessageKind = "read"     // Sender has read the message in Ref
+	KindEncrypted   MessageKind = "e2ee"     // Content is an end-to-end encrypted envelope for Recipient
+)
+
+// Status is the presence state of a user
+type Status string
+
+// Presence states
+const (
+	StatusOnline  Status = "online"
+	StatusAway    Status = "away"
+	StatusOffline Status = "offline"
+)
+
+// maxTrackedReceipts bounds the number of messages read receipts are kept for
+const maxTrackedReceipts = 10000
+
+// Presence errors
+var (
+	ErrInvalidStatus = errors.New("status must be online or away")
+	ErrUserOffline   = errors.New("user is not registered")
+)
+
+// ErrEncryptedNotDirect is returned for encrypted messages without a single recipient
+var ErrEncryptedNotDirect = errors.New("encrypted messages must be direct messages")
+
+// MessageRef identifies a message across nodes
+type MessageRef struct {
+	Origin string
+	ID     uint64
+}
+
+// Presence is the last known state of a user
+type Presence struct {
+	UserID   string
+	Status   Status
+	LastSeen int64 // Unix seconds of the last status change
+}
+
+// ReadReceipt records that a user has read a message
+type ReadReceipt struct {
+	UserID string
+	ReadAt int64
+}
+
+// String formats the reference as "origin/id", or just the ID without an origin
+func (r MessageRef) String() string {
+	if r.Origin == "" {
+		return strconv.FormatUint(r.ID, 10)
+	}
+	return r.Origin + "/" + strconv.FormatUint(r.ID, 10)
+}
+
+// Key returns the reference used by read receipts
+func (m Message) Key() MessageRef {
+	return MessageRef{Origin: m.Origin, ID: m.ID}
+}
+
+// SetStatus changes the presence of a registered user to online or away
+func (b *Broker) SetStatus(userID string, status Status) error {
+	if status != StatusOnline && status != StatusAway {
+		return ErrInvalidStatus
+	}
+	b.usersMutex.RLock()
+	_, ok := b.users[userID]
+	b.usersMutex.RUnlock()
+	if !ok {
+		return ErrUserOffline
+	}
+	b.announcePresence(b.updatePresence(userID, status, time.Now().Unix()))
+	return nil
+}
+
+// GetPresence returns the presence of a user, unknown users are offline
+func (b *Broker) GetPresence(userID string) Presence {
+	b.presenceMutex.RLock()
+	defer b.presenceMutex.RUnlock()
+
+	if p, ok := b.presence[userID]; ok {
+		return p
+	}
+	return Presence{UserID: userID, Status: StatusOffline}
+}
+
+// PresenceList returns the presence of every known user sorted by user ID
+func (b *Broker) PresenceList() []Presence {
+	b.presenceMutex.RLock()
+	defer b.presenceMutex.RUnlock()
+
+	list := make([]Presence, 0, len(b.presence))
+	for _, p := range b.presence {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UserID < list[j].UserID })
+	return list
+}
+
+// SendTyping notifies recipient, or the other members of room, that sender is typing
+func (b *Broker) SendTyping(sender, recipient, room string) error {
+	return b.SendMessage(Message{Kind: KindTyping, Sender: sender, Recipient: recipient, Room: room})
+}
+
+// MarkRead records that reader has read msg and sends a receipt to its sender
+func (b *Broker) MarkRead(reader string, msg Message) error {
+	return b.SendMessage(Message{
+		Kind:      KindReadReceipt,
+		Sender:    reader,
+		Recipient: msg.Sender,
+		Ref:       msg.Key(),
+	})
+}
+
+// ReadReceipts lists who has read a message, in the order they read it
+func (b *Broker) ReadReceipts(ref MessageRef) []ReadReceipt {
+	b.presenceMutex.RLock()
+	defer b.presenceMutex.RUnlock()
+	return append([]ReadReceipt(nil), b.receipts[ref]...)
+}
+
+// updatePresence stores a status change and returns the new presence
+func (b *Broker) updatePresence(userID string, status Status, at int64) Presence {
+	p := Presence{UserID: userID, Status: status, LastSeen: at}
+	b.presenceMutex.Lock()
+	b.presence[userID] = p
+	b.presenceMutex.Unlock()
+	return p
+}
+
+// announcePresence routes a presence event to subscribers that asked for them
+// and to other nodes. Announcements are best effort: the presence table is
+// already updated, so when the broker loop is busy the event is dropped
+// rather than blocking the caller.
+func (b *Broker) announcePresence(p Presence) {
+	msg := Message{
+		ID:        b.lastID.Add(1),
+		Origin:    b.nodeID,
+		Kind:      KindPresence,
+		Sender:    p.UserID,
+		Content:   string(p.Status),
+		Broadcast: true,
+		Timestamp: p.LastSeen,
+	}
+	select {
+	case b.input <- msg:
+	default:
+	}
+}
+
+// applyPresence updates the presence table from a routed presence event,
+// events from other nodes arrive this way
+func (b *Broker) applyPresence(msg Message) {
+	b.presenceMutex.Lock()
+	defer b.presenceMutex.Unlock()
+
+	if current, ok := b.presence[msg.Sender]; ok && current.LastSeen > msg.Timestamp {
+		return
+	}
+	b.presence[msg.Sender] = Presence{UserID: msg.Sender, Status: Status(msg.Content), LastSeen: msg.Timestamp}
+}
+
+// recordReceipt stores a read receipt once per reader
+func (b *Broker) recordReceipt(msg Message) {
+	b.presenceMutex.Lock()
+	defer b.presenceMutex.Unlock()
+
+	receipts, tracked := b.receipts[msg.Ref]
+	for _, r := range receipts {
+		if r.UserID == msg.Sender {
+			return
+		}
+	}
+	if !tracked {
+		b.receiptOrder = append(b.receiptOrder, msg.Ref)
+		if len(b.receiptOrder) > maxTrackedReceipts {
+			delete(b.receipts, b.receiptOrder[0])
+			b.receiptOrder = b.receiptOrder[1:]
+		}
+	}
+	b.receipts[msg.Ref] = append(receipts, ReadReceipt{UserID: msg.Sender, ReadAt: msg.Timestamp})
+}
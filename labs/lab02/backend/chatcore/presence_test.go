@@ -0,0 +1,132 @@
+package chatcore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPresenceEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	go broker.Run()
+
+	opts := DefaultSubscriberOptions
+	opts.PresenceEvents = true
+	watcher := newTestUser("W")
+	broker.RegisterUserWithOptions(watcher.ID, watcher.Recv, opts)
+
+	a := newTestUser("A")
+	broker.RegisterUser(a.ID, a.Recv)
+	if m := receive(t, watcher); m.Kind != KindPresence || m.Sender != a.ID || Status(m.Content) != StatusOnline {
+		t.Fatalf("Expected A online, got %+v", m)
+	}
+
+	if err := broker.SetStatus(a.ID, StatusAway); err != nil {
+		t.Fatalf("SetStatus failed: %v", err)
+	}
+	if m := receive(t, watcher); Status(m.Content) != StatusAway {
+		t.Fatalf("Expected A away, got %+v", m)
+	}
+	if p := broker.GetPresence(a.ID); p.Status != StatusAway || p.LastSeen == 0 {
+		t.Errorf("Unexpected presence %+v", p)
+	}
+	if err := broker.SetStatus(a.ID, StatusOffline); err != ErrInvalidStatus {
+		t.Errorf("Expected ErrInvalidStatus, got %v", err)
+	}
+	if err := broker.SetStatus("nobody", StatusAway); err != ErrUserOffline {
+		t.Errorf("Expected ErrUserOffline, got %v", err)
+	}
+
+	broker.UnregisterUser(a.ID)
+	if m := receive(t, watcher); Status(m.Content) != StatusOffline {
+		t.Fatalf("Expected A offline, got %+v", m)
+	}
+	if p := broker.GetPresence(a.ID); p.Status != StatusOffline {
+		t.Errorf("Expected offline presence, got %+v", p)
+	}
+	if list := broker.PresenceList(); len(list) != 2 || list[0].UserID != a.ID {
+		t.Errorf("Unexpected presence list %+v", list)
+	}
+
+	// Subscribers that did not opt in never see presence events
+	b := newTestUser("B")
+	broker.RegisterUser(b.ID, b.Recv)
+	broker.SetStatus(watcher.ID, StatusAway)
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case m := <-b.Recv:
+		t.Errorf("Unexpected message %+v", m)
+	default:
+	}
+}
+
+func TestTypingIsEphemeral(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	broker.SetOfflineStore(NewMemoryOfflineStore(10, time.Hour))
+	go broker.Run()
+
+	a, b := newTestUser("A"), newTestUser("B")
+	broker.RegisterUser(a.ID, a.Recv)
+	broker.RegisterUser(b.ID, b.Recv)
+	broker.CreateRoom("general", a.ID, false)
+	broker.JoinRoom("general", b.ID)
+
+	if err := broker.SendTyping(a.ID, "", "general"); err != nil {
+		t.Fatalf("SendTyping failed: %v", err)
+	}
+	if m := receive(t, b); m.Kind != KindTyping || m.Sender != a.ID {
+		t.Fatalf("Expected typing event, got %+v", m)
+	}
+	select {
+	case m := <-a.Recv:
+		t.Errorf("Sender received its own typing event %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if history, _ := broker.RoomHistory("general", a.ID); len(history) != 0 {
+		t.Errorf("Typing event stored in history: %+v", history)
+	}
+
+	// Typing to an offline user is not kept for later
+	broker.SendTyping(a.ID, "C", "")
+	time.Sleep(50 * time.Millisecond)
+	c := newTestUser("C")
+	broker.RegisterUser(c.ID, c.Recv)
+	select {
+	case m := <-c.Recv:
+		t.Errorf("Unexpected stored typing event %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReadReceipts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	go broker.Run()
+
+	a, b := newTestUser("A"), newTestUser("B")
+	broker.RegisterUser(a.ID, a.Recv)
+	broker.RegisterUser(b.ID, b.Recv)
+
+	broker.SendMessage(Message{Sender: a.ID, Recipient: b.ID, Content: "hello"})
+	msg := receive(t, b)
+	if err := broker.MarkRead(b.ID, msg); err != nil {
+		t.Fatalf("MarkRead failed: %v", err)
+	}
+	receipt := receive(t, a)
+	if receipt.Kind != KindReadReceipt || receipt.Sender != b.ID || receipt.Ref != msg.Key() {
+		t.Fatalf("Unexpected receipt %+v", receipt)
+	}
+
+	// Marking the same message twice keeps a single receipt
+	broker.MarkRead(b.ID, msg)
+	receive(t, a)
+	receipts := broker.ReadReceipts(msg.Key())
+	if len(receipts) != 1 || receipts[0].UserID != b.ID {
+		t.Errorf("Unexpected receipts %+v", receipts)
+	}
+}
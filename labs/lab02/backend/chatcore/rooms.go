@@ -0,0 +1,188 @@
+package chatcore
+
+import (
+	"errors"
+	"sort"
+)
+
+// maxRoomHistory is the number of messages kept per room
+const maxRoomHistory = 100
+
+// Room errors
+var (
+	ErrRoomExists    = errors.New("room already exists")
+	ErrRoomNotFound  = errors.New("room not found")
+	ErrNotRoomMember = errors.New("user is not a member of the room")
+	ErrNotInvited    = errors.New("user is not invited to the private room")
+)
+
+// room is a named channel users join to receive its messages
+type room struct {
+	name    string
+	private bool
+	members map[string]bool
+	invited map[string]bool // only used by private rooms
+	history []Message
+}
+
+// RoomInfo describes a room
+type RoomInfo struct {
+	Name    string
+	Private bool
+	Members int
+}
+
+// CreateRoom creates a room with owner as its first member. Anyone may join
+// a public room, private rooms require an invitation from a member.
+func (b *Broker) CreateRoom(name, owner string, private bool) error {
+	b.roomsMutex.Lock()
+	defer b.roomsMutex.Unlock()
+
+	if _, exists := b.rooms[name]; exists {
+		return ErrRoomExists
+	}
+	b.rooms[name] = &room{
+		name:    name,
+		private: private,
+		members: map[string]bool{owner: true},
+		invited: make(map[string]bool),
+	}
+	return nil
+}
+
+// InviteToRoom allows userID to join a private room, the inviter must be a member
+func (b *Broker) InviteToRoom(name, inviter, userID string) error {
+	b.roomsMutex.Lock()
+	defer b.roomsMutex.Unlock()
+
+	r, ok := b.rooms[name]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	if !r.members[inviter] {
+		return ErrNotRoomMember
+	}
+	r.invited[userID] = true
+	return nil
+}
+
+// JoinRoom adds userID to the room members
+func (b *Broker) JoinRoom(name, userID string) error {
+	b.roomsMutex.Lock()
+	defer b.roomsMutex.Unlock()
+
+	r, ok := b.rooms[name]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	if r.private && !r.members[userID] && !r.invited[userID] {
+		return ErrNotInvited
+	}
+	r.members[userID] = true
+	delete(r.invited, userID)
+	return nil
+}
+
+// LeaveRoom removes userID from the room members
+func (b *Broker) LeaveRoom(name, userID string) error {
+	b.roomsMutex.Lock()
+	defer b.roomsMutex.Unlock()
+
+	r, ok := b.rooms[name]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	if !r.members[userID] {
+		return ErrNotRoomMember
+	}
+	delete(r.members, userID)
+	return nil
+}
+
+// RoomMembers lists the members of a room in sorted order
+func (b *Broker) RoomMembers(name string) ([]string, error) {
+	b.roomsMutex.RLock()
+	defer b.roomsMutex.RUnlock()
+
+	r, ok := b.rooms[name]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	members := make([]string, 0, len(r.members))
+	for userID := range r.members {
+		members = append(members, userID)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// RoomHistory returns the most recent messages of a room, oldest first.
+// Only members can read the history of a private room.
+func (b *Broker) RoomHistory(name, userID string) ([]Message, error) {
+	b.roomsMutex.RLock()
+	defer b.roomsMutex.RUnlock()
+
+	r, ok := b.rooms[name]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	if r.private && !r.members[userID] {
+		return nil, ErrNotRoomMember
+	}
+	return append([]Message(nil), r.history...), nil
+}
+
+// Rooms lists the rooms visible to userID: every public room and the private
+// rooms the user belongs to or is invited to
+func (b *Broker) Rooms(userID string) []RoomInfo {
+	b.roomsMutex.RLock()
+	defer b.roomsMutex.RUnlock()
+
+	rooms := make([]RoomInfo, 0, len(b.rooms))
+	for _, r := range b.rooms {
+		if r.private && !r.members[userID] && !r.invited[userID] {
+			continue
+		}
+		rooms = append(rooms, RoomInfo{Name: r.name, Private: r.private, Members: len(r.members)})
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	return rooms
+}
+
+// checkRoomSender verifies that sender may post to the room
+func (b *Broker) checkRoomSender(name, sender string) error {
+	b.roomsMutex.RLock()
+	defer b.roomsMutex.RUnlock()
+
+	r, ok := b.rooms[name]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	if !r.members[sender] {
+		return ErrNotRoomMember
+	}
+	return nil
+}
+
+// recordRoomMessage appends chat messages to the room history and returns the members to deliver to
+func (b *Broker) recordRoomMessage(msg Message) []string {
+	b.roomsMutex.Lock()
+	defer b.roomsMutex.Unlock()
+
+	r, ok := b.rooms[msg.Room]
+	if !ok {
+		return nil
+	}
+	if msg.Kind == KindChat {
+		r.history = append(r.history, msg)
+		if len(r.history) > maxRoomHistory {
+			r.history = append([]Message(nil), r.history[len(r.history)-maxRoomHistory:]...)
+		}
+	}
+
+	members := make([]string, 0, len(r.members))
+	for userID := range r.members {
+		members = append(members, userID)
+	}
+	return members
+}
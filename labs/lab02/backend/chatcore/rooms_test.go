@@ -0,0 +1,107 @@
+package chatcore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRoomMessaging(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	go broker.Run()
+
+	a, b, c := newTestUser("A"), newTestUser("B"), newTestUser("C")
+	for _, u := range []*testUser{a, b, c} {
+		broker.RegisterUser(u.ID, u.Recv)
+	}
+
+	if err := broker.CreateRoom("general", a.ID, false); err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+	if err := broker.CreateRoom("general", b.ID, false); err != ErrRoomExists {
+		t.Errorf("Expected ErrRoomExists, got %v", err)
+	}
+	if err := broker.JoinRoom("general", b.ID); err != nil {
+		t.Fatalf("JoinRoom failed: %v", err)
+	}
+
+	if err := broker.SendMessage(Message{Sender: c.ID, Room: "general", Content: "let me in"}); err != ErrNotRoomMember {
+		t.Errorf("Expected ErrNotRoomMember, got %v", err)
+	}
+	if err := broker.SendMessage(Message{Sender: a.ID, Room: "general", Content: "hi team"}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	for _, u := range []*testUser{a, b} {
+		select {
+		case m := <-u.Recv:
+			if m.Content != "hi team" || m.Room != "general" {
+				t.Errorf("%s got wrong message: %+v", u.ID, m)
+			}
+		case <-time.After(500 * time.Millisecond):
+			t.Errorf("%s did not receive room message", u.ID)
+		}
+	}
+	select {
+	case m := <-c.Recv:
+		t.Errorf("C is not a member but received %+v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	members, _ := broker.RoomMembers("general")
+	if len(members) != 2 || members[0] != "A" || members[1] != "B" {
+		t.Errorf("Unexpected members: %v", members)
+	}
+	history, _ := broker.RoomHistory("general", c.ID)
+	if len(history) != 1 || history[0].Content != "hi team" {
+		t.Errorf("Unexpected history: %+v", history)
+	}
+
+	if err := broker.LeaveRoom("general", b.ID); err != nil {
+		t.Fatalf("LeaveRoom failed: %v", err)
+	}
+	if err := broker.LeaveRoom("general", b.ID); err != ErrNotRoomMember {
+		t.Errorf("Expected ErrNotRoomMember, got %v", err)
+	}
+	if err := broker.SendMessage(Message{Sender: b.ID, Room: "general", Content: "bye"}); err != ErrNotRoomMember {
+		t.Errorf("Expected ErrNotRoomMember after leaving, got %v", err)
+	}
+	if err := broker.SendMessage(Message{Sender: a.ID, Room: "missing", Content: "?"}); err != ErrRoomNotFound {
+		t.Errorf("Expected ErrRoomNotFound, got %v", err)
+	}
+}
+
+func TestPrivateRoom(t *testing.T) {
+	broker := NewBroker(context.Background())
+
+	if err := broker.CreateRoom("secret", "A", true); err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+	if err := broker.JoinRoom("secret", "B"); err != ErrNotInvited {
+		t.Errorf("Expected ErrNotInvited, got %v", err)
+	}
+	if err := broker.InviteToRoom("secret", "C", "B"); err != ErrNotRoomMember {
+		t.Errorf("Expected ErrNotRoomMember for non-member inviter, got %v", err)
+	}
+	if _, err := broker.RoomHistory("secret", "B"); err != ErrNotRoomMember {
+		t.Errorf("Expected ErrNotRoomMember reading history, got %v", err)
+	}
+	if rooms := broker.Rooms("B"); len(rooms) != 0 {
+		t.Errorf("Private room should be hidden, got %+v", rooms)
+	}
+
+	if err := broker.InviteToRoom("secret", "A", "B"); err != nil {
+		t.Fatalf("InviteToRoom failed: %v", err)
+	}
+	if rooms := broker.Rooms("B"); len(rooms) != 1 || !rooms[0].Private {
+		t.Errorf("Invited user should see the room, got %+v", rooms)
+	}
+	if err := broker.JoinRoom("secret", "B"); err != nil {
+		t.Fatalf("JoinRoom failed: %v", err)
+	}
+	if _, err := broker.RoomHistory("secret", "B"); err != nil {
+		t.Errorf("Member should read history, got %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package chatcore
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DeliveryPolicy decides what happens when a subscriber's queue is full
+type DeliveryPolicy int
+
+const (
+	// PolicyBlock keeps a message up to BlockTimeout when the queue is full,
+	// then drops it. The subscriber's goroutine does the waiting, so the
+	// broker never blocks on a slow user.
+	PolicyBlock DeliveryPolicy = iota
+	// PolicyDropOldest discards the oldest queued message to make room
+	PolicyDropOldest
+	// PolicyDropNewest discards the incoming message
+	PolicyDropNewest
+	// PolicyDisconnect unregisters the subscriber
+	PolicyDisconnect
+)
+
+func (p DeliveryPolicy) String() string {
+	switch p {
+	case PolicyBlock:
+		return "block"
+	case PolicyDropOldest:
+		return "drop-oldest"
+	case PolicyDropNewest:
+		return "drop-newest"
+	case PolicyDisconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// SubscriberOptions configure delivery to one user
+type SubscriberOptions struct {
+	QueueSize    int // messages buffered by the broker in addition to the receiving channel
+	Policy       DeliveryPolicy
+	BlockTimeout time.Duration // only used by PolicyBlock
+
+	PresenceEvents bool // receive KindPresence messages when other users change status
+}
+
+// DefaultSubscriberOptions are used by RegisterUser
+var DefaultSubscriberOptions = SubscriberOptions{
+	QueueSize:    64,
+	Policy:       PolicyBlock,
+	BlockTimeout: time.Second,
+}
+
+// SubscriberStats are delivery counters of one user
+type SubscriberStats struct {
+	Policy    DeliveryPolicy
+	Queued    int
+	Delivered uint64
+	Dropped   uint64
+}
+
+// BrokerStats are delivery counters of the whole broker
+type BrokerStats struct {
+	Delivered    uint64
+	Dropped      uint64
+	Disconnected uint64 // subscribers removed by PolicyDisconnect
+	Users        map[string]SubscriberStats
+}
+
+// subscriber owns a bounded queue that a goroutine drains into the user's channel,
+// so a user that stops reading only fills its own queue
+type subscriber struct {
+	userID    string
+	recv      chan Message
+	queue     chan Message
+	backlog   []Message           // delivered before anything in the queue
+	replayed  map[MessageRef]bool // keys of the backlog, skipped in the queue
+	opts      SubscriberOptions
+	mu        sync.Mutex
+	waiting   []waitingMessage // PolicyBlock overflow, delivered after the queue
+	wake      chan struct{}    // signals the forwarder that waiting grew
+	quit      chan struct{}
+	closeOnce sync.Once
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+func newSubscriber(userID string, recv chan Message, opts SubscriberOptions) *subscriber {
+	if opts.QueueSize < 1 {
+		opts.QueueSize = 1
+	}
+	return &subscriber{
+		userID: userID,
+		recv:   recv,
+		queue:  make(chan Message, opts.QueueSize),
+		opts:   opts,
+		wake:   make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+	}
+}
+
+// waitingMessage is a message that did not fit in the queue and is dropped
+// if it cannot be delivered before its deadline
+type waitingMessage struct {
+	msg      Message
+	deadline time.Time
+}
+
+// setBacklog sets the stored messages to deliver first, it must be called
+// before forward
+func (s *subscriber) setBacklog(backlog []Message) {
+	s.backlog = backlog
+	if len(backlog) > 0 {
+		s.replayed = make(map[MessageRef]bool, len(backlog))
+		for _, msg := range backlog {
+			s.replayed[msg.Key()] = true
+		}
+	}
+}
+
+// forward moves queued messages to the receiving channel until the subscriber is closed
+func (s *subscriber) forward(done <-chan struct{}) {
+	for _, msg := range s.backlog {
+		select {
+		case s.recv <- msg:
+			s.delivered.Add(1)
+		case <-s.quit:
+			return
+		case <-done:
+			return
+		}
+	}
+	s.backlog = nil
+
+	for {
+		// Queued messages are older than waiting ones
+		select {
+		case msg := <-s.queue:
+			if !s.send(msg, nil, done) {
+				return
+			}
+			continue
+		default:
+		}
+		if w, ok := s.nextWaiting(); ok {
+			timer := time.NewTimer(time.Until(w.deadline))
+			sent := s.send(w.msg, timer.C, done)
+			timer.Stop()
+			if !sent {
+				return
+			}
+			continue
+		}
+
+		select {
+		case msg := <-s.queue:
+			if !s.send(msg, nil, done) {
+				return
+			}
+		case <-s.wake:
+		case <-s.quit:
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// send hands a message to the user, a message whose deadline fires first is
+// dropped. It returns false once the subscriber is closed.
+func (s *subscriber) send(msg Message, deadline <-chan time.Time, done <-chan struct{}) bool {
+	if s.replayed[msg.Key()] {
+		return true
+	}
+	select {
+	case s.recv <- msg:
+		s.delivered.Add(1)
+	case <-deadline:
+		s.dropped.Add(1)
+	case <-s.quit:
+		return false
+	case <-done:
+		return false
+	}
+	return true
+}
+
+// nextWaiting takes the oldest waiting message that has not expired
+func (s *subscriber) nextWaiting() (waitingMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked(time.Now())
+	if len(s.waiting) == 0 {
+		return waitingMessage{}, false
+	}
+	w := s.waiting[0]
+	s.waiting[0] = waitingMessage{}
+	s.waiting = s.waiting[1:]
+	return w, true
+}
+
+// expireLocked drops waiting messages past their deadline, the caller must hold mu
+func (s *subscriber) expireLocked(now time.Time) {
+	expired := 0
+	for expired < len(s.waiting) && now.After(s.waiting[expired].deadline) {
+		s.waiting[expired] = waitingMessage{}
+		expired++
+	}
+	if expired > 0 {
+		s.waiting = s.waiting[expired:]
+		s.dropped.Add(uint64(expired))
+	}
+}
+
+func (s *subscriber) close() {
+	s.closeOnce.Do(func() { close(s.quit) })
+}
+
+// enqueue applies the delivery policy without blocking, it returns false if
+// the subscriber must be disconnected
+func (s *subscriber) enqueue(msg Message) bool {
+	if s.opts.Policy == PolicyBlock {
+		s.wait(msg)
+		return true
+	}
+
+	select {
+	case s.queue <- msg:
+		return true
+	default:
+	}
+
+	switch s.opts.Policy {
+	case PolicyDropNewest:
+		s.dropped.Add(1)
+	case PolicyDropOldest:
+		for {
+			select {
+			case <-s.queue:
+				s.dropped.Add(1)
+			default:
+			}
+			select {
+			case s.queue <- msg:
+				return true
+			default:
+			}
+		}
+	case PolicyDisconnect:
+		s.dropped.Add(1)
+		return false
+	}
+	return true
+}
+
+// wait queues a message, or leaves it to the forwarder for up to
+// BlockTimeout when the queue is full. Once a message waits, later ones wait
+// behind it to keep the order.
+func (s *subscriber) wait(msg Message) {
+	s.mu.Lock()
+	now := time.Now()
+	s.expireLocked(now)
+	if len(s.waiting) == 0 {
+		select {
+		case s.queue <- msg:
+			s.mu.Unlock()
+			return
+		default:
+		}
+	}
+	s.waiting = append(s.waiting, waitingMessage{msg: msg, deadline: now.Add(s.opts.BlockTimeout)})
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) stats() SubscriberStats {
+	s.mu.Lock()
+	s.expireLocked(time.Now())
+	waiting := len(s.waiting)
+	s.mu.Unlock()
+	return SubscriberStats{
+		Policy:    s.opts.Policy,
+		Queued:    len(s.queue) + waiting,
+		Delivered: s.delivered.Load(),
+		Dropped:   s.dropped.Load(),
+	}
+}
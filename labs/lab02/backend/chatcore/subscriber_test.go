@@ -0,0 +1,157 @@
+package chatcore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// sendAndWait sends n direct messages to userID and waits for the broker to route them
+func sendAndWait(t *testing.T, broker *Broker, userID string, n int) {
+	t.Helper()
+	// Let the forwarder take the first message so the queue state is predictable
+	broker.SendMessage(Message{Sender: "sender", Recipient: userID, Content: "0"})
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if s, ok := broker.Stats().Users[userID]; ok && s.Queued == 0 && len(broker.input) == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 1; i < n; i++ {
+		msg := Message{Sender: "sender", Recipient: userID, Content: fmt.Sprint(i)}
+		if err := broker.SendMessage(msg); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+	}
+	deadline = time.Now().Add(time.Second)
+	for len(broker.input) > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+}
+
+func TestDeliveryPolicies(t *testing.T) {
+	tests := []struct {
+		policy        DeliveryPolicy
+		expectFirst   string
+		expectDropped uint64
+	}{
+		// The forwarder holds message 0 while waiting on the full channel,
+		// the queue holds the next two
+		{PolicyDropNewest, "0", 2},
+		{PolicyDropOldest, "0", 2},
+		{PolicyBlock, "0", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			broker := NewBroker(ctx)
+			go broker.Run()
+
+			recv := make(chan Message)
+			broker.RegisterUserWithOptions("slow", recv, SubscriberOptions{
+				QueueSize:    2,
+				Policy:       tt.policy,
+				BlockTimeout: 10 * time.Millisecond,
+			})
+			fast := newTestUser("fast")
+			broker.RegisterUser(fast.ID, fast.Recv)
+
+			sendAndWait(t, broker, "slow", 5)
+
+			stats := broker.Stats()
+			if got := stats.Users["slow"].Dropped; got != tt.expectDropped {
+				t.Errorf("Expected %d dropped, got %d", tt.expectDropped, got)
+			}
+			if stats.Dropped != tt.expectDropped {
+				t.Errorf("Expected broker total %d dropped, got %d", tt.expectDropped, stats.Dropped)
+			}
+
+			// A slow user must not stall delivery to others
+			broker.SendMessage(Message{Sender: "sender", Recipient: fast.ID, Content: "hi"})
+			select {
+			case <-fast.Recv:
+			case <-time.After(500 * time.Millisecond):
+				t.Fatal("fast user did not receive message")
+			}
+
+			first := <-recv
+			if first.Content != tt.expectFirst {
+				t.Errorf("Expected first message %s, got %s", tt.expectFirst, first.Content)
+			}
+			if tt.policy == PolicyDropOldest {
+				second := <-recv
+				if second.Content != "3" {
+					t.Errorf("Expected oldest messages to be dropped, got %s", second.Content)
+				}
+			}
+		})
+	}
+}
+
+func TestDisconnectPolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	go broker.Run()
+
+	recv := make(chan Message)
+	broker.RegisterUserWithOptions("frozen", recv, SubscriberOptions{QueueSize: 1, Policy: PolicyDisconnect})
+
+	sendAndWait(t, broker, "frozen", 3)
+
+	stats := broker.Stats()
+	if _, ok := stats.Users["frozen"]; ok {
+		t.Error("Expected frozen user to be disconnected")
+	}
+	if stats.Disconnected != 1 {
+		t.Errorf("Expected 1 disconnected subscriber, got %d", stats.Disconnected)
+	}
+	if stats.Dropped != 1 {
+		t.Errorf("Expected 1 dropped message, got %d", stats.Dropped)
+	}
+}
+
+func TestStuckSubscriberDoesNotDelayOthers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewBroker(ctx)
+	go broker.Run()
+
+	// Nobody reads from the stuck user's channel
+	broker.RegisterUser("stuck", make(chan Message))
+	fast := newTestUser("fast")
+	broker.RegisterUser(fast.ID, fast.Recv)
+
+	start := time.Now()
+	overflow := DefaultSubscriberOptions.QueueSize + 10
+	for i := 0; i < overflow; i++ {
+		broker.SendMessage(Message{Sender: "sender", Recipient: "stuck", Content: fmt.Sprint(i)})
+	}
+	for i := 0; i < 10; i++ {
+		broker.SendMessage(Message{Sender: "sender", Recipient: fast.ID, Content: fmt.Sprint(i)})
+		select {
+		case <-fast.Recv:
+		case <-time.After(DefaultSubscriberOptions.BlockTimeout / 2):
+			t.Fatalf("Message %d to the fast user was held up by the stuck one", i)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > DefaultSubscriberOptions.BlockTimeout/2 {
+		t.Errorf("Delivery took %v", elapsed)
+	}
+
+	// The stuck user's overflow is dropped once it waited BlockTimeout
+	deadline := time.Now().Add(2 * DefaultSubscriberOptions.BlockTimeout)
+	for broker.Stats().Users["stuck"].Dropped == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := broker.Stats().Users["stuck"]; stats.Dropped == 0 || stats.Queued > DefaultSubscriberOptions.QueueSize {
+		t.Errorf("Expected the overflow to expire, got %+v", stats)
+	}
+}
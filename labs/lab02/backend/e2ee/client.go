@@ -0,0 +1,187 @@
+package e2ee
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"errors"
+
+	"lab02/chatcore"
+	"lab02/user"
+)
+
+// KeyDirectory publishes and looks up long-term public keys,
+// user.UserManager implements it
+type KeyDirectory interface {
+	// SetPublicKey publishes the key of userID, actor must be the same user
+	SetPublicKey(userID, actor string, key []byte) error
+	PublicKey(userID string) ([]byte, error)
+	// PublicKeys returns the published key followed by the keys it replaced
+	// and when they were replaced
+	PublicKeys(userID string) ([]user.PublishedKey, error)
+}
+
+// ErrNoKeys is returned when creating a client without a key
+var ErrNoKeys = errors.New("client needs at least one key")
+
+// Message is a direct message as seen by its recipient
+type Message struct {
+	ID        uint64
+	Sender    string
+	Text      string
+	Timestamp int64
+	Encrypted bool // false for plaintext messages sent without this package
+}
+
+// Client is a chat user that sends and receives end-to-end encrypted direct
+// messages through a broker
+type Client struct {
+	id        string
+	keys      []*ecdh.PrivateKey // the published key first, then earlier ones
+	broker    *chatcore.Broker
+	directory KeyDirectory
+	recv      chan chatcore.Message
+}
+
+// NewClient generates a key pair for userID, publishes the public key to the
+// directory and registers the user with the broker. The user must already
+// exist in the directory. The key is lost when the client goes away, so
+// messages still queued for it cannot be read by a later client: use
+// OpenClient to keep keys across restarts.
+func NewClient(userID string, broker *chatcore.Broker, directory KeyDirectory) (*Client, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithKey(userID, key, broker, directory)
+}
+
+// NewClientWithKey is NewClient with an existing long-term key
+func NewClientWithKey(userID string, key *ecdh.PrivateKey, broker *chatcore.Broker, directory KeyDirectory) (*Client, error) {
+	return NewClientWithKeys(userID, []*ecdh.PrivateKey{key}, broker, directory)
+}
+
+// NewClientWithKeys publishes the first key and keeps the others to open
+// messages that were sealed for them before the user switched keys
+func NewClientWithKeys(userID string, keys []*ecdh.PrivateKey, broker *chatcore.Broker, directory KeyDirectory) (*Client, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	if err := directory.SetPublicKey(userID, userID, keys[0].PublicKey().Bytes()); err != nil {
+		return nil, err
+	}
+	c := &Client{
+		id:        userID,
+		keys:      keys,
+		broker:    broker,
+		directory: directory,
+		recv:      make(chan chatcore.Message, chatcore.DefaultSubscriberOptions.QueueSize),
+	}
+	broker.RegisterUser(userID, c.recv)
+	return c, nil
+}
+
+// ID returns the user the client acts for
+func (c *Client) ID() string {
+	return c.id
+}
+
+// PublicKey returns the client's published long-term public key
+func (c *Client) PublicKey() []byte {
+	return c.keys[0].PublicKey().Bytes()
+}
+
+// Send encrypts text for the recipient's published key and sends it
+func (c *Client) Send(recipient, text string) error {
+	recipientKey, err := c.lookup(recipient)
+	if err != nil {
+		return err
+	}
+	env, err := Seal(c.keys[0], c.id, recipientKey, recipient, []byte(text))
+	if err != nil {
+		return err
+	}
+	return c.broker.SendMessage(chatcore.Message{
+		Kind:      chatcore.KindEncrypted,
+		Sender:    c.id,
+		Recipient: recipient,
+		Content:   env.Encode(),
+	})
+}
+
+// Receive waits for the next chat message and decrypts it. Presence, typing
+// and read receipt events are skipped. An error is returned for envelopes
+// that fail to decrypt; the client can keep receiving afterwards.
+func (c *Client) Receive(ctx context.Context) (Message, error) {
+	for {
+		var msg chatcore.Message
+		select {
+		case msg = <-c.recv:
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+
+		switch msg.Kind {
+		case chatcore.KindChat:
+			return Message{ID: msg.ID, Sender: msg.Sender, Text: msg.Content, Timestamp: msg.Timestamp}, nil
+		case chatcore.KindEncrypted:
+			text, err := c.open(msg)
+			if err != nil {
+				return Message{}, err
+			}
+			return Message{ID: msg.ID, Sender: msg.Sender, Text: text, Timestamp: msg.Timestamp, Encrypted: true}, nil
+		}
+	}
+}
+
+// Close unregisters the client from the broker
+func (c *Client) Close() {
+	c.broker.UnregisterUser(c.id)
+}
+
+// open decrypts an encrypted message with the client key it was sealed for,
+// checking it against the sender's published key. A key the sender has
+// replaced is only accepted for messages stamped no later than the second it
+// was replaced, so a leaked old key cannot be used to forge new messages.
+func (c *Client) open(msg chatcore.Message) (string, error) {
+	env, err := DecodeEnvelope(msg.Content)
+	if err != nil {
+		return "", err
+	}
+	key := c.keys[0]
+	for _, k := range c.keys {
+		if bytes.Equal(k.PublicKey().Bytes(), env.RecipientKey) {
+			key = k
+			break
+		}
+	}
+	senderKeys, err := c.directory.PublicKeys(msg.Sender)
+	if err != nil {
+		return "", err
+	}
+	raw := senderKeys[0].Key
+	for _, k := range senderKeys {
+		if bytes.Equal(k.Key, env.SenderKey) && (k.ReplacedAt == 0 || msg.Timestamp <= k.ReplacedAt) {
+			raw = k.Key
+			break
+		}
+	}
+	senderKey, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return "", err
+	}
+	text, err := Open(key, c.id, senderKey, msg.Sender, env)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// lookup fetches and parses a published key
+func (c *Client) lookup(userID string) (*ecdh.PublicKey, error) {
+	raw, err := c.directory.PublicKey(userID)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPublicKey(raw)
+}
@@ -0,0 +1,259 @@
+package e2ee
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lab02/chatcore"
+	"lab02/user"
+)
+
+func newUsers(t *testing.T, ids ...string) *user.UserManager {
+	t.Helper()
+	users := user.NewUserManager()
+	for _, id := range ids {
+		if err := users.AddUser(user.User{ID: id, Name: id, Email: id + "@example.com"}); err != nil {
+			t.Fatalf("AddUser failed: %v", err)
+		}
+	}
+	return users
+}
+
+func TestRoundTripThroughBroker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	broker := chatcore.NewBroker(ctx)
+	offline := chatcore.NewMemoryOfflineStore(10, time.Hour)
+	broker.SetOfflineStore(offline)
+	go broker.Run()
+	users := newUsers(t, "alice", "bob")
+
+	alice, err := NewClient("alice", broker, users)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	bob, err := NewClient("bob", broker, users)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer alice.Close()
+	defer bob.Close()
+
+	if err := alice.Send("bob", "meet at the usual place"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	msg, err := bob.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if msg.Text != "meet at the usual place" || msg.Sender != "alice" || !msg.Encrypted {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	// The broker only ever held ciphertext
+	stored, _ := offline.Pending("bob")
+	if len(stored) != 1 || stored[0].Kind != chatcore.KindEncrypted || strings.Contains(stored[0].Content, "usual place") {
+		t.Errorf("broker stored plaintext: %+v", stored)
+	}
+
+	if err := bob.Send("alice", "see you there"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if msg, err := alice.Receive(ctx); err != nil || msg.Text != "see you there" {
+		t.Errorf("reply: got %+v, %v", msg, err)
+	}
+}
+
+func TestSendWithoutPublishedKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := chatcore.NewBroker(ctx)
+	go broker.Run()
+	users := newUsers(t, "alice", "carol")
+
+	alice, _ := NewClient("alice", broker, users)
+	if err := alice.Send("carol", "hi"); err != user.ErrNoPublicKey {
+		t.Errorf("expected ErrNoPublicKey, got %v", err)
+	}
+	if _, err := NewClient("mallory", broker, users); err != user.ErrUserNotFound {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestEncryptedMessagesMustBeDirect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := chatcore.NewBroker(ctx)
+	err := broker.SendMessage(chatcore.Message{Kind: chatcore.KindEncrypted, Sender: "alice", Broadcast: true, Content: "x"})
+	if err != chatcore.ErrEncryptedNotDirect {
+		t.Errorf("expected ErrEncryptedNotDirect, got %v", err)
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	alice, _ := GenerateKey()
+	bob, _ := GenerateKey()
+	mallory, _ := GenerateKey()
+
+	env, err := Seal(alice, "alice", bob.PublicKey(), "bob", []byte("transfer 10"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	decoded, err := DecodeEnvelope(env.Encode())
+	if err != nil {
+		t.Fatalf("DecodeEnvelope failed: %v", err)
+	}
+	if text, err := Open(bob, "bob", alice.PublicKey(), "alice", decoded); err != nil || string(text) != "transfer 10" {
+		t.Fatalf("Open failed: %q, %v", text, err)
+	}
+
+	tampered := env
+	tampered.Ciphertext = append([]byte(nil), env.Ciphertext...)
+	tampered.Ciphertext[0] ^= 1
+	tests := []struct {
+		name      string
+		recipient string
+		sender    string
+		env       Envelope
+		want      error
+	}{
+		{"modified ciphertext", "bob", "alice", tampered, ErrDecrypt},
+		{"claimed sender", "bob", "carol", env, ErrDecrypt},
+		{"other recipient id", "robert", "alice", env, ErrDecrypt},
+		{"future version", "bob", "alice", Envelope{Version: 2}, ErrUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Open(bob, tt.recipient, alice.PublicKey(), tt.sender, tt.env); err != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	if _, err := Open(mallory, "bob", alice.PublicKey(), "alice", env); err != ErrWrongRecipient {
+		t.Errorf("expected ErrWrongRecipient, got %v", err)
+	}
+	// A message sealed with any key but the published one is rejected
+	forged, _ := Seal(mallory, "alice", bob.PublicKey(), "bob", []byte("transfer 1000"))
+	if _, err := Open(bob, "bob", alice.PublicKey(), "alice", forged); err != ErrUnknownSenderKey {
+		t.Errorf("expected ErrUnknownSenderKey, got %v", err)
+	}
+	if _, err := DecodeEnvelope("not base64!"); err != ErrMalformedEnvelope {
+		t.Errorf("expected ErrMalformedEnvelope, got %v", err)
+	}
+}
+
+func TestReplacedSenderKeyOpensOnlyEarlierMessages(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	broker := chatcore.NewBroker(ctx)
+	go broker.Run()
+	users := newUsers(t, "alice", "bob")
+	bob, _ := NewClient("bob", broker, users)
+	defer bob.Close()
+
+	old, _ := GenerateKey()
+	fresh, _ := GenerateKey()
+	users.SetPublicKey("alice", "alice", old.PublicKey().Bytes())
+	users.SetPublicKey("alice", "alice", fresh.PublicKey().Bytes())
+	keys, _ := users.PublicKeys("alice")
+	rotated := keys[1].ReplacedAt
+
+	send := func(text string, timestamp int64) {
+		env, _ := Seal(old, "alice", bob.keys[0].PublicKey(), "bob", []byte(text))
+		err := broker.SendMessage(chatcore.Message{
+			Kind:      chatcore.KindEncrypted,
+			Sender:    "alice",
+			Recipient: "bob",
+			Content:   env.Encode(),
+			Timestamp: timestamp,
+		})
+		if err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+	}
+	send("sent before the switch", rotated)
+	if msg, err := bob.Receive(ctx); err != nil || msg.Text != "sent before the switch" {
+		t.Fatalf("Receive: got %+v, %v", msg, err)
+	}
+	// Whoever holds the replaced key cannot use it for new messages
+	send("forged later", rotated+1)
+	if _, err := bob.Receive(ctx); err != ErrUnknownSenderKey {
+		t.Errorf("expected ErrUnknownSenderKey, got %v", err)
+	}
+	if err := users.SetPublicKey("alice", "bob", old.PublicKey().Bytes()); err != user.ErrNotKeyOwner {
+		t.Errorf("expected ErrNotKeyOwner, got %v", err)
+	}
+}
+
+func TestDeliveryAcrossReconnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	broker := chatcore.NewBroker(ctx)
+	broker.SetOfflineStore(chatcore.NewMemoryOfflineStore(10, time.Hour))
+	go broker.Run()
+	users := newUsers(t, "alice", "bob")
+	bobKeys := filepath.Join(t.TempDir(), "bob.keys")
+
+	alice, _ := NewClient("alice", broker, users)
+	bob, err := OpenClient("bob", bobKeys, broker, users)
+	if err != nil {
+		t.Fatalf("OpenClient failed: %v", err)
+	}
+	bob.Close()
+	if err := alice.Send("bob", "while you were away"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Alice switches keys before bob reads the message
+	alice.Close()
+	alice, _ = NewClient("alice", broker, users)
+	defer alice.Close()
+
+	// Bob comes back with the saved key and reads the stored message
+	bob, err = OpenClient("bob", bobKeys, broker, users)
+	if err != nil {
+		t.Fatalf("OpenClient failed: %v", err)
+	}
+	msg, err := bob.Receive(ctx)
+	if err != nil || msg.Text != "while you were away" {
+		t.Fatalf("Receive after reconnect: got %+v, %v", msg, err)
+	}
+	bob.Close()
+
+	// After rotating, envelopes sealed for the earlier key still open
+	if err := alice.Send("bob", "sealed for the old key"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	keys, _ := LoadKeys(bobKeys)
+	fresh, _ := GenerateKey()
+	if err := SaveKeys(bobKeys, append([]*ecdh.PrivateKey{fresh}, keys...)); err != nil {
+		t.Fatalf("SaveKeys failed: %v", err)
+	}
+	bob, _ = OpenClient("bob", bobKeys, broker, users)
+	defer bob.Close()
+	if msg, err := bob.Receive(ctx); err != nil || msg.Text != "while you were away" {
+		t.Fatalf("Receive after rotation: got %+v, %v", msg, err)
+	}
+	if msg, err := bob.Receive(ctx); err != nil || msg.Text != "sealed for the old key" {
+		t.Fatalf("Receive after rotation: got %+v, %v", msg, err)
+	}
+	if !bytes.Equal(bob.PublicKey(), fresh.PublicKey().Bytes()) {
+		t.Errorf("Expected the new key to be published")
+	}
+
+	// A client with only a fresh key cannot read what was sealed before
+	bob.Close()
+	stranger, _ := NewClient("bob", broker, users)
+	defer stranger.Close()
+	if _, err := stranger.Receive(ctx); err != ErrWrongRecipient {
+		t.Errorf("expected ErrWrongRecipient, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+// Package e2ee encrypts direct messages between chat users so the broker
+// only ever routes ciphertext.
+//
+// Every user has a long-term X25519 key published through the user service.
+// A sender combines a fresh ephemeral key with its long-term key:
+//
+//	secret = X25519(ephemeral, recipient) || X25519(sender, recipient)
+//	key    = HKDF-SHA256(secret, salt = ephemeral public key, info)
+//
+// and seals the text with AES-256-GCM. The ephemeral half gives every message
+// its own key, the long-term half proves the sender holds its published key.
+// Sender and recipient IDs and all public keys are authenticated as
+// associated data, so an envelope cannot be replayed under other names.
+package e2ee
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+// Version is the envelope format produced by Seal
+const Version = 1
+
+// hkdfInfo separates these keys from any other use of the same secrets
+const hkdfInfo = "lab02 e2ee v1 message key"
+
+// Envelope errors
+var (
+	ErrUnsupportedVersion = errors.New("unsupported envelope version")
+	ErrMalformedEnvelope  = errors.New("malformed envelope")
+	ErrDecrypt            = errors.New("message authentication failed")
+	ErrWrongRecipient     = errors.New("envelope is for another key")
+	ErrUnknownSenderKey   = errors.New("envelope sender key does not match the published key")
+)
+
+// Envelope is an encrypted message as it travels through the broker
+type Envelope struct {
+	Version      int    `json:"v"`
+	SenderKey    []byte `json:"sk"` // sender's long-term public key
+	RecipientKey []byte `json:"rk"` // recipient public key the message was sealed for
+	EphemeralKey []byte `json:"ek"`
+	Nonce        []byte `json:"n"`
+	Ciphertext   []byte `json:"c"`
+}
+
+// GenerateKey creates a long-term X25519 key pair
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// Seal encrypts plaintext from senderID to recipientID
+func Seal(sender *ecdh.PrivateKey, senderID string, recipient *ecdh.PublicKey, recipientID string, plaintext []byte) (Envelope, error) {
+	ephemeral, err := GenerateKey()
+	if err != nil {
+		return Envelope{}, err
+	}
+	env := Envelope{
+		Version:      Version,
+		SenderKey:    sender.PublicKey().Bytes(),
+		RecipientKey: recipient.Bytes(),
+		EphemeralKey: ephemeral.PublicKey().Bytes(),
+	}
+
+	dhEphemeral, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return Envelope{}, err
+	}
+	dhStatic, err := sender.ECDH(recipient)
+	if err != nil {
+		return Envelope{}, err
+	}
+	aead, err := newAEAD(append(dhEphemeral, dhStatic...), env.EphemeralKey)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	env.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return Envelope{}, err
+	}
+	env.Ciphertext = aead.Seal(nil, env.Nonce, plaintext, env.associatedData(senderID, recipientID))
+	return env, nil
+}
+
+// Open decrypts an envelope sent by senderID to recipientID. senderKey is the
+// key senderID published, an envelope signed with any other key is rejected.
+func Open(recipient *ecdh.PrivateKey, recipientID string, senderKey *ecdh.PublicKey, senderID string, env Envelope) ([]byte, error) {
+	if env.Version != Version {
+		return nil, ErrUnsupportedVersion
+	}
+	if !bytes.Equal(env.RecipientKey, recipient.PublicKey().Bytes()) {
+		return nil, ErrWrongRecipient
+	}
+	if !bytes.Equal(env.SenderKey, senderKey.Bytes()) {
+		return nil, ErrUnknownSenderKey
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(env.EphemeralKey)
+	if err != nil {
+		return nil, ErrMalformedEnvelope
+	}
+
+	dhEphemeral, err := recipient.ECDH(ephemeral)
+	if err != nil {
+		return nil, ErrMalformedEnvelope
+	}
+	dhStatic, err := recipient.ECDH(senderKey)
+	if err != nil {
+		return nil, ErrMalformedEnvelope
+	}
+	aead, err := newAEAD(append(dhEphemeral, dhStatic...), env.EphemeralKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, ErrMalformedEnvelope
+	}
+	plaintext, err := aead.Open(nil, env.Nonce, env.Ciphertext, env.associatedData(senderID, recipientID))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// Encode serializes an envelope for chatcore.Message.Content
+func (e Envelope) Encode() string {
+	data, _ := json.Marshal(e)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// DecodeEnvelope parses the output of Envelope.Encode
+func DecodeEnvelope(s string) (Envelope, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return Envelope{}, ErrMalformedEnvelope
+	}
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return Envelope{}, ErrMalformedEnvelope
+	}
+	return env, nil
+}
+
+// associatedData binds the envelope to its participants. Each field is
+// length-prefixed so different IDs can never produce the same bytes.
+func (e Envelope) associatedData(senderID, recipientID string) []byte {
+	ad := []byte{byte(e.Version)}
+	for _, field := range [][]byte{[]byte(senderID), []byte(recipientID), e.SenderKey, e.RecipientKey, e.EphemeralKey} {
+		ad = binary.BigEndian.AppendUint32(ad, uint32(len(field)))
+		ad = append(ad, field...)
+	}
+	return ad
+}
+
+// newAEAD derives the message key and returns the cipher for it
+func newAEAD(secret, salt []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, secret, salt, hkdfInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
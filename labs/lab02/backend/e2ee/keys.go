@@ -0,0 +1,90 @@
+package e2ee
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"lab02/chatcore"
+)
+
+// ErrMalformedKeyFile is returned for a key file that LoadKeys cannot parse
+var ErrMalformedKeyFile = errors.New("malformed key file")
+
+// OpenClient is NewClientWithKeys with the keys saved at path, a new key is
+// generated and saved first if the file does not exist. A client opened
+// again from the same file can read messages sealed for its earlier run.
+func OpenClient(userID, path string, broker *chatcore.Broker, directory KeyDirectory) (*Client, error) {
+	keys, err := LoadKeys(path)
+	if errors.Is(err, os.ErrNotExist) {
+		var key *ecdh.PrivateKey
+		if key, err = GenerateKey(); err == nil {
+			keys = []*ecdh.PrivateKey{key}
+			err = SaveKeys(path, keys)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithKeys(userID, keys, broker, directory)
+}
+
+// LoadKeys reads private keys saved by SaveKeys, the current key first
+func LoadKeys(path string) ([]*ecdh.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*ecdh.PrivateKey
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		raw, err := base64.StdEncoding.DecodeString(scanner.Text())
+		if err != nil {
+			return nil, ErrMalformedKeyFile
+		}
+		key, err := ecdh.X25519().NewPrivateKey(raw)
+		if err != nil {
+			return nil, ErrMalformedKeyFile
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, ErrMalformedKeyFile
+	}
+	return keys, nil
+}
+
+// SaveKeys writes private keys to path, readable only by the owner, one
+// base64 key per line with the current key first. The file is replaced
+// atomically.
+func SaveKeys(path string, keys []*ecdh.PrivateKey) error {
+	if len(keys) == 0 {
+		return ErrNoKeys
+	}
+	var buf bytes.Buffer
+	for _, key := range keys {
+		buf.WriteString(base64.StdEncoding.EncodeToString(key.Bytes()))
+		buf.WriteByte('\n')
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
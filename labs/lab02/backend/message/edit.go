@@ -0,0 +1,208 @@
+package message
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"lab02/blob"
+)
+
+// Errors returned when changing stored messages
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	ErrParentNotFound  = errors.New("parent message not found")
+	ErrNotSender       = errors.New("only the sender can change a message")
+	ErrMessageDeleted  = errors.New("message has been deleted")
+	ErrEmptyReaction   = errors.New("reaction must not be empty")
+	ErrEmptyContent    = errors.New("message must have content or attachments")
+)
+
+// Edit is a previous version of a message
+type Edit struct {
+	Content  string
+	EditedAt int64 // Unix seconds when this version was replaced
+}
+
+// Reaction lists the users that reacted to a message with one emoji
+type Reaction struct {
+	Emoji string
+	Users []string // in the order they reacted
+}
+
+// Count returns the number of users with this reaction
+func (r Reaction) Count() int {
+	return len(r.Users)
+}
+
+// Get returns a message by ID, deleted messages are returned as tombstones
+func (s *MessageStore) Get(id uint64) (Message, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	i, ok := s.findLocked(id)
+	if !ok {
+		return Message{}, ErrMessageNotFound
+	}
+	return s.messages[i], nil
+}
+
+// Edit replaces the content of a message, keeping the previous version in its
+// edit history. Only the sender may edit.
+func (s *MessageStore) Edit(id uint64, sender, content string) (Message, error) {
+	return s.update(id, func(msg *Message) error {
+		if msg.Sender != sender {
+			return ErrNotSender
+		}
+		if isEmpty(content, msg.Attachments) {
+			return ErrEmptyContent
+		}
+		now := s.now().Unix()
+		msg.Edits = append(msg.Edits[:len(msg.Edits):len(msg.Edits)], Edit{Content: msg.Content, EditedAt: now})
+		msg.Content = content
+		msg.EditedAt = now
+		return nil
+	})
+}
+
+// isEmpty reports whether a message would have nothing to show, whitespace
+// alone is not content
+func isEmpty(content string, attachments []blob.Attachment) bool {
+	return strings.TrimSpace(content) == "" && len(attachments) == 0
+}
+
+// Delete replaces a message with a tombstone that keeps its place in history
+// and threads. Only the sender may delete.
+func (s *MessageStore) Delete(id uint64, sender string) (Message, error) {
+	return s.update(id, func(msg *Message) error {
+		if msg.Sender != sender {
+			return ErrNotSender
+		}
+		msg.Content = ""
+		msg.Edits = nil
+		msg.Reactions = nil
+		msg.Attachments = nil
+		msg.Deleted = true
+		msg.DeletedAt = s.now().Unix()
+		return nil
+	})
+}
+
+// React adds the user's reaction to a message, reacting twice with the same
+// emoji has no effect
+func (s *MessageStore) React(id uint64, user, emoji string) (Message, error) {
+	if emoji == "" {
+		return Message{}, ErrEmptyReaction
+	}
+	return s.update(id, func(msg *Message) error {
+		reactions := cloneReactions(msg.Reactions)
+		for i, r := range reactions {
+			if r.Emoji != emoji {
+				continue
+			}
+			for _, u := range r.Users {
+				if u == user {
+					return nil
+				}
+			}
+			reactions[i].Users = append(r.Users, user)
+			msg.Reactions = reactions
+			return nil
+		}
+		msg.Reactions = append(reactions, Reaction{Emoji: emoji, Users: []string{user}})
+		return nil
+	})
+}
+
+// Unreact removes the user's reaction from a message
+func (s *MessageStore) Unreact(id uint64, user, emoji string) (Message, error) {
+	return s.update(id, func(msg *Message) error {
+		reactions := cloneReactions(msg.Reactions)
+		for i, r := range reactions {
+			if r.Emoji != emoji {
+				continue
+			}
+			users := r.Users[:0]
+			for _, u := range r.Users {
+				if u != user {
+					users = append(users, u)
+				}
+			}
+			if len(users) == 0 {
+				reactions = append(reactions[:i], reactions[i+1:]...)
+			} else {
+				reactions[i].Users = users
+			}
+			break
+		}
+		msg.Reactions = reactions
+		return nil
+	})
+}
+
+// Thread returns the replies to a message, oldest first
+func (s *MessageStore) Thread(parentID uint64) ([]Message, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if _, ok := s.findLocked(parentID); !ok {
+		return nil, ErrMessageNotFound
+	}
+	replies := []Message{}
+	for _, msg := range s.messages {
+		if msg.ParentID == parentID {
+			replies = append(replies, msg)
+		}
+	}
+	return replies, nil
+}
+
+// update applies change to a live message and persists the result. Messages
+// handed out earlier share slices with the stored copy, so change must not
+// modify Edits or Reactions in place.
+func (s *MessageStore) update(id uint64, change func(*Message) error) (Message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return Message{}, ErrStoreClosed
+	}
+	i, ok := s.findLocked(id)
+	if !ok {
+		return Message{}, ErrMessageNotFound
+	}
+	if s.messages[i].Deleted {
+		return Message{}, ErrMessageDeleted
+	}
+
+	msg := s.messages[i]
+	if err := change(&msg); err != nil {
+		return Message{}, err
+	}
+	if err := s.appendLocked(msg); err != nil {
+		return Message{}, err
+	}
+	switch {
+	case msg.Deleted:
+		s.index.Remove(msg.ID)
+	case msg.Content != s.messages[i].Content:
+		s.index.Add(msg.ID, msg.Content, msg.Timestamp)
+	}
+	s.messages[i] = msg
+	return msg, nil
+}
+
+// findLocked returns the position of a message in s.messages
+func (s *MessageStore) findLocked(id uint64) (int, bool) {
+	i := sort.Search(len(s.messages), func(i int) bool { return s.messages[i].ID >= id })
+	return i, i < len(s.messages) && s.messages[i].ID == id
+}
+
+// cloneReactions copies reactions so the result can be modified
+func cloneReactions(reactions []Reaction) []Reaction {
+	clone := make([]Reaction, len(reactions))
+	for i, r := range reactions {
+		clone[i] = Reaction{Emoji: r.Emoji, Users: append([]string(nil), r.Users...)}
+	}
+	return clone
+}
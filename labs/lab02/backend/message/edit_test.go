@@ -0,0 +1,156 @@
+package message
+
+import (
+	"path/filepath"
+	"testing"
+
+	"lab02/blob"
+)
+
+func TestEditAndDelete(t *testing.T) {
+	store := NewMessageStore()
+	msg, _ := store.Append(Message{Sender: "alice", Content: "helo", Attachments: []blob.Attachment{{ID: "abc", Name: "a.png"}}})
+
+	if _, err := store.Edit(msg.ID, "bob", "hijacked"); err != ErrNotSender {
+		t.Errorf("expected ErrNotSender, got %v", err)
+	}
+	edited, err := store.Edit(msg.ID, "alice", "hello")
+	if err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	if edited.Content != "hello" || len(edited.Edits) != 1 || edited.Edits[0].Content != "helo" || edited.EditedAt == 0 {
+		t.Errorf("unexpected edit result: %+v", edited)
+	}
+	if results, _ := store.Search("hello", 0); len(results) != 1 {
+		t.Errorf("expected edited content to be searchable, got %d results", len(results))
+	}
+
+	deleted, err := store.Delete(msg.ID, "alice")
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if !deleted.Deleted || deleted.Content != "" || deleted.Edits != nil || deleted.Attachments != nil {
+		t.Errorf("expected tombstone, got %+v", deleted)
+	}
+	if _, err := store.Edit(msg.ID, "alice", "again"); err != ErrMessageDeleted {
+		t.Errorf("expected ErrMessageDeleted, got %v", err)
+	}
+	if results, _ := store.Search("hello", 0); len(results) != 0 {
+		t.Errorf("expected deleted message to leave the index, got %d results", len(results))
+	}
+
+	// The tombstone stays in history so clients can render it
+	msgs, _ := store.GetMessages("")
+	if len(msgs) != 1 || !msgs[0].Deleted {
+		t.Errorf("expected tombstone in history, got %+v", msgs)
+	}
+	if _, err := store.Delete(42, "alice"); err != ErrMessageNotFound {
+		t.Errorf("expected ErrMessageNotFound, got %v", err)
+	}
+}
+
+func TestEmptyContent(t *testing.T) {
+	store := NewMessageStore()
+	if _, err := store.Append(Message{Sender: "alice", Content: " \n\t"}); err != ErrEmptyContent {
+		t.Errorf("expected ErrEmptyContent on append, got %v", err)
+	}
+	text, _ := store.Append(Message{Sender: "alice", Content: "hello"})
+	for _, content := range []string{"", "   "} {
+		if _, err := store.Edit(text.ID, "alice", content); err != ErrEmptyContent {
+			t.Errorf("expected ErrEmptyContent editing to %q, got %v", content, err)
+		}
+	}
+	if got, _ := store.Get(text.ID); got.Content != "hello" || len(got.Edits) != 0 {
+		t.Errorf("expected rejected edits to leave the message unchanged, got %+v", got)
+	}
+
+	// Attachments are content of their own, as when creating a message
+	image, err := store.Append(Message{Sender: "alice", Attachments: []blob.Attachment{{ID: "abc", Name: "a.png"}}})
+	if err != nil {
+		t.Fatalf("Append with only an attachment failed: %v", err)
+	}
+	if _, err := store.Edit(image.ID, "alice", ""); err != nil {
+		t.Errorf("expected caption to be removable, got %v", err)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	store := NewMessageStore()
+	msg, _ := store.Append(Message{Sender: "alice", Content: "lunch?"})
+	before, _ := store.Get(msg.ID)
+
+	store.React(msg.ID, "bob", "👍")
+	store.React(msg.ID, "carol", "👍")
+	store.React(msg.ID, "bob", "👍")
+	got, err := store.React(msg.ID, "carol", "🍕")
+	if err != nil {
+		t.Fatalf("React failed: %v", err)
+	}
+	if len(got.Reactions) != 2 || got.Reactions[0].Emoji != "👍" || got.Reactions[0].Count() != 2 || got.Reactions[1].Count() != 1 {
+		t.Fatalf("unexpected reactions: %+v", got.Reactions)
+	}
+	if len(before.Reactions) != 0 {
+		t.Errorf("earlier copy was modified: %+v", before.Reactions)
+	}
+
+	got, _ = store.Unreact(msg.ID, "carol", "🍕")
+	got, _ = store.Unreact(msg.ID, "bob", "👍")
+	if len(got.Reactions) != 1 || got.Reactions[0].Users[0] != "carol" {
+		t.Errorf("unexpected reactions after unreact: %+v", got.Reactions)
+	}
+	if _, err := store.React(msg.ID, "bob", ""); err != ErrEmptyReaction {
+		t.Errorf("expected ErrEmptyReaction, got %v", err)
+	}
+}
+
+func TestThreads(t *testing.T) {
+	store := NewMessageStore()
+	root, _ := store.Append(Message{Sender: "alice", Content: "release today?"})
+	store.Append(Message{Sender: "bob", Content: "unrelated"})
+	store.Append(Message{Sender: "bob", Content: "yes", ParentID: root.ID})
+	store.Append(Message{Sender: "carol", Content: "after lunch", ParentID: root.ID})
+
+	if _, err := store.Append(Message{Sender: "bob", Content: "?", ParentID: 99}); err != ErrParentNotFound {
+		t.Errorf("expected ErrParentNotFound, got %v", err)
+	}
+	replies, err := store.Thread(root.ID)
+	if err != nil {
+		t.Fatalf("Thread failed: %v", err)
+	}
+	if len(replies) != 2 || replies[0].Content != "yes" || replies[1].Content != "after lunch" {
+		t.Errorf("unexpected thread: %+v", replies)
+	}
+}
+
+func TestChangesSurviveReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.log")
+	store, err := OpenMessageStore(path, RetentionPolicy{})
+	if err != nil {
+		t.Fatalf("OpenMessageStore failed: %v", err)
+	}
+	first, _ := store.Append(Message{Sender: "alice", Content: "draft"})
+	second, _ := store.Append(Message{Sender: "bob", Content: "oops"})
+	store.Edit(first.ID, "alice", "final")
+	store.React(first.ID, "bob", "🎉")
+	store.Delete(second.ID, "bob")
+	store.Close()
+
+	store, err = OpenMessageStore(path, RetentionPolicy{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer store.Close()
+	msgs, _ := store.GetMessages("")
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %+v", msgs)
+	}
+	if msgs[0].Content != "final" || len(msgs[0].Edits) != 1 || len(msgs[0].Reactions) != 1 {
+		t.Errorf("edit or reaction lost: %+v", msgs[0])
+	}
+	if !msgs[1].Deleted {
+		t.Errorf("delete lost: %+v", msgs[1])
+	}
+	if results, _ := store.Search("oops", 0); len(results) != 0 {
+		t.Errorf("deleted message indexed after reopen")
+	}
+}
@@ -0,0 +1,91 @@
+package message
+
+import (
+	"lab02/recordlog"
+)
+
+// defaultCompactThreshold is the minimum number of expired records in the log
+// before it gets rewritten
+const defaultCompactThreshold = 1024
+
+// logRecord is one entry of the append-only log, exactly one field is set.
+// A message with an ID already in the log replaces the earlier version.
+type logRecord struct {
+	Message *Message `json:"message,omitempty"`
+	NextID  uint64   `json:"next_id,omitempty"` // written first after compaction
+}
+
+// OpenMessageStore opens a MessageStore backed by an append-only log at path,
+// creating the file if needed. Messages in the log are restored, and a record
+// torn by a crash is discarded. Every message is synced to disk before
+// AddMessage returns.
+func OpenMessageStore(path string, policy RetentionPolicy) (*MessageStore, error) {
+	s := NewMessageStoreWithRetention(policy)
+	log, err := recordlog.Open(path, s.replay)
+	if err != nil {
+		return nil, err
+	}
+	s.log, s.compactThreshold = log, defaultCompactThreshold
+	for _, msg := range s.messages {
+		if !msg.Deleted {
+			s.index.Add(msg.ID, msg.Content, msg.Timestamp)
+		}
+	}
+	s.pruneLocked()
+	return s, nil
+}
+
+// Close releases the log file, the store rejects writes afterwards
+func (s *MessageStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.log == nil {
+		return nil
+	}
+	return s.log.Close()
+}
+
+// replay applies one record of the log while it is opened
+func (s *MessageStore) replay(rec logRecord) {
+	switch {
+	case rec.Message != nil:
+		if i, ok := s.findLocked(rec.Message.ID); ok {
+			s.messages[i] = *rec.Message
+			return
+		}
+		s.messages = append(s.messages, *rec.Message)
+		if rec.Message.ID >= s.nextID {
+			s.nextID = rec.Message.ID + 1
+		}
+	case rec.NextID > s.nextID:
+		s.nextID = rec.NextID
+	}
+}
+
+// appendLocked writes a message to the log of a persistent store
+func (s *MessageStore) appendLocked(msg Message) error {
+	if s.log == nil {
+		return nil
+	}
+	return s.log.Append(logRecord{Message: &msg})
+}
+
+// compactLocked rewrites the log with only the retained messages once enough
+// records have expired. Failures are ignored: the old log is still valid and
+// retention is applied again on recovery.
+func (s *MessageStore) compactLocked() {
+	if s.log == nil || s.log.Records()-len(s.messages) < s.compactThreshold {
+		return
+	}
+	recs := make([]logRecord, 0, len(s.messages)+1)
+	recs = append(recs, logRecord{NextID: s.nextID})
+	for i := range s.messages {
+		recs = append(recs, logRecord{Message: &s.messages[i]})
+	}
+	s.log.Rewrite(recs)
+}
@@ -0,0 +1,97 @@
+package message
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenMessageStoreRecovers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.log")
+
+	store, err := OpenMessageStore(path, RetentionPolicy{})
+	if err != nil {
+		t.Fatalf("OpenMessageStore failed: %v", err)
+	}
+	store.AddMessage(Message{Sender: "alice", Content: "first"})
+	store.AddMessage(Message{Sender: "bob", Content: "second"})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := store.AddMessage(Message{Content: "late"}); err != ErrStoreClosed {
+		t.Errorf("expected ErrStoreClosed, got %v", err)
+	}
+
+	store, err = OpenMessageStore(path, RetentionPolicy{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer store.Close()
+	msgs, _ := store.GetMessages("")
+	if len(msgs) != 2 || msgs[0].Content != "first" || msgs[1].Content != "second" {
+		t.Fatalf("unexpected messages after recovery: %+v", msgs)
+	}
+
+	msg, _ := store.Append(Message{Content: "third"})
+	if msg.ID != 3 {
+		t.Errorf("expected ID 3 after recovery, got %d", msg.ID)
+	}
+}
+
+func TestOpenMessageStoreTruncatedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.log")
+
+	store, _ := OpenMessageStore(path, RetentionPolicy{})
+	store.AddMessage(Message{Content: "kept"})
+	store.AddMessage(Message{Content: "torn"})
+	store.Close()
+
+	// Simulate a crash in the middle of writing the last record
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenMessageStore(path, RetentionPolicy{})
+	if err != nil {
+		t.Fatalf("OpenMessageStore failed: %v", err)
+	}
+	msgs, _ := store.GetMessages("")
+	if len(msgs) != 1 || msgs[0].Content != "kept" {
+		t.Fatalf("expected only the intact message, got %+v", msgs)
+	}
+
+	// New writes must land after the last intact record
+	store.AddMessage(Message{Content: "after crash"})
+	store.Close()
+	store, _ = OpenMessageStore(path, RetentionPolicy{})
+	defer store.Close()
+	msgs, _ = store.GetMessages("")
+	if len(msgs) != 2 || msgs[1].Content != "after crash" {
+		t.Errorf("unexpected messages: %+v", msgs)
+	}
+}
+
+func TestOpenMessageStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.log")
+
+	store, _ := OpenMessageStore(path, RetentionPolicy{MaxCount: 2})
+	store.compactThreshold = 3
+	for i := 0; i < 10; i++ {
+		store.AddMessage(Message{Content: "msg"})
+	}
+	if store.log.Records() > 2+1+3 {
+		t.Errorf("expected log to be compacted, has %d records", store.log.Records())
+	}
+	store.Close()
+
+	store, _ = OpenMessageStore(path, RetentionPolicy{MaxCount: 2})
+	defer store.Close()
+	if store.Len() != 2 {
+		t.Errorf("expected 2 messages, got %d", store.Len())
+	}
+	msg, _ := store.Append(Message{Content: "next"})
+	if msg.ID != 11 {
+		t.Errorf("expected ID 11 after compaction, got %d", msg.ID)
+	}
+}
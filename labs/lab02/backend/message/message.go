@@ -2,43 +2,243 @@ package message
 
 import (
 	"errors"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"lab02/blob"
+	"lab02/recordlog"
+	"lab02/search"
 )
 
+// ErrStoreClosed is returned when writing to a store after Close
+var ErrStoreClosed = errors.New("message store is closed")
+
 // Message represents a chat message
-// TODO: Add more fields if needed
 
 type Message struct {
+	ID        uint64 // assigned by the store, increases with every message
+	ParentID  uint64 // message this one replies to, zero for a new thread
 	Sender    string
 	Content   string
-	Timestamp int64
+	Timestamp int64 // Unix seconds, set by the store when zero
+
+	Edits     []Edit     // previous versions of Content, oldest first
+	EditedAt  int64      // Unix seconds of the last edit, zero if never edited
+	Deleted   bool       // tombstone, Content and Edits are cleared
+	DeletedAt int64      // Unix seconds of the deletion
+	Reactions []Reaction // in order of the first reaction with each emoji
+
+	Attachments []blob.Attachment // files stored in a blob.Store
+}
+
+// RetentionPolicy limits how much history is kept, zero values mean unlimited
+type RetentionPolicy struct {
+	MaxCount int
+	MaxAge   time.Duration
 }
 
 // MessageStore stores chat messages
-// Contains a slice of messages and a mutex for concurrency
+// Contains a slice of messages ordered by ID and a mutex for concurrency
 
 type MessageStore struct {
-	messages []Message
-	mutex    sync.RWMutex
-	// TODO: Add more fields if needed
+	messages  []Message
+	mutex     sync.RWMutex
+	nextID    uint64
+	retention RetentionPolicy
+	log       *recordlog.Log[logRecord] // nil for in-memory stores
+	index     *search.Index
+	closed    bool
+	now       func() time.Time
+
+	compactThreshold int // expired log records that trigger a rewrite
 }
 
-// NewMessageStore creates a new MessageStore
+// NewMessageStore creates a new unbounded in-memory MessageStore
 func NewMessageStore() *MessageStore {
-	// TODO: Initialize MessageStore fields
+	return NewMessageStoreWithRetention(RetentionPolicy{})
+}
+
+// NewMessageStoreWithRetention creates a new in-memory MessageStore that
+// discards messages outside the retention policy
+func NewMessageStoreWithRetention(policy RetentionPolicy) *MessageStore {
 	return &MessageStore{
-		messages: make([]Message, 0, 100),
+		messages:  make([]Message, 0, 100),
+		nextID:    1,
+		retention: policy,
+		index:     search.NewIndex(),
+		now:       time.Now,
 	}
 }
 
 // AddMessage stores a new message
 func (s *MessageStore) AddMessage(msg Message) error {
-	// TODO: Add message to storage (concurrent safe)
-	return nil
+	_, err := s.Append(msg)
+	return err
+}
+
+// Append stores a new message and returns it with its ID and timestamp set
+func (s *MessageStore) Append(msg Message) (Message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return Message{}, ErrStoreClosed
+	}
+	if isEmpty(msg.Content, msg.Attachments) {
+		return Message{}, ErrEmptyContent
+	}
+	if msg.ParentID != 0 {
+		if _, ok := s.findLocked(msg.ParentID); !ok {
+			return Message{}, ErrParentNotFound
+		}
+	}
+	msg.ID = s.nextID
+	msg.Edits, msg.EditedAt, msg.Deleted, msg.DeletedAt, msg.Reactions = nil, 0, false, 0, nil
+	if msg.Timestamp == 0 {
+		msg.Timestamp = s.now().Unix()
+	}
+	if err := s.appendLocked(msg); err != nil {
+		return Message{}, err
+	}
+	s.nextID++
+	s.messages = append(s.messages, msg)
+	s.index.Add(msg.ID, msg.Content, msg.Timestamp)
+	s.pruneLocked()
+	return msg, nil
 }
 
 // GetMessages retrieves messages (optionally by user)
 func (s *MessageStore) GetMessages(user string) ([]Message, error) {
-	// TODO: Retrieve messages (all or by user)
-	return nil, errors.New("not implemented")
+	page, err := s.Query(Query{Sender: user})
+	return page.Messages, err
+}
+
+// Query selects messages, all conditions are combined with AND
+type Query struct {
+	Sender  string // exact sender, empty matches everyone
+	Since   int64  // inclusive lower bound on Timestamp, zero means no bound
+	Until   int64  // exclusive upper bound on Timestamp, zero means no bound
+	Keyword string // case-insensitive substring of Content
+	Cursor  uint64 // return messages with ID greater than the cursor
+	Limit   int    // maximum page size, zero means no limit
+}
+
+// Page is a slice of query results in ID order
+type Page struct {
+	Messages   []Message
+	NextCursor uint64 // pass as Query.Cursor to get the next page, zero if there are no more results
+}
+
+// Query returns the messages matching q, oldest first
+func (s *MessageStore) Query(q Query) (Page, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	keyword := strings.ToLower(q.Keyword)
+	minTimestamp := s.minTimestampLocked()
+
+	start := sort.Search(len(s.messages), func(i int) bool {
+		return s.messages[i].ID > q.Cursor
+	})
+
+	page := Page{Messages: []Message{}}
+	for _, msg := range s.messages[start:] {
+		switch {
+		case msg.Timestamp < minTimestamp:
+			continue
+		case q.Sender != "" && msg.Sender != q.Sender:
+			continue
+		case q.Since != 0 && msg.Timestamp < q.Since:
+			continue
+		case q.Until != 0 && msg.Timestamp >= q.Until:
+			continue
+		case keyword != "" && !strings.Contains(strings.ToLower(msg.Content), keyword):
+			continue
+		}
+		if q.Limit > 0 && len(page.Messages) == q.Limit {
+			page.NextCursor = page.Messages[len(page.Messages)-1].ID
+			break
+		}
+		page.Messages = append(page.Messages, msg)
+	}
+	return page, nil
+}
+
+// SearchResult is a message matching a full-text search
+type SearchResult struct {
+	Message Message
+	Score   float64
+	Matches []search.Span // byte ranges of Content to highlight
+}
+
+// Search runs a full-text query over message contents, most relevant and
+// recent first. See search.Index.Search for the query syntax.
+func (s *MessageStore) Search(query string, limit int) ([]SearchResult, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	hits := s.index.Search(query, search.Options{Limit: limit, Now: s.now()})
+	results := make([]SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		i, ok := s.findLocked(hit.ID)
+		if !ok {
+			continue
+		}
+		results = append(results, SearchResult{Message: s.messages[i], Score: hit.Score, Matches: hit.Matches})
+	}
+	return results, nil
+}
+
+// Len returns the number of retained messages
+func (s *MessageStore) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.messages)
+}
+
+// Prune applies the retention policy, AddMessage also prunes so calling it is
+// only needed to expire messages by age while the chat is idle
+func (s *MessageStore) Prune() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	s.pruneLocked()
+	return nil
+}
+
+// pruneLocked drops messages outside the retention policy, the caller must hold the write lock
+func (s *MessageStore) pruneLocked() {
+	drop := 0
+	if s.retention.MaxCount > 0 && len(s.messages) > s.retention.MaxCount {
+		drop = len(s.messages) - s.retention.MaxCount
+	}
+	minTimestamp := s.minTimestampLocked()
+	for drop < len(s.messages) && s.messages[drop].Timestamp < minTimestamp {
+		drop++
+	}
+	if drop == 0 {
+		return
+	}
+	for _, msg := range s.messages[:drop] {
+		s.index.Remove(msg.ID)
+	}
+
+	// Reslicing keeps pruning O(drop). Clearing lets the contents of the
+	// dropped messages be collected, and the next append that outgrows the
+	// array copies only the retained ones.
+	clear(s.messages[:drop])
+	s.messages = s.messages[drop:]
+	s.compactLocked()
+}
+
+// minTimestampLocked returns the oldest timestamp allowed by MaxAge
+func (s *MessageStore) minTimestampLocked() int64 {
+	if s.retention.MaxAge <= 0 {
+		return 0
+	}
+	return s.now().Add(-s.retention.MaxAge).Unix()
 }
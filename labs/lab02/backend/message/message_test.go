@@ -3,6 +3,7 @@ package message
 import (
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestAddMessageConcurrent(t *testing.T) {
@@ -42,3 +43,128 @@ func TestGetMessagesByUser(t *testing.T) {
 		t.Errorf("expected 2 messages for alice, got %d", len(msgs))
 	}
 }
+
+func TestQueryPagination(t *testing.T) {
+	store := NewMessageStore()
+	for i := 1; i <= 5; i++ {
+		store.AddMessage(Message{Sender: "alice", Content: "msg", Timestamp: int64(i)})
+	}
+
+	var got []Message
+	cursor := uint64(0)
+	pages := 0
+	for {
+		page, err := store.Query(Query{Cursor: cursor, Limit: 2})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		got = append(got, page.Messages...)
+		pages++
+		if page.NextCursor == 0 {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if pages != 3 {
+		t.Errorf("expected 3 pages, got %d", pages)
+	}
+	if len(got) != 5 {
+		t.Fatalf("expected 5 messages, got %d", len(got))
+	}
+	for i, msg := range got {
+		if msg.ID != uint64(i+1) {
+			t.Errorf("expected ID %d at position %d, got %d", i+1, i, msg.ID)
+		}
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	store := NewMessageStore()
+	store.AddMessage(Message{Sender: "alice", Content: "Hello World", Timestamp: 100})
+	store.AddMessage(Message{Sender: "bob", Content: "hello there", Timestamp: 200})
+	store.AddMessage(Message{Sender: "alice", Content: "goodbye", Timestamp: 300})
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected int
+	}{
+		{"keyword case-insensitive", Query{Keyword: "HELLO"}, 2},
+		{"time range", Query{Since: 150, Until: 300}, 1},
+		{"since only", Query{Since: 200}, 2},
+		{"sender and keyword", Query{Sender: "alice", Keyword: "hello"}, 1},
+		{"no match", Query{Keyword: "missing"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := store.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if len(page.Messages) != tt.expected {
+				t.Errorf("expected %d messages, got %d", tt.expected, len(page.Messages))
+			}
+		})
+	}
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	store := NewMessageStoreWithRetention(RetentionPolicy{MaxCount: 3})
+	for i := 0; i < 10; i++ {
+		store.AddMessage(Message{Sender: "alice", Content: "msg"})
+	}
+	msgs, _ := store.GetMessages("")
+	if len(msgs) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(msgs))
+	}
+	if msgs[0].ID != 8 {
+		t.Errorf("expected oldest retained ID 8, got %d", msgs[0].ID)
+	}
+
+	now := time.Unix(1000, 0)
+	store = NewMessageStoreWithRetention(RetentionPolicy{MaxAge: time.Minute})
+	store.now = func() time.Time { return now }
+	store.AddMessage(Message{Content: "old", Timestamp: 900})
+	store.AddMessage(Message{Content: "new", Timestamp: 990})
+	if store.Len() != 1 {
+		t.Errorf("expected expired message to be pruned, have %d", store.Len())
+	}
+
+	now = now.Add(time.Minute)
+	page, _ := store.Query(Query{})
+	if len(page.Messages) != 0 {
+		t.Errorf("expected expired messages to be hidden, got %d", len(page.Messages))
+	}
+	store.Prune()
+	if store.Len() != 0 {
+		t.Errorf("expected Prune to drop expired messages, have %d", store.Len())
+	}
+}
+
+func TestSearch(t *testing.T) {
+	store := NewMessageStoreWithRetention(RetentionPolicy{MaxCount: 3})
+	store.AddMessage(Message{Sender: "alice", Content: "deploy the backend"})
+	store.AddMessage(Message{Sender: "bob", Content: "Deployment finished"})
+	store.AddMessage(Message{Sender: "carol", Content: "lunch?"})
+
+	results, err := store.Search("deploy*", 0)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	for _, r := range results {
+		span := r.Matches[0]
+		if got := r.Message.Content[span.Start:span.End]; got != "deploy" && got != "Deployment" {
+			t.Errorf("unexpected highlighted term %q", got)
+		}
+	}
+
+	// Messages dropped by retention are removed from the index
+	store.AddMessage(Message{Sender: "dave", Content: "coffee"})
+	results, _ = store.Search("deploy*", 0)
+	if len(results) != 1 || results[0].Message.Sender != "bob" {
+		t.Errorf("expected only bob's message, got %+v", results)
+	}
+}
@@ -0,0 +1,175 @@
+package moderation
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WordList matches whole words case-insensitively. With Mask each match is
+// replaced by asterisks, any other action applies to the whole message.
+func WordList(action Action, words ...string) Filter {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[strings.ToLower(w)] = true
+	}
+	return FilterFunc(func(msg *Message) Result {
+		var b strings.Builder
+		matched := ""
+		content := msg.Content
+		start := -1
+		flush := func(end int) {
+			word := content[start:end]
+			if set[strings.ToLower(word)] {
+				if matched == "" {
+					matched = word
+				}
+				if action == Mask {
+					word = strings.Repeat("*", utf8.RuneCountInString(word))
+				}
+			}
+			b.WriteString(word)
+			start = -1
+		}
+		for i, r := range content {
+			isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+			switch {
+			case isWord && start < 0:
+				start = i
+			case !isWord && start >= 0:
+				flush(i)
+			}
+			if !isWord {
+				b.WriteRune(r)
+			}
+		}
+		if start >= 0 {
+			flush(len(content))
+		}
+
+		if matched == "" {
+			return Result{Action: Allow}
+		}
+		if action == Mask {
+			msg.Content = b.String()
+		}
+		return Result{Action: action, Reason: fmt.Sprintf("blocked word %q", matched)}
+	})
+}
+
+// linkPattern finds URLs with a scheme and bare www. hosts
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// LinkPolicy applies action to links whose host is not one of allowed or a
+// subdomain of one. With no allowed hosts every link matches. Mask replaces
+// the link with "[link removed]".
+func LinkPolicy(action Action, allowed ...string) Filter {
+	return FilterFunc(func(msg *Message) Result {
+		var blocked string
+		content := linkPattern.ReplaceAllStringFunc(msg.Content, func(link string) string {
+			if hostAllowed(link, allowed) {
+				return link
+			}
+			if blocked == "" {
+				blocked = link
+			}
+			return "[link removed]"
+		})
+		if blocked == "" {
+			return Result{Action: Allow}
+		}
+		if action == Mask {
+			msg.Content = content
+		}
+		return Result{Action: action, Reason: fmt.Sprintf("link %s is not allowed", blocked)}
+	})
+}
+
+func hostAllowed(link string, allowed []string) bool {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if host == a || strings.HasSuffix(host, "."+a) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxLength rejects messages longer than limit characters
+func MaxLength(limit int) Filter {
+	return FilterFunc(func(msg *Message) Result {
+		if n := utf8.RuneCountInString(msg.Content); n > limit {
+			return Result{Action: Reject, Reason: fmt.Sprintf("message is %d characters, the limit is %d", n, limit)}
+		}
+		return Result{Action: Allow}
+	})
+}
+
+// SpamFilter limits how fast each user may send and how often they may
+// repeat the same content within a sliding window
+type SpamFilter struct {
+	Window      time.Duration
+	MaxMessages int    // messages per user per window, zero means no limit
+	MaxRepeats  int    // identical messages per user per window, zero means no limit
+	Action      Action // applied when a limit is exceeded
+
+	mutex   sync.Mutex
+	history map[string][]sent // per user, oldest first
+}
+
+type sent struct {
+	at      time.Time
+	content string
+}
+
+// NewSpamFilter creates a SpamFilter that rejects messages over the limits
+func NewSpamFilter(window time.Duration, maxMessages, maxRepeats int) *SpamFilter {
+	return &SpamFilter{Window: window, MaxMessages: maxMessages, MaxRepeats: maxRepeats, Action: Reject}
+}
+
+// Check records the message and applies the limits. Messages over the limit
+// still count, so a user who keeps flooding stays limited.
+func (f *SpamFilter) Check(msg *Message) Result {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.history == nil {
+		f.history = make(map[string][]sent)
+	}
+	cutoff := msg.At.Add(-f.Window)
+	history := f.history[msg.UserID]
+	i := 0
+	for i < len(history) && !history[i].at.After(cutoff) {
+		i++
+	}
+	content := strings.ToLower(strings.TrimSpace(msg.Content))
+	history = append(history[i:], sent{at: msg.At, content: content})
+	f.history[msg.UserID] = history
+
+	if f.MaxMessages > 0 && len(history) > f.MaxMessages {
+		return Result{Action: f.Action, Reason: fmt.Sprintf("more than %d messages in %s", f.MaxMessages, f.Window)}
+	}
+	repeats := 0
+	for _, h := range history {
+		if h.content == content {
+			repeats++
+		}
+	}
+	if f.MaxRepeats > 0 && repeats > f.MaxRepeats {
+		return Result{Action: f.Action, Reason: fmt.Sprintf("same message more than %d times in %s", f.MaxRepeats, f.Window)}
+	}
+	return Result{Action: Allow}
+}
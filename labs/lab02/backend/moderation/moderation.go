@@ -0,0 +1,157 @@
+// Package moderation inspects chat messages with a chain of filters that can
+// allow, mask, flag or reject them. Flagged messages are kept in a review
+// queue for moderators.
+package moderation
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Action is the outcome of a filter, later actions are more severe
+type Action int
+
+// Actions in order of severity
+const (
+	Allow  Action = iota
+	Mask          // deliver with the offending parts replaced
+	Flag          // deliver and queue for review
+	Reject        // do not deliver
+)
+
+// String returns the name of the action
+func (a Action) String() string {
+	switch a {
+	case Allow:
+		return "allow"
+	case Mask:
+		return "mask"
+	case Flag:
+		return "flag"
+	case Reject:
+		return "reject"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrRejected is matched by errors.Is for every *RejectedError
+var ErrRejected = errors.New("message rejected")
+
+// RejectedError is returned by Pipeline.Enforce for rejected messages
+type RejectedError struct {
+	Reasons []string
+}
+
+func (e *RejectedError) Error() string {
+	return "message rejected: " + strings.Join(e.Reasons, "; ")
+}
+
+// Is reports whether target is ErrRejected
+func (e *RejectedError) Is(target error) bool {
+	return target == ErrRejected
+}
+
+// Message is the content being moderated
+type Message struct {
+	UserID  string
+	Content string
+	At      time.Time // when the message was sent, zero means now
+}
+
+// Result is the outcome of one filter. A Mask result must come with the
+// message content already rewritten.
+type Result struct {
+	Action Action
+	Reason string
+}
+
+// Filter inspects a message, filters that mask rewrite msg.Content
+type Filter interface {
+	Check(msg *Message) Result
+}
+
+// FilterFunc adapts a function to the Filter interface
+type FilterFunc func(msg *Message) Result
+
+// Check calls f(msg)
+func (f FilterFunc) Check(msg *Message) Result {
+	return f(msg)
+}
+
+// Verdict is the combined outcome of a pipeline
+type Verdict struct {
+	Action  Action   // most severe action of any filter
+	Content string   // content to deliver, with masks applied
+	Reasons []string // reasons of every filter that did not allow the message
+}
+
+// Pipeline runs mute/ban restrictions followed by filters in order, stopping
+// at the first rejection
+type Pipeline struct {
+	restrictions *Restrictions
+	filters      []Filter
+	queue        *ReviewQueue
+}
+
+// NewPipeline creates a pipeline with its own restrictions and review queue
+func NewPipeline(filters ...Filter) *Pipeline {
+	return &Pipeline{
+		restrictions: NewRestrictions(),
+		filters:      filters,
+		queue:        NewReviewQueue(DefaultQueueSize),
+	}
+}
+
+// Restrictions returns the per-user mutes and bans checked before the filters
+func (p *Pipeline) Restrictions() *Restrictions {
+	return p.restrictions
+}
+
+// Queue returns the review queue of flagged messages
+func (p *Pipeline) Queue() *ReviewQueue {
+	return p.queue
+}
+
+// Check runs the pipeline without side effects on the review queue
+func (p *Pipeline) Check(msg Message) Verdict {
+	if msg.At.IsZero() {
+		msg.At = time.Now()
+	}
+	verdict := Verdict{Action: Allow}
+	apply := func(r Result) bool {
+		if r.Action == Allow {
+			return true
+		}
+		if r.Action > verdict.Action {
+			verdict.Action = r.Action
+		}
+		verdict.Reasons = append(verdict.Reasons, r.Reason)
+		return r.Action != Reject
+	}
+
+	if apply(p.restrictions.Check(&msg)) {
+		for _, f := range p.filters {
+			if !apply(f.Check(&msg)) {
+				break
+			}
+		}
+	}
+	verdict.Content = msg.Content
+	return verdict
+}
+
+// Enforce checks a message, returning a *RejectedError if it is rejected and
+// the content to deliver otherwise. Flagged messages are queued for review
+// under ref, the caller's identifier for the message.
+func (p *Pipeline) Enforce(ref string, msg Message) (string, error) {
+	verdict := p.Check(msg)
+	switch verdict.Action {
+	case Reject:
+		return "", &RejectedError{Reasons: verdict.Reasons}
+	case Flag:
+		p.queue.Add(ref, msg.UserID, verdict.Content, verdict.Reasons)
+	}
+	return verdict.Content, nil
+}
@@ -0,0 +1,127 @@
+package moderation
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWordList(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  Action
+		content string
+		want    Action
+		masked  string
+	}{
+		{"clean", Mask, "have a nice day", Allow, "have a nice day"},
+		{"mask", Mask, "what the Heck, heck!", Mask, "what the ****, ****!"},
+		{"substring", Mask, "checkpoint", Allow, "checkpoint"},
+		{"flag", Flag, "heck", Flag, "heck"},
+		{"reject", Reject, "oh heck", Reject, "oh heck"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := Message{UserID: "alice", Content: tt.content}
+			r := WordList(tt.action, "heck").Check(&msg)
+			if r.Action != tt.want || msg.Content != tt.masked {
+				t.Errorf("got %v %q, want %v %q", r.Action, msg.Content, tt.want, tt.masked)
+			}
+		})
+	}
+}
+
+func TestLinkPolicy(t *testing.T) {
+	filter := LinkPolicy(Mask, "example.com")
+	msg := Message{Content: "docs at https://docs.example.com/a and www.evil.test/x"}
+	r := filter.Check(&msg)
+	if r.Action != Mask || msg.Content != "docs at https://docs.example.com/a and [link removed]" {
+		t.Errorf("got %v %q", r.Action, msg.Content)
+	}
+
+	msg = Message{Content: "see https://example.com.evil.test"}
+	if r := LinkPolicy(Reject, "example.com").Check(&msg); r.Action != Reject {
+		t.Errorf("lookalike host allowed: %v", r.Action)
+	}
+}
+
+func TestSpamFilter(t *testing.T) {
+	filter := NewSpamFilter(time.Minute, 3, 1)
+	start := time.Unix(1000, 0)
+	check := func(user, content string, offset time.Duration) Action {
+		msg := Message{UserID: user, Content: content, At: start.Add(offset)}
+		return filter.Check(&msg).Action
+	}
+
+	if check("alice", "hi", 0) != Allow || check("alice", "HI ", time.Second) != Reject {
+		t.Error("repeated message was not rejected")
+	}
+	if check("bob", "hi", time.Second) != Allow {
+		t.Error("limits are not per user")
+	}
+	check("carol", "a", 0)
+	check("carol", "b", time.Second)
+	check("carol", "c", 2*time.Second)
+	if check("carol", "d", 3*time.Second) != Reject {
+		t.Error("rate limit was not applied")
+	}
+	if check("carol", "e", 2*time.Minute) != Allow {
+		t.Error("window did not slide")
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	p := NewPipeline(MaxLength(20), WordList(Mask, "darn"), WordList(Flag, "refund"))
+
+	if content, err := p.Enforce("1", Message{UserID: "alice", Content: "darn it"}); err != nil || content != "**** it" {
+		t.Errorf("got %q, %v", content, err)
+	}
+	_, err := p.Enforce("2", Message{UserID: "alice", Content: "this message is far too long"})
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) || !errors.Is(err, ErrRejected) || len(rejected.Reasons) != 1 {
+		t.Errorf("expected rejection, got %v", err)
+	}
+
+	// Flagged messages are delivered and queued with every reason
+	content, err := p.Enforce("3", Message{UserID: "bob", Content: "darn, refund me"})
+	if err != nil || content != "****, refund me" {
+		t.Errorf("got %q, %v", content, err)
+	}
+	pending := p.Queue().List(StatusPending)
+	if len(pending) != 1 || pending[0].Ref != "3" || len(pending[0].Reasons) != 2 {
+		t.Fatalf("unexpected queue %+v", pending)
+	}
+
+	item, err := p.Queue().Resolve(pending[0].ID, StatusRemoved, "mod")
+	if err != nil || item.Status != StatusRemoved || item.Reviewer != "mod" {
+		t.Errorf("Resolve: %+v, %v", item, err)
+	}
+	if _, err := p.Queue().Resolve(pending[0].ID, StatusApproved, "mod"); err != ErrAlreadyReviewed {
+		t.Errorf("expected ErrAlreadyReviewed, got %v", err)
+	}
+	if _, err := p.Queue().Resolve(99, StatusApproved, "mod"); err != ErrReviewNotFound {
+		t.Errorf("expected ErrReviewNotFound, got %v", err)
+	}
+	if len(p.Queue().List(StatusPending)) != 0 {
+		t.Error("resolved item still pending")
+	}
+}
+
+func TestRestrictions(t *testing.T) {
+	p := NewPipeline()
+	p.Restrictions().Mute("alice", time.Hour)
+	p.Restrictions().Ban("bob")
+
+	for _, user := range []string{"alice", "bob"} {
+		if _, err := p.Enforce("", Message{UserID: user, Content: "hi"}); !errors.Is(err, ErrRejected) {
+			t.Errorf("%s: expected rejection, got %v", user, err)
+		}
+	}
+	p.Restrictions().Lift("alice")
+	p.Restrictions().Mute("carol", -time.Second)
+	for _, user := range []string{"alice", "carol"} {
+		if _, err := p.Enforce("", Message{UserID: user, Content: "hi"}); err != nil {
+			t.Errorf("%s: unexpected error %v", user, err)
+		}
+	}
+}
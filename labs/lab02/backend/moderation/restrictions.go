@@ -0,0 +1,74 @@
+package moderation
+
+import (
+	"sync"
+	"time"
+)
+
+// Restrictions tracks muted and banned users, both are rejected by the
+// pipeline before any filter runs
+type Restrictions struct {
+	mutex  sync.RWMutex
+	muted  map[string]time.Time // user -> end of the mute
+	banned map[string]bool
+	now    func() time.Time
+}
+
+// NewRestrictions creates an empty set of restrictions
+func NewRestrictions() *Restrictions {
+	return &Restrictions{
+		muted:  make(map[string]time.Time),
+		banned: make(map[string]bool),
+		now:    time.Now,
+	}
+}
+
+// Mute stops a user from sending for d
+func (r *Restrictions) Mute(userID string, d time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.muted[userID] = r.now().Add(d)
+}
+
+// Ban stops a user from sending until Lift is called
+func (r *Restrictions) Ban(userID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.banned[userID] = true
+}
+
+// Lift removes any mute or ban of a user
+func (r *Restrictions) Lift(userID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.muted, userID)
+	delete(r.banned, userID)
+}
+
+// MutedUntil returns the end of a user's mute, the zero time if not muted
+func (r *Restrictions) MutedUntil(userID string) time.Time {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if until, ok := r.muted[userID]; ok && r.now().Before(until) {
+		return until
+	}
+	return time.Time{}
+}
+
+// IsBanned reports whether a user is banned
+func (r *Restrictions) IsBanned(userID string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.banned[userID]
+}
+
+// Check rejects messages from banned and muted users
+func (r *Restrictions) Check(msg *Message) Result {
+	if r.IsBanned(msg.UserID) {
+		return Result{Action: Reject, Reason: "user is banned"}
+	}
+	if until := r.MutedUntil(msg.UserID); !until.IsZero() {
+		return Result{Action: Reject, Reason: "user is muted until " + until.Format(time.RFC3339)}
+	}
+	return Result{Action: Allow}
+}
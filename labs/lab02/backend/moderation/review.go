@@ -0,0 +1,115 @@
+package moderation
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultQueueSize is the number of review items kept by NewPipeline
+const DefaultQueueSize = 1000
+
+// Review errors
+var (
+	ErrReviewNotFound    = errors.New("review item not found")
+	ErrAlreadyReviewed   = errors.New("review item already resolved")
+	ErrInvalidResolution = errors.New("resolution must be approved or removed")
+)
+
+// ReviewStatus is the state of a flagged message
+type ReviewStatus string
+
+// Review states
+const (
+	StatusPending  ReviewStatus = "pending"
+	StatusApproved ReviewStatus = "approved"
+	StatusRemoved  ReviewStatus = "removed"
+)
+
+// ReviewItem is a flagged message waiting for or resolved by a moderator
+type ReviewItem struct {
+	ID         uint64       `json:"id"`
+	Ref        string       `json:"ref"` // caller's identifier of the message
+	UserID     string       `json:"user_id"`
+	Content    string       `json:"content"`
+	Reasons    []string     `json:"reasons"`
+	FlaggedAt  time.Time    `json:"flagged_at"`
+	Status     ReviewStatus `json:"status"`
+	Reviewer   string       `json:"reviewer,omitempty"`
+	ReviewedAt *time.Time   `json:"reviewed_at,omitempty"`
+}
+
+// ReviewQueue keeps the most recent flagged messages, oldest first
+type ReviewQueue struct {
+	mutex  sync.RWMutex
+	items  []ReviewItem
+	limit  int
+	lastID uint64
+}
+
+// NewReviewQueue creates a queue that drops the oldest items beyond limit
+func NewReviewQueue(limit int) *ReviewQueue {
+	return &ReviewQueue{limit: limit}
+}
+
+// Add queues a flagged message and returns its review item
+func (q *ReviewQueue) Add(ref, userID, content string, reasons []string) ReviewItem {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.lastID++
+	item := ReviewItem{
+		ID:        q.lastID,
+		Ref:       ref,
+		UserID:    userID,
+		Content:   content,
+		Reasons:   append([]string(nil), reasons...),
+		FlaggedAt: time.Now(),
+		Status:    StatusPending,
+	}
+	q.items = append(q.items, item)
+	if q.limit > 0 && len(q.items) > q.limit {
+		q.items = append([]ReviewItem(nil), q.items[len(q.items)-q.limit:]...)
+	}
+	return item
+}
+
+// List returns the items with the given status, or all items if status is empty
+func (q *ReviewQueue) List(status ReviewStatus) []ReviewItem {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	items := []ReviewItem{}
+	for _, item := range q.items {
+		if status == "" || item.Status == status {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Resolve records a moderator's decision on a pending item. The caller is
+// responsible for removing the message when the status is StatusRemoved.
+func (q *ReviewQueue) Resolve(id uint64, status ReviewStatus, reviewer string) (ReviewItem, error) {
+	if status != StatusApproved && status != StatusRemoved {
+		return ReviewItem{}, ErrInvalidResolution
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for i := range q.items {
+		if q.items[i].ID != id {
+			continue
+		}
+		if q.items[i].Status != StatusPending {
+			return ReviewItem{}, ErrAlreadyReviewed
+		}
+		now := time.Now()
+		q.items[i].Status = status
+		q.items[i].Reviewer = reviewer
+		q.items[i].ReviewedAt = &now
+		return q.items[i], nil
+	}
+	return ReviewItem{}, ErrReviewNotFound
+}
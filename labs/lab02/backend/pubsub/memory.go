@@ -0,0 +1,101 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrClosed is returned when using a closed transport
+var ErrClosed = errors.New("pubsub: transport closed")
+
+// subscriptionBuffer is the number of undelivered payloads kept per subscription
+const subscriptionBuffer = 1024
+
+// MemoryBus is an in-process pub/sub backend. Every broker that shares a bus
+// sees the messages of the others, which makes it useful for tests.
+type MemoryBus struct {
+	mutex   sync.RWMutex
+	subs    map[string]map[chan []byte]struct{} // topic -> subscriptions
+	closed  bool
+	dropped atomic.Uint64
+}
+
+// NewMemoryBus creates an empty bus
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{subs: make(map[string]map[chan []byte]struct{})}
+}
+
+// Publish delivers payload to every subscription of topic without waiting:
+// like a Redis subscriber that falls too far behind, a subscription whose
+// buffer is full misses the payload
+func (b *MemoryBus) Publish(ctx context.Context, topic string, payload []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+	for ch := range b.subs[topic] {
+		select {
+		case ch <- append([]byte(nil), payload...):
+		default:
+			b.dropped.Add(1)
+		}
+	}
+	return nil
+}
+
+// Dropped returns how many payloads were lost to full subscriptions
+func (b *MemoryBus) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Subscribe returns a channel of payloads published to topic, it is closed
+// when ctx is cancelled or the bus is closed
+func (b *MemoryBus) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+	ch := make(chan []byte, subscriptionBuffer)
+	if b.subs[topic] == nil {
+		b.subs[topic] = make(map[chan []byte]struct{})
+	}
+	b.subs[topic][ch] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if _, ok := b.subs[topic][ch]; ok {
+			delete(b.subs[topic], ch)
+			close(ch)
+		}
+	}()
+	return ch, nil
+}
+
+// Close closes every subscription
+func (b *MemoryBus) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for topic, subs := range b.subs {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(b.subs, topic)
+	}
+	return nil
+}
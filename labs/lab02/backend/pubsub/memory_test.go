@@ -0,0 +1,93 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, ch <-chan []byte) string {
+	t.Helper()
+	select {
+	case payload, ok := <-ch:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return string(payload)
+	case <-time.After(time.Second):
+		t.Fatal("no payload received")
+		return ""
+	}
+}
+
+func TestMemoryBus(t *testing.T) {
+	bus := NewMemoryBus()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	first, err := bus.Subscribe(ctx, "chat")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	second, _ := bus.Subscribe(context.Background(), "chat")
+	other, _ := bus.Subscribe(context.Background(), "other")
+
+	if err := bus.Publish(context.Background(), "chat", []byte("hello")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if got := receive(t, first); got != "hello" {
+		t.Errorf("expected hello, got %q", got)
+	}
+	if got := receive(t, second); got != "hello" {
+		t.Errorf("expected hello, got %q", got)
+	}
+	select {
+	case <-other:
+		t.Error("payload delivered to another topic")
+	default:
+	}
+
+	cancel()
+	if _, ok := <-first; ok {
+		t.Error("expected cancelled subscription to be closed")
+	}
+
+	bus.Close()
+	if _, ok := <-second; ok {
+		t.Error("expected subscriptions to be closed with the bus")
+	}
+	if err := bus.Publish(context.Background(), "chat", nil); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestMemoryBusSlowSubscriber(t *testing.T) {
+	bus := NewMemoryBus()
+	defer bus.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	slow, _ := bus.Subscribe(ctx, "chat")
+
+	// Nobody reads, so the buffer fills and later payloads are dropped
+	// instead of blocking the publisher
+	for i := 0; i < subscriptionBuffer+5; i++ {
+		if err := bus.Publish(context.Background(), "chat", []byte("x")); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+	if got := bus.Dropped(); got != 5 {
+		t.Errorf("Expected 5 dropped payloads, got %d", got)
+	}
+
+	// Cancelling a full subscription does not deadlock
+	done := make(chan struct{})
+	go func() {
+		cancel()
+		for range slow {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("cancelled subscription was not closed")
+	}
+}
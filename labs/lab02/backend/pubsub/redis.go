@@ -0,0 +1,276 @@
+package pubsub
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// dialTimeout bounds connecting to the server
+const dialTimeout = 5 * time.Second
+
+// commandTimeout bounds a command whose context has no deadline, so a server
+// that stops answering cannot block the caller forever
+const commandTimeout = 5 * time.Second
+
+// Reconnect backoff, doubled after every failed attempt
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+// RedisTransport publishes and subscribes through a server speaking the Redis
+// protocol (RESP2). Publishing shares one connection, every subscription
+// opens its own because a subscribed connection cannot issue other commands.
+// Broken connections are replaced, payloads published while a subscription
+// reconnects are lost as with any Redis subscriber.
+type RedisTransport struct {
+	addr    string
+	timeout time.Duration // commandTimeout, shorter in tests
+	backoff time.Duration // minBackoff, shorter in tests
+
+	mutex     sync.Mutex // serializes commands on conn
+	conn      net.Conn   // nil after a failure until the next Publish redials
+	reader    *bufio.Reader
+	writer    *bufio.Writer
+	retryAt   time.Time // no redial before, after a failed one
+	lastErr   error
+	nextDelay time.Duration
+	closed    bool
+	subs      map[net.Conn]struct{}
+}
+
+// DialRedis connects to the server at addr (host:port)
+func DialRedis(addr string) (*RedisTransport, error) {
+	t := &RedisTransport{
+		addr:    addr,
+		timeout: commandTimeout,
+		backoff: minBackoff,
+		subs:    make(map[net.Conn]struct{}),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	if err := t.connectLocked(ctx); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Publish sends PUBLISH topic payload, reconnecting first if the previous
+// command failed. Without a deadline on ctx the command times out after
+// commandTimeout.
+func (t *RedisTransport) Publish(ctx context.Context, topic string, payload []byte) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closed {
+		return ErrClosed
+	}
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	if t.conn == nil {
+		if time.Now().Before(t.retryAt) {
+			return t.lastErr
+		}
+		if err := t.connectLocked(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Cancelling ctx interrupts a command that is waiting on the network
+	deadline, _ := ctx.Deadline()
+	t.conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { t.conn.SetDeadline(time.Now()) })
+	reply, err := t.commandLocked([]byte("PUBLISH"), []byte(topic), payload)
+	if !stop() {
+		err = errors.Join(err, ctx.Err())
+	}
+	if err != nil {
+		// The connection may hold half a reply, start over with a new one
+		t.conn.Close()
+		t.conn = nil
+		return err
+	}
+	t.conn.SetDeadline(time.Time{})
+	if e, ok := reply.(respError); ok {
+		return e
+	}
+	return nil
+}
+
+// commandLocked writes a command and reads its reply, the caller must hold mutex
+func (t *RedisTransport) commandLocked(args ...[]byte) (interface{}, error) {
+	if err := writeCommand(t.writer, args...); err != nil {
+		return nil, err
+	}
+	return readValue(t.reader)
+}
+
+// connectLocked dials the publishing connection, backing off after failures.
+// The caller must hold mutex.
+func (t *RedisTransport) connectLocked(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		if t.nextDelay == 0 {
+			t.nextDelay = t.backoff
+		}
+		t.retryAt = time.Now().Add(t.nextDelay)
+		t.nextDelay = min(2*t.nextDelay, maxBackoff)
+		t.lastErr = err
+		return err
+	}
+	t.conn, t.reader, t.writer = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+	t.nextDelay, t.lastErr = 0, nil
+	return nil
+}
+
+// withTimeout applies commandTimeout to a context without a deadline
+func (t *RedisTransport) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, t.timeout)
+}
+
+// Subscribe sends SUBSCRIBE topic on a new connection and streams the published
+// payloads until ctx is cancelled or the transport is closed. A broken
+// connection is replaced, retrying with exponential backoff.
+func (t *RedisTransport) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	conn, reader, err := t.subscribe(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan []byte, subscriptionBuffer)
+	go func() {
+		defer close(out)
+		for {
+			t.stream(ctx, conn, reader, out)
+			delay := t.backoff
+			for {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+				conn, reader, err = t.subscribe(ctx, topic)
+				if err == nil {
+					break
+				}
+				if errors.Is(err, ErrClosed) || ctx.Err() != nil {
+					return
+				}
+				delay = min(2*delay, maxBackoff)
+			}
+		}
+	}()
+	return out, nil
+}
+
+// subscribe opens a connection subscribed to topic
+func (t *RedisTransport) subscribe(ctx context.Context, topic string) (net.Conn, *bufio.Reader, error) {
+	t.mutex.Lock()
+	closed := t.closed
+	t.mutex.Unlock()
+	if closed {
+		return nil, nil, ErrClosed
+	}
+
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	reader := bufio.NewReader(conn)
+	if err := writeCommand(bufio.NewWriter(conn), []byte("SUBSCRIBE"), []byte(topic)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	// The first reply confirms the subscription
+	reply, err := readValue(reader)
+	if err == nil {
+		if kind, _ := pushKind(reply); kind != "subscribe" {
+			err = errors.New("redis: unexpected reply to SUBSCRIBE")
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		conn.Close()
+		return nil, nil, ErrClosed
+	}
+	t.subs[conn] = struct{}{}
+	return conn, reader, nil
+}
+
+// stream forwards the payloads of a subscribed connection until it fails or
+// ctx is cancelled, then closes it
+func (t *RedisTransport) stream(ctx context.Context, conn net.Conn, reader *bufio.Reader, out chan<- []byte) {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer func() {
+		stop()
+		conn.Close()
+		t.mutex.Lock()
+		delete(t.subs, conn)
+		t.mutex.Unlock()
+	}()
+	for {
+		reply, err := readValue(reader)
+		if err != nil {
+			return
+		}
+		kind, values := pushKind(reply)
+		if kind != "message" || len(values) != 3 {
+			continue
+		}
+		payload, _ := values[2].([]byte)
+		select {
+		case out <- payload:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close closes the publishing connection and every subscription
+func (t *RedisTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	for conn := range t.subs {
+		conn.Close()
+	}
+	if t.conn == nil {
+		return nil
+	}
+	return t.conn.Close()
+}
+
+// pushKind returns the lower-case kind of a pub/sub push such as "message"
+func pushKind(reply interface{}) (string, []interface{}) {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) == 0 {
+		return "", nil
+	}
+	kind, _ := values[0].([]byte)
+	return string(bytes.ToLower(kind)), values
+}
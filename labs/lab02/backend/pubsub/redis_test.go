@@ -0,0 +1,243 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a local stand-in that implements PING, PUBLISH and SUBSCRIBE
+type fakeRedis struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	subs     map[string][]*bufio.Writer
+	conns    []net.Conn
+}
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRedis{listener: listener, subs: make(map[string][]*bufio.Writer)}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fakeRedis) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.conns = append(s.conns, conn)
+		s.mutex.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// disconnect drops every client connection, like a server restart
+func (s *fakeRedis) disconnect() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+	s.subs = make(map[string][]*bufio.Writer)
+}
+
+func (s *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		value, err := readValue(reader)
+		if err != nil {
+			return
+		}
+		args, _ := value.([]interface{})
+		if len(args) == 0 {
+			return
+		}
+		cmd, _ := args[0].([]byte)
+
+		s.mutex.Lock()
+		switch strings.ToUpper(string(cmd)) {
+		case "PING":
+			writer.WriteString("+PONG\r\n")
+			writer.Flush()
+		case "SUBSCRIBE":
+			topic := args[1].([]byte)
+			s.subs[string(topic)] = append(s.subs[string(topic)], writer)
+			writeCommand(writer, []byte("subscribe"), topic)
+		case "PUBLISH":
+			topic, payload := args[1].([]byte), args[2].([]byte)
+			for _, w := range s.subs[string(topic)] {
+				writeCommand(w, []byte("message"), topic, payload)
+			}
+			writer.WriteString(":1\r\n")
+			writer.Flush()
+		default:
+			writer.WriteString("-ERR unknown command\r\n")
+			writer.Flush()
+		}
+		s.mutex.Unlock()
+	}
+}
+
+func TestRedisTransport(t *testing.T) {
+	server := startFakeRedis(t)
+
+	publisher, err := DialRedis(server.addr())
+	if err != nil {
+		t.Fatalf("DialRedis failed: %v", err)
+	}
+	defer publisher.Close()
+	subscriber, err := DialRedis(server.addr())
+	if err != nil {
+		t.Fatalf("DialRedis failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := subscriber.Subscribe(ctx, "chat")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	payload := "binary\r\n\x00payload"
+	if err := publisher.Publish(context.Background(), "chat", []byte(payload)); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if got := receive(t, ch); got != payload {
+		t.Errorf("expected %q, got %q", payload, got)
+	}
+
+	subscriber.Close()
+	for range ch {
+	}
+	if err := subscriber.Publish(context.Background(), "chat", nil); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestRedisTransportReconnects(t *testing.T) {
+	server := startFakeRedis(t)
+	transport, err := DialRedis(server.addr())
+	if err != nil {
+		t.Fatalf("DialRedis failed: %v", err)
+	}
+	defer transport.Close()
+	transport.backoff = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := transport.Subscribe(ctx, "chat")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	server.disconnect()
+
+	// The first Publish may fail on the dead connection, later ones redial.
+	// Payloads published before the subscription is back are lost.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		transport.Publish(context.Background(), "chat", []byte("again"))
+		select {
+		case payload, ok := <-ch:
+			if !ok {
+				t.Fatal("subscription closed instead of reconnecting")
+			}
+			if string(payload) != "again" {
+				t.Errorf("expected again, got %q", payload)
+			}
+			return
+		case <-time.After(20 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscription did not reconnect")
+		}
+	}
+}
+
+func TestRedisPublishTimeout(t *testing.T) {
+	// A server that accepts connections and never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	transport, err := DialRedis(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("DialRedis failed: %v", err)
+	}
+	defer transport.Close()
+	transport.timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	if err := transport.Publish(context.Background(), "chat", []byte("x")); err == nil {
+		t.Error("expected Publish to time out")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := transport.Publish(ctx, "chat", []byte("x")); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled context to end Publish, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Publish blocked for %v", elapsed)
+	}
+}
+
+func TestReadValueLimits(t *testing.T) {
+	for _, input := range []string{
+		"*99999999999\r\n",
+		"$999999999\r\n",
+		strings.Repeat("*1\r\n", maxArrayDepth+1) + ":1\r\n",
+	} {
+		if _, err := readValue(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("expected %q to be rejected", input)
+		}
+	}
+}
+
+func TestReadValue(t *testing.T) {
+	input := "*3\r\n$7\r\nmessage\r\n:42\r\n$-1\r\n-ERR boom\r\n"
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	value, err := readValue(reader)
+	if err != nil {
+		t.Fatalf("readValue failed: %v", err)
+	}
+	values := value.([]interface{})
+	if string(values[0].([]byte)) != "message" || values[1].(int64) != 42 || values[2] != nil {
+		t.Errorf("unexpected array %v", values)
+	}
+
+	value, _ = readValue(reader)
+	if e, ok := value.(respError); !ok || e.Error() != "redis: ERR boom" {
+		t.Errorf("expected error reply, got %v", value)
+	}
+}
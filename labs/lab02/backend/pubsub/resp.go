@@ -0,0 +1,104 @@
+package pubsub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Limits that guard against huge allocations and deep recursion from a
+// broken peer, pub/sub replies are small arrays of bulk strings
+const (
+	maxBulkLength  = 64 << 20
+	maxArrayLength = 1 << 16
+	maxArrayDepth  = 8
+)
+
+// respError is an error reply sent by the server
+type respError string
+
+func (e respError) Error() string { return "redis: " + string(e) }
+
+// writeCommand encodes a command as a RESP array of bulk strings
+func writeCommand(w *bufio.Writer, args ...[]byte) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n", len(arg))
+		w.Write(arg)
+		w.WriteString("\r\n")
+	}
+	return w.Flush()
+}
+
+// readValue decodes one RESP2 value: simple strings and bulk strings become
+// []byte, integers int64, arrays []interface{}, errors respError and nil bulk
+// strings or arrays nil
+func readValue(r *bufio.Reader) (interface{}, error) {
+	return readNested(r, 0)
+}
+
+// readNested decodes a value inside depth arrays
+func readNested(r *bufio.Reader, depth int) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return []byte(line[1:]), nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxBulkLength {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxArrayLength {
+			return nil, fmt.Errorf("redis: invalid array length %q", line[1:])
+		}
+		if depth >= maxArrayDepth {
+			return nil, errors.New("redis: arrays nested too deeply")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = readNested(r, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", line[0])
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("redis: malformed line")
+	}
+	return line[:len(line)-2], nil
+}
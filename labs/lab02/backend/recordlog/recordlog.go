@@ -0,0 +1,233 @@
+// Package recordlog implements the crash-safe append-only log behind the
+// message store and the offline store
+package recordlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// recordHeaderSize is the payload length followed by its CRC32
+const recordHeaderSize = 8
+
+// maxRecordSize guards against allocating garbage lengths from a corrupt header
+const maxRecordSize = 1 << 24
+
+var errCorruptRecord = errors.New("corrupt log record")
+
+// ErrClosed is returned when writing to a closed log
+var ErrClosed = errors.New("record log is closed")
+
+// File is the part of *os.File used by a Log
+type File interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Truncate(size int64) error
+	Sync() error
+}
+
+// Log is an append-only file of length-prefixed, checksummed JSON records.
+// It is not safe for concurrent use, callers serialize access.
+type Log[T any] struct {
+	path    string
+	file    File
+	size    int64 // offset after the last whole record
+	records int   // records currently in the file
+	err     error // set when a failed write could not be undone
+}
+
+// Open opens the log at path, creating the file if needed, and passes every
+// record to replay in order. A record torn by a crash at the end of the file
+// is discarded, a damaged record followed by whole ones is an error.
+func Open[T any](path string, replay func(T)) (*Log[T], error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log[T]{path: path, file: file}
+	if err := l.recover(replay); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// recover replays the file and truncates an incomplete last record
+func (l *Log[T]) recover(replay func(T)) error {
+	end, err := l.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(l.file)
+	for {
+		rec, n, err := readRecord[T](reader)
+		if err == io.EOF {
+			break
+		}
+		if err == errCorruptRecord && l.size+n >= end {
+			// A crash while appending leaves a partial last record,
+			// everything before it is intact
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("record log %s is corrupt at offset %d: %w", l.path, l.size, err)
+		}
+		replay(rec)
+		l.size += n
+		l.records++
+	}
+	if err := l.file.Truncate(l.size); err != nil {
+		return err
+	}
+	_, err = l.file.Seek(l.size, io.SeekStart)
+	return err
+}
+
+// Records returns the number of records currently in the file
+func (l *Log[T]) Records() int {
+	return l.records
+}
+
+// Append writes a record and syncs it to disk, undoing a partial write when
+// it fails
+func (l *Log[T]) Append(rec T) error {
+	if l.err != nil {
+		return l.err
+	}
+	n, err := writeRecord(l.file, rec)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		l.undo()
+		return err
+	}
+	l.size += n
+	l.records++
+	return nil
+}
+
+// undo cuts the file back to the last whole record, so the next record does
+// not follow a torn one, or stops further writes when even that fails
+func (l *Log[T]) undo() {
+	if err := l.file.Truncate(l.size); err != nil {
+		l.err = fmt.Errorf("record log %s is unusable: %w", l.path, err)
+		return
+	}
+	if _, err := l.file.Seek(l.size, io.SeekStart); err != nil {
+		l.err = fmt.Errorf("record log %s is unusable: %w", l.path, err)
+	}
+}
+
+// Rewrite replaces the contents of the log with recs. The new log is written
+// next to the old one and atomically renamed over it, so on failure the old
+// log is still valid.
+func (l *Log[T]) Rewrite(recs []T) error {
+	if l.err != nil {
+		return l.err
+	}
+	tmpPath := l.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	w := bufio.NewWriter(tmp)
+	var size int64
+	for i := 0; err == nil && i < len(recs); i++ {
+		var n int64
+		n, err = writeRecord(w, recs[i])
+		size += n
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, l.path)
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	syncDir(filepath.Dir(l.path))
+
+	l.file.Close()
+	l.file = tmp
+	l.size, l.records = size, len(recs)
+	return nil
+}
+
+// Close releases the file, writes fail with ErrClosed afterwards
+func (l *Log[T]) Close() error {
+	if l.err == nil {
+		l.err = ErrClosed
+	}
+	return l.file.Close()
+}
+
+// writeRecord writes one record and returns its size on disk
+func writeRecord[T any](w io.Writer, rec T) (int64, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[recordHeaderSize:], payload)
+	if _, err := w.Write(buf); err != nil {
+		return 0, err
+	}
+	return int64(len(buf)), nil
+}
+
+// readRecord returns the next record and its size on disk, io.EOF at a clean
+// end. A corrupt record still reports the size its header claims.
+func readRecord[T any](r io.Reader) (T, int64, error) {
+	var rec T
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return rec, recordHeaderSize, errCorruptRecord
+		}
+		return rec, 0, err
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	n := int64(recordHeaderSize) + int64(size)
+	if size > maxRecordSize {
+		return rec, n, errCorruptRecord
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return rec, n, errCorruptRecord
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return rec, n, errCorruptRecord
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, n, errCorruptRecord
+	}
+	return rec, n, nil
+}
+
+// syncDir makes a rename durable, not every platform supports it so errors are ignored
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,119 @@
+package recordlog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testRecord struct {
+	N int `json:"n"`
+}
+
+// open opens the log at path and returns it with the replayed records
+func open(t *testing.T, path string) (*Log[testRecord], []int) {
+	t.Helper()
+	var got []int
+	l, err := Open(path, func(rec testRecord) { got = append(got, rec.N) })
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return l, got
+}
+
+func TestLogRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.log")
+	l, _ := open(t, path)
+	for n := 1; n <= 3; n++ {
+		if err := l.Append(testRecord{n}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	l.Close()
+	if err := l.Append(testRecord{4}); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+
+	// A crash in the middle of the last record loses only that record
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	l, got := open(t, path)
+	if len(got) != 2 || got[1] != 2 || l.Records() != 2 {
+		t.Fatalf("Expected records 1 and 2, got %v", got)
+	}
+	l.Append(testRecord{5})
+	l.Close()
+	l, got = open(t, path)
+	l.Close()
+	if len(got) != 3 || got[2] != 5 {
+		t.Errorf("Expected the new record after the intact ones, got %v", got)
+	}
+
+	// Damage before the last record is not mistaken for a crash
+	data, _ := os.ReadFile(path)
+	data[recordHeaderSize+2] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+	if _, err := Open(path, func(testRecord) {}); err == nil {
+		t.Errorf("Expected a corrupt log to be rejected")
+	}
+}
+
+func TestLogRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.log")
+	l, _ := open(t, path)
+	for n := 1; n <= 5; n++ {
+		l.Append(testRecord{n})
+	}
+	if err := l.Rewrite([]testRecord{{4}, {5}}); err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	l.Append(testRecord{6})
+	if l.Records() != 3 {
+		t.Errorf("Expected 3 records, got %d", l.Records())
+	}
+	l.Close()
+
+	_, got := open(t, path)
+	if len(got) != 3 || got[0] != 4 || got[2] != 6 {
+		t.Errorf("Unexpected records after rewrite %v", got)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary file to be removed, got %v", err)
+	}
+}
+
+// failingFile writes only part of the next record and then fails
+type failingFile struct {
+	File
+	fail bool
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	if f.fail {
+		f.fail = false
+		n, _ := f.File.Write(p[:len(p)/2])
+		return n, errors.New("disk full")
+	}
+	return f.File.Write(p)
+}
+
+func TestLogUndoesPartialWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.log")
+	l, _ := open(t, path)
+	l.Append(testRecord{1})
+	l.file = &failingFile{File: l.file, fail: true}
+	if err := l.Append(testRecord{2}); err == nil {
+		t.Fatal("Expected the failed write to be reported")
+	}
+	l.Append(testRecord{3})
+	l.Close()
+
+	// Without the undo the torn record would hide every later one
+	_, got := open(t, path)
+	if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Errorf("Unexpected records %v", got)
+	}
+}
@@ -0,0 +1,383 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Span is a byte range [Start, End) of a matched term in the indexed text
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Result is a single search hit
+type Result struct {
+	ID      uint64
+	Score   float64
+	Matches []Span // matched terms in text order, for highlighting
+}
+
+// Options tune a search
+type Options struct {
+	Limit           int           // maximum number of results, zero means no limit
+	RecencyHalfLife time.Duration // age at which the recency boost halves, defaults to 24h
+	Now             time.Time     // reference time for recency, defaults to time.Now
+}
+
+type document struct {
+	timestamp int64    // Unix seconds
+	tokens    []Span   // byte offsets of every token
+	terms     []string // distinct terms, for removal
+}
+
+// Index is an inverted index over short texts such as chat messages.
+// It is safe for concurrent use and is updated incrementally.
+type Index struct {
+	mutex    sync.RWMutex
+	postings map[string]map[uint64][]int // term -> document -> token positions
+	docs     map[uint64]*document
+	terms    []string // sorted terms for prefix lookups, nil when stale
+}
+
+// NewIndex creates an empty index
+func NewIndex() *Index {
+	return &Index{
+		postings: make(map[string]map[uint64][]int),
+		docs:     make(map[uint64]*document),
+	}
+}
+
+// Add indexes text under id, replacing any previous text for the same id
+func (idx *Index) Add(id uint64, text string, timestamp int64) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.removeLocked(id)
+	doc := &document{timestamp: timestamp}
+	for pos, tok := range tokenize(text) {
+		doc.tokens = append(doc.tokens, tok.span)
+		docs, ok := idx.postings[tok.term]
+		if !ok {
+			docs = make(map[uint64][]int)
+			idx.postings[tok.term] = docs
+			idx.terms = nil
+		}
+		if _, seen := docs[id]; !seen {
+			doc.terms = append(doc.terms, tok.term)
+		}
+		docs[id] = append(docs[id], pos)
+	}
+	idx.docs[id] = doc
+}
+
+// Remove drops id from the index
+func (idx *Index) Remove(id uint64) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	idx.removeLocked(id)
+}
+
+// Len returns the number of indexed documents
+func (idx *Index) Len() int {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	return len(idx.docs)
+}
+
+func (idx *Index) removeLocked(id uint64) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	delete(idx.docs, id)
+	for _, term := range doc.terms {
+		docs := idx.postings[term]
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(idx.postings, term)
+			idx.terms = nil
+		}
+	}
+}
+
+// Search finds documents matching every clause of the query, best first.
+// Words match case-insensitively, a trailing * matches any word with that
+// prefix and double quotes match a phrase.
+func (idx *Index) Search(query string, opts Options) []Result {
+	clauses := parseQuery(query)
+	if len(clauses) == 0 {
+		return []Result{}
+	}
+	if opts.RecencyHalfLife <= 0 {
+		opts.RecencyHalfLife = 24 * time.Hour
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+
+	idx.mutex.RLock()
+	for idx.terms == nil {
+		// Rebuild the sorted term list for prefix lookups, another writer may
+		// invalidate it again before the read lock is reacquired
+		idx.mutex.RUnlock()
+		idx.mutex.Lock()
+		if idx.terms == nil {
+			idx.terms = make([]string, 0, len(idx.postings))
+			for term := range idx.postings {
+				idx.terms = append(idx.terms, term)
+			}
+			sort.Strings(idx.terms)
+		}
+		idx.mutex.Unlock()
+		idx.mutex.RLock()
+	}
+	defer idx.mutex.RUnlock()
+
+	var candidates map[uint64]*Result
+	for _, c := range clauses {
+		matches := idx.matchClause(c)
+		if candidates == nil {
+			candidates = matches
+		} else {
+			for id, r := range candidates {
+				m, ok := matches[id]
+				if !ok {
+					delete(candidates, id)
+					continue
+				}
+				r.Score += m.Score
+				r.Matches = append(r.Matches, m.Matches...)
+			}
+		}
+		if len(candidates) == 0 {
+			return []Result{}
+		}
+	}
+
+	results := make([]Result, 0, len(candidates))
+	for id, r := range candidates {
+		age := opts.Now.Sub(time.Unix(idx.docs[id].timestamp, 0))
+		if age < 0 {
+			age = 0
+		}
+		// Recency factor decays from 1 towards 0.5 so relevance still dominates
+		r.Score *= 0.5 + 0.5*math.Exp2(-float64(age)/float64(opts.RecencyHalfLife))
+		r.Matches = normalizeSpans(r.Matches)
+		results = append(results, *r)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID > results[j].ID
+	})
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+	return results
+}
+
+// matchClause returns the documents matching one clause with their scores
+func (idx *Index) matchClause(c clause) map[uint64]*Result {
+	results := make(map[uint64]*Result)
+	if len(c.terms) > 1 {
+		idx.matchPhrase(c.terms, results)
+		return results
+	}
+
+	terms := c.terms
+	if c.prefix {
+		terms = idx.expandPrefix(c.terms[0])
+	}
+	for _, term := range terms {
+		docs := idx.postings[term]
+		idf := idx.idf(len(docs))
+		for id, positions := range docs {
+			r := results[id]
+			if r == nil {
+				r = &Result{ID: id}
+				results[id] = r
+			}
+			r.Score += tfWeight(len(positions), len(idx.docs[id].tokens)) * idf
+			for _, pos := range positions {
+				r.Matches = append(r.Matches, idx.docs[id].tokens[pos])
+			}
+		}
+	}
+	return results
+}
+
+// matchPhrase finds documents where terms appear at consecutive positions
+func (idx *Index) matchPhrase(terms []string, results map[uint64]*Result) {
+	first := idx.postings[terms[0]]
+	idf := 0.0
+	for _, term := range terms {
+		idf += idx.idf(len(idx.postings[term]))
+	}
+
+	for id, starts := range first {
+		doc := idx.docs[id]
+		hits := 0
+		var spans []Span
+		for _, start := range starts {
+			if !idx.phraseAt(id, terms, start) {
+				continue
+			}
+			hits++
+			spans = append(spans, Span{doc.tokens[start].Start, doc.tokens[start+len(terms)-1].End})
+		}
+		if hits > 0 {
+			results[id] = &Result{ID: id, Score: tfWeight(hits, len(doc.tokens)) * idf, Matches: spans}
+		}
+	}
+}
+
+func (idx *Index) phraseAt(id uint64, terms []string, start int) bool {
+	for offset, term := range terms[1:] {
+		positions := idx.postings[term][id]
+		want := start + offset + 1
+		i := sort.SearchInts(positions, want)
+		if i == len(positions) || positions[i] != want {
+			return false
+		}
+	}
+	return true
+}
+
+func (idx *Index) expandPrefix(prefix string) []string {
+	i := sort.SearchStrings(idx.terms, prefix)
+	var terms []string
+	for ; i < len(idx.terms) && strings.HasPrefix(idx.terms[i], prefix); i++ {
+		terms = append(terms, idx.terms[i])
+	}
+	return terms
+}
+
+func (idx *Index) idf(df int) float64 {
+	return math.Log(1 + float64(len(idx.docs))/float64(df+1))
+}
+
+// tfWeight dampens repeated terms and favours short documents
+func tfWeight(count, length int) float64 {
+	return (1 + math.Log(float64(count))) / math.Sqrt(float64(length))
+}
+
+// Highlight wraps every span of text in pre and post
+func Highlight(text string, spans []Span, pre, post string) string {
+	var b strings.Builder
+	last := 0
+	for _, s := range normalizeSpans(spans) {
+		if s.Start < last || s.End > len(text) {
+			continue
+		}
+		b.WriteString(text[last:s.Start])
+		b.WriteString(pre)
+		b.WriteString(text[s.Start:s.End])
+		b.WriteString(post)
+		last = s.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// normalizeSpans sorts spans and merges overlapping ones
+func normalizeSpans(spans []Span) []Span {
+	if len(spans) < 2 {
+		return spans
+	}
+	sorted := append([]Span(nil), spans...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	merged := sorted[:1]
+	for _, s := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if s.Start <= last.End {
+			if s.End > last.End {
+				last.End = s.End
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+type token struct {
+	term string
+	span Span
+}
+
+// tokenize splits text into lower-case words of letters and digits
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || (start >= 0 && unicode.IsMark(r)) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), Span{start, i}})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), Span{start, len(text)}})
+	}
+	return tokens
+}
+
+type clause struct {
+	terms  []string // more than one term is a phrase
+	prefix bool
+}
+
+// parseQuery splits a query into words, prefix words and quoted phrases
+func parseQuery(query string) []clause {
+	var clauses []clause
+	for len(query) > 0 {
+		query = strings.TrimLeftFunc(query, unicode.IsSpace)
+		if query == "" {
+			break
+		}
+
+		if query[0] == '"' {
+			end := strings.IndexByte(query[1:], '"')
+			phrase := query[1:]
+			if end >= 0 {
+				phrase, query = query[1:end+1], query[end+2:]
+			} else {
+				query = ""
+			}
+			var terms []string
+			for _, tok := range tokenize(phrase) {
+				terms = append(terms, tok.term)
+			}
+			if len(terms) > 0 {
+				clauses = append(clauses, clause{terms: terms})
+			}
+			continue
+		}
+
+		end := strings.IndexFunc(query, unicode.IsSpace)
+		word := query
+		if end >= 0 {
+			word, query = query[:end], query[end:]
+		} else {
+			query = ""
+		}
+		prefix := strings.HasSuffix(word, "*")
+		tokens := tokenize(word)
+		for i, tok := range tokens {
+			// "e-mail*" becomes the word e and the prefix mail
+			clauses = append(clauses, clause{terms: []string{tok.term}, prefix: prefix && i == len(tokens)-1})
+		}
+	}
+	return clauses
+}
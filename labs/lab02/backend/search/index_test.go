@@ -0,0 +1,114 @@
+package search
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestIndex() *Index {
+	idx := NewIndex()
+	now := time.Now().Unix()
+	idx.Add(1, "Hello World, hello again", now)
+	idx.Add(2, "The world is big", now)
+	idx.Add(3, "HELLO there, helpful friend", now)
+	idx.Add(4, "big world news", now-int64(30*24*time.Hour/time.Second))
+	return idx
+}
+
+func ids(results []Result) []uint64 {
+	out := make([]uint64, len(results))
+	for i, r := range results {
+		out[i] = r.ID
+	}
+	return out
+}
+
+func TestSearchTerms(t *testing.T) {
+	idx := newTestIndex()
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []uint64
+	}{
+		{"case-insensitive", "hello", []uint64{1, 3}},
+		{"all terms required", "world big", []uint64{2, 4}},
+		{"prefix", "hel*", []uint64{1, 3}},
+		{"phrase", `"world is big"`, []uint64{2}},
+		{"phrase order matters", `"big world"`, []uint64{4}},
+		{"no match", "missing", []uint64{}},
+		{"empty", "   ", []uint64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ids(idx.Search(tt.query, Options{}))
+			if len(got) != len(tt.expected) {
+				t.Fatalf("Search(%q) = %v, want %v", tt.query, got, tt.expected)
+			}
+			seen := make(map[uint64]bool)
+			for _, id := range got {
+				seen[id] = true
+			}
+			for _, id := range tt.expected {
+				if !seen[id] {
+					t.Errorf("Search(%q) = %v, missing %d", tt.query, got, id)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := newTestIndex()
+
+	// Document 1 mentions hello twice
+	results := idx.Search("hello", Options{})
+	if results[0].ID != 1 {
+		t.Errorf("expected document 1 first, got %v", ids(results))
+	}
+
+	// Documents 2 and 4 have the same length and terms, 4 is a month old
+	results = idx.Search("world big", Options{})
+	if results[0].ID != 2 {
+		t.Errorf("expected recent document first, got %v", ids(results))
+	}
+
+	if got := idx.Search("world", Options{Limit: 2}); len(got) != 2 {
+		t.Errorf("expected 2 results with limit, got %d", len(got))
+	}
+}
+
+func TestSearchUpdateAndRemove(t *testing.T) {
+	idx := newTestIndex()
+
+	idx.Add(2, "completely different", time.Now().Unix())
+	if got := ids(idx.Search(`"world is"`, Options{})); len(got) != 0 {
+		t.Errorf("expected replaced text to be gone, got %v", got)
+	}
+	idx.Remove(3)
+	if got := ids(idx.Search("helpful", Options{})); len(got) != 0 {
+		t.Errorf("expected removed document to be gone, got %v", got)
+	}
+	if got := ids(idx.Search("compl*", Options{})); len(got) != 1 || got[0] != 2 {
+		t.Errorf("expected new term to be found by prefix, got %v", got)
+	}
+	if idx.Len() != 3 {
+		t.Errorf("expected 3 documents, got %d", idx.Len())
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	idx := NewIndex()
+	text := "Привет, world! Hello world."
+	idx.Add(1, text, 0)
+
+	results := idx.Search(`привет "hello world"`, Options{})
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	got := Highlight(text, results[0].Matches, "[", "]")
+	want := "[Привет], world! [Hello world]."
+	if got != want {
+		t.Errorf("Highlight = %q, want %q", got, want)
+	}
+}
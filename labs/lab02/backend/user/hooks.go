@@ -0,0 +1,103 @@
+package user
+
+// EventType describes a change to the set of users
+type EventType int
+
+// Event types
+const (
+	UserAdded EventType = iota
+	UserRemoved
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case UserAdded:
+		return "added"
+	case UserRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is passed to hooks after a user is added or removed
+type Event struct {
+	Type EventType
+	User User
+}
+
+// Hook is called after every change, outside the manager's lock so it may
+// call back into the manager. Hooks see one event at a time, in the order
+// the changes were made. The call that made a change runs the hooks before
+// returning, unless another call is already running them. That call then
+// delivers the event too.
+type Hook func(Event)
+
+// OnChange registers a hook, hooks run in registration order. The returned
+// function unregisters it.
+func (m *UserManager) OnChange(hook Hook) func() {
+	m.hooksMutex.Lock()
+	defer m.hooksMutex.Unlock()
+
+	id := m.nextHookID
+	m.nextHookID++
+	m.hooks[id] = hook
+	m.hookOrder = append(m.hookOrder, id)
+
+	return func() {
+		m.hooksMutex.Lock()
+		defer m.hooksMutex.Unlock()
+
+		delete(m.hooks, id)
+		for i, hookID := range m.hookOrder {
+			if hookID == id {
+				m.hookOrder = append(m.hookOrder[:i], m.hookOrder[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// enqueue records a change for the hooks, the caller must hold mutex so
+// events are queued in the order the changes were made
+func (m *UserManager) enqueue(e Event) {
+	m.eventsMutex.Lock()
+	m.events = append(m.events, e)
+	m.eventsMutex.Unlock()
+}
+
+// dispatch runs the hooks for the queued events. Only one caller does so at
+// a time, later events are left for it.
+func (m *UserManager) dispatch() {
+	m.eventsMutex.Lock()
+	if m.dispatching {
+		m.eventsMutex.Unlock()
+		return
+	}
+	m.dispatching = true
+	for len(m.events) > 0 {
+		e := m.events[0]
+		m.events = m.events[1:]
+		m.eventsMutex.Unlock()
+		m.notify(e)
+		m.eventsMutex.Lock()
+	}
+	m.events = nil
+	m.dispatching = false
+	m.eventsMutex.Unlock()
+}
+
+// notify runs the registered hooks
+func (m *UserManager) notify(e Event) {
+	m.hooksMutex.RLock()
+	hooks := make([]Hook, 0, len(m.hookOrder))
+	for _, id := range m.hookOrder {
+		hooks = append(hooks, m.hooks[id])
+	}
+	m.hooksMutex.RUnlock()
+
+	for _, hook := range hooks {
+		hook(e)
+	}
+}
@@ -0,0 +1,232 @@
+package user
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUserIndexes(t *testing.T) {
+	mgr := NewUserManager()
+	mgr.AddUser(User{Name: "Alice", Email: "alice@example.com", ID: "1"})
+
+	tests := []struct {
+		name string
+		user User
+		err  error
+	}{
+		{"duplicate id", User{Name: "Other", Email: "other@example.com", ID: "1"}, ErrUserExists},
+		{"duplicate email", User{Name: "Other", Email: "ALICE@example.com", ID: "2"}, ErrEmailTaken},
+		{"duplicate name", User{Name: "alice", Email: "other@example.com", ID: "2"}, ErrNameTaken},
+		{"invalid", User{Name: "", Email: "other@example.com", ID: "2"}, ErrInvalidName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := mgr.AddUser(tt.user); err != tt.err {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	if u, err := mgr.GetUserByEmail("Alice@Example.com"); err != nil || u.ID != "1" {
+		t.Errorf("GetUserByEmail: got %+v, %v", u, err)
+	}
+	if u, err := mgr.GetUserByName("ALICE"); err != nil || u.ID != "1" {
+		t.Errorf("GetUserByName: got %+v, %v", u, err)
+	}
+
+	// Removing a user frees its email and name
+	mgr.RemoveUser("1")
+	if _, err := mgr.GetUserByEmail("alice@example.com"); err != ErrUserNotFound {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+	if err := mgr.AddUser(User{Name: "Alice", Email: "alice@example.com", ID: "3"}); err != nil {
+		t.Errorf("re-adding freed name and email failed: %v", err)
+	}
+	if err := mgr.RemoveUser("1"); err != ErrUserNotFound {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	mgr := NewUserManager()
+	for i, name := range []string{"carol", "Bob", "alice", "Albert", "dave"} {
+		mgr.AddUser(User{Name: name, Email: name + "@example.com", ID: string(rune('a' + i))})
+	}
+
+	var names []string
+	opts := ListOptions{Limit: 2}
+	for {
+		page, err := mgr.ListUsers(opts)
+		if err != nil {
+			t.Fatalf("ListUsers failed: %v", err)
+		}
+		for _, u := range page.Users {
+			names = append(names, u.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	want := []string{"Albert", "alice", "Bob", "carol", "dave"}
+	if len(names) != len(want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, names)
+		}
+	}
+
+	page, _ := mgr.ListUsers(ListOptions{Prefix: "AL"})
+	if len(page.Users) != 2 || page.Users[0].Name != "Albert" || page.Users[1].Name != "alice" {
+		t.Errorf("unexpected prefix results: %+v", page.Users)
+	}
+	page, _ = mgr.ListUsers(ListOptions{Prefix: "al", Cursor: "albert"})
+	if len(page.Users) != 1 || page.Users[0].Name != "alice" {
+		t.Errorf("unexpected prefix page: %+v", page.Users)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	mgr := NewUserManager()
+	var events []Event
+	unsubscribe := mgr.OnChange(func(e Event) {
+		events = append(events, e)
+		// Hooks run outside the lock and may read the manager
+		mgr.GetUser(e.User.ID)
+	})
+
+	mgr.AddUser(User{Name: "Bob", Email: "bob@example.com", ID: "bob"})
+	mgr.AddUser(User{Name: "Bob", Email: "bob@example.com", ID: "bob"})
+	mgr.RemoveUser("bob")
+	unsubscribe()
+	mgr.AddUser(User{Name: "Eve", Email: "eve@example.com", ID: "eve"})
+
+	if len(events) != 2 || events[0].Type != UserAdded || events[1].Type != UserRemoved || events[1].User.ID != "bob" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestHookOrderUnderConcurrency(t *testing.T) {
+	mgr := NewUserManager()
+	var mu sync.Mutex
+	var events []EventType
+	mgr.OnChange(func(e Event) {
+		runtime.Gosched() // give another change a chance to overtake this one
+		mu.Lock()
+		events = append(events, e.Type)
+		mu.Unlock()
+	})
+
+	// Only one goroutine at a time can add or remove the user, so the hooks
+	// must see added and removed strictly alternating
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				mgr.AddUser(User{Name: "Bob", Email: "bob@example.com", ID: "bob"})
+				mgr.RemoveUser("bob")
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, typ := range events {
+		if want := EventType(i % 2); typ != want {
+			t.Fatalf("event %d is %s, want %s", i, typ, want)
+		}
+	}
+	if len(events)%2 != 0 {
+		t.Errorf("expected a removal for every addition, got %d events", len(events))
+	}
+}
+
+// A hook that changes the manager gets its own event after the current one
+func TestHookReentrantChange(t *testing.T) {
+	mgr := NewUserManager()
+	var events []Event
+	mgr.OnChange(func(e Event) {
+		events = append(events, e)
+		if e.Type == UserAdded {
+			mgr.RemoveUser(e.User.ID)
+		}
+	})
+	mgr.AddUser(User{Name: "Bob", Email: "bob@example.com", ID: "bob"})
+	if len(events) != 2 || events[0].Type != UserAdded || events[1].Type != UserRemoved {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestCancellationInEveryMethod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mgr := NewUserManagerWithContext(ctx)
+	mgr.AddUser(User{Name: "Bob", Email: "bob@example.com", ID: "bob"})
+	cancel()
+
+	if _, err := mgr.GetUser("bob"); err != context.Canceled {
+		t.Errorf("GetUser: expected context.Canceled, got %v", err)
+	}
+	if _, err := mgr.GetUserByEmail("bob@example.com"); err != context.Canceled {
+		t.Errorf("GetUserByEmail: expected context.Canceled, got %v", err)
+	}
+	if _, err := mgr.ListUsers(ListOptions{}); err != context.Canceled {
+		t.Errorf("ListUsers: expected context.Canceled, got %v", err)
+	}
+	if err := mgr.RemoveUser("bob"); err != context.Canceled {
+		t.Errorf("RemoveUser: expected context.Canceled, got %v", err)
+	}
+}
+
+func TestPublicKeys(t *testing.T) {
+	mgr := NewUserManager()
+	mgr.AddUser(User{Name: "Bob", Email: "bob@example.com", ID: "bob"})
+
+	if _, err := mgr.PublicKey("bob"); err != ErrNoPublicKey {
+		t.Errorf("expected ErrNoPublicKey, got %v", err)
+	}
+	if err := mgr.SetPublicKey("bob", "bob", []byte("short")); err != ErrInvalidPublicKey {
+		t.Errorf("expected ErrInvalidPublicKey, got %v", err)
+	}
+	key := make([]byte, PublicKeySize)
+	key[0] = 7
+	if err := mgr.SetPublicKey("bob", "bob", key); err != nil {
+		t.Fatalf("SetPublicKey failed: %v", err)
+	}
+	// Nobody else may replace the key
+	if err := mgr.SetPublicKey("bob", "mallory", make([]byte, PublicKeySize)); err != ErrNotKeyOwner {
+		t.Errorf("expected ErrNotKeyOwner, got %v", err)
+	}
+	key[0] = 8
+	if got, err := mgr.PublicKey("bob"); err != nil || got[0] != 7 {
+		t.Errorf("PublicKey: got %v, %v", got, err)
+	}
+
+	// Replaced keys are kept, newest first, with the time they were replaced,
+	// and publishing the same key again does not add it twice
+	now := time.Unix(1000, 0)
+	mgr.now = func() time.Time { return now }
+	for i := byte(9); i < 9+maxReplacedKeys+2; i++ {
+		now = now.Add(time.Minute)
+		key[0] = i
+		mgr.SetPublicKey("bob", "bob", key)
+		mgr.SetPublicKey("bob", "bob", key)
+	}
+	keys, err := mgr.PublicKeys("bob")
+	if err != nil || len(keys) != 1+maxReplacedKeys || keys[0].Key[0] != 9+maxReplacedKeys+1 || keys[1].Key[0] != 9+maxReplacedKeys {
+		t.Fatalf("PublicKeys: got %v, %v", keys, err)
+	}
+	if keys[0].ReplacedAt != 0 || keys[1].ReplacedAt != now.Unix() || keys[2].ReplacedAt != now.Add(-time.Minute).Unix() {
+		t.Errorf("Unexpected rotation times %+v", keys)
+	}
+	if err := mgr.SetPublicKey("eve", "eve", make([]byte, PublicKeySize)); err != ErrUserNotFound {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+}
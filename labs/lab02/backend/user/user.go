@@ -1,67 +1,319 @@
 package user
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"net/mail"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 // User represents a chat user
-// TODO: Add more fields if needed
 
 type User struct {
-	Name  string
-	Email string
-	ID    string
+	Name      string
+	Email     string
+	ID        string
+	PublicKey []byte // X25519 key for end-to-end encrypted messages, optional
+}
+
+// Errors returned by Validate and UserManager
+var (
+	ErrInvalidName  = errors.New("name must not be empty")
+	ErrInvalidEmail = errors.New("invalid email address")
+	ErrInvalidID    = errors.New("id must not be empty")
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists   = errors.New("user id already exists")
+	ErrEmailTaken   = errors.New("email is already in use")
+	ErrNameTaken    = errors.New("name is already in use")
+
+	ErrInvalidPublicKey = errors.New("public key must be 32 bytes")
+	ErrNoPublicKey      = errors.New("user has not published a public key")
+	ErrNotKeyOwner      = errors.New("only the user can change their public key")
+)
+
+// PublicKeySize is the length of an X25519 public key
+const PublicKeySize = 32
+
+// maxReplacedKeys bounds how many earlier public keys are kept per user
+const maxReplacedKeys = 8
+
+// PublishedKey is a public key of a user as returned by PublicKeys
+type PublishedKey struct {
+	Key        []byte
+	ReplacedAt int64 // Unix seconds the key was replaced, zero for the current key
 }
 
 // Validate checks if the user data is valid
 func (u *User) Validate() error {
-	// TODO: Validate name, email, id
+	if strings.TrimSpace(u.Name) == "" {
+		return ErrInvalidName
+	}
+	if addr, err := mail.ParseAddress(u.Email); err != nil || addr.Address != u.Email {
+		return ErrInvalidEmail
+	}
+	if strings.TrimSpace(u.ID) == "" {
+		return ErrInvalidID
+	}
+	if len(u.PublicKey) != 0 && len(u.PublicKey) != PublicKeySize {
+		return ErrInvalidPublicKey
+	}
 	return nil
 }
 
 // UserManager manages users
-// Contains a map of users, a mutex, and a context
+// Contains a map of users, indexes by email and name, a mutex, and a context.
+// Every method fails with the context error once the context is done.
 
 type UserManager struct {
-	ctx   context.Context
-	users map[string]User // userID -> User
-	mutex sync.RWMutex    // Protects users map
-	// TODO: Add more fields if needed
+	ctx     context.Context
+	users   map[string]User           // userID -> User
+	byEmail map[string]string         // lowercase email -> userID
+	byName  map[string]string         // lowercase name -> userID
+	names   []string                  // lowercase names in sorted order, for listing
+	oldKeys map[string][]PublishedKey // userID -> replaced public keys, newest first
+	mutex   sync.RWMutex              // Protects users, oldKeys and the indexes
+	now     func() time.Time
+
+	hooks      map[int]Hook
+	hookOrder  []int
+	nextHookID int
+	hooksMutex sync.RWMutex // Protects hooks
+
+	events      []Event // changes not yet passed to the hooks, in order
+	dispatching bool    // a caller is running the hooks for events
+	eventsMutex sync.Mutex
 }
 
 // NewUserManager creates a new UserManager
 func NewUserManager() *UserManager {
-	// TODO: Initialize UserManager fields
-	return &UserManager{
-		users: make(map[string]User),
-	}
+	return NewUserManagerWithContext(context.Background())
 }
 
 // NewUserManagerWithContext creates a new UserManager with context
 func NewUserManagerWithContext(ctx context.Context) *UserManager {
-	// TODO: Initialize UserManager with context
 	return &UserManager{
-		ctx:   ctx,
-		users: make(map[string]User),
+		ctx:     ctx,
+		users:   make(map[string]User),
+		byEmail: make(map[string]string),
+		byName:  make(map[string]string),
+		oldKeys: make(map[string][]PublishedKey),
+		now:     time.Now,
+		hooks:   make(map[int]Hook),
 	}
 }
 
-// AddUser adds a user
+// AddUser adds a user, ID, email and name must all be unique. Email and name
+// are compared case-insensitively.
 func (m *UserManager) AddUser(u User) error {
-	// TODO: Add user to map, check context
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	email, name := strings.ToLower(u.Email), strings.ToLower(u.Name)
+
+	m.mutex.Lock()
+	_, exists := m.users[u.ID]
+	switch {
+	case exists:
+		m.mutex.Unlock()
+		return ErrUserExists
+	case m.byEmail[email] != "":
+		m.mutex.Unlock()
+		return ErrEmailTaken
+	case m.byName[name] != "":
+		m.mutex.Unlock()
+		return ErrNameTaken
+	}
+	if u.PublicKey != nil {
+		u.PublicKey = append([]byte(nil), u.PublicKey...)
+	}
+	m.users[u.ID] = u
+	m.byEmail[email] = u.ID
+	m.byName[name] = u.ID
+	i := sort.SearchStrings(m.names, name)
+	m.names = append(m.names, "")
+	copy(m.names[i+1:], m.names[i:])
+	m.names[i] = name
+	m.enqueue(Event{Type: UserAdded, User: u})
+	m.mutex.Unlock()
+
+	m.dispatch()
 	return nil
 }
 
 // RemoveUser removes a user
 func (m *UserManager) RemoveUser(id string) error {
-	// TODO: Remove user from map
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	u, ok := m.users[id]
+	if !ok {
+		m.mutex.Unlock()
+		return ErrUserNotFound
+	}
+	name := strings.ToLower(u.Name)
+	delete(m.users, id)
+	delete(m.oldKeys, id)
+	delete(m.byEmail, strings.ToLower(u.Email))
+	delete(m.byName, name)
+	i := sort.SearchStrings(m.names, name)
+	m.names = append(m.names[:i], m.names[i+1:]...)
+	m.enqueue(Event{Type: UserRemoved, User: u})
+	m.mutex.Unlock()
+
+	m.dispatch()
 	return nil
 }
 
 // GetUser retrieves a user by id
 func (m *UserManager) GetUser(id string) (User, error) {
-	// TODO: Get user from map
-	return User{}, errors.New("not found")
+	return m.lookup(func() string { return id })
+}
+
+// GetUserByEmail retrieves a user by email, ignoring case
+func (m *UserManager) GetUserByEmail(email string) (User, error) {
+	return m.lookup(func() string { return m.byEmail[strings.ToLower(email)] })
+}
+
+// GetUserByName retrieves a user by name, ignoring case
+func (m *UserManager) GetUserByName(name string) (User, error) {
+	return m.lookup(func() string { return m.byName[strings.ToLower(name)] })
+}
+
+// lookup finds a user by the ID returned from key, called under the read lock
+func (m *UserManager) lookup(key func() string) (User, error) {
+	if err := m.ctx.Err(); err != nil {
+		return User{}, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	u, ok := m.users[key()]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return u, nil
+}
+
+// SetPublicKey publishes the key other users encrypt direct messages with.
+// Only the user may change their key, actor is the authenticated user making
+// the change. The key it replaces is still returned by PublicKeys along with
+// the time it was replaced, so messages signed with it before the switch can
+// be verified.
+func (m *UserManager) SetPublicKey(id, actor string, key []byte) error {
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	if actor != id {
+		return ErrNotKeyOwner
+	}
+	if len(key) != PublicKeySize {
+		return ErrInvalidPublicKey
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if len(u.PublicKey) != 0 && !bytes.Equal(u.PublicKey, key) {
+		replaced := PublishedKey{Key: u.PublicKey, ReplacedAt: m.now().Unix()}
+		old := append([]PublishedKey{replaced}, m.oldKeys[id]...)
+		m.oldKeys[id] = old[:min(len(old), maxReplacedKeys)]
+	}
+	u.PublicKey = append([]byte(nil), key...)
+	m.users[id] = u
+	return nil
+}
+
+// PublicKey returns the published key of a user
+func (m *UserManager) PublicKey(id string) ([]byte, error) {
+	u, err := m.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.PublicKey) == 0 {
+		return nil, ErrNoPublicKey
+	}
+	return append([]byte(nil), u.PublicKey...), nil
+}
+
+// PublicKeys returns the published key of a user followed by the keys it
+// replaced, newest first
+func (m *UserManager) PublicKeys(id string) ([]PublishedKey, error) {
+	if err := m.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if len(u.PublicKey) == 0 {
+		return nil, ErrNoPublicKey
+	}
+	keys := []PublishedKey{{Key: append([]byte(nil), u.PublicKey...)}}
+	for _, old := range m.oldKeys[id] {
+		keys = append(keys, PublishedKey{Key: append([]byte(nil), old.Key...), ReplacedAt: old.ReplacedAt})
+	}
+	return keys, nil
+}
+
+// ListOptions selects a page of users ordered by name
+type ListOptions struct {
+	Prefix string // case-insensitive name prefix, empty matches everyone
+	Cursor string // NextCursor of the previous page, empty for the first page
+	Limit  int    // maximum page size, zero means no limit
+}
+
+// UserPage is a page of ListUsers results
+type UserPage struct {
+	Users      []User
+	NextCursor string // pass as ListOptions.Cursor to get the next page, empty if there are no more results
+}
+
+// ListUsers returns users ordered by name, case-insensitively
+func (m *UserManager) ListUsers(opts ListOptions) (UserPage, error) {
+	if err := m.ctx.Err(); err != nil {
+		return UserPage{}, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	prefix := strings.ToLower(opts.Prefix)
+	start := sort.SearchStrings(m.names, prefix)
+	if opts.Cursor != "" {
+		// The cursor is the last name returned, continue after it
+		if after := sort.Search(len(m.names), func(i int) bool { return m.names[i] > opts.Cursor }); after > start {
+			start = after
+		}
+	}
+
+	page := UserPage{Users: []User{}}
+	for _, name := range m.names[start:] {
+		if !strings.HasPrefix(name, prefix) {
+			break
+		}
+		if opts.Limit > 0 && len(page.Users) == opts.Limit {
+			page.NextCursor = strings.ToLower(page.Users[len(page.Users)-1].Name)
+			break
+		}
+		page.Users = append(page.Users, m.users[m.byName[name]])
+	}
+	return page, nil
 }
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"lab03-backend/archive"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"lab02/moderation"
+)
+
+// maxImportSize bounds the body of an import
+const maxImportSize = 32 << 20
+
+// setupArchiveRoutes registers the export and import endpoints
+func (h *Handler) setupArchiveRoutes(api *mux.Router) {
+	api.HandleFunc("/messages/export", h.ExportMessages).Methods("GET")
+	api.HandleFunc("/messages/import", h.requireUser(h.ImportMessages)).Methods("POST")
+}
+
+// ExportMessages handles GET /api/messages/export, streaming the messages
+// matching the list filters page by page in the format chosen by ?format=
+// or the Accept header
+func (h *Handler) ExportMessages(w http.ResponseWriter, r *http.Request) {
+	format, err := exportFormat(r)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.Limit = storage.MaxListLimit
+	page, err := h.storage.List(opts)
+	if err == storage.ErrInvalidCursor {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list messages")
+		return
+	}
+
+	// Large exports outlive the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", format.MediaType()+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="messages%s"`, format.Extension()))
+	w.WriteHeader(http.StatusOK)
+	writer, err := archive.NewWriter(w, format)
+	if err != nil {
+		return
+	}
+	for {
+		for _, msg := range page.Messages {
+			if err := writer.Write(msg); err != nil {
+				return
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return
+		}
+		rc.Flush()
+		if page.NextCursor == "" {
+			return
+		}
+		opts.Cursor = page.NextCursor
+		if page, err = h.storage.List(opts); err != nil {
+			// The status was sent already, the truncated file has to do
+			log.Printf("Export failed: %v", err)
+			return
+		}
+	}
+}
+
+// exportFormat picks the format of an export, JSON Lines when neither
+// ?format= nor the Accept header names one
+func exportFormat(r *http.Request) (archive.Format, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		return archive.ParseFormat(name)
+	}
+	offers := make([]string, len(archive.Formats))
+	for i, format := range archive.Formats {
+		offers[i] = format.MediaType()
+	}
+	if format, ok := archive.FormatOf(negotiate(r.Header.Get("Accept"), offers...)); ok {
+		return format, nil
+	}
+	return archive.FormatNDJSON, nil
+}
+
+// ImportMessages handles POST /api/messages/import, creating the messages of
+// an exported file. Every record is validated first; if one is invalid
+// nothing is imported. ?dry_run=true only reports what would be imported.
+// Replies are linked to the new IDs and deleted messages come back as
+// tombstones, so threads stay intact. Inside an atomic batch the import
+// joins the batch's transaction.
+func (h *Handler) ImportMessages(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFrom(r)
+	if claims != nil && !claims.IsModerator() {
+		h.writeError(w, http.StatusForbidden, "only moderators can import messages")
+		return
+	}
+	// Imports by moderators are trusted, without authentication anyone can
+	// import so records are moderated like POST /api/messages
+	moderated := claims == nil && h.moderator != nil
+	format, err := importFormat(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			h.writeError(w, http.StatusBadRequest, "dry_run must be true or false")
+			return
+		}
+	}
+
+	reader, err := archive.NewReader(http.MaxBytesReader(w, r.Body, maxImportSize), format)
+	if err != nil {
+		h.writeError(w, importErrorStatus(err), err.Error())
+		return
+	}
+	report := models.ImportReport{Format: string(format), DryRun: dryRun}
+	records := []*archive.Record{}
+	verdicts := []moderation.Verdict{}
+	known := make(map[int]bool) // IDs of valid records, replies to them link within the file
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var recErr *archive.RecordError
+		if errors.As(err, &recErr) {
+			report.Records++
+			report.AddError(recErr.Line, 0, recErr.Err.Error())
+			continue
+		}
+		if err != nil {
+			h.writeError(w, importErrorStatus(err), err.Error())
+			return
+		}
+		report.Records++
+		if err := h.validateRecord(rec, known); err != nil {
+			report.AddError(rec.Line, rec.ID, err.Error())
+			continue
+		}
+		verdict := moderation.Verdict{Action: moderation.Allow, Content: rec.Content}
+		if moderated && !rec.Deleted {
+			verdict = h.moderator.Check(moderation.Message{UserID: rec.Username, Content: rec.Content})
+			if verdict.Action == moderation.Reject {
+				report.AddError(rec.Line, rec.ID, (&moderation.RejectedError{Reasons: verdict.Reasons}).Error())
+				continue
+			}
+		}
+		if rec.ID != 0 {
+			known[rec.ID] = true
+		}
+		if rec.Deleted {
+			report.Deleted++
+		}
+		records = append(records, rec)
+		verdicts = append(verdicts, verdict)
+	}
+
+	if report.Invalid > 0 {
+		status := http.StatusUnprocessableEntity
+		if dryRun {
+			status = http.StatusOK
+		}
+		h.writeJSON(w, status, models.APIResponse{Success: false, Data: report, Error: fmt.Sprintf("%d invalid records", report.Invalid)})
+		return
+	}
+	report.Imported = len(records)
+	if dryRun {
+		h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: report})
+		return
+	}
+
+	created := make([]*models.Message, len(records))
+	report.Atomic, err = h.atomically(func(store storage.MessageStorage) error {
+		newIDs := make(map[int]int)
+		for i, rec := range records {
+			req := rec.Request()
+			req.Content = verdicts[i].Content
+			if id, ok := newIDs[req.ParentID]; ok {
+				req.ParentID = id
+			}
+			attachments, err := h.resolveAttachments(req.Attachments)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", rec.Line, err)
+			}
+			msg, err := store.CreateReply(req.Username, req.Content, req.ParentID, attachments...)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", rec.Line, err)
+			}
+			created[i] = msg
+			if rec.Deleted {
+				if err := store.Delete(msg.ID); err != nil {
+					return fmt.Errorf("line %d: %w", rec.Line, err)
+				}
+			}
+			if rec.ID != 0 {
+				newIDs[rec.ID] = msg.ID
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.writeError(w, storageErrorStatus(errors.Unwrap(err)), "Import failed: "+err.Error())
+		return
+	}
+	if moderated {
+		for i, msg := range created {
+			h.flag(msg, verdicts[i])
+		}
+	}
+	h.writeJSON(w, http.StatusCreated, models.APIResponse{Success: true, Data: report})
+}
+
+// validateRecord checks a record like POST /api/messages would, its parent
+// must be an earlier record of the file or a stored message. Tombstones
+// need only a username.
+func (h *Handler) validateRecord(rec *archive.Record, known map[int]bool) error {
+	req := rec.Request()
+	if rec.Deleted {
+		if req.Username == "" {
+			return errors.New("username is required")
+		}
+	} else if err := req.Validate(); err != nil {
+		return err
+	}
+	if req.ParentID != 0 && !known[req.ParentID] {
+		if _, err := h.storage.GetByID(req.ParentID); err != nil {
+			return fmt.Errorf("parent message %d not found", req.ParentID)
+		}
+	}
+	_, err := h.resolveAttachments(req.Attachments)
+	return err
+}
+
+// importFormat reads the format of an import from ?format= or Content-Type
+func importFormat(r *http.Request) (archive.Format, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		return archive.ParseFormat(name)
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if format, ok := archive.FormatOf(mediaType); ok {
+		return format, nil
+	}
+	return "", errors.New("set ?format= or a Content-Type of application/x-ndjson, text/csv or text/markdown")
+}
+
+// importErrorStatus maps errors reading an import to HTTP status codes
+func importErrorStatus(err error) int {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"lab03-backend/auth"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lab02/moderation"
+)
+
+func exportMessages(router http.Handler, query, accept string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/api/messages/export"+query, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func importMessages(router http.Handler, query, contentType, body string) (*httptest.ResponseRecorder, models.ImportReport) {
+	req, _ := http.NewRequest("POST", "/api/messages/import"+query, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var res struct {
+		Data models.ImportReport `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &res)
+	return rr, res.Data
+}
+
+func TestExportMessages(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+		// More than one page of List results
+		handler.storage.(storage.Transactor).Atomic(func(tx storage.MessageStorage) error {
+			for i := 0; i < storage.MaxListLimit+20; i++ {
+				tx.Create("alice", "hello")
+			}
+			tx.Create("bob", "line one\nline two")
+			return nil
+		})
+
+		rr := exportMessages(router, "", "")
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-ndjson; charset=utf-8" ||
+			rr.Header().Get("Content-Disposition") != `attachment; filename="messages.ndjson"` {
+			t.Fatalf("Unexpected export response %v %v", rr.Code, rr.Header())
+		}
+		lines := 0
+		scanner := bufio.NewScanner(rr.Body)
+		for scanner.Scan() {
+			var msg models.Message
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || msg.ID != lines+1 {
+				t.Fatalf("Unexpected line %d: %s", lines+1, scanner.Text())
+			}
+			lines++
+		}
+		if lines != storage.MaxListLimit+21 {
+			t.Errorf("Expected every message exported, got %d", lines)
+		}
+
+		rr = exportMessages(router, "?username=bob", "text/csv")
+		if rr.Header().Get("Content-Type") != "text/csv; charset=utf-8" || !strings.Contains(rr.Body.String(), "\"line one\nline two\"") ||
+			strings.Count(rr.Body.String(), "bob") != 1 || strings.Contains(rr.Body.String(), "alice") {
+			t.Errorf("Unexpected CSV export %q", rr.Body.String())
+		}
+		rr = exportMessages(router, "?format=md&username=bob", "")
+		if !strings.HasPrefix(rr.Body.String(), "# Chat transcript\n") || !strings.Contains(rr.Body.String(), "> line one\n> line two\n") {
+			t.Errorf("Unexpected Markdown export %q", rr.Body.String())
+		}
+		if rr := exportMessages(router, "?format=xml", ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown format, got %v", rr.Code)
+		}
+	})
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, format := range []string{"ndjson", "csv", "markdown"} {
+		t.Run(format, func(t *testing.T) {
+			source := NewHandler(storage.NewMemoryStorage())
+			source.storage.Create("alice", "root")
+			source.storage.CreateReply("bob", "gone", 1)
+			source.storage.CreateReply("carol", "reply to a deleted message", 2)
+			source.storage.Delete(2)
+			exported := exportMessages(source.SetupRoutes(), "?format="+format, "").Body.String()
+
+			target := NewHandler(storage.NewMemoryStorage())
+			target.storage.Create("dave", "already here")
+			router := target.SetupRoutes()
+
+			rr, report := importMessages(router, "?format="+format+"&dry_run=true", "", exported)
+			if rr.Code != http.StatusOK || !report.DryRun || report.Imported != 3 || report.Deleted != 1 || target.storage.Count() != 1 {
+				t.Fatalf("Unexpected dry run %v %+v", rr.Code, report)
+			}
+			rr, report = importMessages(router, "?format="+format, "", exported)
+			if rr.Code != http.StatusCreated || !report.Atomic || report.Imported != 3 {
+				t.Fatalf("Unexpected import %v %+v", rr.Code, report)
+			}
+
+			msgs := target.storage.GetAll()
+			if len(msgs) != 4 || msgs[1].Content != "root" || !msgs[2].Deleted || msgs[2].ParentID != 2 ||
+				msgs[3].ParentID != 3 || msgs[3].Username != "carol" {
+				for _, msg := range msgs {
+					t.Logf("%+v", msg)
+				}
+				t.Errorf("Expected threads linked to the new IDs")
+			}
+		})
+	}
+}
+
+func TestImportValidation(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+		input := "username,content,parent_id\n" +
+			"alice,hello,\n" +
+			",no user,\n" +
+			"bob,reply to nothing,42\n" +
+			"carol,,\n"
+
+		rr, report := importMessages(router, "?dry_run=true", "text/csv", input)
+		if rr.Code != http.StatusOK || report.Records != 4 || report.Invalid != 3 || len(report.Errors) != 3 {
+			t.Fatalf("Unexpected dry run %v %+v", rr.Code, report)
+		}
+		if e := report.Errors[1]; e.Line != 4 || !strings.Contains(e.Error, "parent message 42 not found") {
+			t.Errorf("Unexpected error %+v", e)
+		}
+		if rr, _ := importMessages(router, "", "text/csv", input); rr.Code != http.StatusUnprocessableEntity || handler.storage.Count() != 0 {
+			t.Errorf("Expected nothing imported, got %v with %d messages", rr.Code, handler.storage.Count())
+		}
+
+		if rr, _ := importMessages(router, "", "application/xml", "<messages/>"); rr.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Expected 415 for an unknown format, got %v", rr.Code)
+		}
+		if rr, _ := importMessages(router, "", "text/csv", "id,content\n1,x\n"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for a CSV file without username, got %v", rr.Code)
+		}
+		if rr, _ := importMessages(router, "?dry_run=maybe", "text/csv", input); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an invalid dry_run, got %v", rr.Code)
+		}
+	})
+}
+
+func TestImportIsModerated(t *testing.T) {
+	handler := NewHandler(storage.NewMemoryStorage())
+	handler.SetModerator(moderation.NewPipeline(
+		moderation.MaxLength(20),
+		moderation.WordList(moderation.Mask, "darn"),
+		moderation.WordList(moderation.Flag, "refund"),
+	), "secret")
+	router := handler.SetupRoutes()
+
+	input := "username,content\nalice,darn it\nbob,refund please\ncarol,way too long for the limit\n"
+	rr, report := importMessages(router, "", "text/csv", input)
+	if rr.Code != http.StatusUnprocessableEntity || report.Invalid != 1 || report.Errors[0].Line != 4 || handler.storage.Count() != 0 {
+		t.Fatalf("Expected the rejected record to fail the import, got %v %+v", rr.Code, report)
+	}
+
+	input = strings.TrimSuffix(input, "carol,way too long for the limit\n")
+	if rr, _ := importMessages(router, "", "text/csv", input); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected the import to succeed, got %v %s", rr.Code, rr.Body.String())
+	}
+	if msg, _ := handler.storage.GetByID(1); msg.Content != "**** it" {
+		t.Errorf("Expected masked content, got %q", msg.Content)
+	}
+	if reviews := handler.moderator.Queue().List(moderation.StatusPending); len(reviews) != 1 || reviews[0].Ref != "2" {
+		t.Errorf("Expected the flagged record to be queued for review, got %+v", reviews)
+	}
+}
+
+func TestImportRequiresModerator(t *testing.T) {
+	handler := NewHandler(storage.NewMemoryStorage())
+	router, issue := setupAuthRouter(t, handler, 0)
+	body := `{"username":"alice","content":"hi"}`
+
+	if rr := authRequest(router, "POST", "/api/messages/import?format=ndjson", issue("alice", auth.RoleUser), json.RawMessage(body)); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a user, got %v", rr.Code)
+	}
+	if rr := authRequest(router, "POST", "/api/messages/import?format=ndjson", issue("mod", auth.RoleModerator), json.RawMessage(body)); rr.Code != http.StatusCreated {
+		t.Errorf("Expected a moderator to import, got %v %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"lab03-backend/models"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"lab02/blob"
+)
+
+// attachmentsPath is where attachments are downloaded, signed URLs point below it
+const attachmentsPath = "/api/attachments"
+
+// uploadOverhead leaves room for multipart headers on top of the blob size limit
+const uploadOverhead = 64 << 10
+
+// SetAttachments enables uploads to store and signed download URLs
+func (h *Handler) SetAttachments(store *blob.Store, signer *blob.Signer, limits blob.Limits) {
+	h.blobs = store
+	h.signer = signer
+	h.blobLimits = limits
+}
+
+// setupAttachmentRoutes registers the upload and download endpoints
+func (h *Handler) setupAttachmentRoutes(api *mux.Router) {
+	api.HandleFunc("/attachments", h.requireUser(h.UploadAttachment)).Methods("POST")
+	api.HandleFunc("/attachments/{id}", h.DownloadAttachment).Methods("GET")
+}
+
+// UploadAttachment handles POST /api/attachments with the file in the
+// multipart field "file". The returned ID can be referenced by messages.
+func (h *Handler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	if h.blobs == nil {
+		h.writeError(w, http.StatusNotFound, "Attachments are not enabled")
+		return
+	}
+	if h.blobLimits.MaxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.blobLimits.MaxSize+uploadOverhead)
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Expected a multipart/form-data body")
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			h.writeError(w, http.StatusBadRequest, "file is required")
+			return
+		}
+		if err != nil {
+			h.writeError(w, uploadErrorStatus(err), "Invalid multipart body")
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		att, err := h.blobs.Put(part, part.FileName())
+		part.Close()
+		if err != nil {
+			h.writeError(w, uploadErrorStatus(err), err.Error())
+			return
+		}
+		h.writeJSON(w, http.StatusCreated, models.APIResponse{Success: true, Data: h.link(models.Attachment{Attachment: att})})
+		return
+	}
+}
+
+// DownloadAttachment handles GET /api/attachments/{id}?expires=&sig=, the
+// query must come from a signed URL that has not expired
+func (h *Handler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	if h.blobs == nil {
+		h.writeError(w, http.StatusNotFound, "Attachments are not enabled")
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if err := h.signer.Verify(id, r.URL.Query()); err != nil {
+		h.writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err := h.blobs.Serve(w, r, id); err != nil {
+		if err == blob.ErrNotFound {
+			h.writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to read attachment")
+	}
+}
+
+// resolveAttachments looks up the attachments a new message references
+func (h *Handler) resolveAttachments(ids []string) ([]models.Attachment, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if h.blobs == nil {
+		return nil, errors.New("attachments are not enabled")
+	}
+	attachments := make([]models.Attachment, 0, len(ids))
+	for _, id := range ids {
+		att, err := h.blobs.Attachment(id)
+		if err != nil {
+			return nil, errors.New("attachment " + id + " not found")
+		}
+		attachments = append(attachments, models.Attachment{Attachment: att})
+	}
+	return attachments, nil
+}
+
+// present returns msg with fresh signed URLs for its attachments. Stored
+// messages are shared, so a copy is returned when there is anything to sign.
+func (h *Handler) present(msg *models.Message) *models.Message {
+	if msg == nil || len(msg.Attachments) == 0 || h.signer == nil {
+		return msg
+	}
+	clone := *msg
+	clone.Attachments = make([]models.Attachment, len(msg.Attachments))
+	for i, att := range msg.Attachments {
+		clone.Attachments[i] = h.link(att)
+	}
+	return &clone
+}
+
+// presentAll applies present to every message
+func (h *Handler) presentAll(msgs []*models.Message) []*models.Message {
+	result := make([]*models.Message, len(msgs))
+	for i, msg := range msgs {
+		result[i] = h.present(msg)
+	}
+	return result
+}
+
+// link adds signed download URLs to an attachment
+func (h *Handler) link(att models.Attachment) models.Attachment {
+	var expires time.Time
+	att.URL, expires = h.signer.URL(attachmentsPath, att.ID)
+	att.URLExpiresAt = &expires
+	if att.ThumbnailID != "" {
+		att.ThumbnailURL, _ = h.signer.URL(attachmentsPath, att.ThumbnailID)
+	}
+	return att
+}
+
+// uploadErrorStatus maps upload errors to HTTP status codes
+func uploadErrorStatus(err error) int {
+	var maxBytes *http.MaxBytesError
+	switch {
+	case errors.Is(err, blob.ErrTooLarge), errors.As(err, &maxBytes):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, blob.ErrTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusBadRequest
+	}
+}
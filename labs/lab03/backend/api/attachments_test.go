@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"lab02/blob"
+)
+
+func newAttachmentHandler(t *testing.T, limits blob.Limits) *Handler {
+	t.Helper()
+	store, err := blob.Open(t.TempDir(), limits)
+	if err != nil {
+		t.Fatalf("blob.Open failed: %v", err)
+	}
+	handler := NewHandler(storage.NewMemoryStorage())
+	handler.SetAttachments(store, blob.NewSigner([]byte("secret")), limits)
+	return handler
+}
+
+func upload(t *testing.T, router http.Handler, name string, content []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("note", "ignored")
+	fw, _ := mw.CreateFormFile("file", name)
+	fw.Write(content)
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/api/attachments", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAttachments(t *testing.T) {
+	handler := newAttachmentHandler(t, blob.DefaultLimits)
+	router := handler.SetupRoutes()
+
+	rr := upload(t, router, "notes.txt", []byte("meeting notes"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %v, got %v: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var uploaded struct {
+		Data models.Attachment `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&uploaded)
+	if uploaded.Data.ID == "" || uploaded.Data.MIMEType != "text/plain" || uploaded.Data.URL == "" || uploaded.Data.URLExpiresAt == nil {
+		t.Fatalf("Unexpected attachment %+v", uploaded.Data)
+	}
+
+	body, _ := json.Marshal(models.CreateMessageRequest{Username: "alice", Attachments: []string{uploaded.Data.ID}})
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/messages", bytes.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %v, got %v: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Data models.Message `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&created)
+	if len(created.Data.Attachments) != 1 || created.Data.Attachments[0].Name != "notes.txt" {
+		t.Fatalf("Unexpected message %+v", created.Data)
+	}
+	if stored, _ := handler.storage.GetByID(created.Data.ID); stored.Attachments[0].URL != "" {
+		t.Errorf("Expected signed URLs to stay out of storage, got %q", stored.Attachments[0].URL)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", created.Data.Attachments[0].URL, nil))
+	if data, _ := io.ReadAll(rr.Body); rr.Code != http.StatusOK || string(data) != "meeting notes" {
+		t.Errorf("Unexpected download %v %q", rr.Code, data)
+	}
+
+	u, _ := url.Parse(created.Data.Attachments[0].URL)
+	q := u.Query()
+	q.Set("sig", "forged")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", u.Path+"?"+q.Encode(), nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status %v for a forged signature, got %v", http.StatusForbidden, rr.Code)
+	}
+
+	body, _ = json.Marshal(models.CreateMessageRequest{Username: "alice", Attachments: []string{"missing"}})
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/messages", bytes.NewReader(body)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %v for an unknown attachment, got %v", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	router := newAttachmentHandler(t, blob.Limits{MaxSize: 8, AllowedTypes: []string{"text/plain"}}).SetupRoutes()
+
+	if rr := upload(t, router, "big.txt", []byte("more than eight bytes")); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %v, got %v", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+	if rr := upload(t, router, "doc.pdf", []byte("%PDF-1")); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status %v, got %v", http.StatusUnsupportedMediaType, rr.Code)
+	}
+
+	disabled := NewHandler(storage.NewMemoryStorage()).SetupRoutes()
+	if rr := upload(t, disabled, "a.txt", []byte("a")); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %v without a blob store, got %v", http.StatusNotFound, rr.Code)
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"lab03-backend/auth"
+	"lab03-backend/models"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// claimsKey is the request context key of the authenticated user's claims
+type claimsKey struct{}
+
+// SetAuth requires a bearer token from tokens for every request that changes
+// data. The username is then taken from the token, only authors and
+// moderators may change a message, and authors may edit only within
+// editWindow of posting (zero allows edits at any time).
+func (h *Handler) SetAuth(tokens *auth.TokenService, editWindow time.Duration) {
+	h.tokens = tokens
+	h.editWindow = editWindow
+}
+
+// requireUser rejects requests without a valid token when auth is enabled
+// and stores the token's claims in the request context
+func (h *Handler) requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.tokens == nil {
+			next(w, r)
+			return
+		}
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || token == "" {
+			h.writeUnauthorized(w, "Authorization token is required")
+			return
+		}
+		claims, err := h.tokens.Validate(token)
+		if err != nil {
+			h.writeUnauthorized(w, err.Error())
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)))
+	}
+}
+
+// claimsFrom returns the authenticated user's claims, nil when auth is disabled
+func claimsFrom(r *http.Request) *auth.Claims {
+	claims, _ := r.Context().Value(claimsKey{}).(*auth.Claims)
+	return claims
+}
+
+// username returns the acting user: the token's user when auth is enabled,
+// otherwise the claimed one. A claimed username that differs from the token
+// is answered with 403 and returns false.
+func (h *Handler) username(w http.ResponseWriter, r *http.Request, claimed string) (string, bool) {
+	claims := claimsFrom(r)
+	if claims == nil {
+		return claimed, true
+	}
+	if claimed != "" && claimed != claims.Username {
+		h.writeError(w, http.StatusForbidden, "username does not match the authenticated user")
+		return "", false
+	}
+	return claims.Username, true
+}
+
+// authorizeChange checks that the authenticated user may edit or delete msg,
+// writing a 403 response and returning false if not
+func (h *Handler) authorizeChange(w http.ResponseWriter, r *http.Request, msg *models.Message, edit bool) bool {
+	claims := claimsFrom(r)
+	if claims == nil || claims.IsModerator() {
+		return true
+	}
+	if msg.Username != claims.Username {
+		h.writeError(w, http.StatusForbidden, "only the author or a moderator can change this message")
+		return false
+	}
+	if edit && h.editWindow > 0 && time.Since(msg.Timestamp) > h.editWindow {
+		h.writeError(w, http.StatusForbidden, fmt.Sprintf("messages can only be edited within %s of posting", h.editWindow))
+		return false
+	}
+	return true
+}
+
+// writeUnauthorized responds with 401 and a bearer challenge
+func (h *Handler) writeUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	h.writeError(w, http.StatusUnauthorized, message)
+}
+
+// GetCurrentUser handles GET /api/auth/me, returning the token's user
+func (h *Handler) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFrom(r)
+	if claims == nil {
+		h.writeError(w, http.StatusNotFound, "Authentication is not enabled")
+		return
+	}
+	res := models.TokenResponse{Username: claims.Username, Role: claims.Role, ExpiresAt: claims.ExpiresAt.Time}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: res})
+}
+
+// IssueToken handles POST /api/admin/tokens, creating a token for a user
+func (h *Handler) IssueToken(w http.ResponseWriter, r *http.Request) {
+	if h.tokens == nil {
+		h.writeError(w, http.StatusNotFound, "Authentication is not enabled")
+		return
+	}
+	var req models.TokenRequest
+	if err := h.parseJSON(r, &req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	token, expires, err := h.tokens.Issue(req.Username, req.Role, time.Duration(req.TTLSeconds)*time.Second)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	role := req.Role
+	if role == "" {
+		role = auth.RoleUser
+	}
+	res := models.TokenResponse{Token: token, Username: req.Username, Role: role, ExpiresAt: expires}
+	h.writeJSON(w, http.StatusCreated, models.APIResponse{Success: true, Data: res})
+}
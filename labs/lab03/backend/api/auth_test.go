@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"lab03-backend/auth"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func setupAuthRouter(t *testing.T, handler *Handler, editWindow time.Duration) (http.Handler, func(username, role string) string) {
+	t.Helper()
+	tokens, err := auth.NewTokenService("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetAuth(tokens, editWindow)
+	issue := func(username, role string) string {
+		token, _, err := tokens.Issue(username, role, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	return handler.SetupRoutes(), issue
+}
+
+func authRequest(router http.Handler, method, url, token string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, url, &buf)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAuthRequiredForChanges(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router, issue := setupAuthRouter(t, handler, 0)
+
+		rr := authRequest(router, "POST", "/api/messages", "", models.CreateMessageRequest{Username: "alice", Content: "hi"})
+		if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected 401 with challenge, got %v %q", rr.Code, rr.Header().Get("WWW-Authenticate"))
+		}
+		if rr := authRequest(router, "POST", "/api/messages", "garbage", models.CreateMessageRequest{Content: "hi"}); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for invalid token, got %v", rr.Code)
+		}
+
+		alice := issue("alice", auth.RoleUser)
+		if rr := authRequest(router, "POST", "/api/messages", alice, models.CreateMessageRequest{Username: "bob", Content: "hi"}); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for impersonation, got %v", rr.Code)
+		}
+		rr = authRequest(router, "POST", "/api/messages", alice, models.CreateMessageRequest{Content: "hi"})
+		var created struct {
+			Data models.Message `json:"data"`
+		}
+		json.NewDecoder(rr.Body).Decode(&created)
+		if rr.Code != http.StatusCreated || created.Data.Username != "alice" {
+			t.Fatalf("Expected message by alice, got %v %+v", rr.Code, created.Data)
+		}
+
+		if rr := authRequest(router, "GET", "/api/messages", "", nil); rr.Code != http.StatusOK {
+			t.Errorf("Expected reads to stay public, got %v", rr.Code)
+		}
+		if rr := authRequest(router, "POST", "/api/messages/1/reactions", alice, models.ReactionRequest{Emoji: "👍"}); rr.Code != http.StatusOK {
+			t.Errorf("Expected reaction by token user, got %v", rr.Code)
+		}
+		if msg, _ := handler.storage.GetByID(1); len(msg.Reactions) != 1 || msg.Reactions[0].Users[0] != "alice" {
+			t.Errorf("Expected reaction from alice, got %v", msg.Reactions)
+		}
+	})
+}
+
+func TestOwnershipOfUpdatesAndDeletes(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router, issue := setupAuthRouter(t, handler, time.Hour)
+		alice, bob, mod := issue("alice", auth.RoleUser), issue("bob", auth.RoleUser), issue("mod", auth.RoleModerator)
+		authRequest(router, "POST", "/api/messages", alice, models.CreateMessageRequest{Content: "hello"})
+		authRequest(router, "POST", "/api/messages", alice, models.CreateMessageRequest{Content: "again"})
+
+		update := models.UpdateMessageRequest{Content: "edited"}
+		if rr := authRequest(router, "PUT", "/api/messages/1", bob, update); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for another user's edit, got %v", rr.Code)
+		}
+		if rr := authRequest(router, "DELETE", "/api/messages/1", bob, nil); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for another user's delete, got %v", rr.Code)
+		}
+		if rr := authRequest(router, "PUT", "/api/messages/1", alice, update); rr.Code != http.StatusOK {
+			t.Errorf("Expected author's edit to succeed, got %v", rr.Code)
+		}
+		if rr := authRequest(router, "PUT", "/api/messages/2", mod, update); rr.Code != http.StatusOK {
+			t.Errorf("Expected moderator's edit to succeed, got %v", rr.Code)
+		}
+		if rr := authRequest(router, "DELETE", "/api/messages/1", mod, nil); rr.Code != http.StatusNoContent {
+			t.Errorf("Expected moderator's delete to succeed, got %v", rr.Code)
+		}
+		if rr := authRequest(router, "DELETE", "/api/messages/2", alice, nil); rr.Code != http.StatusNoContent {
+			t.Errorf("Expected author's delete to succeed, got %v", rr.Code)
+		}
+		if rr := authRequest(router, "DELETE", "/api/messages/99", alice, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for missing message, got %v", rr.Code)
+		}
+	})
+}
+
+func TestEditWindow(t *testing.T) {
+	handler := NewHandler(storage.NewMemoryStorage())
+	router, issue := setupAuthRouter(t, handler, time.Nanosecond)
+	alice, mod := issue("alice", auth.RoleUser), issue("mod", auth.RoleModerator)
+	authRequest(router, "POST", "/api/messages", alice, models.CreateMessageRequest{Content: "hello"})
+	time.Sleep(time.Millisecond)
+
+	update := models.UpdateMessageRequest{Content: "edited"}
+	if rr := authRequest(router, "PUT", "/api/messages/1", alice, update); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 after the edit window, got %v", rr.Code)
+	}
+	if rr := authRequest(router, "PUT", "/api/messages/1", mod, update); rr.Code != http.StatusOK {
+		t.Errorf("Expected moderator to edit after the window, got %v", rr.Code)
+	}
+	if rr := authRequest(router, "DELETE", "/api/messages/1", alice, nil); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected author to delete after the window, got %v", rr.Code)
+	}
+}
+
+func TestIssueTokenEndpoint(t *testing.T) {
+	handler := NewHandler(storage.NewMemoryStorage())
+	handler.SetModerator(nil, "admin")
+	router, _ := setupAuthRouter(t, handler, 0)
+
+	req := models.TokenRequest{Username: "alice", Role: auth.RoleModerator}
+	if rr := authRequest(router, "POST", "/api/admin/tokens", "", req); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without admin token, got %v", rr.Code)
+	}
+	if rr := authRequest(router, "POST", "/api/admin/tokens", "admin", models.TokenRequest{Username: "alice", Role: "root"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown role, got %v", rr.Code)
+	}
+	rr := authRequest(router, "POST", "/api/admin/tokens", "admin", req)
+	var issued struct {
+		Data models.TokenResponse `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&issued)
+	if rr.Code != http.StatusCreated || issued.Data.Token == "" {
+		t.Fatalf("Expected issued token, got %v %+v", rr.Code, issued.Data)
+	}
+
+	rr = authRequest(router, "GET", "/api/auth/me", issued.Data.Token, nil)
+	var me struct {
+		Data models.TokenResponse `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&me)
+	if rr.Code != http.StatusOK || me.Data.Username != "alice" || me.Data.Role != auth.RoleModerator {
+		t.Errorf("Unexpected current user %v %+v", rr.Code, me.Data)
+	}
+	if rr := authRequest(router, "GET", "/api/admin/reviews", "admin", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected moderation routes to stay disabled, got %v", rr.Code)
+	}
+}
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// errBatchFailed aborts an atomic batch after a request failed
+var errBatchFailed = errors.New("batch request failed")
+
+// setupBatchRoutes registers the batch and bulk endpoints
+func (h *Handler) setupBatchRoutes(api *mux.Router) {
+	api.HandleFunc("/batch", h.Batch).Methods("POST")
+	api.HandleFunc("/messages/batch", h.requireUser(h.CreateMessages)).Methods("POST")
+	api.HandleFunc("/messages", h.requireUser(h.DeleteMessages)).Methods("DELETE")
+}
+
+// Batch handles POST /api/batch, running several API requests and
+// reporting the status of each
+func (h *Handler) Batch(w http.ResponseWriter, r *http.Request) {
+	var req models.BatchRequest
+	if err := h.parseJSON(r, &req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for i, item := range req.Requests {
+		if !batchable(item.Method, item.Path) {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("request %d: %s %s cannot run in a batch", i, item.Method, item.Path))
+			return
+		}
+	}
+	h.writeBatch(w, r, req.Requests, req.Atomic, http.StatusOK)
+}
+
+// CreateMessages handles POST /api/messages/batch, creating all messages
+// or, if one of them fails, none
+func (h *Handler) CreateMessages(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateMessagesRequest
+	if err := h.parseJSON(r, &req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	items := make([]models.BatchRequestItem, len(req.Messages))
+	for i, msg := range req.Messages {
+		body, err := json.Marshal(msg)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		items[i] = models.BatchRequestItem{ID: strconv.Itoa(i), Method: http.MethodPost, Path: "/api/messages", Body: body}
+	}
+	h.writeBatch(w, r, items, true, http.StatusCreated)
+}
+
+// DeleteMessages handles DELETE /api/messages, deleting the messages listed
+// in ?ids= or those matching the username, since and until filters, all or
+// none of them
+func (h *Handler) DeleteMessages(w http.ResponseWriter, r *http.Request) {
+	ids, err := h.bulkDeleteIDs(r.URL.Query())
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	items := make([]models.BatchRequestItem, len(ids))
+	for i, id := range ids {
+		items[i] = models.BatchRequestItem{ID: strconv.Itoa(id), Method: http.MethodDelete, Path: "/api/messages/" + strconv.Itoa(id)}
+	}
+	h.writeBatch(w, r, items, true, http.StatusOK)
+}
+
+// bulkDeleteIDs returns the messages a bulk delete targets, filters skip
+// messages that are already deleted
+func (h *Handler) bulkDeleteIDs(query url.Values) ([]int, error) {
+	if list := query.Get("ids"); list != "" {
+		seen := make(map[int]bool)
+		ids := []int{}
+		for _, field := range strings.Split(list, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return nil, errors.New("ids must be a comma-separated list of message IDs")
+			}
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) > models.MaxBatchMessages {
+			return nil, fmt.Errorf("at most %d messages can be deleted at once", models.MaxBatchMessages)
+		}
+		return ids, nil
+	}
+
+	opts, err := parseListOptions(query)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Username == "" && opts.Since.IsZero() && opts.Until.IsZero() {
+		return nil, errors.New("ids or a username, since or until filter is required")
+	}
+	opts.Limit = storage.MaxListLimit
+	ids := []int{}
+	for {
+		page, err := h.storage.List(opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range page.Messages {
+			if !msg.Deleted {
+				ids = append(ids, msg.ID)
+			}
+		}
+		if len(ids) > models.MaxBatchMessages {
+			return nil, fmt.Errorf("the filter matches more than %d messages", models.MaxBatchMessages)
+		}
+		if page.NextCursor == "" {
+			return ids, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// writeBatch runs a batch and responds with okStatus if every request
+// succeeded, 207 Multi-Status otherwise
+func (h *Handler) writeBatch(w http.ResponseWriter, r *http.Request, items []models.BatchRequestItem, atomic bool, okStatus int) {
+	version, _ := negotiated(w)
+	res, err := h.runBatch(r, version, items, atomic)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to apply batch: "+err.Error())
+		return
+	}
+	if res.Failed > 0 {
+		h.writeJSON(w, http.StatusMultiStatus, models.APIResponse{
+			Success: false,
+			Data:    res,
+			Error:   fmt.Sprintf("%d of %d requests failed", res.Failed, len(items)),
+		})
+		return
+	}
+	h.writeJSON(w, okStatus, models.APIResponse{Success: true, Data: res})
+}
+
+// runBatch runs items in order through the API. An atomic batch runs in a
+// storage transaction and stops at the first failure, discarding earlier
+// changes; storages without transactions keep them.
+func (h *Handler) runBatch(r *http.Request, version int, items []models.BatchRequestItem, atomic bool) (models.BatchResponse, error) {
+	results := make([]models.BatchResult, len(items))
+	failed := -1
+	run := func(store storage.MessageStorage) error {
+		batch := *h
+		batch.storage = store
+		router := batch.SetupRoutes()
+		for i, item := range items {
+			results[i] = runBatchItem(router, r, version, item)
+			if atomic && results[i].Status >= http.StatusBadRequest {
+				failed = i
+				return errBatchFailed
+			}
+		}
+		return nil
+	}
+
+	res := models.BatchResponse{Results: results}
+	var err error
+	if atomic {
+		res.Atomic, err = h.atomically(run)
+	} else {
+		err = run(h.storage)
+	}
+	if err != nil && err != errBatchFailed {
+		return res, err
+	}
+
+	if failed >= 0 {
+		reason := fmt.Sprintf("not applied because request %d failed", failed)
+		for i := range results {
+			// Changes before the failure were rolled back, later ones never ran
+			if i > failed || (res.Atomic && i < failed) {
+				results[i] = errorResult(items[i].ID, http.StatusFailedDependency, reason)
+			}
+		}
+	}
+	for _, result := range results {
+		if result.Status < http.StatusBadRequest {
+			res.Succeeded++
+		} else {
+			res.Failed++
+		}
+	}
+	return res, nil
+}
+
+// atomically runs fn in a storage transaction, or on the storage itself
+// when it has none, and reports which. Inside an atomic batch h.storage is
+// the batch's transaction, which fn joins.
+func (h *Handler) atomically(fn func(store storage.MessageStorage) error) (bool, error) {
+	if transactor, ok := h.storage.(storage.Transactor); ok {
+		if err := transactor.Atomic(fn); !errors.Is(err, storage.ErrNotAtomic) {
+			return true, err
+		}
+	}
+	return false, fn(h.storage)
+}
+
+// runBatchItem serves one batch request, inheriting the Authorization header
+// and the API version of the batch
+func runBatchItem(router http.Handler, r *http.Request, version int, item models.BatchRequestItem) models.BatchResult {
+	req, err := http.NewRequestWithContext(r.Context(), item.Method, item.Path, bytes.NewReader(item.Body))
+	if err != nil {
+		return errorResult(item.ID, http.StatusBadRequest, "Invalid request path")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", fmt.Sprintf("application/vnd.lab03.v%d+json", version))
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	for name, value := range item.Headers {
+		req.Header.Set(name, value)
+	}
+
+	rec := &batchRecorder{header: make(http.Header)}
+	router.ServeHTTP(rec, req)
+	result := models.BatchResult{ID: item.ID, Status: rec.status}
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	mediaType, _, _ := mime.ParseMediaType(rec.header.Get("Content-Type"))
+	if body := bytes.TrimSpace(rec.body.Bytes()); mediaType == "application/json" && json.Valid(body) {
+		result.Body = body
+	}
+	return result
+}
+
+// batchable reports whether a request may run in a batch: batches do not
+// nest, since a transaction holds the storage, and streams never end
+func batchable(method, target string) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	switch p := versionPrefix.ReplaceAllString(path.Clean(u.Path), "/api"); {
+	case p == "/api/batch", p == "/api/messages/batch", p == "/api/messages/stream":
+		return false
+	case p == "/api/messages" && method == http.MethodDelete:
+		return false
+	default:
+		return true
+	}
+}
+
+// errorResult builds a failed batch result with an error body
+func errorResult(id string, status int, message string) models.BatchResult {
+	body, _ := json.Marshal(models.APIResponse{Success: false, Error: message})
+	return models.BatchResult{ID: id, Status: status, Body: body}
+}
+
+// batchRecorder captures the response to a batch request
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *batchRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *batchRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *batchRecorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(p)
+}
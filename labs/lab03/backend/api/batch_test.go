@@ -0,0 +1,233 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func doBatch(t *testing.T, router http.Handler, method, url string, body interface{}) (int, models.BatchResponse) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, err := http.NewRequest(method, url, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var res struct {
+		Data models.BatchResponse `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&res)
+	return rr.Code, res.Data
+}
+
+func resultStatuses(res models.BatchResponse) []int {
+	statuses := make([]int, len(res.Results))
+	for i, result := range res.Results {
+		statuses[i] = result.Status
+	}
+	return statuses
+}
+
+func equalStatuses(got []int, want ...int) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCreateMessagesBatch(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+
+		batch := models.CreateMessagesRequest{Messages: []models.CreateMessageRequest{
+			{Username: "alice", Content: "one"},
+			{Username: "bob", Content: "two"},
+			{Username: "carol", Content: "reply", ParentID: 1},
+		}}
+		status, res := doBatch(t, router, "POST", "/api/messages/batch", batch)
+		if status != http.StatusCreated || !res.Atomic || res.Succeeded != 3 || !equalStatuses(resultStatuses(res), 201, 201, 201) {
+			t.Fatalf("Unexpected batch result %v %+v", status, res)
+		}
+		var created struct {
+			Data models.Message `json:"data"`
+		}
+		json.Unmarshal(res.Results[2].Body, &created)
+		if created.Data.ID != 3 || created.Data.ParentID != 1 {
+			t.Errorf("Expected the created reply in the result, got %+v", created.Data)
+		}
+
+		batch.Messages[1].Content = ""
+		status, res = doBatch(t, router, "POST", "/api/messages/batch", batch)
+		if status != http.StatusMultiStatus || res.Failed != 3 || !equalStatuses(resultStatuses(res), 424, 400, 424) {
+			t.Errorf("Expected the batch to fail as a whole, got %v %+v", status, resultStatuses(res))
+		}
+		if count := handler.storage.Count(); count != 3 {
+			t.Errorf("Expected no messages from the failed batch, got %d", count)
+		}
+
+		if status, _ := doBatch(t, router, "POST", "/api/messages/batch", models.CreateMessagesRequest{}); status != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an empty batch, got %v", status)
+		}
+	})
+}
+
+func TestBulkDeleteMessages(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+		for _, username := range []string{"alice", "bob", "alice", "carol"} {
+			handler.storage.Create(username, "hello")
+		}
+
+		if status, _ := doBatch(t, router, "DELETE", "/api/messages", nil); status != http.StatusBadRequest {
+			t.Errorf("Expected 400 without ids or filter, got %v", status)
+		}
+		status, res := doBatch(t, router, "DELETE", "/api/messages?ids=2,99", nil)
+		if status != http.StatusMultiStatus || !equalStatuses(resultStatuses(res), 424, 404) {
+			t.Errorf("Expected the unknown ID to fail the delete, got %v %+v", status, resultStatuses(res))
+		}
+		if msg, _ := handler.storage.GetByID(2); msg.Deleted {
+			t.Errorf("Expected message 2 to survive the failed delete")
+		}
+
+		status, res = doBatch(t, router, "DELETE", "/api/messages?username=alice", nil)
+		if status != http.StatusOK || !equalStatuses(resultStatuses(res), 204, 204) || res.Results[0].ID != "1" || res.Results[1].ID != "3" {
+			t.Errorf("Expected alice's messages to be deleted, got %v %+v", status, res.Results)
+		}
+		status, res = doBatch(t, router, "DELETE", "/api/messages?username=alice", nil)
+		if status != http.StatusOK || len(res.Results) != 0 {
+			t.Errorf("Expected deleted messages to be skipped, got %v %+v", status, res.Results)
+		}
+		if status, _ := doBatch(t, router, "DELETE", "/api/messages?ids=2,4,2", nil); status != http.StatusOK || handler.storage.Count() != 0 {
+			t.Errorf("Expected all messages deleted, got %v with %d left", status, handler.storage.Count())
+		}
+	})
+}
+
+func TestBatchRequests(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+		handler.storage.Create("alice", "hello")
+
+		body := func(v interface{}) json.RawMessage {
+			data, _ := json.Marshal(v)
+			return data
+		}
+		requests := []models.BatchRequestItem{
+			{ID: "create", Method: "POST", Path: "/api/messages", Body: body(models.CreateMessageRequest{Username: "bob", Content: "hi"})},
+			{ID: "edit", Method: "PUT", Path: "/api/messages/1", Body: body(models.UpdateMessageRequest{Content: "edited"})},
+			{ID: "missing", Method: "GET", Path: "/api/messages/99"},
+			{ID: "read", Method: "GET", Path: "/api/messages/1"},
+		}
+		status, res := doBatch(t, router, "POST", "/api/batch", models.BatchRequest{Requests: requests})
+		if status != http.StatusMultiStatus || res.Atomic || !equalStatuses(resultStatuses(res), 201, 200, 404, 200) {
+			t.Fatalf("Unexpected batch result %v %+v", status, resultStatuses(res))
+		}
+		var read struct {
+			Data models.Message `json:"data"`
+		}
+		json.Unmarshal(res.Results[3].Body, &read)
+		if res.Results[3].ID != "read" || read.Data.Content != "edited" {
+			t.Errorf("Expected later requests to see earlier changes, got %+v", read.Data)
+		}
+
+		requests[1].Body = body(models.UpdateMessageRequest{Content: "edited again"})
+		status, res = doBatch(t, router, "POST", "/api/batch", models.BatchRequest{Atomic: true, Requests: requests})
+		if status != http.StatusMultiStatus || !res.Atomic || !equalStatuses(resultStatuses(res), 424, 424, 404, 424) {
+			t.Errorf("Expected the atomic batch to fail as a whole, got %v %+v", status, resultStatuses(res))
+		}
+		if msg, _ := handler.storage.GetByID(1); msg.Content != "edited" || handler.storage.Count() != 2 {
+			t.Errorf("Expected the atomic batch to be rolled back, got %+v", msg)
+		}
+
+		status, res = doBatch(t, router, "POST", "/api/batch", models.BatchRequest{Atomic: true, Requests: requests[:2]})
+		if status != http.StatusOK || res.Succeeded != 2 || handler.storage.Count() != 3 {
+			t.Errorf("Expected the atomic batch to apply, got %v %+v", status, res)
+		}
+
+		for _, path := range []string{"/api/batch", "/api/messages/stream", "/api/messages/batch"} {
+			batch := models.BatchRequest{Requests: []models.BatchRequestItem{{Method: "POST", Path: path}}}
+			if status, _ := doBatch(t, router, "POST", "/api/batch", batch); status != http.StatusBadRequest {
+				t.Errorf("Expected 400 for %s in a batch, got %v", path, status)
+			}
+		}
+		if status, _ := doBatch(t, router, "POST", "/api/batch", models.BatchRequest{Requests: []models.BatchRequestItem{{Method: "PATCH", Path: "/api/messages"}}}); status != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unsupported method, got %v", status)
+		}
+	})
+}
+
+func TestAtomicBatchWithImport(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+		requests := []models.BatchRequestItem{
+			{ID: "import", Method: "POST", Path: "/api/messages/import?format=ndjson", Body: json.RawMessage(`{"username":"alice","content":"imported"}`)},
+			{ID: "missing", Method: "GET", Path: "/api/messages/99"},
+		}
+
+		// The import joins the transaction of the batch
+		status, res := doBatch(t, router, "POST", "/api/batch", models.BatchRequest{Atomic: true, Requests: requests})
+		if status != http.StatusMultiStatus || !res.Atomic || !equalStatuses(resultStatuses(res), 424, 404) || handler.storage.Count() != 0 {
+			t.Errorf("Expected the import to be rolled back, got %v %+v with %d messages", status, resultStatuses(res), handler.storage.Count())
+		}
+		status, res = doBatch(t, router, "POST", "/api/batch", models.BatchRequest{Atomic: true, Requests: requests[:1]})
+		if status != http.StatusOK || !equalStatuses(resultStatuses(res), 201) || handler.storage.Count() != 1 {
+			t.Errorf("Expected the import to commit, got %v %+v with %d messages", status, resultStatuses(res), handler.storage.Count())
+		}
+	})
+}
+
+func TestBatchWithoutTransactions(t *testing.T) {
+	handler := NewHandler(struct{ storage.MessageStorage }{storage.NewMemoryStorage()})
+	router := handler.SetupRoutes()
+
+	batch := models.CreateMessagesRequest{Messages: []models.CreateMessageRequest{
+		{Username: "alice", Content: "one"},
+		{Username: "bob"},
+		{Username: "carol", Content: "three"},
+	}}
+	status, res := doBatch(t, router, "POST", "/api/messages/batch", batch)
+	if status != http.StatusMultiStatus || res.Atomic || !equalStatuses(resultStatuses(res), 201, 400, 424) {
+		t.Errorf("Expected the batch to stop at the failure, got %v %+v", status, res)
+	}
+	if count := handler.storage.Count(); count != 1 {
+		t.Errorf("Expected the message before the failure to be kept, got %d", count)
+	}
+}
+
+func TestBatchAuthorization(t *testing.T) {
+	handler := NewHandler(storage.NewMemoryStorage())
+	router, issue := setupAuthRouter(t, handler, 0)
+	alice, bob := issue("alice", "user"), issue("bob", "user")
+
+	batch := models.CreateMessagesRequest{Messages: []models.CreateMessageRequest{{Content: "one"}, {Content: "two"}}}
+	if rr := authRequest(router, "POST", "/api/messages/batch", "", batch); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %v", rr.Code)
+	}
+	if rr := authRequest(router, "POST", "/api/messages/batch", alice, batch); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected alice's batch to be created, got %v", rr.Code)
+	}
+	if msg, _ := handler.storage.GetByID(2); msg.Username != "alice" {
+		t.Errorf("Expected the token's username, got %+v", msg)
+	}
+
+	if rr := authRequest(router, "DELETE", "/api/messages?ids=1,2", bob, nil); rr.Code != http.StatusMultiStatus || handler.storage.Count() != 2 {
+		t.Errorf("Expected bob's bulk delete to be refused, got %v with %d left", rr.Code, handler.storage.Count())
+	}
+	if rr := authRequest(router, "DELETE", "/api/messages?username=alice", alice, nil); rr.Code != http.StatusOK || handler.storage.Count() != 0 {
+		t.Errorf("Expected alice to delete her messages, got %v", rr.Code)
+	}
+}
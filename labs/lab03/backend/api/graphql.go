@@ -0,0 +1,644 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lab03-backend/graphql"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Default GraphQL limits, a page of 50 messages with their authors and
+// reactions costs about 300. Selections are counted once per fragment, not
+// per spread.
+const (
+	DefaultGraphQLMaxDepth      = 10
+	DefaultGraphQLMaxComplexity = 5000
+	DefaultGraphQLMaxSelections = 1000
+)
+
+// repliesCost is the assumed number of replies when estimating complexity
+const repliesCost = 10
+
+// graphqlRequestKey is the context key of the HTTP request a GraphQL
+// operation came from, mutations inherit its Authorization header
+type graphqlRequestKey struct{}
+
+// graphqlStatsKey is the context key of the user statistics of an operation
+type graphqlStatsKey struct{}
+
+// graphqlContext prepares the context of an operation from an HTTP request
+func graphqlContext(ctx context.Context, r *http.Request) context.Context {
+	ctx = context.WithValue(ctx, graphqlRequestKey{}, r)
+	return context.WithValue(ctx, graphqlStatsKey{}, &userStats{})
+}
+
+// SetGraphQLLimits sets the deepest field nesting and the highest
+// complexity GraphQL operations may have, zero removes a limit
+func (h *Handler) SetGraphQLLimits(maxDepth, maxComplexity int) {
+	h.graphqlMaxDepth = maxDepth
+	h.graphqlMaxComplexity = maxComplexity
+}
+
+// setupGraphQLRoutes registers the GraphQL endpoint
+func (h *Handler) setupGraphQLRoutes(api *mux.Router) {
+	schema := h.graphqlSchema()
+	api.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		h.GraphQL(w, r, schema)
+	}).Methods("GET", "POST")
+}
+
+// GraphQL handles /api/graphql. POST runs queries and mutations, GET runs
+// queries from ?query=, and WebSocket upgrades speak graphql-transport-ws
+// for subscriptions.
+func (h *Handler) GraphQL(w http.ResponseWriter, r *http.Request, schema *graphql.Schema) {
+	if r.Method == http.MethodGet && strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		h.serveGraphQLWS(w, r, schema)
+		return
+	}
+
+	var req graphql.Request
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		req.Query, req.OperationName = query.Get("query"), query.Get("operationName")
+		if vars := query.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				writeGraphQL(w, http.StatusBadRequest, &graphql.Response{Errors: []*graphql.Error{{Message: "variables must be a JSON object"}}})
+				return
+			}
+		}
+		// GET must not change data
+		if doc, err := graphql.Parse(req.Query); err == nil {
+			if op, err := doc.Operation(req.OperationName); err == nil && op.Type != graphql.Query {
+				w.Header().Set("Allow", "POST")
+				writeGraphQL(w, http.StatusMethodNotAllowed, &graphql.Response{Errors: []*graphql.Error{{Message: "Only queries can be sent with GET, use POST."}}})
+				return
+			}
+		}
+	} else if err := h.parseJSON(r, &req); err != nil {
+		writeGraphQL(w, http.StatusBadRequest, &graphql.Response{Errors: []*graphql.Error{{Message: "Invalid request body"}}})
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeGraphQL(w, http.StatusBadRequest, &graphql.Response{Errors: []*graphql.Error{{Message: "query is required"}}})
+		return
+	}
+
+	res := schema.Execute(graphqlContext(r.Context(), r), req)
+	status := http.StatusOK
+	if res.Data == nil {
+		status = http.StatusBadRequest // not executed
+	}
+	writeGraphQL(w, status, res)
+}
+
+// writeGraphQL writes a GraphQL response, which has its own format rather
+// than APIResponse
+func writeGraphQL(w http.ResponseWriter, status int, res *graphql.Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		fmt.Printf("Failed to write GraphQL response: %v\n", err)
+	}
+}
+
+// graphqlUser is a user as seen by GraphQL: someone who has posted
+type graphqlUser struct {
+	username string
+}
+
+// userActivity is what GraphQL reports about a user's messages
+type userActivity struct {
+	count int       // messages, tombstones excluded
+	last  time.Time // timestamp of the latest message
+}
+
+// userStats is the activity of every user, computed with a single scan of
+// the storage when an operation first asks for it. Changes made since then
+// are not seen until reset.
+type userStats struct {
+	mutex  sync.Mutex
+	byUser map[string]userActivity // nil until loaded
+}
+
+// reset makes the next lookup scan the storage again
+func (s *userStats) reset() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.byUser = nil
+}
+
+// graphqlConnection is a page of messages
+type graphqlConnection struct {
+	nodes      []*models.Message
+	nextCursor string
+}
+
+// graphqlSchema builds the GraphQL schema over the handler's storage
+func (h *Handler) graphqlSchema() *graphql.Schema {
+	dateTime := &graphql.Scalar{
+		Name: "DateTime",
+		Serialize: func(v interface{}) (interface{}, error) {
+			switch t := v.(type) {
+			case time.Time:
+				return t.Format(time.RFC3339Nano), nil
+			case *time.Time:
+				return t.Format(time.RFC3339Nano), nil
+			}
+			return nil, fmt.Errorf("DateTime cannot represent %v", v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			s, _ := v.(string)
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, fmt.Errorf("DateTime must be an RFC 3339 time")
+			}
+			return t, nil
+		},
+	}
+	nonNullString := graphql.NewNonNull(graphql.String)
+	nonNullInt := graphql.NewNonNull(graphql.Int)
+
+	edit := &graphql.Object{Name: "MessageEdit", Fields: graphql.Fields{
+		"content":  {Type: nonNullString, Resolve: source(func(e models.MessageEdit) interface{} { return e.Content })},
+		"editedAt": {Type: graphql.NewNonNull(dateTime), Resolve: source(func(e models.MessageEdit) interface{} { return e.EditedAt })},
+	}}
+	reaction := &graphql.Object{Name: "Reaction", Fields: graphql.Fields{
+		"emoji": {Type: nonNullString, Resolve: source(func(r models.Reaction) interface{} { return r.Emoji })},
+		"count": {Type: nonNullInt, Resolve: source(func(r models.Reaction) interface{} { return r.Count })},
+		"users": {Type: graphql.NewNonNull(graphql.NewList(nonNullString)), Resolve: source(func(r models.Reaction) interface{} { return r.Users })},
+	}}
+	attachment := &graphql.Object{Name: "Attachment", Fields: graphql.Fields{
+		"id":           {Type: graphql.NewNonNull(graphql.ID), Resolve: source(func(a models.Attachment) interface{} { return a.ID })},
+		"name":         {Type: nonNullString, Resolve: source(func(a models.Attachment) interface{} { return a.Name })},
+		"mimeType":     {Type: nonNullString, Resolve: source(func(a models.Attachment) interface{} { return a.MIMEType })},
+		"size":         {Type: graphql.NewNonNull(graphql.Float), Resolve: source(func(a models.Attachment) interface{} { return a.Size })},
+		"width":        {Type: graphql.Int, Resolve: source(func(a models.Attachment) interface{} { return optional(a.Width) })},
+		"height":       {Type: graphql.Int, Resolve: source(func(a models.Attachment) interface{} { return optional(a.Height) })},
+		"url":          {Type: graphql.String, Resolve: source(func(a models.Attachment) interface{} { return optional(a.URL) })},
+		"thumbnailUrl": {Type: graphql.String, Resolve: source(func(a models.Attachment) interface{} { return optional(a.ThumbnailURL) })},
+	}}
+
+	message := &graphql.Object{Name: "Message"}
+	user := &graphql.Object{Name: "User"}
+	connection := &graphql.Object{Name: "MessageConnection", Fields: graphql.Fields{
+		"nodes":      {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(message))), Resolve: source(func(c *graphqlConnection) interface{} { return c.nodes })},
+		"nextCursor": {Type: graphql.String, Resolve: source(func(c *graphqlConnection) interface{} { return optional(c.nextCursor) })},
+	}}
+	listArgs := graphql.Args{
+		"limit": {Type: graphql.Int},
+		"after": {Type: graphql.String},
+		"sort":  {Type: graphql.String},
+		"order": {Type: graphql.String},
+		"since": {Type: dateTime},
+		"until": {Type: dateTime},
+	}
+
+	message.Fields = graphql.Fields{
+		"id":       {Type: graphql.NewNonNull(graphql.ID), Resolve: source(func(m *models.Message) interface{} { return m.ID })},
+		"username": {Type: nonNullString, Resolve: source(func(m *models.Message) interface{} { return m.Username })},
+		"author": {Type: graphql.NewNonNull(user), Resolve: source(func(m *models.Message) interface{} {
+			return &graphqlUser{username: m.Username}
+		})},
+		"content":   {Type: nonNullString, Resolve: source(func(m *models.Message) interface{} { return m.Content })},
+		"timestamp": {Type: graphql.NewNonNull(dateTime), Resolve: source(func(m *models.Message) interface{} { return m.Timestamp })},
+		"editedAt":  {Type: dateTime, Resolve: source(func(m *models.Message) interface{} { return m.EditedAt })},
+		"deleted":   {Type: graphql.NewNonNull(graphql.Boolean), Resolve: source(func(m *models.Message) interface{} { return m.Deleted })},
+		"deletedAt": {Type: dateTime, Resolve: source(func(m *models.Message) interface{} { return m.DeletedAt })},
+		"edits": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(edit))), Resolve: source(func(m *models.Message) interface{} {
+			return nonNil(m.Edits)
+		})},
+		"reactions": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(reaction))), Resolve: source(func(m *models.Message) interface{} {
+			return nonNil(m.Reactions)
+		})},
+		"attachments": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(attachment))), Resolve: source(func(m *models.Message) interface{} {
+			return nonNil(m.Attachments)
+		})},
+		"parent": {Type: message, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			msg := p.Source.(*models.Message)
+			if msg.ParentID == 0 {
+				return nil, nil
+			}
+			return h.graphqlMessage(msg.ParentID)
+		}},
+		"replies": {
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(message))),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				replies, err := h.storage.Replies(p.Source.(*models.Message).ID)
+				if err != nil {
+					return nil, err
+				}
+				return h.presentAll(replies), nil
+			},
+			Complexity: func(args map[string]interface{}, children int) int { return 1 + repliesCost*children },
+		},
+	}
+
+	user.Fields = graphql.Fields{
+		"username": {Type: nonNullString, Resolve: source(func(u *graphqlUser) interface{} { return u.username })},
+		"messageCount": {Type: nonNullInt, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			activity, err := h.userActivity(p.Context, p.Source.(*graphqlUser).username)
+			return activity.count, err
+		}},
+		"lastActiveAt": {Type: dateTime, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			activity, err := h.userActivity(p.Context, p.Source.(*graphqlUser).username)
+			if activity.last.IsZero() {
+				return nil, err
+			}
+			return activity.last, err
+		}},
+		"messages": {
+			Type: graphql.NewNonNull(connection),
+			Args: listArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return h.graphqlList(p.Args, p.Source.(*graphqlUser).username, "")
+			},
+			Complexity: listComplexity,
+		},
+	}
+
+	messagesArgs := graphql.Args{"username": {Type: graphql.String}, "search": {Type: graphql.String}}
+	for name, arg := range listArgs {
+		messagesArgs[name] = arg
+	}
+	query := &graphql.Object{Name: "Query", Fields: graphql.Fields{
+		"message": {
+			Type: message,
+			Args: graphql.Args{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, err := graphqlID(p.Args["id"])
+				if err != nil {
+					return nil, err
+				}
+				return h.graphqlMessage(id)
+			},
+		},
+		"messages": {
+			Type: graphql.NewNonNull(connection),
+			Args: messagesArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				username, _ := p.Args["username"].(string)
+				search, _ := p.Args["search"].(string)
+				return h.graphqlList(p.Args, username, search)
+			},
+			Complexity: listComplexity,
+		},
+		"user": {
+			Type: user,
+			Args: graphql.Args{"username": {Type: nonNullString}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				username := p.Args["username"].(string)
+				activity, err := h.userActivity(p.Context, username)
+				if err != nil || activity.count == 0 {
+					return nil, err
+				}
+				return &graphqlUser{username: username}, nil
+			},
+		},
+		"users": {
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(user))),
+			Args: graphql.Args{"limit": {Type: graphql.Int, Default: storage.DefaultListLimit}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return h.graphqlUsers(p.Args["limit"].(int))
+			},
+			Complexity: listComplexity,
+		},
+	}}
+
+	createInput := &graphql.InputObject{Name: "CreateMessageInput", Fields: graphql.InputFields{
+		"username":    {Type: graphql.String}, // taken from the token when auth is enabled
+		"content":     {Type: graphql.String},
+		"parentId":    {Type: graphql.ID},
+		"attachments": {Type: graphql.NewList(graphql.NewNonNull(graphql.ID))},
+	}}
+	updateInput := &graphql.InputObject{Name: "UpdateMessageInput", Fields: graphql.InputFields{
+		"content": {Type: nonNullString},
+	}}
+	mutation := &graphql.Object{Name: "Mutation", Fields: graphql.Fields{
+		"createMessage": {
+			Type: graphql.NewNonNull(message),
+			Args: graphql.Args{"input": {Type: graphql.NewNonNull(createInput)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				input := p.Args["input"].(map[string]interface{})
+				req := models.CreateMessageRequest{}
+				req.Username, _ = input["username"].(string)
+				req.Content, _ = input["content"].(string)
+				if parentID, ok := input["parentId"]; ok && parentID != nil {
+					id, err := graphqlID(parentID)
+					if err != nil {
+						return nil, err
+					}
+					req.ParentID = id
+				}
+				if attachments, ok := input["attachments"].([]interface{}); ok {
+					for _, id := range attachments {
+						req.Attachments = append(req.Attachments, id.(string))
+					}
+				}
+				return h.graphqlMutate(p.Context, http.MethodPost, "/api/messages", req)
+			},
+		},
+		"updateMessage": {
+			Type: graphql.NewNonNull(message),
+			Args: graphql.Args{"id": {Type: graphql.NewNonNull(graphql.ID)}, "input": {Type: graphql.NewNonNull(updateInput)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, err := graphqlID(p.Args["id"])
+				if err != nil {
+					return nil, err
+				}
+				input := p.Args["input"].(map[string]interface{})
+				req := models.UpdateMessageRequest{Content: input["content"].(string)}
+				return h.graphqlMutate(p.Context, http.MethodPut, "/api/messages/"+strconv.Itoa(id), req)
+			},
+		},
+		"deleteMessage": {
+			Type: graphql.NewNonNull(message),
+			Args: graphql.Args{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, err := graphqlID(p.Args["id"])
+				if err != nil {
+					return nil, err
+				}
+				if _, err := h.graphqlMutate(p.Context, http.MethodDelete, "/api/messages/"+strconv.Itoa(id), nil); err != nil {
+					return nil, err
+				}
+				return h.graphqlMessage(id) // the tombstone
+			},
+		},
+	}}
+
+	subscription := &graphql.Object{Name: "Subscription", Fields: graphql.Fields{
+		"messageCreated": {
+			Type:      graphql.NewNonNull(message),
+			Args:      graphql.Args{"parentId": {Type: graphql.ID}},
+			Subscribe: h.subscribeMessages,
+		},
+	}}
+
+	return &graphql.Schema{
+		Query:         query,
+		Mutation:      mutation,
+		Subscription:  subscription,
+		MaxDepth:      h.graphqlMaxDepth,
+		MaxComplexity: h.graphqlMaxComplexity,
+		MaxSelections: DefaultGraphQLMaxSelections,
+	}
+}
+
+// source adapts a getter on the parent value to a resolver
+func source[T any](get func(T) interface{}) func(p graphql.ResolveParams) (interface{}, error) {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		return get(p.Source.(T)), nil
+	}
+}
+
+// optional turns zero values into null
+func optional[T comparable](v T) interface{} {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return v
+}
+
+// nonNil turns a nil list into an empty one
+func nonNil[T any](list []T) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
+
+// listComplexity charges the children of a list field once per item
+func listComplexity(args map[string]interface{}, children int) int {
+	limit, ok := args["limit"].(int)
+	if !ok || limit <= 0 {
+		limit = storage.DefaultListLimit
+	}
+	return 1 + min(limit, storage.MaxListLimit)*children
+}
+
+// graphqlID parses a message ID argument
+func graphqlID(v interface{}) (int, error) {
+	s, _ := v.(string)
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, &graphql.Error{Message: "Invalid ID", Extensions: map[string]interface{}{"status": http.StatusBadRequest}}
+	}
+	return id, nil
+}
+
+// graphqlMessage returns a message or its tombstone, null if it does not exist
+func (h *Handler) graphqlMessage(id int) (*models.Message, error) {
+	msg, err := h.storage.GetByID(id)
+	if err == storage.ErrMessageNotFound || err == storage.ErrInvalidID {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.present(msg), nil
+}
+
+// graphqlList lists or searches messages, the arguments are validated like
+// the query parameters of GET /api/messages
+func (h *Handler) graphqlList(args map[string]interface{}, username, search string) (*graphqlConnection, error) {
+	query := url.Values{}
+	if limit, ok := args["limit"].(int); ok {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	for name, param := range map[string]string{"after": "cursor", "sort": "sort", "order": "order"} {
+		if value, ok := args[name].(string); ok {
+			query.Set(param, value)
+		}
+	}
+	for _, name := range []string{"since", "until"} {
+		if t, ok := args[name].(time.Time); ok {
+			query.Set(name, t.Format(time.RFC3339Nano))
+		}
+	}
+	query.Set("username", username)
+	opts, err := parseListOptions(query)
+	if err != nil {
+		return nil, &graphql.Error{Message: err.Error(), Extensions: map[string]interface{}{"status": http.StatusBadRequest}}
+	}
+
+	if search != "" {
+		var nodes []*models.Message
+		for _, result := range h.storage.Search(search, searchLimit(opts)) {
+			if username == "" || result.Username == username {
+				nodes = append(nodes, h.present(result.Message))
+			}
+		}
+		return &graphqlConnection{nodes: nodes}, nil
+	}
+	page, err := h.storage.List(opts)
+	if err == storage.ErrInvalidCursor {
+		return nil, &graphql.Error{Message: err.Error(), Extensions: map[string]interface{}{"status": http.StatusBadRequest}}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &graphqlConnection{nodes: h.presentAll(page.Messages), nextCursor: page.NextCursor}, nil
+}
+
+// eachMessage calls fn for every message matching opts, in pages
+func (h *Handler) eachMessage(opts storage.ListOptions, fn func(msg *models.Message) bool) error {
+	opts.Limit = storage.MaxListLimit
+	for {
+		page, err := h.storage.List(opts)
+		if err != nil {
+			return err
+		}
+		for _, msg := range page.Messages {
+			if !fn(msg) {
+				return nil
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// userActivity returns a user's activity from the statistics of the
+// operation, or from a scan of their messages outside of one
+func (h *Handler) userActivity(ctx context.Context, username string) (userActivity, error) {
+	stats, _ := ctx.Value(graphqlStatsKey{}).(*userStats)
+	if stats == nil {
+		activity, err := h.scanActivity(storage.ListOptions{Username: username})
+		return activity[username], err
+	}
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	if stats.byUser == nil {
+		activity, err := h.scanActivity(storage.ListOptions{})
+		if err != nil {
+			return userActivity{}, err
+		}
+		stats.byUser = activity
+	}
+	return stats.byUser[username], nil
+}
+
+// scanActivity computes the activity of the users of the messages matching
+// opts
+func (h *Handler) scanActivity(opts storage.ListOptions) (map[string]userActivity, error) {
+	byUser := make(map[string]userActivity)
+	err := h.eachMessage(opts, func(msg *models.Message) bool {
+		activity := byUser[msg.Username]
+		if !msg.Deleted {
+			activity.count++
+		}
+		if msg.Timestamp.After(activity.last) {
+			activity.last = msg.Timestamp
+		}
+		byUser[msg.Username] = activity
+		return true
+	})
+	return byUser, err
+}
+
+// graphqlUsers lists up to limit users in the order of their first message
+func (h *Handler) graphqlUsers(limit int) ([]*graphqlUser, error) {
+	users := []*graphqlUser{}
+	seen := make(map[string]bool)
+	err := h.eachMessage(storage.ListOptions{}, func(msg *models.Message) bool {
+		if !seen[msg.Username] {
+			seen[msg.Username] = true
+			users = append(users, &graphqlUser{username: msg.Username})
+		}
+		return len(users) < limit
+	})
+	return users, err
+}
+
+// graphqlMutate runs a mutation through the REST API, so it is validated,
+// moderated and authorized exactly like the REST request
+func (h *Handler) graphqlMutate(ctx context.Context, method, path string, body interface{}) (*models.Message, error) {
+	r, _ := ctx.Value(graphqlRequestKey{}).(*http.Request)
+	if r == nil {
+		return nil, errors.New("mutation outside of a request")
+	}
+	item := models.BatchRequestItem{Method: method, Path: path}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		item.Body = data
+	}
+	if h.router == nil {
+		return nil, errors.New("mutation outside of the handler's routes")
+	}
+	result := runBatchItem(h.router, r, DefaultVersion, item)
+	stats, _ := ctx.Value(graphqlStatsKey{}).(*userStats)
+	stats.reset() // later fields see the change
+	var res struct {
+		Data  *models.Message `json:"data"`
+		Error string          `json:"error"`
+	}
+	json.Unmarshal(result.Body, &res)
+	if result.Status >= http.StatusBadRequest {
+		if res.Error == "" {
+			res.Error = http.StatusText(result.Status)
+		}
+		return nil, &graphql.Error{Message: res.Error, Extensions: map[string]interface{}{"status": result.Status}}
+	}
+	return res.Data, nil
+}
+
+// subscribeMessages streams created messages, optionally only the replies
+// to parentId
+func (h *Handler) subscribeMessages(p graphql.ResolveParams) (<-chan interface{}, error) {
+	parentID := 0
+	if value, ok := p.Args["parentId"]; ok && value != nil {
+		id, err := graphqlID(value)
+		if err != nil {
+			return nil, err
+		}
+		parentID = id
+	}
+	_, events, _, cancel := h.feed.Subscribe(storage.EventID{}, false)
+	messages := make(chan interface{})
+	go func() {
+		defer close(messages)
+		defer cancel()
+		for {
+			select {
+			case <-p.Context.Done():
+				return
+			case ev, ok := <-events:
+				if !ok {
+					return // dropped for falling behind
+				}
+				if ev.Type != storage.EventCreated || (parentID != 0 && ev.Message.ParentID != parentID) {
+					continue
+				}
+				// The operation runs again for this message, count it
+				stats, _ := p.Context.Value(graphqlStatsKey{}).(*userStats)
+				stats.reset()
+				select {
+				case messages <- h.present(ev.Message):
+				case <-p.Context.Done():
+					return
+				}
+			}
+		}
+	}()
+	return messages, nil
+}
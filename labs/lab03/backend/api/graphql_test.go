@@ -0,0 +1,229 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"lab03-backend/storage"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// graphqlResult is a decoded GraphQL response
+type graphqlResult struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Path       []interface{}          `json:"path"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func doGraphQL(t *testing.T, router http.Handler, token, query string, vars map[string]interface{}) (int, graphqlResult) {
+	t.Helper()
+	rr := authRequest(router, "POST", "/api/graphql", token, map[string]interface{}{"query": query, "variables": vars})
+	var res graphqlResult
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatalf("Invalid GraphQL response: %v", err)
+	}
+	return rr.Code, res
+}
+
+func TestGraphQLQueries(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		store := handler.storage
+		root, _ := store.Create("alice", "hello graphql")
+		store.CreateReply("bob", "hi alice", root.ID)
+		store.React(root.ID, "bob", "👍")
+		store.Create("alice", "second")
+		gone, _ := store.Create("carol", "oops")
+		store.Delete(gone.ID)
+		router := handler.SetupRoutes()
+
+		code, res := doGraphQL(t, router, "", `{
+			message(id: 1) {
+				id content author { username messageCount }
+				reactions { emoji count users }
+				replies { username parent { id } }
+			}
+			missing: message(id: 99) { id }
+		}`, nil)
+		want := `{"message":{"id":"1","content":"hello graphql","author":{"username":"alice","messageCount":2},` +
+			`"reactions":[{"emoji":"👍","count":1,"users":["bob"]}],"replies":[{"username":"bob","parent":{"id":"1"}}]},"missing":null}`
+		if code != http.StatusOK || string(res.Data) != want || len(res.Errors) != 0 {
+			t.Errorf("Got %v %s %+v, want %s", code, res.Data, res.Errors, want)
+		}
+
+		_, res = doGraphQL(t, router, "", `query($after: String) {
+			messages(limit: 2, after: $after) { nodes { id } nextCursor }
+		}`, nil)
+		var page struct {
+			Messages struct {
+				Nodes      []struct{ ID string }
+				NextCursor string
+			}
+		}
+		json.Unmarshal(res.Data, &page)
+		if len(page.Messages.Nodes) != 2 || page.Messages.NextCursor == "" {
+			t.Fatalf("Expected a first page, got %s %+v", res.Data, res.Errors)
+		}
+		_, res = doGraphQL(t, router, "", `query($after: String) { messages(limit: 10, after: $after) { nodes { id deleted } nextCursor } }`,
+			map[string]interface{}{"after": page.Messages.NextCursor})
+		if want := `{"messages":{"nodes":[{"id":"3","deleted":false},{"id":"4","deleted":true}],"nextCursor":null}}`; string(res.Data) != want {
+			t.Errorf("Got %s, want %s", res.Data, want)
+		}
+
+		_, res = doGraphQL(t, router, "", `{ messages(search: "graphql") { nodes { id } } users { username lastActiveAt } user(username: "carol") { messageCount } nobody: user(username: "dave") { username } }`, nil)
+		var found struct {
+			Messages struct{ Nodes []struct{ ID string } }
+			Users    []struct {
+				Username     string
+				LastActiveAt *time.Time
+			}
+			User struct{ MessageCount int }
+		}
+		json.Unmarshal(res.Data, &found)
+		if len(found.Messages.Nodes) != 1 || len(found.Users) != 3 || found.Users[2].Username != "carol" || found.Users[0].LastActiveAt == nil ||
+			found.User.MessageCount != 0 || !strings.Contains(string(res.Data), `"nobody":null`) {
+			t.Errorf("Unexpected search and users %s %+v", res.Data, res.Errors)
+		}
+
+		_, res = doGraphQL(t, router, "", `{ messages(order: "sideways") { nodes { id } } }`, nil)
+		if len(res.Errors) != 1 || res.Errors[0].Message != "order must be asc or desc" || res.Errors[0].Extensions["status"] != 400.0 {
+			t.Errorf("Expected REST validation of list arguments, got %+v", res.Errors)
+		}
+	})
+}
+
+func TestGraphQLGetAndRequestErrors(t *testing.T) {
+	handler := NewHandler(storage.NewMemoryStorage())
+	handler.storage.Create("alice", "hi")
+	router := handler.SetupRoutes()
+
+	rr := authRequest(router, "GET", "/api/graphql?query="+url.QueryEscape(`{ message(id: 1) { content } }`), "", nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"content":"hi"`) {
+		t.Errorf("Expected a GET query, got %v %s", rr.Code, rr.Body)
+	}
+	rr = authRequest(router, "GET", "/api/graphql?query="+url.QueryEscape(`mutation { deleteMessage(id: 1) { id } }`), "", nil)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected mutations over GET to be refused, got %v", rr.Code)
+	}
+
+	for _, query := range []string{`{ message(id: 1) { nope } }`, `{ message(id: 1) `, ``,
+		`subscription { ...A } fragment A on Subscription { ...A }`} {
+		if code, res := doGraphQL(t, router, "", query, nil); code != http.StatusBadRequest || len(res.Errors) != 1 || res.Data != nil {
+			t.Errorf("%q: expected 400 with one error, got %v %s %+v", query, code, res.Data, res.Errors)
+		}
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router, issue := setupAuthRouter(t, handler, 0)
+		alice, bob := issue("alice", ""), issue("bob", "")
+
+		create := `mutation($input: CreateMessageInput!) { createMessage(input: $input) { id username content } }`
+		code, res := doGraphQL(t, router, "", create, map[string]interface{}{"input": map[string]interface{}{"content": "hi"}})
+		if code != http.StatusOK || len(res.Errors) != 1 || res.Errors[0].Extensions["status"] != 401.0 || string(res.Data) != "null" {
+			t.Errorf("Expected 401 from the REST handler, got %v %s %+v", code, res.Data, res.Errors)
+		}
+		_, res = doGraphQL(t, router, alice, create, map[string]interface{}{"input": map[string]interface{}{"content": ""}})
+		if len(res.Errors) != 1 || res.Errors[0].Message != "content is required" {
+			t.Errorf("Expected REST validation, got %+v", res.Errors)
+		}
+		_, res = doGraphQL(t, router, alice, create, map[string]interface{}{"input": map[string]interface{}{"content": "hi"}})
+		if want := `{"createMessage":{"id":"1","username":"alice","content":"hi"}}`; string(res.Data) != want {
+			t.Errorf("Got %s %+v, want %s", res.Data, res.Errors, want)
+		}
+		_, res = doGraphQL(t, router, bob, `mutation { createMessage(input: {content: "re", parentId: 1}) { parent { username } } }`, nil)
+		if want := `{"createMessage":{"parent":{"username":"alice"}}}`; string(res.Data) != want {
+			t.Errorf("Got %s %+v, want %s", res.Data, res.Errors, want)
+		}
+
+		update := `mutation { updateMessage(id: 1, input: {content: "edited"}) { content edits { content } } }`
+		if _, res := doGraphQL(t, router, bob, update, nil); len(res.Errors) != 1 || res.Errors[0].Extensions["status"] != 403.0 {
+			t.Errorf("Expected 403 for someone else's message, got %+v", res.Errors)
+		}
+		_, res = doGraphQL(t, router, alice, update, nil)
+		if want := `{"updateMessage":{"content":"edited","edits":[{"content":"hi"}]}}`; string(res.Data) != want {
+			t.Errorf("Got %s %+v, want %s", res.Data, res.Errors, want)
+		}
+
+		_, res = doGraphQL(t, router, alice, `mutation { deleteMessage(id: 1) { id deleted deletedAt } }`, nil)
+		var deleted struct {
+			DeleteMessage struct {
+				Deleted   bool
+				DeletedAt *time.Time
+			}
+		}
+		json.Unmarshal(res.Data, &deleted)
+		if !deleted.DeleteMessage.Deleted || deleted.DeleteMessage.DeletedAt == nil {
+			t.Errorf("Expected the tombstone, got %s %+v", res.Data, res.Errors)
+		}
+		if _, res := doGraphQL(t, router, alice, `mutation { deleteMessage(id: 42) { id } }`, nil); len(res.Errors) != 1 || res.Errors[0].Extensions["status"] != 404.0 {
+			t.Errorf("Expected 404, got %+v", res.Errors)
+		}
+	})
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	handler := NewHandler(storage.NewMemoryStorage())
+	handler.SetGraphQLLimits(3, 200)
+	router := handler.SetupRoutes()
+
+	_, res := doGraphQL(t, router, "", `{ message(id: 1) { parent { parent { id } } } }`, nil)
+	if len(res.Errors) != 1 || res.Errors[0].Extensions["code"] != "QUERY_TOO_DEEP" {
+		t.Errorf("Expected the depth limit, got %+v", res.Errors)
+	}
+	_, res = doGraphQL(t, router, "", `{ messages(limit: 100) { nodes { id content } } }`, nil)
+	if len(res.Errors) != 1 || res.Errors[0].Extensions["code"] != "QUERY_TOO_COMPLEX" {
+		t.Errorf("Expected the complexity limit, got %+v", res.Errors)
+	}
+	if _, res := doGraphQL(t, router, "", `{ messages(limit: 10) { nodes { id content } } }`, nil); len(res.Errors) != 0 {
+		t.Errorf("Expected a cheap query to pass, got %+v", res.Errors)
+	}
+}
+
+// countingStorage counts the pages listed from a storage
+type countingStorage struct {
+	storage.MessageStorage
+	lists int
+}
+
+func (s *countingStorage) List(opts storage.ListOptions) (storage.Page, error) {
+	s.lists++
+	return s.MessageStorage.List(opts)
+}
+
+func TestGraphQLUserStatsScanOnce(t *testing.T) {
+	counting := &countingStorage{MessageStorage: storage.NewMemoryStorage()}
+	handler := NewHandler(counting)
+	for i := 0; i < 30; i++ {
+		handler.storage.Create(fmt.Sprintf("user%d", i), "hello")
+	}
+	router := handler.SetupRoutes()
+
+	counting.lists = 0
+	_, res := doGraphQL(t, router, "", `{
+		users(limit: 30) { messageCount lastActiveAt }
+		messages(limit: 30) { nodes { author { messageCount } } }
+	}`, nil)
+	if len(res.Errors) != 0 || !strings.Contains(string(res.Data), `"messageCount":1`) {
+		t.Fatalf("Unexpected result %s %+v", res.Data, res.Errors)
+	}
+	// One page for users, one for messages and one scan for the statistics
+	if counting.lists != 3 {
+		t.Errorf("Expected 3 pages to be listed, got %d", counting.lists)
+	}
+
+	// Statistics are recomputed after each mutation
+	_, res = doGraphQL(t, router, "", `mutation {
+		a: createMessage(input: {username: "user0", content: "again"}) { author { messageCount } }
+		b: createMessage(input: {username: "user0", content: "and again"}) { author { messageCount } }
+	}`, nil)
+	if want := `{"a":{"author":{"messageCount":2}},"b":{"author":{"messageCount":3}}}`; string(res.Data) != want {
+		t.Errorf("Got %s %+v, want %s", res.Data, res.Errors, want)
+	}
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"lab03-backend/graphql"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// graphqlWSProtocol is the WebSocket subprotocol of GraphQL over WebSocket,
+// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+const graphqlWSProtocol = "graphql-transport-ws"
+
+// graphqlInitTimeout is how long a client has to send connection_init
+const graphqlInitTimeout = 10 * time.Second
+
+// graphql-transport-ws close codes
+const (
+	wsInvalidMessage      = 4400
+	wsUnauthorized        = 4401
+	wsForbidden           = 4403
+	wsInitTimeout         = 4408
+	wsSubscriberExists    = 4409
+	wsTooManyInitRequests = 4429
+)
+
+var graphqlUpgrader = websocket.Upgrader{
+	Subprotocols: []string{graphqlWSProtocol},
+	CheckOrigin: func(r *http.Request) bool {
+		return true // like the CORS policy, any origin may connect
+	},
+}
+
+// wsMessage is a message of the graphql-transport-ws protocol
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// graphqlSession is one WebSocket connection with its running operations
+type graphqlSession struct {
+	h       *Handler
+	schema  *graphql.Schema
+	conn    *websocket.Conn
+	request *http.Request // carries the Authorization of connection_init
+
+	writeMu sync.Mutex // one writer at a time
+	mu      sync.Mutex
+	ops     map[string]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// serveGraphQLWS speaks graphql-transport-ws: the client sends
+// connection_init, optionally with {"Authorization": "Bearer ..."} as
+// payload, then subscribe messages that are answered with next messages
+// until the operation completes
+func (h *Handler) serveGraphQLWS(w http.ResponseWriter, r *http.Request, schema *graphql.Schema) {
+	conn, err := graphqlUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade replied with an error
+	}
+	defer conn.Close()
+	s := &graphqlSession{h: h, schema: schema, conn: conn, request: r, ops: make(map[string]context.CancelFunc)}
+	if conn.Subprotocol() != graphqlWSProtocol {
+		s.close(websocket.CloseProtocolError, "Subprotocol "+graphqlWSProtocol+" is required")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer func() {
+		cancel()
+		s.wg.Wait()
+	}()
+	// The connection outlives the server's timeouts
+	conn.SetWriteDeadline(time.Time{})
+	conn.SetReadDeadline(time.Now().Add(graphqlInitTimeout))
+
+	acknowledged := false
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if !acknowledged && errors.As(err, &netErr) && netErr.Timeout() {
+				s.close(wsInitTimeout, "Connection initialisation timeout")
+			}
+			return
+		}
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.close(wsInvalidMessage, "Invalid message received")
+			return
+		}
+
+		switch msg.Type {
+		case "connection_init":
+			if acknowledged {
+				s.close(wsTooManyInitRequests, "Too many initialisation requests")
+				return
+			}
+			if !s.init(ctx, msg.Payload) {
+				return
+			}
+			acknowledged = true
+			conn.SetReadDeadline(time.Time{})
+			s.send(wsMessage{Type: "connection_ack"})
+		case "ping":
+			s.send(wsMessage{Type: "pong", Payload: msg.Payload})
+		case "pong":
+		case "subscribe":
+			if !acknowledged {
+				s.close(wsUnauthorized, "Unauthorized")
+				return
+			}
+			var req graphql.Request
+			if msg.ID == "" || json.Unmarshal(msg.Payload, &req) != nil {
+				s.close(wsInvalidMessage, "Invalid message received")
+				return
+			}
+			if !s.start(ctx, msg.ID, req) {
+				s.close(wsSubscriberExists, "Subscriber for "+msg.ID+" already exists")
+				return
+			}
+		case "complete":
+			s.stop(msg.ID)
+		default:
+			s.close(wsInvalidMessage, "Invalid message received")
+			return
+		}
+	}
+}
+
+// init applies the connection_init payload, rejecting invalid tokens
+func (s *graphqlSession) init(ctx context.Context, payload json.RawMessage) bool {
+	var params map[string]interface{}
+	if len(payload) > 0 && json.Unmarshal(payload, &params) != nil {
+		s.close(wsInvalidMessage, "Invalid message received")
+		return false
+	}
+	authorization, _ := params["Authorization"].(string)
+	if authorization == "" {
+		authorization, _ = params["authorization"].(string)
+	}
+	if authorization == "" {
+		return true // the upgrade request's header, if any, is used
+	}
+	if s.h.tokens != nil {
+		token, _ := strings.CutPrefix(authorization, "Bearer ")
+		if _, err := s.h.tokens.Validate(token); err != nil {
+			s.close(wsForbidden, "Forbidden")
+			return false
+		}
+	}
+	s.request = s.request.Clone(ctx)
+	s.request.Header.Set("Authorization", authorization)
+	return true
+}
+
+// start runs an operation, false if its ID is in use. The subscription is
+// registered before start returns, so later messages on the connection are
+// ordered after it
+func (s *graphqlSession) start(ctx context.Context, id string, req graphql.Request) bool {
+	s.mu.Lock()
+	if _, ok := s.ops[id]; ok {
+		s.mu.Unlock()
+		return false
+	}
+	ctx, cancel := context.WithCancel(graphqlContext(ctx, s.request))
+	s.ops[id] = cancel
+	s.mu.Unlock()
+
+	responses, errRes := s.schema.Subscribe(ctx, req)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		if errRes != nil {
+			if s.finish(ctx, id) {
+				payload, _ := json.Marshal(errRes.Errors)
+				s.send(wsMessage{ID: id, Type: "error", Payload: payload})
+			}
+			return
+		}
+		for res := range responses {
+			payload, _ := json.Marshal(res)
+			s.send(wsMessage{ID: id, Type: "next", Payload: payload})
+		}
+		if s.finish(ctx, id) {
+			s.send(wsMessage{ID: id, Type: "complete"})
+		}
+	}()
+	return true
+}
+
+// finish forgets an operation that ended, reporting whether the server
+// ended it rather than the client
+func (s *graphqlSession) finish(ctx context.Context, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ctx.Err() != nil {
+		return false
+	}
+	delete(s.ops, id)
+	return true
+}
+
+// stop cancels an operation the client completed
+func (s *graphqlSession) stop(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, ok := s.ops[id]; ok {
+		cancel()
+		delete(s.ops, id)
+	}
+}
+
+// send writes a protocol message
+func (s *graphqlSession) send(msg wsMessage) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.WriteJSON(msg)
+}
+
+// close ends the connection with a close code
+func (s *graphqlSession) close(code int, reason string) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
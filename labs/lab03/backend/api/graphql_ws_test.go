@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"lab03-backend/storage"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialGraphQL opens a graphql-transport-ws connection to the server
+func dialGraphQL(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: []string{graphqlWSProtocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/graphql", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// expectWS reads the next message and checks its type
+func expectWS(t *testing.T, conn *websocket.Conn, typ string) wsMessage {
+	t.Helper()
+	var msg wsMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("Expected %s, got %v", typ, err)
+	}
+	if msg.Type != typ {
+		t.Fatalf("Expected %s, got %s %s", typ, msg.Type, msg.Payload)
+	}
+	return msg
+}
+
+func TestGraphQLWebSocketSubscription(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		server := httptest.NewServer(handler.SetupRoutes())
+		defer server.Close()
+		root, _ := handler.storage.Create("alice", "root")
+
+		conn := dialGraphQL(t, server)
+		defer conn.Close()
+		conn.WriteJSON(wsMessage{Type: "connection_init"})
+		expectWS(t, conn, "connection_ack")
+
+		conn.WriteJSON(map[string]interface{}{"id": "1", "type": "subscribe", "payload": map[string]interface{}{
+			"query":     `subscription($parent: ID) { messageCreated(parentId: $parent) { content username } }`,
+			"variables": map[string]interface{}{"parent": root.ID},
+		}})
+		conn.WriteJSON(map[string]interface{}{"id": "2", "type": "subscribe", "payload": map[string]interface{}{
+			"query": `{ message(id: 1) { content } }`,
+		}})
+		if msg := expectWS(t, conn, "next"); msg.ID != "2" || string(msg.Payload) != `{"data":{"message":{"content":"root"}}}` {
+			t.Errorf("Unexpected query result %s %s", msg.ID, msg.Payload)
+		}
+		if msg := expectWS(t, conn, "complete"); msg.ID != "2" {
+			t.Errorf("Expected the query to complete, got %s", msg.ID)
+		}
+
+		handler.storage.Create("bob", "not a reply")
+		authRequest(server.Config.Handler, "POST", "/api/messages", "",
+			map[string]interface{}{"username": "bob", "content": "a reply", "parent_id": root.ID})
+		if msg := expectWS(t, conn, "next"); msg.ID != "1" || string(msg.Payload) != `{"data":{"messageCreated":{"content":"a reply","username":"bob"}}}` {
+			t.Errorf("Unexpected event %s %s", msg.ID, msg.Payload)
+		}
+
+		// After the client completes, no more events are sent
+		conn.WriteJSON(wsMessage{ID: "1", Type: "complete"})
+		conn.WriteJSON(wsMessage{Type: "ping"})
+		expectWS(t, conn, "pong")
+		handler.storage.CreateReply("carol", "late", root.ID)
+		conn.WriteJSON(wsMessage{Type: "ping"})
+		expectWS(t, conn, "pong")
+	})
+}
+
+func TestGraphQLWebSocketProtocolErrors(t *testing.T) {
+	handler := NewHandler(storage.NewMemoryStorage())
+	router, issue := setupAuthRouter(t, handler, 0)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	closeCode := func(conn *websocket.Conn) int {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				if ce, ok := err.(*websocket.CloseError); ok {
+					return ce.Code
+				}
+				return 0
+			}
+		}
+	}
+
+	conn := dialGraphQL(t, server)
+	conn.WriteJSON(wsMessage{ID: "1", Type: "subscribe", Payload: json.RawMessage(`{"query":"{ users { username } }"}`)})
+	if code := closeCode(conn); code != wsUnauthorized {
+		t.Errorf("Expected %d before connection_init, got %d", wsUnauthorized, code)
+	}
+	conn.Close()
+
+	conn = dialGraphQL(t, server)
+	conn.WriteJSON(wsMessage{Type: "connection_init", Payload: json.RawMessage(`{"Authorization":"Bearer nope"}`)})
+	if code := closeCode(conn); code != wsForbidden {
+		t.Errorf("Expected %d for an invalid token, got %d", wsForbidden, code)
+	}
+	conn.Close()
+
+	conn = dialGraphQL(t, server)
+	defer conn.Close()
+	payload, _ := json.Marshal(map[string]string{"Authorization": "Bearer " + issue("alice", "")})
+	conn.WriteJSON(wsMessage{Type: "connection_init", Payload: payload})
+	expectWS(t, conn, "connection_ack")
+
+	// Mutations run as the user of connection_init
+	conn.WriteJSON(wsMessage{ID: "m", Type: "subscribe", Payload: json.RawMessage(`{"query":"mutation { createMessage(input: {content: \"hi\"}) { username } }"}`)})
+	if msg := expectWS(t, conn, "next"); string(msg.Payload) != `{"data":{"createMessage":{"username":"alice"}}}` {
+		t.Errorf("Unexpected mutation result %s", msg.Payload)
+	}
+	expectWS(t, conn, "complete")
+
+	conn.WriteJSON(wsMessage{ID: "v", Type: "subscribe", Payload: json.RawMessage(`{"query":"subscription { nope }"}`)})
+	if msg := expectWS(t, conn, "error"); msg.ID != "v" || !strings.Contains(string(msg.Payload), "nope") {
+		t.Errorf("Unexpected validation error %s %s", msg.ID, msg.Payload)
+	}
+
+	sub := json.RawMessage(`{"query":"subscription { messageCreated { id } }"}`)
+	conn.WriteJSON(wsMessage{ID: "s", Type: "subscribe", Payload: sub})
+	conn.WriteJSON(wsMessage{ID: "s", Type: "subscribe", Payload: sub})
+	if code := closeCode(conn); code != wsSubscriberExists {
+		t.Errorf("Expected %d for a duplicate ID, got %d", wsSubscriberExists, code)
+	}
+}
@@ -2,26 +2,68 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"lab03-backend/auth"
+	"lab03-backend/httpstatus"
 	"lab03-backend/models"
 	"lab03-backend/storage"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+
+	"lab02/blob"
+	"lab02/moderation"
 )
 
 // Handler holds the storage instance
 type Handler struct {
 	// TODO: Add storage field of type *storage.MemoryStorage
-	storage *storage.MemoryStorage
+	storage storage.MessageStorage
+
+	moderator  *moderation.Pipeline // nil disables moderation
+	adminToken string
+
+	blobs      *blob.Store // nil disables attachments
+	signer     *blob.Signer
+	blobLimits blob.Limits
+
+	feed      *storage.Feed // message events for the SSE stream
+	heartbeat time.Duration
+
+	tokens     *auth.TokenService // nil disables authentication
+	editWindow time.Duration
+
+	statusImages *httpstatus.Images // nil links status images to http.cat
+
+	deprecations map[int]Deprecation // by API version
+
+	graphqlMaxDepth      int
+	graphqlMaxComplexity int
+
+	router *mux.Router // built by SetupRoutes, runs GraphQL mutations
 }
 
-// NewHandler creates a new handler instance
-func NewHandler(storage *storage.MemoryStorage) *Handler {
+// NewHandler creates a new handler instance. Changes made through the
+// handler are published to the message stream.
+func NewHandler(store storage.MessageStorage) *Handler {
 	// TODO: Return a new Handler instance with provided storage
-	return &Handler{storage: storage}
+	observed, ok := store.(*storage.ObservedStorage)
+	if !ok {
+		observed = storage.Observe(store, storage.NewFeed(storage.DefaultReplaySize))
+	}
+	return &Handler{
+		storage:              observed,
+		feed:                 observed.Feed(),
+		heartbeat:            defaultHeartbeat,
+		graphqlMaxDepth:      DefaultGraphQLMaxDepth,
+		graphqlMaxComplexity: DefaultGraphQLMaxComplexity,
+	}
 }
 
 // SetupRoutes configures all API routes
@@ -39,27 +81,118 @@ func (h *Handler) SetupRoutes() *mux.Router {
 	// TODO: Return the router
 	router := mux.NewRouter()
 	router.Use(corsMiddleware)
+	h.setupVersionedRoutes(router)
+	h.router = router
+	return router
+}
 
-	api := router.PathPrefix("/api").Subrouter()
+// setupAPIRoutes registers every endpoint of one API version
+func (h *Handler) setupAPIRoutes(api *mux.Router) {
 	api.HandleFunc("/messages", h.GetMessages).Methods("GET")
-	api.HandleFunc("/messages", h.CreateMessage).Methods("POST")
-	api.HandleFunc("/messages/{id}", h.UpdateMessage).Methods("PUT")
-	api.HandleFunc("/messages/{id}", h.DeleteMessage).Methods("DELETE")
+	api.HandleFunc("/messages", h.requireUser(h.CreateMessage)).Methods("POST")
+	api.HandleFunc("/messages/stream", h.StreamMessages).Methods("GET")
+	h.setupBatchRoutes(api)
+	h.setupArchiveRoutes(api)
+	api.HandleFunc("/messages/{id}", h.GetMessage).Methods("GET")
+	api.HandleFunc("/messages/{id}", h.requireUser(h.UpdateMessage)).Methods("PUT")
+	api.HandleFunc("/messages/{id}", h.requireUser(h.DeleteMessage)).Methods("DELETE")
+	api.HandleFunc("/messages/{id}/replies", h.GetReplies).Methods("GET")
+	api.HandleFunc("/messages/{id}/reactions", h.requireUser(h.AddReaction)).Methods("POST")
+	api.HandleFunc("/messages/{id}/reactions/{emoji}", h.requireUser(h.RemoveReaction)).Methods("DELETE")
+	api.HandleFunc("/auth/me", h.requireUser(h.GetCurrentUser)).Methods("GET")
+	api.HandleFunc("/status", h.ListHTTPStatuses).Methods("GET")
 	api.HandleFunc("/status/{code}", h.GetHTTPStatus).Methods("GET")
+	api.HandleFunc("/status/{code}/image", h.GetHTTPStatusImage).Methods("GET")
 	api.HandleFunc("/health", h.HealthCheck).Methods("GET")
-
-	return router
+	h.setupAttachmentRoutes(api)
+	h.setupAdminRoutes(api)
+	h.setupGraphQLRoutes(api)
 }
 
-// GetMessages handles GET /api/messages
+// GetMessages handles GET /api/messages, ?q= runs a full-text search.
+// Otherwise messages are paginated with ?limit= and ?cursor=, ordered with
+// ?sort=id|timestamp and ?order=asc|desc, and filtered by ?username= and
+// ?since=/?until= (RFC 3339). The next page is linked in the Link header and
+// next_cursor.
 func (h *Handler) GetMessages(w http.ResponseWriter, r *http.Request) {
 	// TODO: Implement GetMessages handler
 	// Get all messages from storage
 	// Create successful API response
 	// Write JSON response with status 200
 	// Handle any errors appropriately
-	messages := h.storage.GetAll()
-	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: messages})
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if q := r.URL.Query().Get("q"); q != "" {
+		results := h.storage.Search(q, searchLimit(opts))
+		for _, result := range results {
+			result.Message = h.present(result.Message)
+		}
+		h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: results})
+		return
+	}
+	page, err := h.storage.List(opts)
+	if err == storage.ErrInvalidCursor {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list messages")
+		return
+	}
+	if page.NextCursor != "" {
+		next := r.URL.Query()
+		next.Set("cursor", page.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: h.presentAll(page.Messages), NextCursor: page.NextCursor})
+}
+
+// parseListOptions reads the pagination, sorting and filter parameters of GET /api/messages
+func parseListOptions(query url.Values) (storage.ListOptions, error) {
+	opts := storage.ListOptions{
+		Cursor:   query.Get("cursor"),
+		Sort:     storage.SortField(query.Get("sort")),
+		Username: query.Get("username"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return opts, errors.New("limit must be a positive number")
+		}
+		opts.Limit = n
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, errors.New("order must be asc or desc")
+	}
+	if opts.Sort != "" && opts.Sort != storage.SortByID && opts.Sort != storage.SortByTimestamp {
+		return opts, errors.New("sort must be id or timestamp")
+	}
+	for name, dst := range map[string]*time.Time{"since": &opts.Since, "until": &opts.Until} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return opts, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*dst = t
+		}
+	}
+	return opts, nil
+}
+
+// searchLimit is the number of search results to return for a page size,
+// defaulted and capped like listings
+func searchLimit(opts storage.ListOptions) int {
+	if opts.Limit <= 0 {
+		return storage.DefaultListLimit
+	}
+	return min(opts.Limit, storage.MaxListLimit)
 }
 
 // CreateMessage handles POST /api/messages
@@ -76,12 +209,109 @@ func (h *Handler) CreateMessage(w http.ResponseWriter, r *http.Request) {
 		h.writeError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	username, ok := h.username(w, r, req.Username)
+	if !ok {
+		return
+	}
+	req.Username = username
 	if err := req.Validate(); err != nil {
 		h.writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	msg, _ := h.storage.Create(req.Username, req.Content)
-	h.writeJSON(w, http.StatusCreated, models.APIResponse{Success: true, Data: msg})
+	attachments, err := h.resolveAttachments(req.Attachments)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	verdict, ok := h.moderate(w, req.Username, req.Content)
+	if !ok {
+		return
+	}
+	msg, err := h.storage.CreateReply(req.Username, verdict.Content, req.ParentID, attachments...)
+	if err != nil {
+		h.writeError(w, storageErrorStatus(err), err.Error())
+		return
+	}
+	h.flag(msg, verdict)
+	h.writeJSON(w, http.StatusCreated, models.APIResponse{Success: true, Data: h.present(msg)})
+}
+
+// GetMessage handles GET /api/messages/{id}, deleted messages are returned as tombstones
+func (h *Handler) GetMessage(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.parseID(w, r)
+	if !ok {
+		return
+	}
+	msg, err := h.storage.GetByID(id)
+	if err != nil {
+		h.writeError(w, storageErrorStatus(err), err.Error())
+		return
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: h.present(msg)})
+}
+
+// GetReplies handles GET /api/messages/{id}/replies
+func (h *Handler) GetReplies(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.parseID(w, r)
+	if !ok {
+		return
+	}
+	replies, err := h.storage.Replies(id)
+	if err != nil {
+		h.writeError(w, storageErrorStatus(err), err.Error())
+		return
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: h.presentAll(replies)})
+}
+
+// AddReaction handles POST /api/messages/{id}/reactions
+func (h *Handler) AddReaction(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.parseID(w, r)
+	if !ok {
+		return
+	}
+	var req models.ReactionRequest
+	if err := h.parseJSON(r, &req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	username, ok := h.username(w, r, req.Username)
+	if !ok {
+		return
+	}
+	req.Username = username
+	if err := req.Validate(); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	msg, err := h.storage.React(id, req.Username, req.Emoji)
+	if err != nil {
+		h.writeError(w, storageErrorStatus(err), err.Error())
+		return
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: h.present(msg)})
+}
+
+// RemoveReaction handles DELETE /api/messages/{id}/reactions/{emoji}?username=
+func (h *Handler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.parseID(w, r)
+	if !ok {
+		return
+	}
+	username, ok := h.username(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+	if username == "" {
+		h.writeError(w, http.StatusBadRequest, "username is required")
+		return
+	}
+	msg, err := h.storage.Unreact(id, username, mux.Vars(r)["emoji"])
+	if err != nil {
+		h.writeError(w, storageErrorStatus(err), err.Error())
+		return
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: h.present(msg)})
 }
 
 // UpdateMessage handles PUT /api/messages/{id}
@@ -109,12 +339,25 @@ func (h *Handler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
 		h.writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	msg, err := h.storage.Update(id, req.Content)
+	current, err := h.storage.GetByID(id)
 	if err != nil {
-		h.writeError(w, http.StatusNotFound, err.Error())
+		h.writeError(w, storageErrorStatus(err), err.Error())
+		return
+	}
+	if !h.authorizeChange(w, r, current, true) {
+		return
+	}
+	verdict, ok := h.moderate(w, current.Username, req.Content)
+	if !ok {
 		return
 	}
-	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: msg})
+	msg, err := h.storage.Update(id, verdict.Content)
+	if err != nil {
+		h.writeError(w, storageErrorStatus(err), err.Error())
+		return
+	}
+	h.flag(msg, verdict)
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: h.present(msg)})
 
 }
 
@@ -131,8 +374,16 @@ func (h *Handler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 		h.writeError(w, http.StatusBadRequest, "Invalid ID")
 		return
 	}
+	current, err := h.storage.GetByID(id)
+	if err != nil {
+		h.writeError(w, storageErrorStatus(err), err.Error())
+		return
+	}
+	if !h.authorizeChange(w, r, current, false) {
+		return
+	}
 	if err := h.storage.Delete(id); err != nil {
-		h.writeError(w, http.StatusNotFound, err.Error())
+		h.writeError(w, storageErrorStatus(err), err.Error())
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -150,19 +401,27 @@ func (h *Handler) GetHTTPStatus(w http.ResponseWriter, r *http.Request) {
 	// Create successful API response
 	// Write JSON response with status 200
 	// Handle parsing and validation errors appropriately
-	codeStr := mux.Vars(r)["code"]
-	code, err := strconv.Atoi(codeStr)
-	if err != nil || code < 100 || code > 599 {
+	code, err := strconv.Atoi(mux.Vars(r)["code"])
+	if err != nil || !httpstatus.Valid(code) {
 		h.writeError(w, http.StatusBadRequest, "Invalid HTTP status code")
 		return
 	}
-	res := models.HTTPStatusResponse{
-		StatusCode:  code,
-		ImageURL:    fmt.Sprintf("https://http.cat/%d", code),
-		Description: getHTTPStatusDescription(code),
+	status, _ := httpstatus.Lookup(code)
+	w.Header().Set("Vary", "Accept")
+	image, imageType, err := h.statusImage(code)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to load status image")
+		return
+	}
+	_, format := negotiated(w)
+	switch negotiate(r.Header.Get("Accept"), format, imageType) {
+	case format:
+		h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: h.describeStatus(status)})
+	case imageType:
+		h.writeStatusImage(w, r, code, image, imageType)
+	default:
+		h.writeError(w, http.StatusNotAcceptable, "Status is available as application/json or "+imageType)
 	}
-	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: res})
-
 }
 
 // HealthCheck handles GET /api/health
@@ -183,13 +442,24 @@ func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	h.writeJSON(w, http.StatusOK, res)
 }
 
-// Helper function to write JSON responses
+// Helper function to write JSON responses, or MessagePack when the client
+// asked for it. Messages are converted to the negotiated API version.
 func (h *Handler) writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	// TODO: Implement writeJSON helper
 	// Set Content-Type header to "application/json"
 	// Set status code
 	// Encode data as JSON and write to response
 	// Log any encoding errors
+	version, format := negotiated(w)
+	data = versioned(version, data)
+	if format != "application/json" {
+		w.Header().Set("Content-Type", format)
+		w.WriteHeader(status)
+		if err := newMsgpackEncoder(w).Encode(data); err != nil {
+			fmt.Printf("Failed to write MessagePack: %v\n", err)
+		}
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(data); err != nil {
@@ -205,41 +475,41 @@ func (h *Handler) writeError(w http.ResponseWriter, status int, message string)
 	h.writeJSON(w, status, models.APIResponse{Success: false, Error: message})
 }
 
-// Helper function to parse JSON request body
+// Helper function to parse JSON request body, MessagePack bodies are
+// accepted with a MessagePack Content-Type
 func (h *Handler) parseJSON(r *http.Request, dst interface{}) error {
 	// TODO: Implement parseJSON helper
 	// Create JSON decoder from request body
 	// Decode into destination interface
 	// Return any decoding errors
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if slices.Contains(msgpackTypes, mediaType) {
+		return newMsgpackDecoder(r.Body).Decode(dst)
+	}
 	return json.NewDecoder(r.Body).Decode(dst)
 }
 
-// Helper function to get HTTP status description
-func getHTTPStatusDescription(code int) string {
-	// TODO: Implement getHTTPStatusDescription
-	// Return appropriate description for common HTTP status codes
-	// Use a switch statement or map to handle:
-	// 200: "OK", 201: "Created", 204: "No Content"
-	// 400: "Bad Request", 401: "Unauthorized", 404: "Not Found"
-	// 500: "Internal Server Error", etc.
-	// Return "Unknown Status" for unrecognized codes
-	switch code {
-	case 200:
-		return "OK"
-	case 201:
-		return "Created"
-	case 204:
-		return "No Content"
-	case 400:
-		return "Bad Request"
-	case 401:
-		return "Unauthorized"
-	case 404:
-		return "Not Found"
-	case 500:
-		return "Internal Server Error"
+// Helper function to parse the {id} path variable, writes a 400 response on failure
+func (h *Handler) parseID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// storageErrorStatus maps storage errors to HTTP status codes
+func storageErrorStatus(err error) int {
+	switch err {
+	case storage.ErrMessageDeleted:
+		return http.StatusGone
+	case storage.ErrParentNotFound:
+		return http.StatusBadRequest
+	case storage.ErrMessageNotFound:
+		return http.StatusNotFound
 	default:
-		return "Unknown Status"
+		return http.StatusInternalServerError
 	}
 }
 
@@ -256,6 +526,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Expose-Headers", "API-Version, Deprecation, Sunset, Link")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -7,205 +7,397 @@ import (
 	"lab03-backend/storage"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
-func setupTestHandler() *Handler {
-	storage := storage.NewMemoryStorage()
-	return NewHandler(storage)
+// storageBackends are the storage implementations every handler test runs against
+var storageBackends = []struct {
+	name string
+	open func(t *testing.T) storage.MessageStorage
+}{
+	{"memory", func(t *testing.T) storage.MessageStorage { return storage.NewMemoryStorage() }},
+	{"sqlite", func(t *testing.T) storage.MessageStorage {
+		s, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "messages.db"))
+		if err != nil {
+			t.Fatalf("OpenSQLite failed: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}},
 }
 
-func TestGetMessages(t *testing.T) {
-	handler := setupTestHandler()
-	router := handler.SetupRoutes()
-
-	req, err := http.NewRequest("GET", "/api/messages", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	rr := httptest.NewRecorder()
-	router.ServeHTTP(rr, req)
-
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("Expected status %v, got %v", http.StatusOK, status)
-	}
-
-	var response models.APIResponse
-	err = json.NewDecoder(rr.Body).Decode(&response)
-	if err != nil {
-		t.Fatalf("Could not decode response: %v", err)
+// forEachStorage runs test with a fresh handler on every storage backend
+func forEachStorage(t *testing.T, test func(t *testing.T, handler *Handler)) {
+	for _, backend := range storageBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			test(t, NewHandler(backend.open(t)))
+		})
 	}
+}
 
-	if !response.Success {
-		t.Error("Expected success to be true")
-	}
+func TestGetMessages(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+
+		req, err := http.NewRequest("GET", "/api/messages", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, status)
+		}
+
+		var response models.APIResponse
+		err = json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Could not decode response: %v", err)
+		}
+
+		if !response.Success {
+			t.Error("Expected success to be true")
+		}
+	})
 }
 
 func TestCreateMessage(t *testing.T) {
-	handler := setupTestHandler()
-	router := handler.SetupRoutes()
-
-	createReq := models.CreateMessageRequest{
-		Username: "testuser",
-		Content:  "test message",
-	}
-
-	jsonData, _ := json.Marshal(createReq)
-	req, err := http.NewRequest("POST", "/api/messages", bytes.NewBuffer(jsonData))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	rr := httptest.NewRecorder()
-	router.ServeHTTP(rr, req)
-
-	if status := rr.Code; status != http.StatusCreated {
-		t.Errorf("Expected status %v, got %v", http.StatusCreated, status)
-	}
-
-	var response models.APIResponse
-	err = json.NewDecoder(rr.Body).Decode(&response)
-	if err != nil {
-		t.Fatalf("Could not decode response: %v", err)
-	}
-
-	if !response.Success {
-		t.Error("Expected success to be true")
-	}
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+
+		createReq := models.CreateMessageRequest{
+			Username: "testuser",
+			Content:  "test message",
+		}
+
+		jsonData, _ := json.Marshal(createReq)
+		req, err := http.NewRequest("POST", "/api/messages", bytes.NewBuffer(jsonData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusCreated {
+			t.Errorf("Expected status %v, got %v", http.StatusCreated, status)
+		}
+
+		var response models.APIResponse
+		err = json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Could not decode response: %v", err)
+		}
+
+		if !response.Success {
+			t.Error("Expected success to be true")
+		}
+	})
 }
 
 func TestUpdateMessage(t *testing.T) {
-	handler := setupTestHandler()
-	router := handler.SetupRoutes()
-
-	// First create a message
-	createReq := models.CreateMessageRequest{
-		Username: "testuser",
-		Content:  "original message",
-	}
-
-	jsonData, _ := json.Marshal(createReq)
-	createHttpReq, _ := http.NewRequest("POST", "/api/messages", bytes.NewBuffer(jsonData))
-	createHttpReq.Header.Set("Content-Type", "application/json")
-
-	createRr := httptest.NewRecorder()
-	router.ServeHTTP(createRr, createHttpReq)
-
-	// Now update the message
-	updateReq := models.UpdateMessageRequest{
-		Content: "updated message",
-	}
-
-	jsonData, _ = json.Marshal(updateReq)
-	req, err := http.NewRequest("PUT", "/api/messages/1", bytes.NewBuffer(jsonData))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	rr := httptest.NewRecorder()
-	router.ServeHTTP(rr, req)
-
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("Expected status %v, got %v", http.StatusOK, status)
-	}
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+
+		// First create a message
+		createReq := models.CreateMessageRequest{
+			Username: "testuser",
+			Content:  "original message",
+		}
+
+		jsonData, _ := json.Marshal(createReq)
+		createHttpReq, _ := http.NewRequest("POST", "/api/messages", bytes.NewBuffer(jsonData))
+		createHttpReq.Header.Set("Content-Type", "application/json")
+
+		createRr := httptest.NewRecorder()
+		router.ServeHTTP(createRr, createHttpReq)
+
+		// Now update the message
+		updateReq := models.UpdateMessageRequest{
+			Content: "updated message",
+		}
+
+		jsonData, _ = json.Marshal(updateReq)
+		req, err := http.NewRequest("PUT", "/api/messages/1", bytes.NewBuffer(jsonData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, status)
+		}
+	})
 }
 
 func TestDeleteMessage(t *testing.T) {
-	handler := setupTestHandler()
-	router := handler.SetupRoutes()
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+
+		// First create a message
+		createReq := models.CreateMessageRequest{
+			Username: "testuser",
+			Content:  "message to delete",
+		}
+
+		jsonData, _ := json.Marshal(createReq)
+		createHttpReq, _ := http.NewRequest("POST", "/api/messages", bytes.NewBuffer(jsonData))
+		createHttpReq.Header.Set("Content-Type", "application/json")
+
+		createRr := httptest.NewRecorder()
+		router.ServeHTTP(createRr, createHttpReq)
+
+		// Now delete the message
+		req, err := http.NewRequest("DELETE", "/api/messages/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNoContent {
+			t.Errorf("Expected status %v, got %v", http.StatusNoContent, status)
+		}
+	})
+}
 
-	// First create a message
-	createReq := models.CreateMessageRequest{
-		Username: "testuser",
-		Content:  "message to delete",
-	}
+func TestGetHTTPStatus(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+
+		tests := []struct {
+			code           string
+			expectedStatus int
+		}{
+			{"200", http.StatusOK},
+			{"404", http.StatusOK},
+			{"500", http.StatusOK},
+			{"999", http.StatusBadRequest}, // Invalid status code
+		}
+
+		for _, tt := range tests {
+			t.Run("status_"+tt.code, func(t *testing.T) {
+				req, err := http.NewRequest("GET", "/api/status/"+tt.code, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
 
-	jsonData, _ := json.Marshal(createReq)
-	createHttpReq, _ := http.NewRequest("POST", "/api/messages", bytes.NewBuffer(jsonData))
-	createHttpReq.Header.Set("Content-Type", "application/json")
+				rr := httptest.NewRecorder()
+				router.ServeHTTP(rr, req)
 
-	createRr := httptest.NewRecorder()
-	router.ServeHTTP(createRr, createHttpReq)
+				if status := rr.Code; status != tt.expectedStatus {
+					t.Errorf("Expected status %v, got %v", tt.expectedStatus, status)
+				}
 
-	// Now delete the message
-	req, err := http.NewRequest("DELETE", "/api/messages/1", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+				if tt.expectedStatus == http.StatusOK {
+					var response models.APIResponse
+					err = json.NewDecoder(rr.Body).Decode(&response)
+					if err != nil {
+						t.Fatalf("Could not decode response: %v", err)
+					}
 
-	rr := httptest.NewRecorder()
-	router.ServeHTTP(rr, req)
+					if !response.Success {
+						t.Error("Expected success to be true")
+					}
+				}
+			})
+		}
+	})
+}
 
-	if status := rr.Code; status != http.StatusNoContent {
-		t.Errorf("Expected status %v, got %v", http.StatusNoContent, status)
-	}
+func TestHealthCheck(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+
+		req, err := http.NewRequest("GET", "/api/health", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, status)
+		}
+
+		// Should return JSON with health status
+		contentType := rr.Header().Get("Content-Type")
+		if contentType != "application/json" {
+			t.Errorf("Expected Content-Type application/json, got %s", contentType)
+		}
+	})
 }
 
-func TestGetHTTPStatus(t *testing.T) {
-	handler := setupTestHandler()
-	router := handler.SetupRoutes()
-
-	tests := []struct {
-		code           string
-		expectedStatus int
-	}{
-		{"200", http.StatusOK},
-		{"404", http.StatusOK},
-		{"500", http.StatusOK},
-		{"999", http.StatusBadRequest}, // Invalid status code
-	}
+func TestGetMessagesSearch(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+		handler.storage.Create("alice", "release notes are ready")
+		handler.storage.Create("bob", "lunch at noon")
+
+		req, err := http.NewRequest("GET", "/api/messages?q=release", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, status)
+		}
+
+		var response struct {
+			Success bool                         `json:"success"`
+			Data    []models.MessageSearchResult `json:"data"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Could not decode response: %v", err)
+		}
+		if len(response.Data) != 1 || response.Data[0].Username != "alice" {
+			t.Fatalf("Expected alice's message, got %+v", response.Data)
+		}
+		if len(response.Data[0].Highlights) != 1 {
+			t.Errorf("Expected 1 highlight, got %d", len(response.Data[0].Highlights))
+		}
+
+		for i := 0; i < 3; i++ {
+			handler.storage.Create("carol", "release candidate")
+		}
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/messages?q=release&limit=2", nil))
+		json.NewDecoder(rr.Body).Decode(&response)
+		if len(response.Data) != 2 {
+			t.Errorf("Expected 2 results with limit=2, got %d", len(response.Data))
+		}
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/messages?q=release&limit=0", nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an invalid limit, got %v", rr.Code)
+		}
+	})
+}
+
+func TestMessageReactionsRepliesAndTombstones(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+		root, _ := handler.storage.Create("alice", "ship it?")
 
-	for _, tt := range tests {
-		t.Run("status_"+tt.code, func(t *testing.T) {
-			req, err := http.NewRequest("GET", "/api/status/"+tt.code, nil)
+		do := func(method, url string, body interface{}) *httptest.ResponseRecorder {
+			var buf bytes.Buffer
+			if body != nil {
+				json.NewEncoder(&buf).Encode(body)
+			}
+			req, err := http.NewRequest(method, url, &buf)
 			if err != nil {
 				t.Fatal(err)
 			}
-
 			rr := httptest.NewRecorder()
 			router.ServeHTTP(rr, req)
-
-			if status := rr.Code; status != tt.expectedStatus {
-				t.Errorf("Expected status %v, got %v", tt.expectedStatus, status)
-			}
-
-			if tt.expectedStatus == http.StatusOK {
-				var response models.APIResponse
-				err = json.NewDecoder(rr.Body).Decode(&response)
-				if err != nil {
-					t.Fatalf("Could not decode response: %v", err)
-				}
-
-				if !response.Success {
-					t.Error("Expected success to be true")
-				}
-			}
-		})
-	}
+			return rr
+		}
+
+		rr := do("POST", "/api/messages", models.CreateMessageRequest{Username: "bob", Content: "yes", ParentID: root.ID})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status %v for reply, got %v", http.StatusCreated, rr.Code)
+		}
+		if rr := do("POST", "/api/messages", models.CreateMessageRequest{Username: "bob", Content: "?", ParentID: 99}); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v for missing parent, got %v", http.StatusBadRequest, rr.Code)
+		}
+
+		rr = do("GET", "/api/messages/1/replies", nil)
+		var replies struct {
+			Data []models.Message `json:"data"`
+		}
+		json.NewDecoder(rr.Body).Decode(&replies)
+		if rr.Code != http.StatusOK || len(replies.Data) != 1 || replies.Data[0].ParentID != root.ID {
+			t.Errorf("Unexpected replies response %v %+v", rr.Code, replies.Data)
+		}
+
+		rr = do("POST", "/api/messages/1/reactions", models.ReactionRequest{Username: "bob", Emoji: "👍"})
+		var reacted struct {
+			Data models.Message `json:"data"`
+		}
+		json.NewDecoder(rr.Body).Decode(&reacted)
+		if rr.Code != http.StatusOK || len(reacted.Data.Reactions) != 1 || reacted.Data.Reactions[0].Count != 1 {
+			t.Errorf("Unexpected reaction response %v %+v", rr.Code, reacted.Data)
+		}
+		if rr := do("POST", "/api/messages/1/reactions", models.ReactionRequest{Username: "bob"}); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v for missing emoji, got %v", http.StatusBadRequest, rr.Code)
+		}
+		rr = do("DELETE", "/api/messages/1/reactions/%F0%9F%91%8D?username=bob", nil)
+		var unreacted struct {
+			Data models.Message `json:"data"`
+		}
+		json.NewDecoder(rr.Body).Decode(&unreacted)
+		if rr.Code != http.StatusOK || len(unreacted.Data.Reactions) != 0 {
+			t.Errorf("Unexpected unreact response %v %+v", rr.Code, unreacted.Data)
+		}
+
+		if rr := do("DELETE", "/api/messages/1", nil); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status %v, got %v", http.StatusNoContent, rr.Code)
+		}
+		rr = do("GET", "/api/messages/1", nil)
+		var tombstone struct {
+			Data models.Message `json:"data"`
+		}
+		json.NewDecoder(rr.Body).Decode(&tombstone)
+		if rr.Code != http.StatusOK || !tombstone.Data.Deleted {
+			t.Errorf("Expected tombstone, got %v %+v", rr.Code, tombstone.Data)
+		}
+		if rr := do("PUT", "/api/messages/1", models.UpdateMessageRequest{Content: "edit"}); rr.Code != http.StatusGone {
+			t.Errorf("Expected status %v when editing a deleted message, got %v", http.StatusGone, rr.Code)
+		}
+	})
 }
 
-func TestHealthCheck(t *testing.T) {
-	handler := setupTestHandler()
-	router := handler.SetupRoutes()
-
-	req, err := http.NewRequest("GET", "/api/health", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+func TestGetMessagesPagination(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		router := handler.SetupRoutes()
+		for _, user := range []string{"alice", "bob", "alice", "bob", "alice"} {
+			handler.storage.Create(user, "hello")
+		}
 
-	rr := httptest.NewRecorder()
-	router.ServeHTTP(rr, req)
-
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("Expected status %v, got %v", http.StatusOK, status)
-	}
-
-	// Should return JSON with health status
-	contentType := rr.Header().Get("Content-Type")
-	if contentType != "application/json" {
-		t.Errorf("Expected Content-Type application/json, got %s", contentType)
-	}
+		get := func(url string) (*httptest.ResponseRecorder, []models.Message, string) {
+			req, _ := http.NewRequest("GET", url, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			var response struct {
+				Data       []models.Message `json:"data"`
+				NextCursor string           `json:"next_cursor"`
+			}
+			json.NewDecoder(rr.Body).Decode(&response)
+			return rr, response.Data, response.NextCursor
+		}
+
+		rr, msgs, cursor := get("/api/messages?limit=2&order=desc&username=alice")
+		if rr.Code != http.StatusOK || len(msgs) != 2 || msgs[0].ID != 5 || msgs[1].ID != 3 || cursor == "" {
+			t.Fatalf("Unexpected first page %v %+v %q", rr.Code, msgs, cursor)
+		}
+		link := rr.Header().Get("Link")
+		if !strings.HasPrefix(link, "</api/messages?") || !strings.HasSuffix(link, `>; rel="next"`) || !strings.Contains(link, "cursor="+cursor) {
+			t.Fatalf("Unexpected Link header %q", link)
+		}
+
+		next := strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+		rr, msgs, cursor = get(next)
+		if rr.Code != http.StatusOK || len(msgs) != 1 || msgs[0].ID != 1 || cursor != "" || rr.Header().Get("Link") != "" {
+			t.Errorf("Unexpected last page %v %+v %q", rr.Code, msgs, cursor)
+		}
+
+		for _, query := range []string{"limit=0", "order=up", "sort=content", "since=yesterday", "cursor=bogus"} {
+			if rr, _, _ := get("/api/messages?" + query); rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %v for %s, got %v", http.StatusBadRequest, query, rr.Code)
+			}
+		}
+	})
 }
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"crypto/subtle"
+	"lab03-backend/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"lab02/moderation"
+)
+
+// SetModerator runs created and updated messages through the pipeline and
+// enables the admin API, which requires "Authorization: Bearer <adminToken>".
+// A nil pipeline enables only the admin routes that do not moderate.
+func (h *Handler) SetModerator(p *moderation.Pipeline, adminToken string) {
+	h.moderator = p
+	h.adminToken = adminToken
+}
+
+// setupAdminRoutes registers the moderation admin API under /api/admin
+func (h *Handler) setupAdminRoutes(api *mux.Router) {
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(h.requireAdmin)
+	admin.HandleFunc("/tokens", h.IssueToken).Methods("POST")
+
+	moderation := admin.NewRoute().Subrouter()
+	moderation.Use(h.requireModeration)
+	moderation.HandleFunc("/reviews", h.GetReviews).Methods("GET")
+	moderation.HandleFunc("/reviews/{id}", h.ResolveReview).Methods("POST")
+	moderation.HandleFunc("/users/{username}/mute", h.MuteUser).Methods("POST")
+	moderation.HandleFunc("/users/{username}/ban", h.BanUser).Methods("POST")
+	moderation.HandleFunc("/users/{username}/restrictions", h.LiftRestrictions).Methods("DELETE")
+}
+
+// requireModeration answers moderation requests with 404 when it is disabled
+func (h *Handler) requireModeration(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.moderator == nil {
+			h.writeError(w, http.StatusNotFound, "Moderation is not enabled")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdmin rejects admin requests without the admin token
+func (h *Handler) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.adminToken == "" {
+			h.writeError(w, http.StatusNotFound, "Admin API is not enabled")
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+			h.writeError(w, http.StatusUnauthorized, "Invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// moderate checks content before it is stored, writing a 422 response and
+// returning false if it is rejected
+func (h *Handler) moderate(w http.ResponseWriter, username, content string) (moderation.Verdict, bool) {
+	if h.moderator == nil {
+		return moderation.Verdict{Action: moderation.Allow, Content: content}, true
+	}
+	verdict := h.moderator.Check(moderation.Message{UserID: username, Content: content})
+	if verdict.Action == moderation.Reject {
+		h.writeError(w, http.StatusUnprocessableEntity, (&moderation.RejectedError{Reasons: verdict.Reasons}).Error())
+		return verdict, false
+	}
+	return verdict, true
+}
+
+// flag queues a stored message for review if the verdict flagged it
+func (h *Handler) flag(msg *models.Message, verdict moderation.Verdict) {
+	if verdict.Action == moderation.Flag {
+		h.moderator.Queue().Add(strconv.Itoa(msg.ID), msg.Username, msg.Content, verdict.Reasons)
+	}
+}
+
+// GetReviews handles GET /api/admin/reviews, ?status= filters by review status
+func (h *Handler) GetReviews(w http.ResponseWriter, r *http.Request) {
+	status := moderation.ReviewStatus(r.URL.Query().Get("status"))
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: h.moderator.Queue().List(status)})
+}
+
+// ResolveReview handles POST /api/admin/reviews/{id}, removing a message
+// replaces it with a tombstone
+func (h *Handler) ResolveReview(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	var req models.ReviewDecisionRequest
+	if err := h.parseJSON(r, &req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	item, err := h.moderator.Queue().Resolve(id, moderation.ReviewStatus(req.Status), req.Reviewer)
+	switch err {
+	case nil:
+	case moderation.ErrReviewNotFound:
+		h.writeError(w, http.StatusNotFound, err.Error())
+		return
+	default:
+		h.writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if item.Status == moderation.StatusRemoved {
+		if messageID, err := strconv.Atoi(item.Ref); err == nil {
+			h.storage.Delete(messageID)
+		}
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: item})
+}
+
+// MuteUser handles POST /api/admin/users/{username}/mute
+func (h *Handler) MuteUser(w http.ResponseWriter, r *http.Request) {
+	var req models.MuteRequest
+	if err := h.parseJSON(r, &req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	username := mux.Vars(r)["username"]
+	h.moderator.Restrictions().Mute(username, time.Duration(req.Seconds)*time.Second)
+	h.writeRestrictions(w, username)
+}
+
+// BanUser handles POST /api/admin/users/{username}/ban
+func (h *Handler) BanUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	h.moderator.Restrictions().Ban(username)
+	h.writeRestrictions(w, username)
+}
+
+// LiftRestrictions handles DELETE /api/admin/users/{username}/restrictions
+func (h *Handler) LiftRestrictions(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	h.moderator.Restrictions().Lift(username)
+	h.writeRestrictions(w, username)
+}
+
+// writeRestrictions responds with the current mute and ban state of a user
+func (h *Handler) writeRestrictions(w http.ResponseWriter, username string) {
+	res := map[string]interface{}{
+		"username": username,
+		"banned":   h.moderator.Restrictions().IsBanned(username),
+	}
+	if until := h.moderator.Restrictions().MutedUntil(username); !until.IsZero() {
+		res["muted_until"] = until.Format(time.RFC3339)
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: res})
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lab02/moderation"
+)
+
+func TestModeratedMessagesAndAdminAPI(t *testing.T) {
+	handler := NewHandler(storage.NewMemoryStorage())
+	handler.SetModerator(moderation.NewPipeline(
+		moderation.MaxLength(20),
+		moderation.WordList(moderation.Mask, "darn"),
+		moderation.WordList(moderation.Flag, "refund"),
+	), "secret")
+	router := handler.SetupRoutes()
+
+	do := func(method, url, token string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req, err := http.NewRequest(method, url, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do("POST", "/api/messages", "", models.CreateMessageRequest{Username: "alice", Content: "way too long for the limit"}); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %v for long message, got %v", http.StatusUnprocessableEntity, rr.Code)
+	}
+	do("POST", "/api/messages", "", models.CreateMessageRequest{Username: "alice", Content: "darn it"})
+	if msg, _ := handler.storage.GetByID(1); msg.Content != "**** it" {
+		t.Errorf("Expected masked content, got %q", msg.Content)
+	}
+	do("POST", "/api/messages", "", models.CreateMessageRequest{Username: "bob", Content: "refund please"})
+
+	if rr := do("GET", "/api/admin/reviews", "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %v without token, got %v", http.StatusUnauthorized, rr.Code)
+	}
+	rr := do("GET", "/api/admin/reviews?status=pending", "secret", nil)
+	var reviews struct {
+		Data []moderation.ReviewItem `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&reviews)
+	if rr.Code != http.StatusOK || len(reviews.Data) != 1 || reviews.Data[0].Ref != "2" {
+		t.Fatalf("Unexpected reviews %v %+v", rr.Code, reviews.Data)
+	}
+
+	url := "/api/admin/reviews/1"
+	if rr := do("POST", url, "secret", models.ReviewDecisionRequest{Status: "removed", Reviewer: "mod"}); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %v, got %v", http.StatusOK, rr.Code)
+	}
+	if msg, _ := handler.storage.GetByID(2); !msg.Deleted {
+		t.Errorf("Expected removed message to be deleted, got %+v", msg)
+	}
+	if rr := do("POST", url, "secret", models.ReviewDecisionRequest{Status: "approved", Reviewer: "mod"}); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status %v for resolved review, got %v", http.StatusConflict, rr.Code)
+	}
+
+	do("POST", "/api/admin/users/bob/mute", "secret", models.MuteRequest{Seconds: 60})
+	if rr := do("POST", "/api/messages", "", models.CreateMessageRequest{Username: "bob", Content: "hello"}); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected muted user to be rejected, got %v", rr.Code)
+	}
+	do("DELETE", "/api/admin/users/bob/restrictions", "secret", nil)
+	if rr := do("POST", "/api/messages", "", models.CreateMessageRequest{Username: "bob", Content: "hello"}); rr.Code != http.StatusCreated {
+		t.Errorf("Expected lifted mute to allow messages, got %v", rr.Code)
+	}
+}
+
+func TestAdminAPIDisabledWithoutModerator(t *testing.T) {
+	router := NewHandler(storage.NewMemoryStorage()).SetupRoutes()
+	req, _ := http.NewRequest("GET", "/api/admin/reviews", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %v, got %v", http.StatusNotFound, rr.Code)
+	}
+}
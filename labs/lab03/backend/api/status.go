@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"lab03-backend/httpstatus"
+	"lab03-backend/models"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// statusImageType is the content type of the http.cat images
+const statusImageType = "image/jpeg"
+
+// SetStatusImages serves status images from images instead of linking to
+// http.cat, so the status endpoints work offline
+func (h *Handler) SetStatusImages(images *httpstatus.Images) {
+	h.statusImages = images
+}
+
+// ListHTTPStatuses handles GET /api/status, listing every registered code
+func (h *Handler) ListHTTPStatuses(w http.ResponseWriter, r *http.Request) {
+	all := httpstatus.All()
+	res := make([]models.HTTPStatusResponse, len(all))
+	for i, status := range all {
+		res[i] = h.describeStatus(status)
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{Success: true, Data: res})
+}
+
+// GetHTTPStatusImage handles GET /api/status/{code}/image
+func (h *Handler) GetHTTPStatusImage(w http.ResponseWriter, r *http.Request) {
+	code, err := strconv.Atoi(mux.Vars(r)["code"])
+	if err != nil || !httpstatus.Valid(code) {
+		h.writeError(w, http.StatusBadRequest, "Invalid HTTP status code")
+		return
+	}
+	image, imageType, err := h.statusImage(code)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to load status image")
+		return
+	}
+	h.writeStatusImage(w, r, code, image, imageType)
+}
+
+// describeStatus converts a catalog entry to its API representation
+func (h *Handler) describeStatus(status httpstatus.Status) models.HTTPStatusResponse {
+	return models.HTTPStatusResponse{
+		StatusCode:  status.Code,
+		ImageURL:    h.statusImageURL(status.Code),
+		Description: status.Reason,
+		Category:    status.Category,
+		Reference:   status.Reference,
+		Retryable:   status.Retryable,
+		Registered:  status.Registered,
+	}
+}
+
+// statusImageURL links the image of a status code
+func (h *Handler) statusImageURL(code int) string {
+	if h.statusImages == nil {
+		return fmt.Sprintf("https://http.cat/%d", code)
+	}
+	return fmt.Sprintf("/api/status/%d/image", code)
+}
+
+// statusImage loads the image of a status code, without images it returns
+// only the content type of the http.cat image
+func (h *Handler) statusImage(code int) ([]byte, string, error) {
+	if h.statusImages == nil {
+		return nil, statusImageType, nil
+	}
+	return h.statusImages.Image(code)
+}
+
+// writeStatusImage sends a status image, redirecting to http.cat when no
+// images are configured
+func (h *Handler) writeStatusImage(w http.ResponseWriter, r *http.Request, code int, image []byte, imageType string) {
+	if image == nil {
+		http.Redirect(w, r, h.statusImageURL(code), http.StatusFound)
+		return
+	}
+	w.Header().Set("Content-Type", imageType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(image))
+}
+
+// negotiate picks the offered media type the Accept header prefers, the
+// first offer without a header and "" if none is acceptable
+func negotiate(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		// The most specific matching range sets the quality of an offer
+		q, specificity := 0.0, -1
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(part)
+			if err != nil {
+				continue
+			}
+			s := mediaRangeSpecificity(mediaType, offer)
+			if s <= specificity {
+				continue
+			}
+			specificity, q = s, 1
+			if value, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(value, 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// mediaRangeSpecificity reports how exactly a media range of an Accept
+// header matches a media type, -1 when it does not match
+func mediaRangeSpecificity(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"lab03-backend/httpstatus"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func getStatus(router http.Handler, url, accept string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", url, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestStatusCatalog(t *testing.T) {
+	router := NewHandler(storage.NewMemoryStorage()).SetupRoutes()
+
+	rr := getStatus(router, "/api/status/429", "")
+	var res struct {
+		Data models.HTTPStatusResponse `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&res)
+	if rr.Code != http.StatusOK || res.Data.Description != "Too Many Requests" || res.Data.Category != httpstatus.ClientError ||
+		!res.Data.Retryable || res.Data.Reference != "RFC 6585" || res.Data.ImageURL != "https://http.cat/429" {
+		t.Errorf("Unexpected status %v %+v", rr.Code, res.Data)
+	}
+
+	rr = getStatus(router, "/api/status", "")
+	var list struct {
+		Data []models.HTTPStatusResponse `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&list)
+	if rr.Code != http.StatusOK || len(list.Data) != len(httpstatus.All()) {
+		t.Errorf("Expected the whole catalog, got %v with %d entries", rr.Code, len(list.Data))
+	}
+
+	if rr := getStatus(router, "/api/status/404", "image/*"); rr.Code != http.StatusFound || rr.Header().Get("Location") != "https://http.cat/404" {
+		t.Errorf("Expected redirect to http.cat, got %v %q", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestStatusImagesAndNegotiation(t *testing.T) {
+	handler := NewHandler(storage.NewMemoryStorage())
+	handler.SetStatusImages(httpstatus.NewImages(fstest.MapFS{"418.jpg": {Data: []byte("\xff\xd8\xff\xe0teapot")}}))
+	router := handler.SetupRoutes()
+
+	rr := getStatus(router, "/api/status/418", "application/json")
+	var res struct {
+		Data models.HTTPStatusResponse `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&res)
+	if rr.Code != http.StatusOK || res.Data.ImageURL != "/api/status/418/image" {
+		t.Errorf("Expected local image URL, got %v %+v", rr.Code, res.Data)
+	}
+
+	tests := []struct {
+		url, accept, contentType string
+		status                   int
+	}{
+		{"/api/status/418", "image/*", "image/jpeg", http.StatusOK},
+		{"/api/status/418", "application/json;q=0.5, image/jpeg", "image/jpeg", http.StatusOK},
+		{"/api/status/418", "image/*;q=0.5, application/json", "application/json", http.StatusOK},
+		{"/api/status/500", "image/png", "image/png", http.StatusOK},
+		{"/api/status/500", "*/*", "application/json", http.StatusOK},
+		{"/api/status/500", "text/html", "application/json", http.StatusNotAcceptable},
+		{"/api/status/500", "image/*, image/png;q=0", "application/json", http.StatusNotAcceptable},
+		{"/api/status/500/image", "", "image/png", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rr := getStatus(router, tt.url, tt.accept)
+		if rr.Code != tt.status || rr.Header().Get("Content-Type") != tt.contentType {
+			t.Errorf("GET %s with Accept %q: got %v %q, expected %v %q", tt.url, tt.accept, rr.Code, rr.Header().Get("Content-Type"), tt.status, tt.contentType)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"lab03-backend/storage"
+	"net/http"
+	"time"
+)
+
+// defaultHeartbeat is how often an idle stream sends a comment so proxies keep it open
+const defaultHeartbeat = 15 * time.Second
+
+// retryMillis is the reconnection delay suggested to EventSource clients
+const retryMillis = 3000
+
+// StreamMessages handles GET /api/messages/stream, a Server-Sent Events feed
+// of "created", "updated" and "deleted" events with the message as data.
+// Event IDs are "<epoch>-<seq>" and clients resume with the Last-Event-ID
+// header (or ?last_event_id=). If the missed events are no longer buffered,
+// or the ID is from before the server restarted, a "reset" event tells them
+// to reload GET /api/messages before applying further events.
+func (h *Handler) StreamMessages(w http.ResponseWriter, r *http.Request) {
+	var lastID storage.EventID
+	resume := false
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value != "" {
+		id, err := storage.ParseEventID(value)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid Last-Event-ID")
+			return
+		}
+		lastID, resume = id, true
+	}
+
+	missed, events, complete, cancel := h.feed.Subscribe(lastID, resume)
+	defer cancel()
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering in nginx
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", retryMillis)
+	if !complete {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, ev := range missed {
+		h.writeEvent(w, ev)
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				// Dropped for falling behind, the client resumes from its last event
+				return
+			}
+			h.writeEvent(w, ev)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes one message event in SSE format
+func (h *Handler) writeEvent(w http.ResponseWriter, ev storage.Event) {
+	version, _ := negotiated(w)
+	data, err := json.Marshal(versioned(version, h.present(ev.Message)))
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+}
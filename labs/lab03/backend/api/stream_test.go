@@ -0,0 +1,96 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvents reads SSE frames until n frames with the given prefix were seen
+func readEvents(t *testing.T, scanner *bufio.Scanner, prefix string, n int) []string {
+	t.Helper()
+	var frames []string
+	var frame strings.Builder
+	for len(frames) < n && scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			frame.WriteString(line + "\n")
+			continue
+		}
+		if strings.HasPrefix(frame.String(), prefix) {
+			frames = append(frames, frame.String())
+		}
+		frame.Reset()
+	}
+	if len(frames) < n {
+		t.Fatalf("Expected %d %q frames, got %v (%v)", n, prefix, frames, scanner.Err())
+	}
+	return frames
+}
+
+func TestStreamMessages(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, handler *Handler) {
+		handler.heartbeat = 20 * time.Millisecond
+		server := httptest.NewServer(handler.SetupRoutes())
+		defer server.Close()
+
+		first, _ := handler.storage.Create("alice", "before")
+
+		resp, err := http.Get(server.URL + "/api/messages/stream")
+		if err != nil {
+			t.Fatalf("GET stream failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Unexpected Content-Type %q", ct)
+		}
+		scanner := bufio.NewScanner(resp.Body)
+		readEvents(t, scanner, "retry:", 1)
+
+		handler.storage.Update(first.ID, "after")
+		handler.storage.Delete(first.ID)
+		frames := readEvents(t, scanner, "id:", 2)
+		// IDs are "<epoch>-<seq>", the epoch is new every time the server starts
+		epoch, _, _ := strings.Cut(strings.TrimPrefix(frames[0], "id: "), "-")
+		if !strings.HasPrefix(frames[0], "id: "+epoch+"-2\nevent: updated\ndata: {") || !strings.Contains(frames[0], `"content":"after"`) {
+			t.Errorf("Unexpected update frame %q", frames[0])
+		}
+		if !strings.HasPrefix(frames[1], "id: "+epoch+"-3\nevent: deleted\n") || !strings.Contains(frames[1], `"deleted":true`) {
+			t.Errorf("Unexpected delete frame %q", frames[1])
+		}
+		readEvents(t, scanner, ": heartbeat", 1)
+
+		// Resume after event 1, which replays the update and the delete
+		req, _ := http.NewRequest("GET", server.URL+"/api/messages/stream", nil)
+		req.Header.Set("Last-Event-ID", epoch+"-1")
+		resumed, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET stream failed: %v", err)
+		}
+		defer resumed.Body.Close()
+		frames = readEvents(t, bufio.NewScanner(resumed.Body), "id:", 2)
+		if !strings.HasPrefix(frames[0], "id: "+epoch+"-2\n") || !strings.HasPrefix(frames[1], "id: "+epoch+"-3\n") {
+			t.Errorf("Unexpected replay %q", frames)
+		}
+
+		// An ID the server does not know, or one from before a restart even if
+		// its sequence number exists, asks the client to reload
+		for _, id := range []string{epoch + "-42", "0123abcd-1", "1"} {
+			stale, err := http.Get(server.URL + "/api/messages/stream?last_event_id=" + id)
+			if err != nil {
+				t.Fatalf("GET stream failed: %v", err)
+			}
+			readEvents(t, bufio.NewScanner(stale.Body), "event: reset", 1)
+			stale.Body.Close()
+		}
+
+		bad, _ := http.Get(server.URL + "/api/messages/stream?last_event_id=abc")
+		bad.Body.Close()
+		if bad.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, bad.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lab03-backend/models"
+	"mime"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// API versions. Requests to /api without a version in the Accept header
+// get DefaultVersion, so older app builds keep working.
+const (
+	DefaultVersion = 1
+	LatestVersion  = 2
+)
+
+// Response media types besides application/json
+const (
+	msgpackType = "application/msgpack"
+)
+
+// msgpackTypes are the media types clients use for MessagePack
+var msgpackTypes = []string{msgpackType, "application/x-msgpack", "application/vnd.msgpack"}
+
+// vendorType matches versioned media types like application/vnd.lab03.v2+json
+var vendorType = regexp.MustCompile(`^application/vnd\.lab03\.v(\d+)\+(json|msgpack)$`)
+
+// versionPrefix matches the version in versioned API paths like /api/v2
+var versionPrefix = regexp.MustCompile(`^/api/v\d+\b`)
+
+// Deprecation announces that an API version will be removed
+type Deprecation struct {
+	Since  time.Time // when the version was deprecated
+	Sunset time.Time // when it stops working, zero if not planned
+	Link   string    // migration guide, optional
+}
+
+// SetDeprecation adds Deprecation and Sunset headers to the responses of an
+// API version, after the sunset its requests fail with 410 Gone
+func (h *Handler) SetDeprecation(version int, d Deprecation) {
+	if h.deprecations == nil {
+		h.deprecations = make(map[int]Deprecation)
+	}
+	h.deprecations[version] = d
+}
+
+// setupVersionedRoutes serves the API under /api/v1 and /api/v2, and under
+// /api with the version chosen by the Accept header
+func (h *Handler) setupVersionedRoutes(router *mux.Router) {
+	for version := 1; version <= LatestVersion; version++ {
+		api := router.PathPrefix(fmt.Sprintf("/api/v%d", version)).Subrouter()
+		api.Use(h.versionMiddleware(version))
+		h.setupAPIRoutes(api)
+	}
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(h.versionMiddleware(0))
+	h.setupAPIRoutes(api)
+}
+
+// versionMiddleware negotiates the API version, zero takes it from the
+// Accept header, and the response format, and applies deprecations
+func (h *Handler) versionMiddleware(pathVersion int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headerVersion, format, accept := negotiateAPI(r.Header.Get("Accept"))
+			version := pathVersion
+			if version == 0 {
+				version = headerVersion
+			}
+			w.Header().Add("Vary", "Accept")
+			if version < 1 || version > LatestVersion {
+				h.writeError(w, http.StatusNotAcceptable, fmt.Sprintf("API version %d is not supported, use 1 to %d", version, LatestVersion))
+				return
+			}
+			w.Header().Set("API-Version", strconv.Itoa(version))
+
+			if d, ok := h.deprecations[version]; ok {
+				w.Header().Set("Deprecation", fmt.Sprintf("@%d", d.Since.Unix()))
+				if d.Link != "" {
+					w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"`, d.Link))
+				}
+				if !d.Sunset.IsZero() {
+					w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+					if time.Now().After(d.Sunset) {
+						h.writeError(w, http.StatusGone, fmt.Sprintf("API version %d was retired on %s", version, d.Sunset.UTC().Format(time.RFC3339)))
+						return
+					}
+				}
+			}
+			// Handlers negotiating other types see the base media types
+			if accept != "" {
+				r.Header.Set("Accept", accept)
+			}
+			next.ServeHTTP(&versionedWriter{ResponseWriter: w, version: version, format: format}, r)
+		})
+	}
+}
+
+// negotiateAPI reads the API version of versioned media types and the
+// preferred response format from an Accept header, and returns the header
+// with versioned media types replaced by their base types
+func negotiateAPI(accept string) (version int, format, base string) {
+	version = DefaultVersion
+	ranges := strings.Split(accept, ",")
+	for i, part := range ranges {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		if match := vendorType.FindStringSubmatch(mediaType); match != nil {
+			version, _ = strconv.Atoi(match[1])
+			// Negotiate the format as if the base type had been sent
+			base := "application/json"
+			if match[2] == "msgpack" {
+				base = msgpackType
+			}
+			ranges[i] = mime.FormatMediaType(base, params)
+		}
+	}
+	base = strings.Join(ranges, ",")
+	format = negotiate(base, append([]string{"application/json"}, msgpackTypes...)...)
+	if format == "" {
+		format = "application/json"
+	}
+	return version, format, base
+}
+
+// versionedWriter carries the negotiated API version and response format
+// to writeJSON
+type versionedWriter struct {
+	http.ResponseWriter
+	version int
+	format  string
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (vw *versionedWriter) Unwrap() http.ResponseWriter {
+	return vw.ResponseWriter
+}
+
+// Hijack lets WebSocket upgrades take over the connection
+func (vw *versionedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(vw.ResponseWriter).Hijack()
+}
+
+// negotiated returns the API version and response format of a response
+func negotiated(w http.ResponseWriter) (int, string) {
+	if vw, ok := w.(*versionedWriter); ok {
+		return vw.version, vw.format
+	}
+	return DefaultVersion, "application/json"
+}
+
+// newMsgpackEncoder encodes with the field names of the JSON API
+func newMsgpackEncoder(w io.Writer) *msgpack.Encoder {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	return enc
+}
+
+// newMsgpackDecoder decodes with the field names of the JSON API
+func newMsgpackDecoder(r io.Reader) *msgpack.Decoder {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	return dec
+}
+
+// versioned converts the messages in a response body to the representation
+// of an API version
+func versioned(version int, data interface{}) interface{} {
+	if version < 2 {
+		return data
+	}
+	switch v := data.(type) {
+	case models.APIResponse:
+		v.Data = versioned(version, v.Data)
+		return v
+	case *models.Message:
+		if v == nil {
+			return v
+		}
+		return models.NewMessageV2(v)
+	case []*models.Message:
+		msgs := make([]*models.MessageV2, len(v))
+		for i, msg := range v {
+			msgs[i] = models.NewMessageV2(msg)
+		}
+		return msgs
+	case []*models.MessageSearchResult:
+		results := make([]models.MessageSearchResultV2, len(v))
+		for i, result := range v {
+			results[i] = models.MessageSearchResultV2{Message: models.NewMessageV2(result.Message), Score: result.Score, Highlights: result.Highlights}
+		}
+		return results
+	default:
+		return data
+	}
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func versionRequest(router http.Handler, method, url, accept, contentType string, body []byte) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, bytes.NewReader(body))
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAPIVersionSelection(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	parent, _ := store.Create("alice", "hello")
+	store.CreateReply("bob", "hi", parent.ID)
+	store.React(parent.ID, "bob", "👍")
+	router := NewHandler(store).SetupRoutes()
+
+	tests := []struct {
+		name, url, accept string
+		version           string
+	}{
+		{"default", "/api/messages/1", "", "1"},
+		{"v1 path", "/api/v1/messages/1", "", "1"},
+		{"v2 path", "/api/v2/messages/1", "", "2"},
+		{"v2 media type", "/api/messages/1", "application/vnd.lab03.v2+json", "2"},
+		{"path wins", "/api/v1/messages/1", "application/vnd.lab03.v2+json", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := versionRequest(router, "GET", tt.url, tt.accept, "", nil)
+			if rr.Code != http.StatusOK || rr.Header().Get("API-Version") != tt.version {
+				t.Fatalf("Expected version %s, got %v %q", tt.version, rr.Code, rr.Header().Get("API-Version"))
+			}
+			var res struct {
+				Data map[string]interface{} `json:"data"`
+			}
+			json.NewDecoder(rr.Body).Decode(&res)
+			_, v1 := res.Data["username"]
+			_, v2 := res.Data["author"]
+			if v1 != (tt.version == "1") || v2 != (tt.version == "2") {
+				t.Errorf("Unexpected version %s body %v", tt.version, res.Data)
+			}
+		})
+	}
+
+	rr := versionRequest(router, "GET", "/api/v2/messages/2", "", "", nil)
+	var res struct {
+		Data models.MessageV2 `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&res)
+	if res.Data.Author != "bob" || res.Data.ReplyTo == nil || *res.Data.ReplyTo != 1 || res.Data.State != models.MessageActive ||
+		res.Data.Edits == nil || res.Data.Attachments == nil {
+		t.Errorf("Unexpected v2 message %+v", res.Data)
+	}
+	rr = versionRequest(router, "GET", "/api/v2/messages/1", "", "", nil)
+	json.NewDecoder(rr.Body).Decode(&res)
+	if res.Data.ReplyTo != nil || len(res.Data.Reactions["👍"]) != 1 {
+		t.Errorf("Unexpected v2 root message %+v", res.Data)
+	}
+
+	if rr := versionRequest(router, "GET", "/api/messages", "application/vnd.lab03.v9+json", "", nil); rr.Code != http.StatusNotAcceptable {
+		t.Errorf("Expected 406 for an unknown version, got %v", rr.Code)
+	}
+	if rr := versionRequest(router, "GET", "/api/v2/status/404", "application/vnd.lab03.v2+json", "", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected versioned JSON status, got %v", rr.Code)
+	}
+}
+
+func TestMessagePack(t *testing.T) {
+	router := NewHandler(storage.NewMemoryStorage()).SetupRoutes()
+
+	body, _ := msgpack.Marshal(map[string]string{"username": "alice", "content": "packed"})
+	rr := versionRequest(router, "POST", "/api/v2/messages", "application/msgpack", "application/msgpack", body)
+	if rr.Code != http.StatusCreated || rr.Header().Get("Content-Type") != "application/msgpack" {
+		t.Fatalf("Expected a MessagePack response, got %v %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var res struct {
+		Success bool                   `msgpack:"success"`
+		Data    map[string]interface{} `msgpack:"data"`
+	}
+	if err := msgpack.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.Success || res.Data["author"] != "alice" || res.Data["content"] != "packed" {
+		t.Errorf("Unexpected MessagePack body %+v", res)
+	}
+
+	rr = versionRequest(router, "GET", "/api/messages/1", "application/vnd.lab03.v1+msgpack", "", nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/msgpack" || rr.Header().Get("API-Version") != "1" {
+		t.Errorf("Expected a v1 MessagePack response, got %v %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	// JSON stays the default, also for clients preferring it
+	rr = versionRequest(router, "GET", "/api/messages/1", "application/json, application/msgpack;q=0.5", "", nil)
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("Expected JSON, got %q", rr.Header().Get("Content-Type"))
+	}
+}
+
+func TestAPIVersionDeprecation(t *testing.T) {
+	handler := NewHandler(storage.NewMemoryStorage())
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	handler.SetDeprecation(1, Deprecation{Since: since, Sunset: time.Now().Add(time.Hour), Link: "https://example.com/migrate"})
+	router := handler.SetupRoutes()
+
+	rr := versionRequest(router, "GET", "/api/v1/messages", "", "", nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Deprecation") != "@1767225600" || rr.Header().Get("Sunset") == "" ||
+		rr.Header().Get("Link") != `<https://example.com/migrate>; rel="deprecation"` {
+		t.Errorf("Expected deprecation headers, got %v %v", rr.Code, rr.Header())
+	}
+	if rr := versionRequest(router, "GET", "/api/v2/messages", "", "", nil); rr.Header().Get("Deprecation") != "" {
+		t.Errorf("Expected v2 not to be deprecated")
+	}
+
+	handler.SetDeprecation(1, Deprecation{Since: since, Sunset: time.Now().Add(-time.Hour)})
+	if rr := versionRequest(router, "GET", "/api/messages", "", "", nil); rr.Code != http.StatusGone {
+		t.Errorf("Expected 410 after the sunset, got %v", rr.Code)
+	}
+	if rr := versionRequest(router, "GET", "/api/messages", "application/vnd.lab03.v2+json", "", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected v2 to keep working, got %v", rr.Code)
+	}
+}
+
+func TestBatchInheritsAPIVersion(t *testing.T) {
+	router := NewHandler(storage.NewMemoryStorage()).SetupRoutes()
+
+	batch := models.BatchRequest{Requests: []models.BatchRequestItem{
+		{ID: "a", Method: "POST", Path: "/api/messages", Body: json.RawMessage(`{"username":"alice","content":"hi"}`)},
+	}}
+	code, res := doBatch(t, router, "POST", "/api/v2/batch", batch)
+	if code != http.StatusOK || len(res.Results) != 1 || !strings.Contains(string(res.Results[0].Body), `"author":"alice"`) {
+		t.Errorf("Expected a v2 result, got %v %+v", code, res)
+	}
+	batch.Requests[0].Path = "/api/v2/batch"
+	if code, _ := doBatch(t, router, "POST", "/api/v1/batch", batch); code != http.StatusBadRequest {
+		t.Errorf("Expected nested versioned batch to be rejected, got %v", code)
+	}
+}
@@ -0,0 +1,165 @@
+// Package archive writes messages to and reads them from export files in
+// JSON Lines, CSV and Markdown transcript formats. Both directions work one
+// message at a time, so exports and imports of any size stream.
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"lab03-backend/models"
+)
+
+// Format is an archive file format
+type Format string
+
+// Supported formats
+const (
+	FormatNDJSON   Format = "ndjson"
+	FormatCSV      Format = "csv"
+	FormatMarkdown Format = "markdown"
+)
+
+// Formats lists the supported formats, JSON Lines first as the default
+var Formats = []Format{FormatNDJSON, FormatCSV, FormatMarkdown}
+
+// ErrUnknownFormat is returned for formats other than Formats
+var ErrUnknownFormat = errors.New("format must be ndjson, csv or markdown")
+
+// mediaTypes and extensions of each format
+var (
+	mediaTypes = map[Format]string{
+		FormatNDJSON:   "application/x-ndjson",
+		FormatCSV:      "text/csv",
+		FormatMarkdown: "text/markdown",
+	}
+	extensions = map[Format]string{
+		FormatNDJSON:   ".ndjson",
+		FormatCSV:      ".csv",
+		FormatMarkdown: ".md",
+	}
+)
+
+// ParseFormat parses a format name, "jsonl" and "md" are accepted as aliases
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "ndjson", "jsonl":
+		return FormatNDJSON, nil
+	case "csv":
+		return FormatCSV, nil
+	case "markdown", "md":
+		return FormatMarkdown, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+// FormatOf returns the format of a media type
+func FormatOf(mediaType string) (Format, bool) {
+	if mediaType == "application/jsonl" {
+		return FormatNDJSON, true
+	}
+	for format, t := range mediaTypes {
+		if t == mediaType {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+// MediaType returns the media type of the format
+func (f Format) MediaType() string {
+	return mediaTypes[f]
+}
+
+// Extension returns the file name extension of the format
+func (f Format) Extension() string {
+	return extensions[f]
+}
+
+// Writer writes messages to an archive
+type Writer interface {
+	// Write adds a message to the archive
+	Write(msg *models.Message) error
+	// Flush writes buffered output to the underlying writer
+	Flush() error
+}
+
+// NewWriter creates a writer of the given format
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case FormatNDJSON:
+		return newNDJSONWriter(w), nil
+	case FormatCSV:
+		return newCSVWriter(w)
+	case FormatMarkdown:
+		return newMarkdownWriter(w)
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// Record is a message read from an archive
+type Record struct {
+	Line        int // line the record starts on
+	ID          int // ID in the archive, replies refer to it
+	ParentID    int
+	Username    string
+	Content     string
+	Deleted     bool
+	Attachments []string
+}
+
+// Request converts the record to the request that would create it
+func (rec *Record) Request() models.CreateMessageRequest {
+	return models.CreateMessageRequest{
+		Username:    rec.Username,
+		Content:     rec.Content,
+		ParentID:    rec.ParentID,
+		Attachments: rec.Attachments,
+	}
+}
+
+// RecordError reports a malformed record, reading can continue after it
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Reader reads messages from an archive
+type Reader interface {
+	// Read returns the next record, io.EOF at the end of the archive and a
+	// *RecordError for a malformed record. Other errors end reading.
+	Read() (*Record, error)
+}
+
+// NewReader creates a reader of the given format
+func NewReader(r io.Reader, format Format) (Reader, error) {
+	switch format {
+	case FormatNDJSON:
+		return newNDJSONReader(r), nil
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatMarkdown:
+		return newMarkdownReader(r), nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// attachmentIDs returns the IDs of a message's attachments
+func attachmentIDs(msg *models.Message) []string {
+	ids := make([]string, len(msg.Attachments))
+	for i, att := range msg.Attachments {
+		ids[i] = att.ID
+	}
+	return ids
+}
@@ -0,0 +1,137 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"lab03-backend/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"lab02/blob"
+)
+
+func testMessages() []*models.Message {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	edited := now.Add(time.Minute)
+	return []*models.Message{
+		{ID: 1, Username: "alice", Content: "hello, \"world\"\n\n### not a heading\n> quoted  ", Timestamp: now, EditedAt: &edited},
+		{ID: 2, Username: "bob", Content: "reply", Timestamp: now, ParentID: 1,
+			Attachments: []models.Attachment{{Attachment: blob.Attachment{ID: "abc"}}, {Attachment: blob.Attachment{ID: "def"}}}},
+		{ID: 3, Username: "carol", Timestamp: now, Deleted: true},
+	}
+}
+
+func readAll(t *testing.T, r Reader) []*Record {
+	t.Helper()
+	var records []*Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range Formats {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, msg := range testMessages() {
+				if err := w.Write(msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewReader(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records := readAll(t, r)
+			if len(records) != 3 {
+				t.Fatalf("Expected 3 records, got %d", len(records))
+			}
+			for i, msg := range testMessages() {
+				got := records[i]
+				want := &Record{Line: got.Line, ID: msg.ID, ParentID: msg.ParentID, Username: msg.Username,
+					Content: msg.Content, Deleted: msg.Deleted, Attachments: got.Attachments}
+				if !reflect.DeepEqual(got, want) || got.Line == 0 {
+					t.Errorf("Record %d: got %+v, want %+v", i, got, want)
+				}
+			}
+			if strings.Join(records[1].Attachments, ",") != "abc,def" || len(records[0].Attachments) != 0 {
+				t.Errorf("Unexpected attachments %v %v", records[0].Attachments, records[1].Attachments)
+			}
+		})
+	}
+}
+
+func TestMalformedRecords(t *testing.T) {
+	tests := []struct {
+		format Format
+		input  string
+		lines  []int // lines of malformed records
+		valid  int
+	}{
+		{FormatNDJSON, "{\"username\":\"a\",\"content\":\"x\"}\nnot json\n\n{\"username\":\"b\"}\n", []int{2}, 2},
+		{FormatCSV, "username,content,id\na,x,1\nb,y,two\nc,z\n", []int{3}, 2},
+		{FormatMarkdown, "# Chat transcript\n\n### #1 a · 2024-01-02T15:04:05Z\n> x\nstray\n> more\n\n### oops\n> y\n### #3 c · 2024-01-02T15:04:05Z · reply to #1\n> z\n", []int{5, 8}, 1},
+	}
+	for _, tt := range tests {
+		r, err := NewReader(strings.NewReader(tt.input), tt.format)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		var lines []int
+		valid := 0
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			var recErr *RecordError
+			if errors.As(err, &recErr) {
+				lines = append(lines, recErr.Line)
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: unexpected error %v", tt.format, err)
+			}
+			if rec.Username == "" {
+				t.Errorf("%s: record without username %+v", tt.format, rec)
+			}
+			valid++
+		}
+		if !reflect.DeepEqual(lines, tt.lines) || valid != tt.valid {
+			t.Errorf("%s: got errors on lines %v and %d records, want %v and %d", tt.format, lines, valid, tt.lines, tt.valid)
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	if _, err := NewReader(strings.NewReader("id,content\n"), FormatCSV); err == nil {
+		t.Error("Expected an error for a CSV header without username")
+	}
+	if _, err := ParseFormat("xml"); err != ErrUnknownFormat {
+		t.Errorf("Expected ErrUnknownFormat, got %v", err)
+	}
+	if f, _ := ParseFormat("jsonl"); f != FormatNDJSON || f.MediaType() != "application/x-ndjson" || f.Extension() != ".ndjson" {
+		t.Errorf("Unexpected format %q", f)
+	}
+	if f, ok := FormatOf("text/markdown"); !ok || f != FormatMarkdown {
+		t.Errorf("Expected markdown for text/markdown, got %q", f)
+	}
+}
@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"lab03-backend/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvHeader names the columns of CSV archives. Readers find columns by
+// name, only username and content are required.
+var csvHeader = []string{"id", "parent_id", "username", "timestamp", "edited_at", "deleted", "attachments", "content"}
+
+// csvWriter writes a header row and one row per message
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w)}
+	if err := cw.w.Write(csvHeader); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter) Write(msg *models.Message) error {
+	var parentID, editedAt string
+	if msg.ParentID != 0 {
+		parentID = strconv.Itoa(msg.ParentID)
+	}
+	if msg.EditedAt != nil {
+		editedAt = msg.EditedAt.Format(time.RFC3339Nano)
+	}
+	return cw.w.Write([]string{
+		strconv.Itoa(msg.ID),
+		parentID,
+		msg.Username,
+		msg.Timestamp.Format(time.RFC3339Nano),
+		editedAt,
+		strconv.FormatBool(msg.Deleted),
+		strings.Join(attachmentIDs(msg), " "),
+		msg.Content,
+	})
+}
+
+func (cw *csvWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// csvReader reads rows by the column names of the header row
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := &csvReader{r: csv.NewReader(r), columns: make(map[string]int)}
+	cr.r.FieldsPerRecord = -1
+	header, err := cr.r.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV header row is missing")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range header {
+		cr.columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"username", "content"} {
+		if _, ok := cr.columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header has no %s column", required)
+		}
+	}
+	return cr, nil
+}
+
+func (cr *csvReader) Read() (*Record, error) {
+	row, err := cr.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	line, _ := cr.r.FieldPos(0)
+	field := func(name string) string {
+		if i, ok := cr.columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	rec := &Record{Line: line, Username: field("username"), Content: field("content"), Attachments: strings.Fields(field("attachments"))}
+	for name, dst := range map[string]*int{"id": &rec.ID, "parent_id": &rec.ParentID} {
+		if value := field(name); value != "" {
+			if *dst, err = strconv.Atoi(value); err != nil {
+				return nil, &RecordError{Line: line, Err: fmt.Errorf("%s must be a number", name)}
+			}
+		}
+	}
+	if value := field("deleted"); value != "" {
+		if rec.Deleted, err = strconv.ParseBool(value); err != nil {
+			return nil, &RecordError{Line: line, Err: errors.New("deleted must be true or false")}
+		}
+	}
+	return rec, nil
+}
@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"lab03-backend/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Markdown transcripts start each message with a heading, followed by its
+// content quoted line by line and a list of attachments:
+//
+//	### #2 bob · 2024-01-02T15:04:05Z · reply to #1 · edited
+//	> Hello
+//	>
+//	> world
+//	- attachment: 3f2a…
+//
+// Deleted messages show "_deleted_" instead of content.
+const markdownTitle = "# Chat transcript"
+
+var markdownHeading = regexp.MustCompile(`^### #(\d+) (.+?) · (\S+)((?: · [^·]+)*)$`)
+
+// markdownWriter writes a transcript readable by people and markdownReader
+type markdownWriter struct {
+	buf *bufio.Writer
+}
+
+func newMarkdownWriter(w io.Writer) (*markdownWriter, error) {
+	mw := &markdownWriter{buf: bufio.NewWriter(w)}
+	if _, err := mw.buf.WriteString(markdownTitle + "\n"); err != nil {
+		return nil, err
+	}
+	return mw, nil
+}
+
+func (mw *markdownWriter) Write(msg *models.Message) error {
+	heading := fmt.Sprintf("\n### #%d %s · %s", msg.ID, msg.Username, msg.Timestamp.UTC().Format(time.RFC3339))
+	if msg.ParentID != 0 {
+		heading += fmt.Sprintf(" · reply to #%d", msg.ParentID)
+	}
+	if msg.EditedAt != nil {
+		heading += " · edited"
+	}
+	mw.buf.WriteString(heading + "\n")
+	if msg.Deleted {
+		mw.buf.WriteString("_deleted_\n")
+	} else if msg.Content != "" {
+		for _, line := range strings.Split(msg.Content, "\n") {
+			if line == "" {
+				mw.buf.WriteString(">\n")
+			} else {
+				mw.buf.WriteString("> " + line + "\n")
+			}
+		}
+	}
+	for _, att := range msg.Attachments {
+		mw.buf.WriteString("- attachment: " + att.ID + "\n")
+	}
+	return nil
+}
+
+func (mw *markdownWriter) Flush() error {
+	return mw.buf.Flush()
+}
+
+// markdownReader parses transcripts written by markdownWriter
+type markdownReader struct {
+	scanner *bufio.Scanner
+	line    int
+	next    string // heading read ahead of the following record
+	nextAt  int
+}
+
+func newMarkdownReader(r io.Reader) *markdownReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+	return &markdownReader{scanner: scanner}
+}
+
+func (mr *markdownReader) Read() (*Record, error) {
+	// Find the heading of the next record, skipping the title and blank lines
+	heading, at := mr.next, mr.nextAt
+	mr.next = ""
+	for heading == "" {
+		line, ok := mr.scan()
+		if !ok {
+			if err := mr.scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		if trimmed := strings.TrimSpace(line); trimmed == "" || trimmed == markdownTitle {
+			continue
+		}
+		heading, at = line, mr.line
+	}
+	rec, err := parseMarkdownHeading(heading)
+	if err != nil {
+		mr.skipRecord()
+		return nil, &RecordError{Line: at, Err: err}
+	}
+	rec.Line = at
+
+	var content []string
+	for {
+		line, ok := mr.scan()
+		if !ok {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "### "):
+			mr.next, mr.nextAt = line, mr.line
+		case line == ">" || strings.HasPrefix(line, "> "):
+			content = append(content, strings.TrimPrefix(strings.TrimPrefix(line, ">"), " "))
+			continue
+		case line == "_deleted_":
+			rec.Deleted = true
+			continue
+		case strings.HasPrefix(line, "- attachment: "):
+			rec.Attachments = append(rec.Attachments, strings.TrimSpace(strings.TrimPrefix(line, "- attachment: ")))
+			continue
+		case strings.TrimSpace(line) == "":
+			continue
+		default:
+			at := mr.line
+			mr.skipRecord()
+			return nil, &RecordError{Line: at, Err: errors.New("expected a quoted line, an attachment or a heading")}
+		}
+		break
+	}
+	rec.Content = strings.Join(content, "\n")
+	return rec, mr.scanner.Err()
+}
+
+// scan reads the next line
+func (mr *markdownReader) scan() (string, bool) {
+	if !mr.scanner.Scan() {
+		return "", false
+	}
+	mr.line++
+	return mr.scanner.Text(), true
+}
+
+// skipRecord discards lines up to the next heading
+func (mr *markdownReader) skipRecord() {
+	for mr.next == "" {
+		line, ok := mr.scan()
+		if !ok {
+			return
+		}
+		if strings.HasPrefix(line, "### ") {
+			mr.next, mr.nextAt = line, mr.line
+		}
+	}
+}
+
+// parseMarkdownHeading parses "### #id username · timestamp · notes"
+func parseMarkdownHeading(heading string) (*Record, error) {
+	match := markdownHeading.FindStringSubmatch(heading)
+	if match == nil {
+		return nil, errors.New(`expected a heading like "### #1 username · 2006-01-02T15:04:05Z"`)
+	}
+	rec := &Record{Username: match[2]}
+	rec.ID, _ = strconv.Atoi(match[1])
+	if _, err := time.Parse(time.RFC3339, match[3]); err != nil {
+		return nil, errors.New("timestamp must be an RFC 3339 time")
+	}
+	for _, note := range strings.Split(match[4], " · ")[1:] {
+		if parent, ok := strings.CutPrefix(note, "reply to #"); ok {
+			id, err := strconv.Atoi(parent)
+			if err != nil {
+				return nil, errors.New("reply must name a message like #1")
+			}
+			rec.ParentID = id
+		}
+	}
+	return rec, nil
+}
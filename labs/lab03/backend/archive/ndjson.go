@@ -0,0 +1,71 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"lab03-backend/models"
+)
+
+// maxLineSize bounds one JSON Lines record
+const maxLineSize = 1 << 20
+
+// ndjsonWriter writes each message as one line of JSON
+type ndjsonWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	buf := bufio.NewWriter(w)
+	return &ndjsonWriter{buf: buf, enc: json.NewEncoder(buf)}
+}
+
+func (nw *ndjsonWriter) Write(msg *models.Message) error {
+	return nw.enc.Encode(msg)
+}
+
+func (nw *ndjsonWriter) Flush() error {
+	return nw.buf.Flush()
+}
+
+// ndjsonReader reads messages in the JSON form of models.Message, one per line
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+	return &ndjsonReader{scanner: scanner}
+}
+
+func (nr *ndjsonReader) Read() (*Record, error) {
+	for nr.scanner.Scan() {
+		nr.line++
+		line := bytes.TrimSpace(nr.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var msg models.Message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil, &RecordError{Line: nr.line, Err: errors.New("invalid JSON")}
+		}
+		return &Record{
+			Line:        nr.line,
+			ID:          msg.ID,
+			ParentID:    msg.ParentID,
+			Username:    msg.Username,
+			Content:     msg.Content,
+			Deleted:     msg.Deleted,
+			Attachments: attachmentIDs(&msg),
+		}, nil
+	}
+	if err := nr.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,105 @@
+// Package auth issues and validates the bearer tokens that identify API users
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Roles a token can carry
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator" // may edit and delete any message
+)
+
+// DefaultTokenTTL is how long issued tokens stay valid
+const DefaultTokenTTL = 24 * time.Hour
+
+// Token errors
+var (
+	ErrEmptySecret  = errors.New("secret key must not be empty")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+	ErrInvalidRole  = errors.New("role must be user or moderator")
+)
+
+// Claims identifies the user a token was issued to
+type Claims struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// IsModerator reports whether the token grants moderator rights
+func (c *Claims) IsModerator() bool {
+	return c.Role == RoleModerator
+}
+
+// TokenService signs tokens with HS256
+type TokenService struct {
+	secret []byte
+	now    func() time.Time
+}
+
+// NewTokenService creates a token service, the secret must stay private
+func NewTokenService(secret string) (*TokenService, error) {
+	if secret == "" {
+		return nil, ErrEmptySecret
+	}
+	return &TokenService{secret: []byte(secret), now: time.Now}, nil
+}
+
+// Issue creates a token for username with the given role, a zero ttl uses DefaultTokenTTL
+func (s *TokenService) Issue(username, role string, ttl time.Duration) (string, time.Time, error) {
+	if username == "" {
+		return "", time.Time{}, errors.New("username is required")
+	}
+	if role == "" {
+		role = RoleUser
+	}
+	if role != RoleUser && role != RoleModerator {
+		return "", time.Time{}, ErrInvalidRole
+	}
+	if ttl <= 0 {
+		ttl = DefaultTokenTTL
+	}
+	now := s.now()
+	expires := now.Add(ttl).Truncate(time.Second)
+	claims := Claims{
+		Username: username,
+		Role:     role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// Validate checks the signature and expiry of a token and returns its claims
+func (s *TokenService) Validate(token string) (*Claims, error) {
+	var claims Claims
+	parser := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Alg()}}
+	_, err := parser.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	})
+	var validation *jwt.ValidationError
+	if errors.As(err, &validation) && validation.Errors&jwt.ValidationErrorExpired != 0 {
+		return nil, ErrTokenExpired
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Username == "" {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIssueAndValidate(t *testing.T) {
+	tokens, err := NewTokenService("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, expires, err := tokens.Issue("alice", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(expires) > time.Hour || time.Until(expires) < 59*time.Minute {
+		t.Errorf("Unexpected expiry %v", expires)
+	}
+	claims, err := tokens.Validate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Username != "alice" || claims.Role != RoleUser || claims.IsModerator() {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	token, _, _ = tokens.Issue("mod", RoleModerator, 0)
+	if claims, err := tokens.Validate(token); err != nil || !claims.IsModerator() {
+		t.Errorf("Expected moderator claims, got %+v, %v", claims, err)
+	}
+}
+
+func TestValidateRejectsBadTokens(t *testing.T) {
+	tokens, _ := NewTokenService("secret")
+	other, _ := NewTokenService("other")
+	token, _, _ := other.Issue("alice", RoleUser, time.Hour)
+	if _, err := tokens.Validate(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken for foreign signature, got %v", err)
+	}
+	if _, err := tokens.Validate("not-a-token"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken for garbage, got %v", err)
+	}
+
+	tokens.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	token, _, _ = tokens.Issue("alice", RoleUser, time.Hour)
+	if _, err := tokens.Validate(token); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestIssueValidation(t *testing.T) {
+	if _, err := NewTokenService(""); !errors.Is(err, ErrEmptySecret) {
+		t.Errorf("Expected ErrEmptySecret, got %v", err)
+	}
+	tokens, _ := NewTokenService("secret")
+	if _, _, err := tokens.Issue("", RoleUser, 0); err == nil {
+		t.Error("Expected error for empty username")
+	}
+	if _, _, err := tokens.Issue("alice", "admin", 0); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("Expected ErrInvalidRole, got %v", err)
+	}
+}
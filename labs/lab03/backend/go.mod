@@ -3,3 +3,24 @@ module lab03-backend
 go 1.24
 
 require github.com/gorilla/mux v1.8.0
+
+require (
+	github.com/golang-jwt/jwt/v4 v4.5.2
+	github.com/gorilla/websocket v1.5.3
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/pressly/goose/v3 v3.24.3
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	lab02 v0.0.0
+)
+
+require (
+	github.com/mfridman/interpolate v0.0.2 // indirect
+	github.com/sethvargo/go-retry v0.3.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	go.uber.org/multierr v1.11.0 // indirect
+	golang.org/x/sync v0.14.0 // indirect
+)
+
+// Full-text search, attachment storage and moderation are shared with the
+// lab02 chat backend
+replace lab02 => ../../lab02/backend
@@ -0,0 +1,437 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Request is a GraphQL request as sent over HTTP and WebSocket
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is the result of a request. Data is nil when the request failed
+// before execution.
+type Response struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is a GraphQL error. Resolvers may return one to set Extensions.
+type Error struct {
+	Message    string                 `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// errorResponse is the response of a request that could not be executed
+func errorResponse(err error) *Response {
+	var gqlErr *Error
+	if !errors.As(err, &gqlErr) {
+		gqlErr = &Error{Message: err.Error()}
+	}
+	return &Response{Errors: []*Error{gqlErr}}
+}
+
+// Execute runs a query or mutation. Subscriptions must use Subscribe.
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	e, err := s.prepare(ctx, req)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if e.op.Type == Subscription {
+		return errorResponse(&Error{Message: "Subscriptions are not supported by this transport, use a WebSocket.", Locations: []Location{e.op.Loc}})
+	}
+	return e.run(nil)
+}
+
+// Subscribe runs an operation and sends its results until ctx is done. A
+// subscription gets one response per event, other operations exactly one.
+// The channel is closed when the stream ends. Requests that cannot be
+// executed return an error response instead of a channel.
+func (s *Schema) Subscribe(ctx context.Context, req Request) (<-chan *Response, *Response) {
+	e, err := s.prepare(ctx, req)
+	if err != nil {
+		return nil, errorResponse(err)
+	}
+	responses := make(chan *Response, 1)
+	if e.op.Type != Subscription {
+		responses <- e.run(nil)
+		close(responses)
+		return responses, nil
+	}
+
+	groups := e.collectFields(s.Subscription, e.op.SelectionSet)
+	if len(groups) == 0 {
+		return nil, errorResponse(&Error{Message: "A subscription must select exactly one top level field.", Locations: []Location{e.op.Loc}})
+	}
+	sel := groups[0].fields[0]
+	field := s.Subscription.Fields[sel.Name]
+	args, err := coerceArgs(field.Args, argumentMap(sel.Arguments), e.vars, "argument")
+	if err != nil {
+		return nil, errorResponse(&Error{Message: err.Error(), Locations: []Location{sel.Loc}})
+	}
+	events, err := field.Subscribe(ResolveParams{Context: ctx, Args: args})
+	if err != nil {
+		return nil, errorResponse(&Error{Message: err.Error(), Locations: []Location{sel.Loc}, Path: []interface{}{sel.ResponseKey()}})
+	}
+	go func() {
+		defer close(responses)
+		for event := range events {
+			select {
+			case responses <- e.run(event):
+			case <-ctx.Done():
+				// Drain so the source can finish closing
+				for range events {
+				}
+				return
+			}
+		}
+	}()
+	return responses, nil
+}
+
+// executor holds the state of one operation
+type executor struct {
+	ctx    context.Context
+	schema *Schema
+	doc    *Document
+	op     *Operation
+	vars   map[string]interface{}
+	errors []*Error
+}
+
+// prepare parses and validates a request and coerces its variables
+func (s *Schema) prepare(ctx context.Context, req Request) (*executor, error) {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	op, err := doc.Operation(req.OperationName)
+	if err != nil {
+		return nil, err
+	}
+	e := &executor{ctx: ctx, schema: s, doc: doc, op: op}
+	if e.vars, err = s.coerceVariables(op, req.Variables); err != nil {
+		return nil, err
+	}
+	if err := s.validate(doc, op, e.vars); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Operation selects the operation to execute, name may be empty if the
+// document has a single operation
+func (doc *Document) Operation(name string) (*Operation, error) {
+	if name == "" {
+		if len(doc.Operations) != 1 {
+			return nil, &Error{Message: "Must provide operation name if query contains multiple operations."}
+		}
+		return doc.Operations[0], nil
+	}
+	for _, op := range doc.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, &Error{Message: fmt.Sprintf("Unknown operation named %q.", name)}
+}
+
+// coerceVariables checks the request's variables against their definitions
+func (s *Schema) coerceVariables(op *Operation, given map[string]interface{}) (map[string]interface{}, error) {
+	vars := make(map[string]interface{}, len(op.Variables))
+	for _, def := range op.Variables {
+		t, ok := s.inputType(def.Type)
+		if !ok {
+			return nil, &Error{Message: fmt.Sprintf("Variable \"$%s\" has unknown input type %s.", def.Name, def.Type), Locations: []Location{def.Loc}}
+		}
+		v, ok := given[def.Name]
+		if !ok {
+			if !def.HasDefault {
+				if _, required := t.(*NonNull); required {
+					return nil, &Error{Message: fmt.Sprintf("Variable \"$%s\" of required type %s was not provided.", def.Name, def.Type), Locations: []Location{def.Loc}}
+				}
+				continue
+			}
+			v = def.Default
+		}
+		coerced, err := coerceInput(t, v, nil)
+		if err != nil {
+			return nil, &Error{Message: fmt.Sprintf("Variable \"$%s\" got invalid value: %v.", def.Name, err), Locations: []Location{def.Loc}}
+		}
+		vars[def.Name] = coerced
+	}
+	return vars, nil
+}
+
+// run executes the operation, event is the source of a subscription field
+func (e *executor) run(event interface{}) *Response {
+	e.errors = nil
+	root := e.schema.Query
+	switch e.op.Type {
+	case Mutation:
+		root = e.schema.Mutation
+	case Subscription:
+		root = e.schema.Subscription
+	}
+	data, _ := e.selectionSet(root, event, e.op.SelectionSet, nil, e.op.Type == Subscription)
+	res := &Response{Errors: e.errors}
+	if data != nil {
+		res.Data = data
+	} else {
+		res.Data = json.RawMessage("null")
+	}
+	return res
+}
+
+// fieldGroup is the fields of a selection set sharing a response key
+type fieldGroup struct {
+	key    string
+	fields []*Selection
+}
+
+// collectFields flattens fragments and applies @skip and @include, grouping
+// fields by response key in document order
+func (e *executor) collectFields(obj *Object, set []*Selection) []*fieldGroup {
+	var groups []*fieldGroup
+	index := make(map[string]*fieldGroup)
+	visited := make(map[string]bool)
+	var collect func(set []*Selection)
+	collect = func(set []*Selection) {
+		for _, sel := range set {
+			if !e.included(sel.Directives) {
+				continue
+			}
+			switch sel.Kind {
+			case FieldSelection:
+				key := sel.ResponseKey()
+				group, ok := index[key]
+				if !ok {
+					group = &fieldGroup{key: key}
+					index[key] = group
+					groups = append(groups, group)
+				}
+				group.fields = append(group.fields, sel)
+			case FragmentSpread:
+				fragment := e.doc.Fragments[sel.Name]
+				if visited[sel.Name] || fragment == nil || fragment.TypeCondition != obj.Name {
+					continue
+				}
+				visited[sel.Name] = true
+				collect(fragment.SelectionSet)
+			case InlineFragment:
+				if sel.TypeCondition == "" || sel.TypeCondition == obj.Name {
+					collect(sel.SelectionSet)
+				}
+			}
+		}
+	}
+	collect(set)
+	return groups
+}
+
+// included evaluates the @skip and @include directives
+func (e *executor) included(directives []*Directive) bool {
+	for _, d := range directives {
+		if d.Name != "skip" && d.Name != "include" {
+			continue
+		}
+		args, err := coerceArgs(conditionArgs, argumentMap(d.Arguments), e.vars, "argument")
+		if err != nil {
+			continue // reported by validation
+		}
+		if args["if"] == (d.Name == "skip") {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionArgs are the arguments of @skip and @include
+var conditionArgs = Args{"if": {Type: NewNonNull(Boolean)}}
+
+// selectionSet resolves the fields of an object. failed reports a null in
+// a non-null field, which nulls the object itself.
+func (e *executor) selectionSet(obj *Object, source interface{}, set []*Selection, path []interface{}, subscription bool) (data orderedMap, failed bool) {
+	groups := e.collectFields(obj, set)
+	data = make(orderedMap, 0, len(groups))
+	for _, group := range groups {
+		sel := group.fields[0]
+		fieldPath := append(append([]interface{}{}, path...), group.key)
+		if sel.Name == "__typename" {
+			data = append(data, entry{group.key, obj.Name})
+			continue
+		}
+		field := obj.Fields[sel.Name]
+		value, err := e.resolve(field, sel, source, subscription)
+		if err != nil {
+			e.fieldError(err, sel, fieldPath)
+		}
+		var sub []*Selection
+		for _, f := range group.fields {
+			sub = append(sub, f.SelectionSet...)
+		}
+		completed, fieldFailed := e.complete(field.Type, value, err != nil, sub, sel, fieldPath)
+		if fieldFailed {
+			if _, required := field.Type.(*NonNull); required {
+				return nil, true
+			}
+			completed = nil
+		}
+		data = append(data, entry{group.key, completed})
+	}
+	return data, false
+}
+
+// resolve calls a field's resolver, recovering from panics
+func (e *executor) resolve(field *Field, sel *Selection, source interface{}, subscription bool) (value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("internal error: %v", r)
+		}
+	}()
+	args, err := coerceArgs(field.Args, argumentMap(sel.Arguments), e.vars, "argument")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case subscription && field.Resolve == nil:
+		return source, nil // the event itself
+	case field.Resolve != nil:
+		return field.Resolve(ResolveParams{Context: e.ctx, Source: source, Args: args})
+	default:
+		if m, ok := source.(map[string]interface{}); ok {
+			return m[sel.Name], nil
+		}
+		return nil, nil
+	}
+}
+
+// complete converts a resolved value to the field's type. failed reports a
+// null in a non-null position whose error has been recorded.
+func (e *executor) complete(t Type, value interface{}, resolveFailed bool, set []*Selection, sel *Selection, path []interface{}) (interface{}, bool) {
+	if nonNull, ok := t.(*NonNull); ok {
+		completed, failed := e.complete(nonNull.Of, value, resolveFailed, set, sel, path)
+		if failed {
+			return nil, true
+		}
+		if completed == nil {
+			if !resolveFailed {
+				e.fieldError(fmt.Errorf("Cannot return null for non-nullable field %s.", sel.Name), sel, path)
+			}
+			return nil, true
+		}
+		return completed, false
+	}
+	if isNil(value) {
+		return nil, false
+	}
+
+	switch t := t.(type) {
+	case *Scalar:
+		serialized, err := t.Serialize(value)
+		if err != nil {
+			e.fieldError(err, sel, path)
+			return nil, true
+		}
+		return serialized, false
+	case *Object:
+		data, failed := e.selectionSet(t, value, set, path, false)
+		if failed {
+			return nil, true
+		}
+		return data, false
+	case *List:
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.fieldError(fmt.Errorf("Expected a list for field %s.", sel.Name), sel, path)
+			return nil, true
+		}
+		_, itemRequired := t.Of.(*NonNull)
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			itemPath := append(append([]interface{}{}, path...), i)
+			item, failed := e.complete(t.Of, rv.Index(i).Interface(), false, set, sel, itemPath)
+			if failed && itemRequired {
+				return nil, true
+			}
+			list[i] = item
+		}
+		return list, false
+	default:
+		e.fieldError(fmt.Errorf("%s is not an output type", t), sel, path)
+		return nil, true
+	}
+}
+
+// fieldError records an error of a field
+func (e *executor) fieldError(err error, sel *Selection, path []interface{}) {
+	gqlErr := &Error{Message: err.Error()}
+	var resolverErr *Error
+	if errors.As(err, &resolverErr) {
+		gqlErr.Message = resolverErr.Message
+		gqlErr.Extensions = resolverErr.Extensions
+	}
+	gqlErr.Locations = []Location{sel.Loc}
+	gqlErr.Path = path
+	e.errors = append(e.errors, gqlErr)
+}
+
+// isNil reports whether v is nil or a nil pointer, slice or map
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// argumentMap indexes arguments by name
+func argumentMap(args []*Argument) map[string]interface{} {
+	m := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		m[arg.Name] = arg.Value
+	}
+	return m
+}
+
+// orderedMap is a JSON object that keeps the order of the selection set
+type orderedMap []entry
+
+type entry struct {
+	key   string
+	value interface{}
+}
+
+func (m orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, e := range m {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(e.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(e.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,380 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testBook struct {
+	ID      int
+	Title   string
+	Author  *testAuthor
+	Related []int
+}
+
+type testAuthor struct {
+	Name string
+}
+
+// testSchema is a small library with books that relate to each other
+func testSchema(books map[int]*testBook, added chan interface{}) *Schema {
+	author := &Object{Name: "Author", Fields: Fields{
+		"name": {Type: NewNonNull(String), Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*testAuthor).Name, nil
+		}},
+	}}
+	book := &Object{Name: "Book"}
+	book.Fields = Fields{
+		"id":    {Type: NewNonNull(ID), Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(*testBook).ID, nil }},
+		"title": {Type: NewNonNull(String), Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(*testBook).Title, nil }},
+		"author": {Type: author, Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*testBook).Author, nil
+		}},
+		"related": {
+			Type: NewNonNull(NewList(NewNonNull(book))),
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				var related []*testBook
+				for _, id := range p.Source.(*testBook).Related {
+					related = append(related, books[id])
+				}
+				return related, nil
+			},
+			Complexity: func(args map[string]interface{}, children int) int { return 1 + 5*children },
+		},
+	}
+	input := &InputObject{Name: "BookInput", Fields: InputFields{
+		"title":  {Type: NewNonNull(String)},
+		"author": {Type: String},
+	}}
+	return &Schema{
+		Query: &Object{Name: "Query", Fields: Fields{
+			"hello": {
+				Type: NewNonNull(String),
+				Args: Args{"name": {Type: String, Default: "world"}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					return "hello " + p.Args["name"].(string), nil
+				},
+			},
+			"book": {
+				Type: book,
+				Args: Args{"id": {Type: NewNonNull(ID)}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					id, _ := strconv.Atoi(p.Args["id"].(string))
+					return books[id], nil
+				},
+			},
+			"books": {
+				Type: NewNonNull(NewList(NewNonNull(book))),
+				Args: Args{"ids": {Type: NewNonNull(NewList(NewNonNull(Int)))}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					var result []*testBook
+					for _, id := range p.Args["ids"].([]interface{}) {
+						result = append(result, books[id.(int)])
+					}
+					return result, nil
+				},
+				Complexity: func(args map[string]interface{}, children int) int {
+					ids, _ := args["ids"].([]interface{})
+					return 1 + len(ids)*children
+				},
+			},
+			"broken": {Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+				return nil, &Error{Message: "shelf is empty", Extensions: map[string]interface{}{"code": "EMPTY"}}
+			}},
+			"panics": {Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+				panic("boom")
+			}},
+			"settings": {Type: NewNonNull(&Object{Name: "Settings", Fields: Fields{"theme": {Type: String}}}), Resolve: func(p ResolveParams) (interface{}, error) {
+				return map[string]interface{}{"theme": "dark"}, nil
+			}},
+		}},
+		Mutation: &Object{Name: "Mutation", Fields: Fields{
+			"addBook": {
+				Type: NewNonNull(book),
+				Args: Args{"input": {Type: NewNonNull(input)}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					in := p.Args["input"].(map[string]interface{})
+					b := &testBook{ID: len(books) + 1, Title: in["title"].(string)}
+					if name, ok := in["author"].(string); ok {
+						b.Author = &testAuthor{Name: name}
+					}
+					books[b.ID] = b
+					return b, nil
+				},
+			},
+		}},
+		Subscription: &Object{Name: "Subscription", Fields: Fields{
+			"bookAdded": {
+				Type: NewNonNull(book),
+				Subscribe: func(p ResolveParams) (<-chan interface{}, error) {
+					events := make(chan interface{})
+					go func() {
+						defer close(events)
+						for {
+							select {
+							case <-p.Context.Done():
+								return
+							case b := <-added:
+								events <- b
+							}
+						}
+					}()
+					return events, nil
+				},
+			},
+		}},
+	}
+}
+
+func testBooks() map[int]*testBook {
+	return map[int]*testBook{
+		1: {ID: 1, Title: "Dune", Author: &testAuthor{Name: "Herbert"}, Related: []int{2}},
+		2: {ID: 2, Title: "Hyperion", Related: []int{1}},
+		3: {ID: 3, Title: "Broken", Related: []int{99}},
+	}
+}
+
+func execute(t *testing.T, s *Schema, query string, vars map[string]interface{}) (string, []*Error) {
+	t.Helper()
+	res := s.Execute(context.Background(), Request{Query: query, Variables: vars})
+	data, err := json.Marshal(res.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Data == nil {
+		data = nil
+	}
+	return string(data), res.Errors
+}
+
+func TestExecute(t *testing.T) {
+	s := testSchema(testBooks(), nil)
+	tests := []struct {
+		name, query string
+		vars        map[string]interface{}
+		want        string
+	}{
+		{"default argument", `{ hello }`, nil, `{"hello":"hello world"}`},
+		{"aliases in order", `{ b: hello(name: "b") a: hello(name: "a") }`, nil, `{"b":"hello b","a":"hello a"}`},
+		{"nested", `{ book(id: 1) { title author { name } related { title } } }`, nil,
+			`{"book":{"title":"Dune","author":{"name":"Herbert"},"related":[{"title":"Hyperion"}]}}`},
+		{"null object", `{ book(id: 2) { author { name } } }`, nil, `{"book":{"author":null}}`},
+		{"variables", `query($id: ID!, $ids: [Int!]!) { book(id: $id) { id } books(ids: $ids) { id } }`,
+			map[string]interface{}{"id": 2, "ids": []interface{}{1.0, 2.0}}, `{"book":{"id":"2"},"books":[{"id":"1"},{"id":"2"}]}`},
+		{"single value as list", `{ books(ids: 1) { title } }`, nil, `{"books":[{"title":"Dune"}]}`},
+		{"fragments", `query { book(id: 1) { ...f ... on Book { id } ... @skip(if: true) { author { name } } } } fragment f on Book { title }`, nil,
+			`{"book":{"title":"Dune","id":"1"}}`},
+		{"include", `query($on: Boolean!) { book(id: 1) { title @include(if: $on) id } }`, map[string]interface{}{"on": false}, `{"book":{"id":"1"}}`},
+		{"merged fields", `{ book(id: 1) { author { name } author { __typename } } }`, nil, `{"book":{"author":{"name":"Herbert","__typename":"Author"}}}`},
+		{"default resolver", `{ settings { theme } __typename }`, nil, `{"settings":{"theme":"dark"},"__typename":"Query"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, errs := execute(t, s, tt.query, tt.vars)
+			if len(errs) != 0 || data != tt.want {
+				t.Errorf("Got %s %v, want %s", data, errs, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	s := testSchema(testBooks(), nil)
+
+	data, errs := execute(t, s, `{ hello broken }`, nil)
+	if data != `{"hello":"hello world","broken":null}` || len(errs) != 1 || errs[0].Extensions["code"] != "EMPTY" ||
+		errs[0].Path[0] != "broken" || errs[0].Locations[0] != (Location{1, 9}) {
+		t.Errorf("Expected a field error, got %s %+v", data, errs)
+	}
+
+	data, errs = execute(t, s, `{ panics }`, nil)
+	if data != `{"panics":null}` || len(errs) != 1 || !strings.Contains(errs[0].Message, "boom") {
+		t.Errorf("Expected a recovered panic, got %s %+v", data, errs)
+	}
+
+	// Book 99 does not exist: the non-null item nulls the non-null list,
+	// which nulls the nullable book
+	data, errs = execute(t, s, `{ hello book(id: 3) { title related { title } } }`, nil)
+	if data != `{"hello":"hello world","book":null}` || len(errs) != 1 {
+		t.Fatalf("Expected null propagation, got %s %+v", data, errs)
+	}
+	if path, _ := json.Marshal(errs[0].Path); string(path) != `["book","related",0]` {
+		t.Errorf("Unexpected error path %s", path)
+	}
+
+	requestErrors := []struct{ query, message string }{
+		{`{ nope }`, `Cannot query field "nope" on type "Query".`},
+		{`{ book(id: 1) }`, `must have a selection of subfields`},
+		{`{ hello { x } }`, `must not have a selection`},
+		{`{ book { id } }`, `argument "id" of type ID! is required`},
+		{`{ hello(nme: "x") }`, `Unknown argument "nme"`},
+		{`{ hello(name: 5) }`, `String cannot represent 5`},
+		{`{ book(id: $id) { id } }`, `Variable "$id" is not defined.`},
+		{`query($id: Int) { book(id: $id) { id } }`, `Variable "$id" of type Int used in position expecting type ID!.`},
+		{`query($id: ID!) { book(id: $id) { id } }`, `Variable "$id" of required type ID! was not provided.`},
+		{`{ ...f }`, `Unknown fragment "f".`},
+		{`{ book(id: 1) { ...a } } fragment a on Book { ...b } fragment b on Book { ...a }`, `within itself`},
+		{`{ book(id: 1) { ...a } } fragment a on Author { name }`, `can never be of type "Author"`},
+		{`subscription { ...a } fragment a on Subscription { ...a }`, `within itself`},
+		{`subscription { ...a } fragment a on Subscription { ...b } fragment b on Subscription { ...a }`, `within itself`},
+		{`{ hello @deprecated }`, `Unknown directive "@deprecated".`},
+		{`query a { hello } query b { hello }`, `Must provide operation name`},
+		{`subscription { bookAdded { id } }`, `use a WebSocket`},
+		{`mutation { addBook(input: {title: "x", pages: 3}) { id } }`, `field "pages" is not defined by type BookInput`},
+	}
+	for _, tt := range requestErrors {
+		data, errs := execute(t, s, tt.query, nil)
+		if data != "" || len(errs) != 1 || !strings.Contains(errs[0].Message, tt.message) {
+			t.Errorf("%s: got %s %+v, want error %q", tt.query, data, errs, tt.message)
+		}
+	}
+}
+
+func TestExecuteMutation(t *testing.T) {
+	books := testBooks()
+	s := testSchema(books, nil)
+	data, errs := execute(t, s, `mutation($in: BookInput!) { addBook(input: $in) { id title author { name } } }`,
+		map[string]interface{}{"in": map[string]interface{}{"title": "Solaris", "author": "Lem"}})
+	if len(errs) != 0 || data != `{"addBook":{"id":"4","title":"Solaris","author":{"name":"Lem"}}}` || books[4] == nil {
+		t.Errorf("Unexpected mutation result %s %+v", data, errs)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	s := testSchema(testBooks(), nil)
+	deep := `{ book(id: 1) { related { related { related { title } } } } }`
+
+	s.MaxDepth = 5
+	if _, errs := execute(t, s, deep, nil); len(errs) != 0 {
+		t.Errorf("Expected depth 5 to pass, got %+v", errs)
+	}
+	s.MaxDepth = 3
+	_, errs := execute(t, s, deep, nil)
+	if len(errs) != 1 || errs[0].Extensions["code"] != "QUERY_TOO_DEEP" || errs[0].Extensions["depth"] != 5 {
+		t.Errorf("Expected the depth limit, got %+v", errs)
+	}
+	// Fragments count where they are spread
+	_, errs = execute(t, s, `{ book(id: 1) { ...r } } fragment r on Book { related { related { id } } }`, nil)
+	if len(errs) != 1 || errs[0].Extensions["code"] != "QUERY_TOO_DEEP" {
+		t.Errorf("Expected the depth limit through fragments, got %+v", errs)
+	}
+
+	s.MaxDepth = 0
+	// books: 1 + 3 * (related: 1 + 5 * title: 1) = 19
+	query := `{ books(ids: [1, 2, 3]) { related { title } } }`
+	s.MaxComplexity = 19
+	if _, errs := execute(t, s, query, nil); len(errs) != 1 || errs[0].Path == nil {
+		// book 99 is missing, the only error is the field error
+		t.Errorf("Expected complexity 19 to pass, got %+v", errs)
+	}
+	s.MaxComplexity = 18
+	_, errs = execute(t, s, query, nil)
+	if len(errs) != 1 || errs[0].Extensions["code"] != "QUERY_TOO_COMPLEX" || errs[0].Extensions["complexity"] != 19 {
+		t.Errorf("Expected the complexity limit, got %+v", errs)
+	}
+	// Arguments from variables are part of the cost
+	_, errs = execute(t, s, `query($ids: [Int!]!) { books(ids: $ids) { related { title } } }`, map[string]interface{}{"ids": []interface{}{1, 2, 3}})
+	if len(errs) != 1 || errs[0].Extensions["code"] != "QUERY_TOO_COMPLEX" {
+		t.Errorf("Expected the complexity limit with variables, got %+v", errs)
+	}
+}
+
+func TestLimitsWithRepeatedFragments(t *testing.T) {
+	s := testSchema(testBooks(), nil)
+	s.MaxDepth = 3
+	// The second spread of r is one level deeper than the first
+	_, errs := execute(t, s, `{ book(id: 1) { ...r related { ...r } } } fragment r on Book { related { id } }`, nil)
+	if len(errs) != 1 || errs[0].Extensions["code"] != "QUERY_TOO_DEEP" || errs[0].Extensions["depth"] != 4 {
+		t.Errorf("Expected the depth limit on the deeper spread, got %+v", errs)
+	}
+
+	// Every fragment spreads the previous one twice, expanding them all
+	// would visit 2^26 fields
+	var query strings.Builder
+	query.WriteString("{ book(id: 1) { ...f26 } } fragment f0 on Book { title }")
+	for i := 1; i <= 26; i++ {
+		fmt.Fprintf(&query, " fragment f%d on Book { ...f%d related { ...f%d } }", i, i-1, i-1)
+	}
+	s.MaxDepth = 0
+	s.MaxComplexity = 5000
+	done := make(chan []*Error, 1)
+	go func() {
+		_, errs := execute(t, s, query.String(), nil)
+		done <- errs
+	}()
+	select {
+	case errs := <-done:
+		if len(errs) != 1 || errs[0].Extensions["code"] != "QUERY_TOO_COMPLEX" {
+			t.Errorf("Expected the complexity limit, got %+v", errs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Validating repeated fragments did not finish")
+	}
+}
+
+func TestSelectionLimit(t *testing.T) {
+	s := testSchema(testBooks(), nil)
+	s.MaxSelections = 4
+	if _, errs := execute(t, s, `{ book(id: 1) { ...f } } fragment f on Book { id title }`, nil); len(errs) != 0 {
+		t.Errorf("Expected 4 selections to pass, got %+v", errs)
+	}
+	_, errs := execute(t, s, `{ book(id: 1) { ...f title } } fragment f on Book { id title }`, nil)
+	if len(errs) != 1 || errs[0].Extensions["code"] != "QUERY_TOO_LARGE" {
+		t.Errorf("Expected the selection limit, got %+v", errs)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	books := testBooks()
+	added := make(chan interface{})
+	s := testSchema(books, added)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	responses, errRes := s.Subscribe(ctx, Request{Query: `subscription { bookAdded { title } }`})
+	if errRes != nil {
+		t.Fatalf("Subscribe failed: %+v", errRes.Errors)
+	}
+	for _, id := range []int{1, 2} {
+		added <- books[id]
+		select {
+		case res := <-responses:
+			data, _ := json.Marshal(res.Data)
+			if want := `{"bookAdded":{"title":"` + books[id].Title + `"}}`; string(data) != want {
+				t.Errorf("Got %s, want %s", data, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("No event received")
+		}
+	}
+	cancel()
+	select {
+	case _, ok := <-responses:
+		if ok {
+			t.Error("Expected the stream to end")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stream not closed after cancel")
+	}
+
+	// Queries yield one response
+	responses, _ = s.Subscribe(context.Background(), Request{Query: `{ hello }`})
+	if res := <-responses; res == nil || len(res.Errors) != 0 {
+		t.Errorf("Unexpected query response %+v", res)
+	}
+	if _, ok := <-responses; ok {
+		t.Error("Expected a single response")
+	}
+
+	_, errRes = s.Subscribe(context.Background(), Request{Query: `subscription { a: bookAdded { id } b: bookAdded { id } }`})
+	var gqlErr *Error
+	if errRes == nil || !errors.As(errRes.Errors[0], &gqlErr) || !strings.Contains(gqlErr.Message, "exactly one") {
+		t.Errorf("Expected a single root field error, got %+v", errRes)
+	}
+}
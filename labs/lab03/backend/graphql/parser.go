@@ -0,0 +1,543 @@
+package graphql
+
+import (
+	"strconv"
+)
+
+// Operation types
+const (
+	Query        = "query"
+	Mutation     = "mutation"
+	Subscription = "subscription"
+)
+
+// Document is a parsed GraphQL document
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+// Operation is a query, mutation or subscription of a document
+type Operation struct {
+	Type         string
+	Name         string // empty for anonymous operations
+	Variables    []*VariableDefinition
+	Directives   []*Directive
+	SelectionSet []*Selection
+	Loc          Location
+}
+
+// VariableDefinition declares a variable of an operation
+type VariableDefinition struct {
+	Name       string
+	Type       *TypeRef
+	Default    interface{}
+	HasDefault bool
+	Loc        Location
+}
+
+// TypeRef is a type written in a document: a named type or, with Elem
+// set, a list
+type TypeRef struct {
+	Name    string
+	Elem    *TypeRef
+	NonNull bool
+}
+
+func (t *TypeRef) String() string {
+	s := t.Name
+	if t.Elem != nil {
+		s = "[" + t.Elem.String() + "]"
+	}
+	if t.NonNull {
+		s += "!"
+	}
+	return s
+}
+
+// Fragment is a named fragment definition
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []*Selection
+	Loc           Location
+}
+
+// SelectionKind tells fields, fragment spreads and inline fragments apart
+type SelectionKind int
+
+// Selection kinds
+const (
+	FieldSelection SelectionKind = iota
+	FragmentSpread
+	InlineFragment
+)
+
+// Selection is a field, a fragment spread (Name is the fragment) or an
+// inline fragment
+type Selection struct {
+	Kind          SelectionKind
+	Alias         string
+	Name          string
+	Arguments     []*Argument
+	Directives    []*Directive
+	TypeCondition string // inline fragments, may be empty
+	SelectionSet  []*Selection
+	Loc           Location
+}
+
+// ResponseKey is the key of a field in the response, its alias if it has one
+func (s *Selection) ResponseKey() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.Name
+}
+
+// Argument is a named argument of a field or directive
+type Argument struct {
+	Name  string
+	Value interface{}
+	Loc   Location
+}
+
+// Directive is a directive like @include(if: $flag)
+type Directive struct {
+	Name      string
+	Arguments []*Argument
+	Loc       Location
+}
+
+// Variable is a reference to a variable in a value
+type Variable string
+
+// EnumValue is an enum literal in a value
+type EnumValue string
+
+// Parse parses a GraphQL document. Values are nil, int, float64, string,
+// bool, EnumValue, Variable, []interface{} and map[string]interface{}.
+func Parse(src string) (*Document, error) {
+	p := &parser{lex: newLexer(src)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	doc := &Document{Fragments: make(map[string]*Fragment)}
+	if p.tok.kind == tokenEOF {
+		return nil, syntaxError(p.tok.loc, "unexpected %s", p.tok)
+	}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			set, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &Operation{Type: Query, SelectionSet: set, Loc: set[0].Loc})
+		case p.tok.kind == tokenName && p.tok.value == "fragment":
+			fragment, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.Fragments[fragment.Name]; ok {
+				return nil, &Error{Message: "There can be only one fragment named \"" + fragment.Name + "\".", Locations: []Location{fragment.Loc}}
+			}
+			doc.Fragments[fragment.Name] = fragment
+		case p.tok.kind == tokenName && (p.tok.value == Query || p.tok.value == Mutation || p.tok.value == Subscription):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		default:
+			return nil, syntaxError(p.tok.loc, "unexpected %s", p.tok)
+		}
+	}
+	return doc, nil
+}
+
+// maxNesting bounds how deeply selection sets, list and object values and
+// list types may nest, so a hostile document cannot exhaust the stack
+const maxNesting = 128
+
+// parser is a recursive descent parser over the lexer's tokens
+type parser struct {
+	lex   *lexer
+	tok   token
+	depth int // nesting of the current selection set, value or type
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// peek reports whether the current token is the punctuator
+func (p *parser) peek(punctuator string) bool {
+	return p.tok.kind == tokenPunctuator && p.tok.value == punctuator
+}
+
+// skip consumes the punctuator if it is the current token
+func (p *parser) skip(punctuator string) (bool, error) {
+	if !p.peek(punctuator) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+// expect consumes the punctuator or fails
+func (p *parser) expect(punctuator string) error {
+	if !p.peek(punctuator) {
+		return syntaxError(p.tok.loc, "expected %q, found %s", punctuator, p.tok)
+	}
+	return p.advance()
+}
+
+// nest enters one more level of nesting at the current token, the caller
+// leaves it with p.depth--
+func (p *parser) nest() error {
+	if p.depth >= maxNesting {
+		return syntaxError(p.tok.loc, "document nests deeper than %d levels", maxNesting)
+	}
+	p.depth++
+	return nil
+}
+
+// name consumes a name
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", syntaxError(p.tok.loc, "expected name, found %s", p.tok)
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+// keyword consumes a specific name
+func (p *parser) keyword(word string) error {
+	if p.tok.kind != tokenName || p.tok.value != word {
+		return syntaxError(p.tok.loc, "expected %q, found %s", word, p.tok)
+	}
+	return p.advance()
+}
+
+func (p *parser) operation() (*Operation, error) {
+	op := &Operation{Type: p.tok.value, Loc: p.tok.loc}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.tok.kind == tokenName {
+		if op.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if op.Variables, err = p.variableDefinitions(); err != nil {
+		return nil, err
+	}
+	if op.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if op.SelectionSet, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (p *parser) variableDefinitions() ([]*VariableDefinition, error) {
+	if ok, err := p.skip("("); !ok || err != nil {
+		return nil, err
+	}
+	var defs []*VariableDefinition
+	for {
+		def := &VariableDefinition{Loc: p.tok.loc}
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		var err error
+		if def.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if def.Type, err = p.typeRef(); err != nil {
+			return nil, err
+		}
+		if ok, err := p.skip("="); err != nil {
+			return nil, err
+		} else if ok {
+			if def.Default, err = p.value(true); err != nil {
+				return nil, err
+			}
+			def.HasDefault = true
+		}
+		defs = append(defs, def)
+		if ok, err := p.skip(")"); ok || err != nil {
+			return defs, err
+		}
+	}
+}
+
+func (p *parser) typeRef() (*TypeRef, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	var t *TypeRef
+	if ok, err := p.skip("["); err != nil {
+		return nil, err
+	} else if ok {
+		elem, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		t = &TypeRef{Elem: elem}
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		t = &TypeRef{Name: name}
+	}
+	nonNull, err := p.skip("!")
+	t.NonNull = nonNull
+	return t, err
+}
+
+func (p *parser) fragment() (*Fragment, error) {
+	fragment := &Fragment{Loc: p.tok.loc}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var err error
+	if fragment.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if fragment.Name == "on" {
+		return nil, syntaxError(fragment.Loc, "a fragment cannot be named \"on\"")
+	}
+	if err := p.keyword("on"); err != nil {
+		return nil, err
+	}
+	if fragment.TypeCondition, err = p.name(); err != nil {
+		return nil, err
+	}
+	if fragment.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if fragment.SelectionSet, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return fragment, nil
+}
+
+func (p *parser) selectionSet() ([]*Selection, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var set []*Selection
+	for {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, sel)
+		if ok, err := p.skip("}"); ok || err != nil {
+			return set, err
+		}
+	}
+}
+
+func (p *parser) selection() (*Selection, error) {
+	sel := &Selection{Loc: p.tok.loc}
+	var err error
+	if ok, err := p.skip("..."); err != nil {
+		return nil, err
+	} else if ok {
+		if p.tok.kind == tokenName && p.tok.value != "on" {
+			sel.Kind = FragmentSpread
+			if sel.Name, err = p.name(); err != nil {
+				return nil, err
+			}
+			sel.Directives, err = p.directives()
+			return sel, err
+		}
+		sel.Kind = InlineFragment
+		if p.tok.kind == tokenName {
+			if err := p.advance(); err != nil { // "on"
+				return nil, err
+			}
+			if sel.TypeCondition, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if sel.Directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		sel.SelectionSet, err = p.selectionSet()
+		return sel, err
+	}
+
+	sel.Kind = FieldSelection
+	if sel.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		sel.Alias = sel.Name
+		if sel.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if sel.Arguments, err = p.arguments(); err != nil {
+		return nil, err
+	}
+	if sel.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if sel.SelectionSet, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+func (p *parser) arguments() ([]*Argument, error) {
+	if ok, err := p.skip("("); !ok || err != nil {
+		return nil, err
+	}
+	var args []*Argument
+	for {
+		arg := &Argument{Loc: p.tok.loc}
+		var err error
+		if arg.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if arg.Value, err = p.value(false); err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if ok, err := p.skip(")"); ok || err != nil {
+			return args, err
+		}
+	}
+}
+
+func (p *parser) directives() ([]*Directive, error) {
+	var directives []*Directive
+	for p.peek("@") {
+		directive := &Directive{Loc: p.tok.loc}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		var err error
+		if directive.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if directive.Arguments, err = p.arguments(); err != nil {
+			return nil, err
+		}
+		directives = append(directives, directive)
+	}
+	return directives, nil
+}
+
+// value parses a value, constant values may not contain variables
+func (p *parser) value(constant bool) (interface{}, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokenInt:
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, syntaxError(tok.loc, "integer %s is out of range", tok.value)
+		}
+		return int(n), p.advance()
+	case tokenFloat:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, syntaxError(tok.loc, "float %s is out of range", tok.value)
+		}
+		return f, p.advance()
+	case tokenString:
+		return tok.value, p.advance()
+	case tokenName:
+		var v interface{}
+		switch tok.value {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			v = EnumValue(tok.value)
+		}
+		return v, p.advance()
+	}
+
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	switch {
+	case p.peek("$") && !constant:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return Variable(name), err
+	case p.peek("["):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		list := []interface{}{}
+		for !p.peek("]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, p.advance()
+	case p.peek("{"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		object := map[string]interface{}{}
+		for !p.peek("}") {
+			loc := p.tok.loc
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if _, ok := object[name]; ok {
+				return nil, &Error{Message: "There can be only one input field named \"" + name + "\".", Locations: []Location{loc}}
+			}
+			if object[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return object, p.advance()
+	default:
+		return nil, syntaxError(tok.loc, "unexpected %s", tok)
+	}
+}
@@ -0,0 +1,130 @@
+package graphql
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	doc, err := Parse(`
+		# Messages of a user
+		query Messages($user: String!, $limit: Int = 10) @include(if: true) {
+			list: messages(username: $user, limit: $limit, tags: ["a", "b"], filter: {deleted: false, score: -1.5e2}) {
+				id
+				...fields
+				... on Message @skip(if: false) { author { name } }
+			}
+		}
+
+		fragment fields on Message { content, note: text(format: PLAIN) }
+
+		mutation { post(content: """
+			Hello
+			  "world"
+		""", escaped: "tab\tquote\" é") { id } }
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Operations) != 2 || len(doc.Fragments) != 1 {
+		t.Fatalf("Expected 2 operations and 1 fragment, got %d and %d", len(doc.Operations), len(doc.Fragments))
+	}
+
+	query := doc.Operations[0]
+	if query.Type != Query || query.Name != "Messages" || len(query.Directives) != 1 || query.Loc != (Location{Line: 3, Column: 3}) {
+		t.Errorf("Unexpected operation %+v", query)
+	}
+	if len(query.Variables) != 2 || query.Variables[0].Type.String() != "String!" || !query.Variables[1].HasDefault || query.Variables[1].Default != 10 {
+		t.Errorf("Unexpected variables %+v %+v", query.Variables[0], query.Variables[1])
+	}
+	list := query.SelectionSet[0]
+	if list.Alias != "list" || list.Name != "messages" || list.ResponseKey() != "list" || len(list.Arguments) != 4 {
+		t.Fatalf("Unexpected field %+v", list)
+	}
+	wantArgs := []interface{}{
+		Variable("user"),
+		Variable("limit"),
+		[]interface{}{"a", "b"},
+		map[string]interface{}{"deleted": false, "score": -150.0},
+	}
+	for i, want := range wantArgs {
+		if !reflect.DeepEqual(list.Arguments[i].Value, want) {
+			t.Errorf("Argument %s = %#v, want %#v", list.Arguments[i].Name, list.Arguments[i].Value, want)
+		}
+	}
+	kinds := []SelectionKind{FieldSelection, FragmentSpread, InlineFragment}
+	for i, sel := range list.SelectionSet {
+		if sel.Kind != kinds[i] {
+			t.Errorf("Selection %d has kind %v, want %v", i, sel.Kind, kinds[i])
+		}
+	}
+	if inline := list.SelectionSet[2]; inline.TypeCondition != "Message" || inline.Directives[0].Name != "skip" {
+		t.Errorf("Unexpected inline fragment %+v", inline)
+	}
+
+	if fields := doc.Fragments["fields"]; fields.TypeCondition != "Message" || fields.SelectionSet[1].Arguments[0].Value != EnumValue("PLAIN") {
+		t.Errorf("Unexpected fragment %+v", fields)
+	}
+
+	post := doc.Operations[1].SelectionSet[0]
+	if doc.Operations[1].Type != Mutation || post.Arguments[0].Value != "Hello\n  \"world\"" || post.Arguments[1].Value != "tab\tquote\" é" {
+		t.Errorf("Unexpected strings %q %q", post.Arguments[0].Value, post.Arguments[1].Value)
+	}
+}
+
+func TestParseShorthandQuery(t *testing.T) {
+	doc, err := Parse(`{ a b { c } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op := doc.Operations[0]; op.Type != Query || op.Name != "" || len(op.SelectionSet) != 2 {
+		t.Errorf("Unexpected operation %+v", op)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		loc Location
+	}{
+		{"", Location{1, 1}},
+		{"{ a", Location{1, 4}},
+		{"query {\n  a(x: )\n}", Location{2, 8}},
+		{"{ a(x: 01) }", Location{1, 9}},
+		{"{ a(x: \"open) }", Location{1, 16}},
+		{"{ a(x: $v) } fragment on on T { a }", Location{1, 14}},
+		{"{ a(x: {b: 1, b: 2}) }", Location{1, 15}},
+		{"query { a } subscription", Location{1, 25}},
+		{"{ a .. }", Location{1, 5}},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.src)
+		var gqlErr *Error
+		if !errors.As(err, &gqlErr) {
+			t.Errorf("Parse(%q) = %v, want a GraphQL error", tt.src, err)
+			continue
+		}
+		if len(gqlErr.Locations) != 1 || gqlErr.Locations[0] != tt.loc {
+			t.Errorf("Parse(%q) failed at %v, want %v: %s", tt.src, gqlErr.Locations, tt.loc, gqlErr.Message)
+		}
+	}
+}
+
+func TestParseNestingLimit(t *testing.T) {
+	deep := map[string]string{
+		"selections": strings.Repeat("{ a ", maxNesting+1) + strings.Repeat("}", maxNesting+1),
+		"values":     "{ a(x: " + strings.Repeat("[", maxNesting+1) + strings.Repeat("]", maxNesting+1) + ") }",
+		"objects":    "{ a(x: " + strings.Repeat("{b: ", maxNesting+1) + "1" + strings.Repeat("}", maxNesting+1) + ") }",
+		"types":      "query($v: " + strings.Repeat("[", maxNesting+1) + "Int" + strings.Repeat("]", maxNesting+1) + ") { a }",
+	}
+	for name, src := range deep {
+		if _, err := Parse(src); err == nil || !strings.Contains(err.Error(), "nests deeper") {
+			t.Errorf("%s: expected the nesting limit, got %v", name, err)
+		}
+	}
+	if _, err := Parse(strings.Repeat("{ a ", maxNesting) + strings.Repeat("}", maxNesting)); err != nil {
+		t.Errorf("Expected %d levels to parse, got %v", maxNesting, err)
+	}
+}
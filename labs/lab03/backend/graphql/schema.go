@@ -0,0 +1,446 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Schema is an executable schema. Zero limits are not enforced.
+type Schema struct {
+	Query        *Object
+	Mutation     *Object // nil if the schema has no mutations
+	Subscription *Object // nil if the schema has no subscriptions
+
+	MaxDepth      int // deepest allowed field nesting, root fields are depth 1
+	MaxComplexity int // highest allowed sum of field costs, see Field.Complexity
+	MaxSelections int // most selections and fragment spreads an operation may contain
+
+	typesOnce sync.Once
+	types     map[string]Type // input types by name
+}
+
+// Type is a GraphQL type: *Scalar, *Object, *InputObject, *List or *NonNull
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf type
+type Scalar struct {
+	Name string
+	// Serialize converts a resolved value to its JSON representation
+	Serialize func(v interface{}) (interface{}, error)
+	// Parse converts an input value, a literal or a decoded JSON variable, to
+	// the Go value resolvers receive
+	Parse func(v interface{}) (interface{}, error)
+}
+
+func (s *Scalar) String() string { return s.Name }
+
+// Object is an output type with fields
+type Object struct {
+	Name   string
+	Fields Fields
+}
+
+func (o *Object) String() string { return o.Name }
+
+// Fields are the fields of an object by name
+type Fields map[string]*Field
+
+// Field is a field of an object
+type Field struct {
+	Type Type
+	Args Args
+	// Resolve returns the value of the field. Without one the field is
+	// looked up in a map[string]interface{} source.
+	Resolve func(p ResolveParams) (interface{}, error)
+	// Subscribe starts the event stream of a subscription root field. The
+	// channel must be closed once the context is done; every event is
+	// resolved as the source of the field.
+	Subscribe func(p ResolveParams) (<-chan interface{}, error)
+	// Complexity returns the cost of the field given the total cost of its
+	// selections, by default 1 + children. List fields multiply children by
+	// the number of items they may return.
+	Complexity func(args map[string]interface{}, children int) int
+}
+
+// Args are the arguments of a field by name
+type Args map[string]*Arg
+
+// Arg is an argument of a field
+type Arg struct {
+	Type    Type
+	Default interface{} // used when the argument is omitted, if not nil
+}
+
+// ResolveParams are passed to resolvers
+type ResolveParams struct {
+	Context context.Context
+	Source  interface{}            // the value of the parent object
+	Args    map[string]interface{} // coerced arguments, omitted ones are absent
+}
+
+// InputObject is an input type with fields
+type InputObject struct {
+	Name   string
+	Fields InputFields
+}
+
+func (o *InputObject) String() string { return o.Name }
+
+// InputFields are the fields of an input object by name
+type InputFields map[string]*Arg
+
+// List is a list of another type
+type List struct {
+	Of Type
+}
+
+func (l *List) String() string { return "[" + l.Of.String() + "]" }
+
+// NewList returns the list type of t
+func NewList(t Type) *List { return &List{Of: t} }
+
+// NonNull is a type that excludes null
+type NonNull struct {
+	Of Type
+}
+
+func (n *NonNull) String() string { return n.Of.String() + "!" }
+
+// NewNonNull returns the non-null type of t
+func NewNonNull(t Type) *NonNull { return &NonNull{Of: t} }
+
+// Built-in scalars
+var (
+	Int = &Scalar{
+		Name: "Int",
+		Serialize: func(v interface{}) (interface{}, error) {
+			n, ok := toInt(v)
+			if !ok {
+				return nil, fmt.Errorf("Int cannot represent %v", v)
+			}
+			return n, nil
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			n, ok := toInt(v)
+			if !ok {
+				return nil, fmt.Errorf("Int cannot represent %v", describeInput(v))
+			}
+			return n, nil
+		},
+	}
+	Float = &Scalar{
+		Name: "Float",
+		Serialize: func(v interface{}) (interface{}, error) {
+			f, ok := toFloat(v)
+			if !ok {
+				return nil, fmt.Errorf("Float cannot represent %v", v)
+			}
+			return f, nil
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			f, ok := toFloat(v)
+			if !ok {
+				return nil, fmt.Errorf("Float cannot represent %v", describeInput(v))
+			}
+			return f, nil
+		},
+	}
+	String = &Scalar{
+		Name: "String",
+		Serialize: func(v interface{}) (interface{}, error) {
+			switch s := v.(type) {
+			case string:
+				return s, nil
+			case fmt.Stringer:
+				return s.String(), nil
+			}
+			return nil, fmt.Errorf("String cannot represent %v", v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			return nil, fmt.Errorf("String cannot represent %v", describeInput(v))
+		},
+	}
+	Boolean = &Scalar{
+		Name: "Boolean",
+		Serialize: func(v interface{}) (interface{}, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("Boolean cannot represent %v", v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("Boolean cannot represent %v", describeInput(v))
+		},
+	}
+	// ID is serialized as a string and accepts strings and integers
+	ID = &Scalar{
+		Name: "ID",
+		Serialize: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if n, ok := toInt(v); ok {
+				return strconv.Itoa(n), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent %v", v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if n, ok := toInt(v); ok {
+				return strconv.Itoa(n), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent %v", describeInput(v))
+		},
+	}
+)
+
+// toInt converts Go integers and integral floats, as decoded from JSON,
+// within the 32-bit range of GraphQL Int
+func toInt(v interface{}) (int, bool) {
+	var n int64
+	switch x := v.(type) {
+	case float64:
+		if x != math.Trunc(x) {
+			return 0, false
+		}
+		if x < math.MinInt32 || x > math.MaxInt32 {
+			return 0, false
+		}
+		n = int64(x)
+	default:
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = rv.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if rv.Uint() > math.MaxInt32 {
+				return 0, false
+			}
+			n = int64(rv.Uint())
+		default:
+			return 0, false
+		}
+	}
+	if n < math.MinInt32 || n > math.MaxInt32 {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// toFloat converts Go numbers to float64
+func toFloat(v interface{}) (float64, bool) {
+	if f, ok := v.(float64); ok {
+		return f, true
+	}
+	if f, ok := v.(float32); ok {
+		return float64(f), true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	}
+	return 0, false
+}
+
+// describeInput formats an input value for error messages
+func describeInput(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return strconv.Quote(x)
+	case EnumValue:
+		return string(x)
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// coerceInput converts an input value to type t, resolving variables.
+// Unset variables count as null.
+func coerceInput(t Type, v interface{}, vars map[string]interface{}) (interface{}, error) {
+	if name, ok := v.(Variable); ok {
+		v = vars[string(name)]
+		// Variables are already coerced to their declared type
+		if nonNull, ok := t.(*NonNull); ok && v == nil {
+			return nil, fmt.Errorf("expected non-null %s, found null", nonNull.Of)
+		}
+		return v, nil
+	}
+	switch t := t.(type) {
+	case *NonNull:
+		if v == nil {
+			return nil, fmt.Errorf("expected non-null %s, found null", t.Of)
+		}
+		return coerceInput(t.Of, v, vars)
+	}
+	if v == nil {
+		return nil, nil
+	}
+	switch t := t.(type) {
+	case *List:
+		items, ok := v.([]interface{})
+		if !ok {
+			// A single value is coerced to a list of one
+			item, err := coerceInput(t.Of, v, vars)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if list[i], err = coerceInput(t.Of, item, vars); err != nil {
+				return nil, fmt.Errorf("in item %d: %v", i, err)
+			}
+		}
+		return list, nil
+	case *InputObject:
+		fields, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected %s, found %s", t.Name, describeInput(v))
+		}
+		for name := range fields {
+			if _, ok := t.Fields[name]; !ok {
+				return nil, fmt.Errorf("field %q is not defined by type %s", name, t.Name)
+			}
+		}
+		return coerceArgs(t.Fields, fields, vars, "field")
+	case *Scalar:
+		if enum, ok := v.(EnumValue); ok {
+			return nil, fmt.Errorf("%s cannot represent %s", t.Name, enum)
+		}
+		return t.Parse(v)
+	default:
+		return nil, fmt.Errorf("%s is not an input type", t)
+	}
+}
+
+// coerceArgs coerces the given arguments or input fields, applying defaults
+// and reporting missing required ones. Omitted ones without default, and
+// ones given as unset variables, are absent from the result.
+func coerceArgs(defs map[string]*Arg, given map[string]interface{}, vars map[string]interface{}, kind string) (map[string]interface{}, error) {
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names) // stable error messages
+	coerced := make(map[string]interface{}, len(defs))
+	for _, name := range names {
+		def := defs[name]
+		v, ok := given[name]
+		if variable, isVar := v.(Variable); isVar {
+			_, ok = vars[string(variable)]
+		}
+		if !ok {
+			if def.Default != nil {
+				coerced[name] = def.Default
+				continue
+			}
+			if _, required := def.Type.(*NonNull); required {
+				return nil, fmt.Errorf("%s %q of type %s is required", kind, name, def.Type)
+			}
+			continue
+		}
+		value, err := coerceInput(def.Type, v, vars)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q: %v", kind, name, err)
+		}
+		coerced[name] = value
+	}
+	return coerced, nil
+}
+
+// inputType resolves a type written in a document against the schema's
+// input types
+func (s *Schema) inputType(ref *TypeRef) (Type, bool) {
+	var t Type
+	if ref.Elem != nil {
+		elem, ok := s.inputType(ref.Elem)
+		if !ok {
+			return nil, false
+		}
+		t = NewList(elem)
+	} else {
+		s.typesOnce.Do(func() { s.types = s.inputTypes() })
+		named, ok := s.types[ref.Name]
+		if !ok {
+			return nil, false
+		}
+		t = named
+	}
+	if ref.NonNull {
+		t = NewNonNull(t)
+	}
+	return t, true
+}
+
+// inputTypes collects the scalars and input objects used by arguments
+func (s *Schema) inputTypes() map[string]Type {
+	types := map[string]Type{"Int": Int, "Float": Float, "String": String, "Boolean": Boolean, "ID": ID}
+	seen := make(map[*Object]bool)
+	var visitInput func(t Type)
+	visitInput = func(t Type) {
+		switch t := t.(type) {
+		case *NonNull:
+			visitInput(t.Of)
+		case *List:
+			visitInput(t.Of)
+		case *Scalar:
+			types[t.Name] = t
+		case *InputObject:
+			if _, ok := types[t.Name]; ok {
+				return
+			}
+			types[t.Name] = t
+			for _, field := range t.Fields {
+				visitInput(field.Type)
+			}
+		}
+	}
+	var visit func(t Type)
+	visit = func(t Type) {
+		switch t := t.(type) {
+		case *NonNull:
+			visit(t.Of)
+		case *List:
+			visit(t.Of)
+		case *Object:
+			if seen[t] {
+				return
+			}
+			seen[t] = true
+			for _, field := range t.Fields {
+				for _, arg := range field.Args {
+					visitInput(arg.Type)
+				}
+				visit(field.Type)
+			}
+		}
+	}
+	for _, root := range []*Object{s.Query, s.Mutation, s.Subscription} {
+		if root != nil {
+			visit(root)
+		}
+	}
+	return types
+}
@@ -0,0 +1,363 @@
+package graphql
+
+import (
+	"fmt"
+)
+
+// validator checks an operation against the schema and measures its depth
+// and complexity
+type validator struct {
+	schema   *Schema
+	doc      *Document
+	op       *Operation
+	vars     map[string]interface{}
+	varTypes map[string]Type
+	spreads  []string // fragments being expanded, to detect cycles
+	depth    int      // deepest field seen
+
+	fragments  map[fragmentKey]fragmentCost // fragments already checked
+	selections int                          // selections and spreads visited
+}
+
+// fragmentKey identifies a fragment spread on a parent type
+type fragmentKey struct {
+	name, parent string
+}
+
+// fragmentCost is the complexity of a fragment and the depth of its deepest
+// field relative to where it is spread
+type fragmentCost struct {
+	complexity, depth int
+}
+
+// validate reports the first problem of an operation: unknown fields,
+// arguments, fragments or directives, invalid arguments, and operations over
+// the schema's depth or complexity limits
+func (s *Schema) validate(doc *Document, op *Operation, vars map[string]interface{}) error {
+	root := s.Query
+	switch op.Type {
+	case Mutation:
+		root = s.Mutation
+	case Subscription:
+		root = s.Subscription
+	}
+	if root == nil {
+		return &Error{Message: fmt.Sprintf("Schema does not support %s operations.", op.Type), Locations: []Location{op.Loc}}
+	}
+	v := &validator{schema: s, doc: doc, op: op, vars: vars, varTypes: make(map[string]Type), fragments: make(map[fragmentKey]fragmentCost)}
+	for _, def := range op.Variables {
+		if _, ok := v.varTypes[def.Name]; ok {
+			return &Error{Message: fmt.Sprintf("There can be only one variable named \"$%s\".", def.Name), Locations: []Location{def.Loc}}
+		}
+		v.varTypes[def.Name], _ = s.inputType(def.Type) // checked by coerceVariables
+	}
+	if err := v.directives(op.Directives); err != nil {
+		return err
+	}
+
+	if op.Type == Subscription {
+		var fields []*Selection
+		if err := v.rootFields(op.SelectionSet, &fields, make(map[string]bool)); err != nil {
+			return err
+		}
+		if len(fields) != 1 {
+			return &Error{Message: "A subscription must select exactly one top level field.", Locations: []Location{op.Loc}}
+		}
+		if field, ok := root.Fields[fields[0].Name]; ok && field.Subscribe == nil {
+			return &Error{Message: fmt.Sprintf("Field %q cannot be subscribed to.", fields[0].Name), Locations: []Location{fields[0].Loc}}
+		}
+	}
+
+	complexity, err := v.selectionSet(root, op.SelectionSet, 1)
+	if err != nil {
+		return err
+	}
+	if s.MaxDepth > 0 && v.depth > s.MaxDepth {
+		return &Error{Message: fmt.Sprintf("Query depth %d exceeds the limit of %d.", v.depth, s.MaxDepth), Locations: []Location{op.Loc},
+			Extensions: map[string]interface{}{"code": "QUERY_TOO_DEEP", "depth": v.depth, "maxDepth": s.MaxDepth}}
+	}
+	return v.checkComplexity(complexity)
+}
+
+// checkComplexity fails once a cost passes the schema's limit. Field costs
+// never fall below the costs of their children, so the walk stops at the
+// first part of an operation that is already too expensive.
+func (v *validator) checkComplexity(complexity int) error {
+	if max := v.schema.MaxComplexity; max > 0 && complexity > max {
+		return &Error{Message: fmt.Sprintf("Query complexity %d exceeds the limit of %d.", complexity, max), Locations: []Location{v.op.Loc},
+			Extensions: map[string]interface{}{"code": "QUERY_TOO_COMPLEX", "complexity": complexity, "maxComplexity": max}}
+	}
+	return nil
+}
+
+// visit counts a selection or fragment spread against the schema's limit
+func (v *validator) visit(sel *Selection) error {
+	v.selections++
+	if max := v.schema.MaxSelections; max > 0 && v.selections > max {
+		return &Error{Message: fmt.Sprintf("Query has more than %d selections.", max), Locations: []Location{sel.Loc},
+			Extensions: map[string]interface{}{"code": "QUERY_TOO_LARGE", "maxSelections": max}}
+	}
+	return nil
+}
+
+// rootFields collects the fields of a selection set through fragments, each
+// fragment is expanded once since spreading it again adds the same fields
+func (v *validator) rootFields(set []*Selection, fields *[]*Selection, expanded map[string]bool) error {
+	for _, sel := range set {
+		if err := v.visit(sel); err != nil {
+			return err
+		}
+		switch sel.Kind {
+		case FieldSelection:
+			*fields = append(*fields, sel)
+		case FragmentSpread:
+			fragment, ok := v.doc.Fragments[sel.Name]
+			if !ok {
+				return &Error{Message: fmt.Sprintf("Unknown fragment %q.", sel.Name), Locations: []Location{sel.Loc}}
+			}
+			if err := v.enter(sel); err != nil {
+				return err
+			}
+			if expanded[sel.Name] {
+				v.spreads = v.spreads[:len(v.spreads)-1]
+				continue
+			}
+			expanded[sel.Name] = true
+			err := v.rootFields(fragment.SelectionSet, fields, expanded)
+			v.spreads = v.spreads[:len(v.spreads)-1]
+			if err != nil {
+				return err
+			}
+		case InlineFragment:
+			if err := v.rootFields(sel.SelectionSet, fields, expanded); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// enter starts expanding a fragment spread, failing if the fragment is
+// already being expanded. The caller pops v.spreads when done.
+func (v *validator) enter(sel *Selection) error {
+	for _, name := range v.spreads {
+		if name == sel.Name {
+			return &Error{Message: fmt.Sprintf("Cannot spread fragment %q within itself.", sel.Name), Locations: []Location{sel.Loc}}
+		}
+	}
+	v.spreads = append(v.spreads, sel.Name)
+	return nil
+}
+
+// selectionSet checks the selections on obj at a depth and returns their
+// complexity
+func (v *validator) selectionSet(obj *Object, set []*Selection, depth int) (int, error) {
+	total := 0
+	for _, sel := range set {
+		if err := v.visit(sel); err != nil {
+			return 0, err
+		}
+		if err := v.directives(sel.Directives); err != nil {
+			return 0, err
+		}
+		var cost int
+		var err error
+		switch sel.Kind {
+		case FieldSelection:
+			cost, err = v.field(obj, sel, depth)
+		case FragmentSpread:
+			cost, err = v.fragmentSpread(obj, sel, depth)
+		case InlineFragment:
+			if sel.TypeCondition != "" && sel.TypeCondition != obj.Name {
+				return 0, &Error{Message: fmt.Sprintf("Fragment cannot be spread here as objects of type %q can never be of type %q.", obj.Name, sel.TypeCondition), Locations: []Location{sel.Loc}}
+			}
+			cost, err = v.selectionSet(obj, sel.SelectionSet, depth)
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += cost
+		if err := v.checkComplexity(total); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// fragmentSpread checks a fragment the first time it is spread on a type and
+// reuses its cost for later spreads
+func (v *validator) fragmentSpread(obj *Object, sel *Selection, depth int) (int, error) {
+	fragment, ok := v.doc.Fragments[sel.Name]
+	if !ok {
+		return 0, &Error{Message: fmt.Sprintf("Unknown fragment %q.", sel.Name), Locations: []Location{sel.Loc}}
+	}
+	if fragment.TypeCondition != obj.Name {
+		return 0, &Error{Message: fmt.Sprintf("Fragment %q cannot be spread here as objects of type %q can never be of type %q.", sel.Name, obj.Name, fragment.TypeCondition), Locations: []Location{sel.Loc}}
+	}
+	key := fragmentKey{sel.Name, obj.Name}
+	if cost, ok := v.fragments[key]; ok {
+		v.depth = max(v.depth, depth+cost.depth)
+		return cost.complexity, nil
+	}
+	if err := v.directives(fragment.Directives); err != nil {
+		return 0, err
+	}
+	if err := v.enter(sel); err != nil {
+		return 0, err
+	}
+	defer func() { v.spreads = v.spreads[:len(v.spreads)-1] }()
+
+	outer := v.depth
+	v.depth = depth
+	complexity, err := v.selectionSet(obj, fragment.SelectionSet, depth)
+	if err != nil {
+		return 0, err
+	}
+	v.fragments[key] = fragmentCost{complexity: complexity, depth: v.depth - depth}
+	v.depth = max(outer, v.depth)
+	return complexity, nil
+}
+
+func (v *validator) field(obj *Object, sel *Selection, depth int) (int, error) {
+	v.depth = max(v.depth, depth)
+	if sel.Name == "__typename" {
+		if len(sel.SelectionSet) > 0 {
+			return 0, &Error{Message: "Field \"__typename\" must not have a selection since type \"String!\" has no subfields.", Locations: []Location{sel.Loc}}
+		}
+		return 0, nil
+	}
+	field, ok := obj.Fields[sel.Name]
+	if !ok {
+		return 0, &Error{Message: fmt.Sprintf("Cannot query field %q on type %q.", sel.Name, obj.Name), Locations: []Location{sel.Loc}}
+	}
+	args, err := v.arguments(field.Args, sel.Arguments, fmt.Sprintf("field %q", sel.Name), sel.Loc)
+	if err != nil {
+		return 0, err
+	}
+
+	children := 0
+	switch named := namedType(field.Type).(type) {
+	case *Object:
+		if len(sel.SelectionSet) == 0 {
+			return 0, &Error{Message: fmt.Sprintf("Field %q of type %q must have a selection of subfields.", sel.Name, field.Type), Locations: []Location{sel.Loc}}
+		}
+		if children, err = v.selectionSet(named, sel.SelectionSet, depth+1); err != nil {
+			return 0, err
+		}
+	default:
+		if len(sel.SelectionSet) > 0 {
+			return 0, &Error{Message: fmt.Sprintf("Field %q must not have a selection since type %q has no subfields.", sel.Name, field.Type), Locations: []Location{sel.Loc}}
+		}
+	}
+	if field.Complexity != nil {
+		return field.Complexity(args, children), nil
+	}
+	return 1 + children, nil
+}
+
+// arguments checks the arguments of a field or directive and coerces them
+func (v *validator) arguments(defs Args, given []*Argument, owner string, loc Location) (map[string]interface{}, error) {
+	seen := make(map[string]bool)
+	for _, arg := range given {
+		def, ok := defs[arg.Name]
+		if !ok {
+			return nil, &Error{Message: fmt.Sprintf("Unknown argument %q on %s.", arg.Name, owner), Locations: []Location{arg.Loc}}
+		}
+		if seen[arg.Name] {
+			return nil, &Error{Message: fmt.Sprintf("There can be only one argument named %q.", arg.Name), Locations: []Location{arg.Loc}}
+		}
+		seen[arg.Name] = true
+		if err := v.variableUsages(def.Type, arg.Value, arg.Loc); err != nil {
+			return nil, err
+		}
+	}
+	args, err := coerceArgs(defs, argumentMap(given), v.vars, "argument")
+	if err != nil {
+		return nil, &Error{Message: fmt.Sprintf("Invalid arguments on %s: %v.", owner, err), Locations: []Location{loc}}
+	}
+	return args, nil
+}
+
+// variableUsages checks that the variables in a value are defined with a
+// type that fits where they are used
+func (v *validator) variableUsages(t Type, value interface{}, loc Location) error {
+	switch x := value.(type) {
+	case Variable:
+		varType, ok := v.varTypes[string(x)]
+		if !ok {
+			return &Error{Message: fmt.Sprintf("Variable \"$%s\" is not defined.", x), Locations: []Location{loc}}
+		}
+		if !assignable(varType, t) {
+			return &Error{Message: fmt.Sprintf("Variable \"$%s\" of type %s used in position expecting type %s.", x, varType, t), Locations: []Location{loc}}
+		}
+	case []interface{}:
+		if list, ok := nullable(t).(*List); ok {
+			for _, item := range x {
+				if err := v.variableUsages(list.Of, item, loc); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		if object, ok := nullable(t).(*InputObject); ok {
+			for name, item := range x {
+				if field, ok := object.Fields[name]; ok {
+					if err := v.variableUsages(field.Type, item, loc); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// directives checks that only @skip and @include are used
+func (v *validator) directives(directives []*Directive) error {
+	for _, d := range directives {
+		if d.Name != "skip" && d.Name != "include" {
+			return &Error{Message: fmt.Sprintf("Unknown directive \"@%s\".", d.Name), Locations: []Location{d.Loc}}
+		}
+		if _, err := v.arguments(conditionArgs, d.Arguments, "directive \"@"+d.Name+"\"", d.Loc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignable reports whether a variable of type from may be used where to
+// is expected
+func assignable(from, to Type) bool {
+	if toNonNull, ok := to.(*NonNull); ok {
+		fromNonNull, ok := from.(*NonNull)
+		return ok && assignable(fromNonNull.Of, toNonNull.Of)
+	}
+	from = nullable(from)
+	if toList, ok := to.(*List); ok {
+		fromList, ok := from.(*List)
+		return ok && assignable(fromList.Of, toList.Of)
+	}
+	return from == to
+}
+
+// nullable strips NonNull from a type
+func nullable(t Type) Type {
+	if nonNull, ok := t.(*NonNull); ok {
+		return nonNull.Of
+	}
+	return t
+}
+
+// namedType strips List and NonNull from a type
+func namedType(t Type) Type {
+	for {
+		switch wrapped := t.(type) {
+		case *NonNull:
+			t = wrapped.Of
+		case *List:
+			t = wrapped.Of
+		default:
+			return t
+		}
+	}
+}
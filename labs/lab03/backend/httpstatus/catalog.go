@@ -0,0 +1,150 @@
+// Package httpstatus describes HTTP status codes from the IANA registry and
+// serves an image for each of them
+package httpstatus
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Categories of status codes, by their first digit
+const (
+	Informational = "informational"
+	Success       = "success"
+	Redirection   = "redirection"
+	ClientError   = "client_error"
+	ServerError   = "server_error"
+)
+
+// UnknownReason is the reason phrase of codes missing from the registry
+const UnknownReason = "Unknown Status"
+
+// Status describes one HTTP status code
+type Status struct {
+	Code       int    `json:"code"`
+	Reason     string `json:"reason"`
+	Category   string `json:"category"`
+	Reference  string `json:"reference,omitempty"` // defining specification
+	Retryable  bool   `json:"retryable"`           // the same request may succeed later
+	Registered bool   `json:"registered"`          // listed in the IANA registry
+}
+
+// registry holds the IANA "HTTP Status Code Registry", leaving out unassigned
+// ranges and temporary registrations
+var registry = map[int]Status{
+	100: {Reason: "Continue", Reference: "RFC 9110, Section 15.2.1"},
+	101: {Reason: "Switching Protocols", Reference: "RFC 9110, Section 15.2.2"},
+	102: {Reason: "Processing", Reference: "RFC 2518"},
+	103: {Reason: "Early Hints", Reference: "RFC 8297"},
+
+	200: {Reason: "OK", Reference: "RFC 9110, Section 15.3.1"},
+	201: {Reason: "Created", Reference: "RFC 9110, Section 15.3.2"},
+	202: {Reason: "Accepted", Reference: "RFC 9110, Section 15.3.3"},
+	203: {Reason: "Non-Authoritative Information", Reference: "RFC 9110, Section 15.3.4"},
+	204: {Reason: "No Content", Reference: "RFC 9110, Section 15.3.5"},
+	205: {Reason: "Reset Content", Reference: "RFC 9110, Section 15.3.6"},
+	206: {Reason: "Partial Content", Reference: "RFC 9110, Section 15.3.7"},
+	207: {Reason: "Multi-Status", Reference: "RFC 4918"},
+	208: {Reason: "Already Reported", Reference: "RFC 5842"},
+	226: {Reason: "IM Used", Reference: "RFC 3229"},
+
+	300: {Reason: "Multiple Choices", Reference: "RFC 9110, Section 15.4.1"},
+	301: {Reason: "Moved Permanently", Reference: "RFC 9110, Section 15.4.2"},
+	302: {Reason: "Found", Reference: "RFC 9110, Section 15.4.3"},
+	303: {Reason: "See Other", Reference: "RFC 9110, Section 15.4.4"},
+	304: {Reason: "Not Modified", Reference: "RFC 9110, Section 15.4.5"},
+	305: {Reason: "Use Proxy", Reference: "RFC 9110, Section 15.4.6"},
+	306: {Reason: "(Unused)", Reference: "RFC 9110, Section 15.4.7"},
+	307: {Reason: "Temporary Redirect", Reference: "RFC 9110, Section 15.4.8"},
+	308: {Reason: "Permanent Redirect", Reference: "RFC 9110, Section 15.4.9"},
+
+	400: {Reason: "Bad Request", Reference: "RFC 9110, Section 15.5.1"},
+	401: {Reason: "Unauthorized", Reference: "RFC 9110, Section 15.5.2"},
+	402: {Reason: "Payment Required", Reference: "RFC 9110, Section 15.5.3"},
+	403: {Reason: "Forbidden", Reference: "RFC 9110, Section 15.5.4"},
+	404: {Reason: "Not Found", Reference: "RFC 9110, Section 15.5.5"},
+	405: {Reason: "Method Not Allowed", Reference: "RFC 9110, Section 15.5.6"},
+	406: {Reason: "Not Acceptable", Reference: "RFC 9110, Section 15.5.7"},
+	407: {Reason: "Proxy Authentication Required", Reference: "RFC 9110, Section 15.5.8"},
+	408: {Reason: "Request Timeout", Reference: "RFC 9110, Section 15.5.9", Retryable: true},
+	409: {Reason: "Conflict", Reference: "RFC 9110, Section 15.5.10"},
+	410: {Reason: "Gone", Reference: "RFC 9110, Section 15.5.11"},
+	411: {Reason: "Length Required", Reference: "RFC 9110, Section 15.5.12"},
+	412: {Reason: "Precondition Failed", Reference: "RFC 9110, Section 15.5.13"},
+	413: {Reason: "Content Too Large", Reference: "RFC 9110, Section 15.5.14"},
+	414: {Reason: "URI Too Long", Reference: "RFC 9110, Section 15.5.15"},
+	415: {Reason: "Unsupported Media Type", Reference: "RFC 9110, Section 15.5.16"},
+	416: {Reason: "Range Not Satisfiable", Reference: "RFC 9110, Section 15.5.17"},
+	417: {Reason: "Expectation Failed", Reference: "RFC 9110, Section 15.5.18"},
+	418: {Reason: "(Unused)", Reference: "RFC 9110, Section 15.5.19"},
+	421: {Reason: "Misdirected Request", Reference: "RFC 9110, Section 15.5.20"},
+	422: {Reason: "Unprocessable Content", Reference: "RFC 9110, Section 15.5.21"},
+	423: {Reason: "Locked", Reference: "RFC 4918"},
+	424: {Reason: "Failed Dependency", Reference: "RFC 4918"},
+	425: {Reason: "Too Early", Reference: "RFC 8470", Retryable: true},
+	426: {Reason: "Upgrade Required", Reference: "RFC 9110, Section 15.5.22"},
+	428: {Reason: "Precondition Required", Reference: "RFC 6585"},
+	429: {Reason: "Too Many Requests", Reference: "RFC 6585", Retryable: true},
+	431: {Reason: "Request Header Fields Too Large", Reference: "RFC 6585"},
+	451: {Reason: "Unavailable For Legal Reasons", Reference: "RFC 7725"},
+
+	500: {Reason: "Internal Server Error", Reference: "RFC 9110, Section 15.6.1"},
+	501: {Reason: "Not Implemented", Reference: "RFC 9110, Section 15.6.2"},
+	502: {Reason: "Bad Gateway", Reference: "RFC 9110, Section 15.6.3", Retryable: true},
+	503: {Reason: "Service Unavailable", Reference: "RFC 9110, Section 15.6.4", Retryable: true},
+	504: {Reason: "Gateway Timeout", Reference: "RFC 9110, Section 15.6.5", Retryable: true},
+	505: {Reason: "HTTP Version Not Supported", Reference: "RFC 9110, Section 15.6.6"},
+	506: {Reason: "Variant Also Negotiates", Reference: "RFC 2295"},
+	507: {Reason: "Insufficient Storage", Reference: "RFC 4918"},
+	508: {Reason: "Loop Detected", Reference: "RFC 5842"},
+	510: {Reason: "Not Extended (OBSOLETED)", Reference: "RFC 2774"},
+	511: {Reason: "Network Authentication Required", Reference: "RFC 6585"},
+}
+
+// Valid reports whether code lies in the 100-599 range of HTTP status codes
+func Valid(code int) bool {
+	return code >= 100 && code <= 599
+}
+
+// CategoryOf returns the category of a valid status code
+func CategoryOf(code int) string {
+	switch code / 100 {
+	case 1:
+		return Informational
+	case 2:
+		return Success
+	case 3:
+		return Redirection
+	case 4:
+		return ClientError
+	default:
+		return ServerError
+	}
+}
+
+// Lookup describes a status code. Valid codes missing from the registry
+// are described with UnknownReason and Registered false.
+func Lookup(code int) (Status, error) {
+	if !Valid(code) {
+		return Status{}, fmt.Errorf("invalid HTTP status code %d", code)
+	}
+	status, ok := registry[code]
+	if !ok {
+		status.Reason = UnknownReason
+	}
+	status.Code = code
+	status.Category = CategoryOf(code)
+	status.Registered = ok
+	return status, nil
+}
+
+// All returns every registered status code in ascending order
+func All() []Status {
+	all := make([]Status, 0, len(registry))
+	for code := range registry {
+		status, _ := Lookup(code)
+		all = append(all, status)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Code < all[j].Code })
+	return all
+}
@@ -0,0 +1,51 @@
+package httpstatus
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		code       int
+		reason     string
+		category   string
+		retryable  bool
+		registered bool
+	}{
+		{100, "Continue", Informational, false, true},
+		{201, "Created", Success, false, true},
+		{308, "Permanent Redirect", Redirection, false, true},
+		{422, "Unprocessable Content", ClientError, false, true},
+		{429, "Too Many Requests", ClientError, true, true},
+		{503, "Service Unavailable", ServerError, true, true},
+		{299, UnknownReason, Success, false, false},
+	}
+	for _, tt := range tests {
+		status, err := Lookup(tt.code)
+		if err != nil {
+			t.Fatalf("Lookup(%d) failed: %v", tt.code, err)
+		}
+		if status.Code != tt.code || status.Reason != tt.reason || status.Category != tt.category ||
+			status.Retryable != tt.retryable || status.Registered != tt.registered {
+			t.Errorf("Unexpected status %+v", status)
+		}
+	}
+	for _, code := range []int{0, 99, 600} {
+		if _, err := Lookup(code); err == nil {
+			t.Errorf("Expected error for code %d", code)
+		}
+	}
+}
+
+func TestAll(t *testing.T) {
+	all := All()
+	if len(all) != len(registry) {
+		t.Fatalf("Expected %d statuses, got %d", len(registry), len(all))
+	}
+	for i, status := range all {
+		if i > 0 && all[i-1].Code >= status.Code {
+			t.Fatalf("Statuses not sorted at %d", status.Code)
+		}
+		if status.Reference == "" || !status.Registered {
+			t.Errorf("Incomplete registry entry %+v", status)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package httpstatus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Size of generated images
+const (
+	ImageWidth  = 400
+	ImageHeight = 300
+)
+
+// imageExtensions are tried in order when looking up an image file
+var imageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".webp"}
+
+// Images serves an image for every status code, read from a directory of
+// files named after the code (e.g. "404.jpg") or drawn locally, so no
+// external service is needed
+type Images struct {
+	files fs.FS
+
+	mutex     sync.Mutex
+	generated map[int][]byte
+}
+
+// NewImages creates an image set reading from files, nil generates every image
+func NewImages(files fs.FS) *Images {
+	return &Images{files: files, generated: make(map[int][]byte)}
+}
+
+// Image returns the image of a status code and its content type
+func (im *Images) Image(code int) ([]byte, string, error) {
+	if !Valid(code) {
+		return nil, "", fmt.Errorf("invalid HTTP status code %d", code)
+	}
+	if im.files != nil {
+		for _, ext := range imageExtensions {
+			data, err := fs.ReadFile(im.files, strconv.Itoa(code)+ext)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, "", err
+			}
+			return data, http.DetectContentType(data), nil
+		}
+	}
+
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+	data, ok := im.generated[code]
+	if !ok {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, Render(code)); err != nil {
+			return nil, "", err
+		}
+		data = buf.Bytes()
+		im.generated[code] = data
+	}
+	return data, "image/png", nil
+}
+
+// categoryColors are the backgrounds of generated images
+var categoryColors = map[string]color.NRGBA{
+	Informational: {0x42, 0x85, 0xf4, 0xff},
+	Success:       {0x34, 0xa8, 0x53, 0xff},
+	Redirection:   {0xf9, 0xa8, 0x25, 0xff},
+	ClientError:   {0xe8, 0x71, 0x0a, 0xff},
+	ServerError:   {0xd9, 0x30, 0x25, 0xff},
+}
+
+// digits is a 5x7 bitmap font, one row per string
+var digits = [10][7]string{
+	{"01110", "10001", "10011", "10101", "11001", "10001", "01110"},
+	{"00100", "01100", "00100", "00100", "00100", "00100", "01110"},
+	{"01110", "10001", "00001", "00010", "00100", "01000", "11111"},
+	{"11111", "00010", "00100", "00010", "00001", "10001", "01110"},
+	{"00010", "00110", "01010", "10010", "11111", "00010", "00010"},
+	{"11111", "10000", "11110", "00001", "00001", "10001", "01110"},
+	{"00110", "01000", "10000", "11110", "10001", "10001", "01110"},
+	{"11111", "00001", "00010", "00100", "01000", "01000", "01000"},
+	{"01110", "10001", "10001", "01110", "10001", "10001", "01110"},
+	{"01110", "10001", "10001", "01111", "00001", "00010", "01100"},
+}
+
+// glyphScale is the size in pixels of one font dot
+const glyphScale = 16
+
+// Render draws the code in white on the color of its category
+func Render(code int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, ImageWidth, ImageHeight))
+	background := categoryColors[CategoryOf(code)]
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = background.R, background.G, background.B, background.A
+	}
+
+	text := strconv.Itoa(code)
+	glyphWidth, gap := 5*glyphScale, glyphScale
+	width := len(text)*glyphWidth + (len(text)-1)*gap
+	x0, y0 := (ImageWidth-width)/2, (ImageHeight-7*glyphScale)/2
+	white := color.NRGBA{0xff, 0xff, 0xff, 0xff}
+	for i, ch := range text {
+		glyph := digits[ch-'0']
+		left := x0 + i*(glyphWidth+gap)
+		for row, bits := range glyph {
+			for col, bit := range bits {
+				if bit != '1' {
+					continue
+				}
+				for y := 0; y < glyphScale; y++ {
+					for x := 0; x < glyphScale; x++ {
+						img.SetNRGBA(left+col*glyphScale+x, y0+row*glyphScale+y, white)
+					}
+				}
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,50 @@
+package httpstatus
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+	"testing/fstest"
+)
+
+func TestImagesFromFiles(t *testing.T) {
+	jpeg := []byte("\xff\xd8\xff\xe0 not really a jpeg")
+	images := NewImages(fstest.MapFS{"404.jpg": {Data: jpeg}})
+
+	data, contentType, err := images.Image(404)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "image/jpeg" || !bytes.Equal(data, jpeg) {
+		t.Errorf("Expected the 404 file, got %s (%d bytes)", contentType, len(data))
+	}
+}
+
+func TestGeneratedImages(t *testing.T) {
+	images := NewImages(fstest.MapFS{})
+	data, contentType, err := images.Image(503)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "image/png" {
+		t.Errorf("Expected image/png, got %s", contentType)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != ImageWidth || bounds.Dy() != ImageHeight {
+		t.Errorf("Unexpected size %v", bounds)
+	}
+	if r, g, b, _ := img.At(0, 0).RGBA(); r>>8 != 0xd9 || g>>8 != 0x30 || b>>8 != 0x25 {
+		t.Errorf("Expected server error background, got %x %x %x", r>>8, g>>8, b>>8)
+	}
+
+	again, _, _ := images.Image(503)
+	if !bytes.Equal(data, again) {
+		t.Error("Expected generated image to be cached")
+	}
+	if _, _, err := images.Image(700); err == nil {
+		t.Error("Expected error for invalid code")
+	}
+}
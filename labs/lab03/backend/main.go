@@ -1,12 +1,21 @@
 package main
 
 import (
+	"crypto/rand"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"lab03-backend/api"
+	"lab03-backend/auth"
+	"lab03-backend/httpstatus"
 	"lab03-backend/storage"
+
+	"lab02/blob"
+	"lab02/moderation"
 )
 
 func main() {
@@ -22,8 +31,24 @@ func main() {
 	// TODO: Add logging to show server is starting
 	// TODO: Start the server and handle any errors
 
-	storage := storage.NewMemoryStorage()
-	handler := api.NewHandler(storage)
+	store := newStorage()
+	handler := api.NewHandler(store)
+	handler.SetModerator(newModerator(), os.Getenv("ADMIN_TOKEN"))
+	if tokens := newTokenService(); tokens != nil {
+		handler.SetAuth(tokens, editWindow())
+	}
+	blobs, signer := newBlobStore()
+	handler.SetAttachments(blobs, signer, blob.DefaultLimits)
+	if images := newStatusImages(); images != nil {
+		handler.SetStatusImages(images)
+	}
+	if d, ok := v1Deprecation(); ok {
+		handler.SetDeprecation(1, d)
+	}
+	handler.SetGraphQLLimits(
+		graphqlLimit("GRAPHQL_MAX_DEPTH", api.DefaultGraphQLMaxDepth),
+		graphqlLimit("GRAPHQL_MAX_COMPLEXITY", api.DefaultGraphQLMaxComplexity),
+	)
 	router := handler.SetupRoutes()
 	server := &http.Server{
 		Addr:         ":8080",
@@ -38,3 +63,161 @@ func main() {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
+
+// newStorage keeps messages in the SQLite database at DATABASE_PATH, or in
+// memory when it is not set. Messages in memory survive restarts when
+// MEMORY_WAL_DIR names a directory for the write-ahead log.
+func newStorage() storage.MessageStorage {
+	path := os.Getenv("DATABASE_PATH")
+	if path == "" {
+		return newMemoryStorage()
+	}
+	store, err := storage.OpenSQLite(path)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	log.Printf("Messages are stored in %s", path)
+	return store
+}
+
+// newMemoryStorage opens the in-memory storage, logged to MEMORY_WAL_DIR if
+// set. WAL_SYNC is "always" (default), "interval" or "never" and
+// SNAPSHOT_INTERVAL how often the log is compacted (default 5m, 0 never).
+func newMemoryStorage() storage.MessageStorage {
+	dir := os.Getenv("MEMORY_WAL_DIR")
+	if dir == "" {
+		log.Println("DATABASE_PATH not set, messages are kept in memory")
+		return storage.NewMemoryStorage()
+	}
+	opts := storage.PersistOptions{Sync: storage.SyncAlways, SnapshotInterval: storage.DefaultSnapshotInterval}
+	if value := os.Getenv("WAL_SYNC"); value != "" {
+		policy, err := storage.ParseSyncPolicy(value)
+		if err != nil {
+			log.Fatalf("Invalid WAL_SYNC: %v", err)
+		}
+		opts.Sync = policy
+	}
+	if value := os.Getenv("SNAPSHOT_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			log.Fatalf("Invalid SNAPSHOT_INTERVAL %q", value)
+		}
+		opts.SnapshotInterval = interval
+	}
+	store, err := storage.OpenMemoryStorage(dir, opts)
+	if err != nil {
+		log.Fatalf("Failed to restore messages: %v", err)
+	}
+	log.Printf("Messages are kept in memory and logged to %s", dir)
+	return store
+}
+
+// newBlobStore opens the attachment store in ATTACHMENTS_DIR (default
+// "attachments"). Download URLs are signed with ATTACHMENT_KEY, or with a
+// random key that invalidates old links on restart.
+func newBlobStore() (*blob.Store, *blob.Signer) {
+	dir := os.Getenv("ATTACHMENTS_DIR")
+	if dir == "" {
+		dir = "attachments"
+	}
+	store, err := blob.Open(dir, blob.DefaultLimits)
+	if err != nil {
+		log.Fatalf("Failed to open attachment store: %v", err)
+	}
+	key := []byte(os.Getenv("ATTACHMENT_KEY"))
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("Failed to generate attachment key: %v", err)
+		}
+	}
+	return store, blob.NewSigner(key)
+}
+
+// newTokenService verifies bearer tokens signed with AUTH_SECRET, returning
+// nil (anyone may post as anyone) when it is not set
+func newTokenService() *auth.TokenService {
+	secret := os.Getenv("AUTH_SECRET")
+	if secret == "" {
+		log.Println("AUTH_SECRET not set, authentication is disabled")
+		return nil
+	}
+	tokens, err := auth.NewTokenService(secret)
+	if err != nil {
+		log.Fatalf("Failed to create token service: %v", err)
+	}
+	return tokens
+}
+
+// editWindow reads how long authors may edit their messages from
+// EDIT_WINDOW (default 15m, 0 for no limit)
+func editWindow() time.Duration {
+	value := os.Getenv("EDIT_WINDOW")
+	if value == "" {
+		return 15 * time.Minute
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		log.Fatalf("Invalid EDIT_WINDOW %q", value)
+	}
+	return window
+}
+
+// newStatusImages serves status images locally when STATUS_IMAGES is set:
+// "generated" draws every image, any other value names a directory of
+// images like "404.jpg" with generated ones filling the gaps
+func newStatusImages() *httpstatus.Images {
+	switch source := os.Getenv("STATUS_IMAGES"); source {
+	case "":
+		return nil
+	case "generated":
+		return httpstatus.NewImages(nil)
+	default:
+		if _, err := os.Stat(source); err != nil {
+			log.Fatalf("Invalid STATUS_IMAGES: %v", err)
+		}
+		return httpstatus.NewImages(os.DirFS(source))
+	}
+}
+
+// v1Deprecation marks API version 1 deprecated when API_V1_SUNSET (RFC
+// 3339) is set, API_V1_DEPRECATION_LINK may point to a migration guide
+func v1Deprecation() (api.Deprecation, bool) {
+	value := os.Getenv("API_V1_SUNSET")
+	if value == "" {
+		return api.Deprecation{}, false
+	}
+	sunset, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("Invalid API_V1_SUNSET %q", value)
+	}
+	log.Printf("API version 1 is deprecated and retires on %s", sunset.Format(time.RFC3339))
+	return api.Deprecation{Since: time.Now(), Sunset: sunset, Link: os.Getenv("API_V1_DEPRECATION_LINK")}, true
+}
+
+// graphqlLimit reads a GraphQL query limit from the environment (0 for no
+// limit)
+func graphqlLimit(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		log.Fatalf("Invalid %s %q", name, value)
+	}
+	return limit
+}
+
+// newModerator builds the content moderation pipeline, MODERATION_WORDS is a
+// comma-separated list of words to mask
+func newModerator() *moderation.Pipeline {
+	filters := []moderation.Filter{
+		moderation.MaxLength(1000),
+		moderation.NewSpamFilter(time.Minute, 30, 3),
+	}
+	if words := os.Getenv("MODERATION_WORDS"); words != "" {
+		filters = append(filters, moderation.WordList(moderation.Mask, strings.Split(words, ",")...))
+	}
+	return moderation.NewPipeline(filters...)
+}
@@ -0,0 +1,31 @@
+package models
+
+// MaxImportErrors is the most problems an import report lists
+const MaxImportErrors = 100
+
+// ImportReport describes the outcome of a message import
+type ImportReport struct {
+	Format   string        `json:"format"`
+	DryRun   bool          `json:"dry_run"`
+	Atomic   bool          `json:"atomic"`   // messages were created in one transaction
+	Records  int           `json:"records"`  // records read from the file
+	Imported int           `json:"imported"` // messages created, or that would be in a dry run
+	Deleted  int           `json:"deleted"`  // imported messages that are tombstones
+	Invalid  int           `json:"invalid"`
+	Errors   []ImportError `json:"errors,omitempty"` // the first MaxImportErrors problems
+}
+
+// ImportError describes an invalid record of an import
+type ImportError struct {
+	Line  int    `json:"line"`
+	ID    int    `json:"id,omitempty"` // message ID in the file
+	Error string `json:"error"`
+}
+
+// AddError records an invalid record
+func (r *ImportReport) AddError(line, id int, message string) {
+	r.Invalid++
+	if len(r.Errors) < MaxImportErrors {
+		r.Errors = append(r.Errors, ImportError{Line: line, ID: id, Error: message})
+	}
+}
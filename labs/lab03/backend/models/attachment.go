@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"lab02/blob"
+)
+
+// MaxAttachments is the most attachments a single message may reference
+const MaxAttachments = 10
+
+// Attachment is a file attached to a message. URL and ThumbnailURL are
+// signed download links added to each response, they stop working after
+// URLExpiresAt.
+type Attachment struct {
+	blob.Attachment
+	URL          string     `json:"url,omitempty"`
+	ThumbnailURL string     `json:"thumbnail_url,omitempty"`
+	URLExpiresAt *time.Time `json:"url_expires_at,omitempty"`
+}
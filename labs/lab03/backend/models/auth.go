@@ -0,0 +1,32 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// TokenRequest represents an admin's request to issue an API token
+type TokenRequest struct {
+	Username   string `json:"username"`
+	Role       string `json:"role,omitempty"`        // "user" (default) or "moderator"
+	TTLSeconds int    `json:"ttl_seconds,omitempty"` // defaults to 24 hours
+}
+
+// TokenResponse represents an issued API token
+type TokenResponse struct {
+	Token     string    `json:"token"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Validate checks if the token request is valid
+func (r *TokenRequest) Validate() error {
+	if r.Username == "" {
+		return errors.New("username is required")
+	}
+	if r.TTLSeconds < 0 {
+		return errors.New("ttl_seconds must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Batch limits
+const (
+	MaxBatchRequests = 100  // requests in one POST /api/batch
+	MaxBatchMessages = 1000 // messages created or deleted at once
+)
+
+// BatchRequest represents several API requests run at once
+type BatchRequest struct {
+	Atomic   bool               `json:"atomic"` // apply all changes or none
+	Requests []BatchRequestItem `json:"requests"`
+}
+
+// BatchRequestItem is one request of a batch. The Authorization header of
+// the batch applies unless Headers overrides it.
+type BatchRequestItem struct {
+	ID      string            `json:"id,omitempty"` // echoed in the result
+	Method  string            `json:"method"`
+	Path    string            `json:"path"` // e.g. "/api/messages/1"
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// CreateMessagesRequest represents a request to create several messages
+type CreateMessagesRequest struct {
+	Messages []CreateMessageRequest `json:"messages"`
+}
+
+// BatchResponse holds the results of a batch in request order
+type BatchResponse struct {
+	Atomic    bool          `json:"atomic"` // false when changes before a failure were kept
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
+
+// BatchResult is the response to one request of a batch, Body holds JSON
+// responses only
+type BatchResult struct {
+	ID     string          `json:"id,omitempty"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// Validate checks if the batch request is valid
+func (r *BatchRequest) Validate() error {
+	if len(r.Requests) == 0 {
+		return errors.New("requests are required")
+	}
+	if len(r.Requests) > MaxBatchRequests {
+		return fmt.Errorf("at most %d requests are allowed", MaxBatchRequests)
+	}
+	for i, item := range r.Requests {
+		switch item.Method {
+		case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete:
+		default:
+			return fmt.Errorf("request %d: method must be GET, POST, PUT or DELETE", i)
+		}
+		if !strings.HasPrefix(item.Path, "/api/") {
+			return fmt.Errorf("request %d: path must start with /api/", i)
+		}
+	}
+	return nil
+}
+
+// Validate checks if the request to create several messages is valid
+func (r *CreateMessagesRequest) Validate() error {
+	if len(r.Messages) == 0 {
+		return errors.New("messages are required")
+	}
+	if len(r.Messages) > MaxBatchMessages {
+		return fmt.Errorf("at most %d messages are allowed", MaxBatchMessages)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -15,6 +16,41 @@ type Message struct {
 	Username  string    `json:"username"`
 	Content   string    `json:"content"`
 	Timestamp time.Time `json:"timestamp"`
+
+	ParentID  int           `json:"parent_id,omitempty"` // message this one replies to
+	Edits     []MessageEdit `json:"edits,omitempty"`     // previous versions, oldest first
+	EditedAt  *time.Time    `json:"edited_at,omitempty"`
+	Deleted   bool          `json:"deleted,omitempty"` // tombstone, content and edits are cleared
+	DeletedAt *time.Time    `json:"deleted_at,omitempty"`
+	Reactions []Reaction    `json:"reactions,omitempty"`
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// MessageEdit is a previous version of a message
+type MessageEdit struct {
+	Content  string    `json:"content"`
+	EditedAt time.Time `json:"edited_at"`
+}
+
+// Reaction lists the users that reacted to a message with one emoji
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// Highlight is a byte range of Content that matched a search query
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// MessageSearchResult is a message returned by a full-text search
+type MessageSearchResult struct {
+	*Message
+	Score      float64     `json:"score"`
+	Highlights []Highlight `json:"highlights"`
 }
 
 // CreateMessageRequest represents the request to create a new message
@@ -23,6 +59,9 @@ type CreateMessageRequest struct {
 	// TODO: Add Content field of type string with json tag "content" and validation tag "required"
 	Username string `json:"username"`
 	Content  string `json:"content"`
+	ParentID int    `json:"parent_id,omitempty"`
+
+	Attachments []string `json:"attachments,omitempty"` // IDs returned by POST /api/attachments
 }
 
 // UpdateMessageRequest represents the request to update a message
@@ -31,6 +70,12 @@ type UpdateMessageRequest struct {
 	Content string `json:"content"`
 }
 
+// ReactionRequest represents the request to add a reaction to a message
+type ReactionRequest struct {
+	Username string `json:"username"`
+	Emoji    string `json:"emoji"`
+}
+
 // HTTPStatusResponse represents the response for HTTP status code endpoint
 type HTTPStatusResponse struct {
 	// TODO: Add StatusCode field of type int with json tag "status_code"
@@ -38,7 +83,11 @@ type HTTPStatusResponse struct {
 	// TODO: Add Description field of type string with json tag "description"
 	StatusCode  int    `json:"status_code"`
 	ImageURL    string `json:"image_url"`
-	Description string `json:"description"`
+	Description string `json:"description"` // reason phrase
+	Category    string `json:"category"`    // informational, success, redirection, client_error or server_error
+	Reference   string `json:"reference,omitempty"`
+	Retryable   bool   `json:"retryable"`
+	Registered  bool   `json:"registered"`
 }
 
 // APIResponse represents a generic API response
@@ -49,6 +98,8 @@ type APIResponse struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+
+	NextCursor string `json:"next_cursor,omitempty"` // set on paginated lists that have more pages
 }
 
 // NewMessage creates a new message with the current timestamp
@@ -71,9 +122,23 @@ func (r *CreateMessageRequest) Validate() error {
 	if r.Username == "" {
 		return errors.New("username is required")
 	}
-	if r.Content == "" {
+	if r.Content == "" && len(r.Attachments) == 0 {
 		return errors.New("content is required")
 	}
+	if len(r.Attachments) > MaxAttachments {
+		return fmt.Errorf("at most %d attachments are allowed", MaxAttachments)
+	}
+	return nil
+}
+
+// Validate checks if the reaction request is valid
+func (r *ReactionRequest) Validate() error {
+	if r.Username == "" {
+		return errors.New("username is required")
+	}
+	if r.Emoji == "" {
+		return errors.New("emoji is required")
+	}
 	return nil
 }
 
@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+// Message states in API version 2
+const (
+	MessageActive  = "active"
+	MessageDeleted = "deleted"
+)
+
+// MessageV2 is the representation of a message in API version 2. Fields
+// are renamed for clarity and lists are always present, so typed clients
+// need no null checks.
+type MessageV2 struct {
+	ID          int                 `json:"id"`
+	Author      string              `json:"author"`
+	Content     string              `json:"content"`
+	CreatedAt   time.Time           `json:"created_at"`
+	EditedAt    *time.Time          `json:"edited_at"`
+	ReplyTo     *int                `json:"reply_to"` // null for thread roots
+	State       string              `json:"state"`    // "active" or "deleted"
+	DeletedAt   *time.Time          `json:"deleted_at"`
+	Edits       []MessageEdit       `json:"edits"`
+	Reactions   map[string][]string `json:"reactions"` // usernames by emoji
+	Attachments []Attachment        `json:"attachments"`
+}
+
+// MessageSearchResultV2 is a search result in API version 2
+type MessageSearchResultV2 struct {
+	Message    *MessageV2  `json:"message"`
+	Score      float64     `json:"score"`
+	Highlights []Highlight `json:"highlights"`
+}
+
+// NewMessageV2 converts a message to its version 2 representation
+func NewMessageV2(msg *Message) *MessageV2 {
+	v2 := &MessageV2{
+		ID:          msg.ID,
+		Author:      msg.Username,
+		Content:     msg.Content,
+		CreatedAt:   msg.Timestamp,
+		EditedAt:    msg.EditedAt,
+		State:       MessageActive,
+		DeletedAt:   msg.DeletedAt,
+		Edits:       msg.Edits,
+		Reactions:   make(map[string][]string, len(msg.Reactions)),
+		Attachments: msg.Attachments,
+	}
+	if msg.ParentID != 0 {
+		parentID := msg.ParentID
+		v2.ReplyTo = &parentID
+	}
+	if msg.Deleted {
+		v2.State = MessageDeleted
+	}
+	if v2.Edits == nil {
+		v2.Edits = []MessageEdit{}
+	}
+	if v2.Attachments == nil {
+		v2.Attachments = []Attachment{}
+	}
+	for _, r := range msg.Reactions {
+		v2.Reactions[r.Emoji] = r.Users
+	}
+	return v2
+}
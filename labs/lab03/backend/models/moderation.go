@@ -0,0 +1,33 @@
+package models
+
+import "errors"
+
+// ReviewDecisionRequest represents a moderator's decision on a flagged message
+type ReviewDecisionRequest struct {
+	Status   string `json:"status"` // "approved" or "removed"
+	Reviewer string `json:"reviewer"`
+}
+
+// MuteRequest represents the request to mute a user
+type MuteRequest struct {
+	Seconds int `json:"seconds"`
+}
+
+// Validate checks if the review decision is valid
+func (r *ReviewDecisionRequest) Validate() error {
+	if r.Status != "approved" && r.Status != "removed" {
+		return errors.New("status must be approved or removed")
+	}
+	if r.Reviewer == "" {
+		return errors.New("reviewer is required")
+	}
+	return nil
+}
+
+// Validate checks if the mute request is valid
+func (r *MuteRequest) Validate() error {
+	if r.Seconds <= 0 {
+		return errors.New("seconds must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"lab03-backend/models"
+
+	"lab02/blob"
+)
+
+// testMessageStorage is the behaviour every MessageStorage must share.
+// newStorage returns an empty storage for each subtest.
+func testMessageStorage(t *testing.T, newStorage func(t *testing.T) MessageStorage) {
+	t.Run("CRUD", func(t *testing.T) {
+		s := newStorage(t)
+		if s.Count() != 0 || len(s.GetAll()) != 0 {
+			t.Fatalf("Expected empty storage")
+		}
+		created, err := s.Create("alice", "hello")
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if created.ID != 1 || created.Username != "alice" || created.Content != "hello" || created.Timestamp.IsZero() {
+			t.Errorf("Unexpected message %+v", created)
+		}
+		second, _ := s.Create("bob", "hi")
+		if second.ID != 2 {
+			t.Errorf("Expected ID 2, got %d", second.ID)
+		}
+
+		got, err := s.GetByID(created.ID)
+		if err != nil || got.Content != "hello" || !got.Timestamp.Equal(created.Timestamp) {
+			t.Errorf("Unexpected GetByID result %+v %v", got, err)
+		}
+		if all := s.GetAll(); len(all) != 2 || s.Count() != 2 {
+			t.Errorf("Expected 2 messages, got %d (count %d)", len(all), s.Count())
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		s := newStorage(t)
+		if _, err := s.GetByID(999); err != ErrMessageNotFound {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+		if _, err := s.Update(999, "x"); err != ErrMessageNotFound {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+		if err := s.Delete(999); err != ErrMessageNotFound {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+		if _, err := s.React(999, "bob", "👍"); err != ErrMessageNotFound {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+		if _, err := s.Replies(999); err != ErrMessageNotFound {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+		if _, err := s.CreateReply("bob", "?", 999); err != ErrParentNotFound {
+			t.Errorf("Expected ErrParentNotFound, got %v", err)
+		}
+	})
+
+	t.Run("EditHistoryAndTombstones", func(t *testing.T) {
+		s := newStorage(t)
+		original, _ := s.Create("alice", "helo")
+		s.React(original.ID, "bob", "👍")
+
+		updated, err := s.Update(original.ID, "hello")
+		if err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		if updated.Content != "hello" || len(updated.Edits) != 1 || updated.Edits[0].Content != "helo" || updated.EditedAt == nil {
+			t.Errorf("Expected edit history, got %+v", updated)
+		}
+		if len(updated.Reactions) != 1 {
+			t.Errorf("Expected reactions to survive an edit, got %+v", updated.Reactions)
+		}
+		if original.Content != "helo" {
+			t.Errorf("Previously returned message changed to %q", original.Content)
+		}
+
+		if err := s.Delete(original.ID); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		tombstone, err := s.GetByID(original.ID)
+		if err != nil {
+			t.Fatalf("Expected tombstone, got %v", err)
+		}
+		if !tombstone.Deleted || tombstone.Content != "" || tombstone.Edits != nil || tombstone.Reactions != nil || tombstone.DeletedAt == nil {
+			t.Errorf("Unexpected tombstone %+v", tombstone)
+		}
+		if len(s.GetAll()) != 1 || s.Count() != 0 {
+			t.Errorf("Expected tombstone listed but not counted")
+		}
+		if _, err := s.Update(original.ID, "again"); err != ErrMessageDeleted {
+			t.Errorf("Expected ErrMessageDeleted, got %v", err)
+		}
+		if _, err := s.React(original.ID, "bob", "👍"); err != ErrMessageDeleted {
+			t.Errorf("Expected ErrMessageDeleted, got %v", err)
+		}
+		if err := s.Delete(original.ID); err != ErrMessageDeleted {
+			t.Errorf("Expected ErrMessageDeleted, got %v", err)
+		}
+	})
+
+	t.Run("ReactionsAndReplies", func(t *testing.T) {
+		s := newStorage(t)
+		root, _ := s.Create("alice", "ship it?")
+
+		s.React(root.ID, "bob", "👍")
+		s.React(root.ID, "carol", "🎉")
+		s.React(root.ID, "bob", "👍")
+		msg, err := s.React(root.ID, "carol", "👍")
+		if err != nil {
+			t.Fatalf("React failed: %v", err)
+		}
+		if len(msg.Reactions) != 2 || msg.Reactions[0].Emoji != "👍" || msg.Reactions[0].Count != 2 || msg.Reactions[1].Emoji != "🎉" {
+			t.Errorf("Unexpected reactions %+v", msg.Reactions)
+		}
+		msg, _ = s.Unreact(root.ID, "bob", "👍")
+		if msg.Reactions[0].Count != 1 || msg.Reactions[0].Users[0] != "carol" {
+			t.Errorf("Unexpected reactions after unreact %+v", msg.Reactions)
+		}
+		msg, _ = s.Unreact(root.ID, "carol", "🎉")
+		if len(msg.Reactions) != 1 {
+			t.Errorf("Expected emptied reaction to disappear, got %+v", msg.Reactions)
+		}
+
+		s.CreateReply("bob", "yes", root.ID)
+		s.Create("carol", "unrelated")
+		s.CreateReply("carol", "after lunch", root.ID)
+		replies, err := s.Replies(root.ID)
+		if err != nil {
+			t.Fatalf("Replies failed: %v", err)
+		}
+		if len(replies) != 2 || replies[0].Content != "yes" || replies[1].Content != "after lunch" || replies[0].ParentID != root.ID {
+			t.Errorf("Unexpected replies %+v", replies)
+		}
+	})
+
+	t.Run("Attachments", func(t *testing.T) {
+		s := newStorage(t)
+		att := models.Attachment{Attachment: blob.Attachment{ID: "abc", Name: "a.png", MIMEType: "image/png", Size: 3}}
+		created, err := s.CreateReply("alice", "", 0, att)
+		if err != nil {
+			t.Fatalf("CreateReply failed: %v", err)
+		}
+		got, _ := s.GetByID(created.ID)
+		if len(got.Attachments) != 1 || got.Attachments[0].Name != "a.png" || got.Attachments[0].Size != 3 {
+			t.Errorf("Unexpected attachments %+v", got.Attachments)
+		}
+		s.Delete(created.ID)
+		if got, _ := s.GetByID(created.ID); got.Attachments != nil {
+			t.Errorf("Expected tombstone without attachments, got %+v", got.Attachments)
+		}
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		s := newStorage(t)
+		s.Create("alice", "Meeting moved to Friday")
+		second, _ := s.Create("bob", "friday works for me")
+		s.Create("carol", "see you monday")
+
+		if results := s.Search("friday", 0); len(results) != 2 {
+			t.Fatalf("Expected 2 results, got %d", len(results))
+		}
+		s.Update(second.ID, "thursday works for me")
+		s.Delete(1)
+		if results := s.Search("fri*", 0); len(results) != 0 {
+			t.Errorf("Expected no results after update and delete, got %d", len(results))
+		}
+		results := s.Search("thursday", 0)
+		if len(results) != 1 || results[0].ID != second.ID {
+			t.Fatalf("Expected updated message, got %+v", results)
+		}
+		h := results[0].Highlights[0]
+		if got := results[0].Content[h.Start:h.End]; got != "thursday" {
+			t.Errorf("Expected highlight of thursday, got %q", got)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		s := newStorage(t)
+		for i := 0; i < 7; i++ {
+			user := "alice"
+			if i%2 == 1 {
+				user = "bob"
+			}
+			s.Create(user, "message")
+			time.Sleep(time.Millisecond)
+		}
+		third, _ := s.GetByID(3)
+		sixth, _ := s.GetByID(6)
+
+		// Walk all pages in both orders and both sort fields
+		for _, sortField := range []SortField{SortByID, SortByTimestamp} {
+			for _, desc := range []bool{false, true} {
+				var ids []int
+				opts := ListOptions{Limit: 3, Sort: sortField, Desc: desc}
+				for pages := 0; ; pages++ {
+					page, err := s.List(opts)
+					if err != nil {
+						t.Fatalf("List failed: %v", err)
+					}
+					for _, msg := range page.Messages {
+						ids = append(ids, msg.ID)
+					}
+					if page.NextCursor == "" {
+						if pages != 2 {
+							t.Errorf("Expected 3 pages, got %d", pages+1)
+						}
+						break
+					}
+					opts.Cursor = page.NextCursor
+				}
+				want := []int{1, 2, 3, 4, 5, 6, 7}
+				if desc {
+					want = []int{7, 6, 5, 4, 3, 2, 1}
+				}
+				if fmt.Sprint(ids) != fmt.Sprint(want) {
+					t.Errorf("sort=%s desc=%v: expected %v, got %v", sortField, desc, want, ids)
+				}
+			}
+		}
+
+		page, err := s.List(ListOptions{Username: "bob", Since: third.Timestamp, Until: sixth.Timestamp})
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(page.Messages) != 1 || page.Messages[0].ID != 4 || page.NextCursor != "" {
+			t.Errorf("Expected only message 4, got %+v", page)
+		}
+
+		first, _ := s.List(ListOptions{Limit: 1})
+		if _, err := s.List(ListOptions{Cursor: first.NextCursor, Desc: true}); err != ErrInvalidCursor {
+			t.Errorf("Expected ErrInvalidCursor for a cursor of another order, got %v", err)
+		}
+		if _, err := s.List(ListOptions{Cursor: "garbage"}); err != ErrInvalidCursor {
+			t.Errorf("Expected ErrInvalidCursor, got %v", err)
+		}
+		if _, err := s.List(ListOptions{Sort: "content"}); err == nil {
+			t.Errorf("Expected an error for an unknown sort field")
+		}
+	})
+
+	t.Run("Concurrency", func(t *testing.T) {
+		s := newStorage(t)
+		root, _ := s.Create("alice", "root")
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if _, err := s.CreateReply("user", "content", root.ID); err != nil {
+					t.Errorf("Concurrent create failed: %v", err)
+				}
+				if _, err := s.React(root.ID, "user"+string(rune('a'+i)), "👍"); err != nil {
+					t.Errorf("Concurrent react failed: %v", err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if s.Count() != 21 {
+			t.Errorf("Expected 21 messages, got %d", s.Count())
+		}
+		if msg, _ := s.GetByID(root.ID); len(msg.Reactions) != 1 || msg.Reactions[0].Count != 20 {
+			t.Errorf("Expected 20 reactions, got %+v", msg.Reactions)
+		}
+	})
+	t.Run("Atomic", func(t *testing.T) {
+		s := newStorage(t)
+		transactor, ok := s.(Transactor)
+		if !ok {
+			t.Skip("storage is not a Transactor")
+		}
+		s.Create("alice", "original words")
+
+		errAbort := errors.New("abort")
+		err := transactor.Atomic(func(tx MessageStorage) error {
+			tx.Create("bob", "uncommitted")
+			if _, err := tx.Update(1, "changed words"); err != nil {
+				return err
+			}
+			if tx.Count() != 2 || len(tx.Search("uncommitted", 10)) != 1 {
+				t.Errorf("Expected the batch to see its own changes")
+			}
+			return errAbort
+		})
+		if err != errAbort {
+			t.Fatalf("Expected the batch error, got %v", err)
+		}
+		if msg, _ := s.GetByID(1); s.Count() != 1 || msg.Content != "original words" || len(msg.Edits) != 0 {
+			t.Errorf("Expected rollback, got %d messages and %+v", s.Count(), msg)
+		}
+		if len(s.Search("uncommitted", 10)) != 0 || len(s.Search("changed", 10)) != 0 || len(s.Search("original", 10)) != 1 {
+			t.Errorf("Expected the search index to be rolled back")
+		}
+
+		err = transactor.Atomic(func(tx MessageStorage) error {
+			if _, err := tx.Create("bob", "committed"); err != nil {
+				return err
+			}
+			return tx.Delete(1)
+		})
+		if err != nil {
+			t.Fatalf("Atomic failed: %v", err)
+		}
+		if msg, _ := s.GetByID(1); s.Count() != 1 || !msg.Deleted {
+			t.Errorf("Expected committed changes, got %d messages and %+v", s.Count(), msg)
+		}
+		if created, err := s.Create("carol", "after"); err != nil || created.ID != 3 {
+			t.Errorf("Expected ID 3 after the batch, got %+v %v", created, err)
+		}
+	})
+	t.Run("NestedAtomic", func(t *testing.T) {
+		s := newStorage(t)
+		transactor, ok := s.(Transactor)
+		if !ok {
+			t.Skip("storage is not a Transactor")
+		}
+
+		nested := func(outerErr error) error {
+			return transactor.Atomic(func(tx MessageStorage) error {
+				tx.Create("alice", "outer")
+				err := tx.(Transactor).Atomic(func(tx MessageStorage) error {
+					_, err := tx.Create("bob", "inner")
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				return outerErr
+			})
+		}
+		errAbort := errors.New("abort")
+		if err := nested(errAbort); err != errAbort {
+			t.Fatalf("Expected the batch error, got %v", err)
+		}
+		if s.Count() != 0 {
+			t.Errorf("Expected the inner changes to roll back with the outer ones, got %d messages", s.Count())
+		}
+		if err := nested(nil); err != nil {
+			t.Fatalf("Nested Atomic failed: %v", err)
+		}
+		if s.Count() != 2 {
+			t.Errorf("Expected both changes to commit, got %d messages", s.Count())
+		}
+	})
+}
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"lab03-backend/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of change an Event reports
+type EventType string
+
+// Event types
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated" // edits and reactions
+	EventDeleted EventType = "deleted" // Message is the tombstone
+)
+
+// DefaultReplaySize is how many events a feed keeps for resuming clients
+const DefaultReplaySize = 1000
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is dropped
+const subscriberBuffer = 64
+
+// Event is a change to a message, IDs increase by one with every event
+type Event struct {
+	ID      EventID
+	Type    EventType
+	Message *models.Message
+}
+
+// EventID identifies an event. Seq restarts with every feed, Epoch tells
+// the feeds apart so an ID from before a restart is never mistaken for a
+// current one.
+type EventID struct {
+	Epoch string
+	Seq   uint64
+}
+
+// ErrInvalidEventID is returned by ParseEventID
+var ErrInvalidEventID = errors.New("invalid event ID")
+
+// String formats the ID as "<epoch>-<seq>"
+func (id EventID) String() string {
+	return id.Epoch + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// ParseEventID parses an ID formatted by String. A bare sequence number,
+// as sent before IDs had an epoch, parses with an empty Epoch.
+func ParseEventID(s string) (EventID, error) {
+	epoch, seq := "", s
+	if i := strings.LastIndexByte(s, '-'); i >= 0 {
+		epoch, seq = s[:i], s[i+1:]
+		if epoch == "" {
+			return EventID{}, ErrInvalidEventID
+		}
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return EventID{}, ErrInvalidEventID
+	}
+	return EventID{Epoch: epoch, Seq: n}, nil
+}
+
+// Feed fans message events out to subscribers and keeps the most recent
+// ones so reconnecting clients can catch up from the last event they saw
+type Feed struct {
+	mutex       sync.Mutex
+	replay      []Event // ring buffer of the latest events
+	start       int     // index of the oldest event in replay
+	epoch       string  // random, new for every feed
+	lastID      uint64
+	subscribers map[chan Event]struct{}
+}
+
+// NewFeed creates a feed that keeps the last replaySize events
+func NewFeed(replaySize int) *Feed {
+	if replaySize <= 0 {
+		replaySize = DefaultReplaySize
+	}
+	return &Feed{
+		replay:      make([]Event, 0, replaySize),
+		epoch:       newEpoch(),
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish assigns an event ID and delivers the event. Subscribers that are
+// too far behind are dropped, their channel is closed so they can resume.
+func (f *Feed) Publish(eventType EventType, msg *models.Message) Event {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.lastID++
+	ev := Event{ID: EventID{Epoch: f.epoch, Seq: f.lastID}, Type: eventType, Message: msg}
+	if len(f.replay) < cap(f.replay) {
+		f.replay = append(f.replay, ev)
+	} else {
+		f.replay[f.start] = ev
+		f.start = (f.start + 1) % len(f.replay)
+	}
+
+	for ch := range f.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			delete(f.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ev
+}
+
+// Subscribe returns the events after lastID still in the replay buffer and a
+// channel of new events. With resume false only new events are delivered.
+// complete is false if events after lastID were already discarded or lastID
+// is from another feed, the client then has to reload its state. cancel must
+// be called when done.
+func (f *Feed) Subscribe(lastID EventID, resume bool) (missed []Event, events <-chan Event, complete bool, cancel func()) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	complete = true
+	if resume {
+		oldest := f.lastID + 1 - uint64(len(f.replay))
+		if lastID.Epoch != f.epoch || lastID.Seq > f.lastID || lastID.Seq+1 < oldest {
+			// From before a restart, or events were dropped
+			complete = false
+		} else {
+			for i := range f.replay {
+				if ev := f.replay[(f.start+i)%len(f.replay)]; ev.ID.Seq > lastID.Seq {
+					missed = append(missed, ev)
+				}
+			}
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	f.subscribers[ch] = struct{}{}
+	cancel = func() {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		if _, ok := f.subscribers[ch]; ok {
+			delete(f.subscribers, ch)
+			close(ch)
+		}
+	}
+	return missed, ch, complete, cancel
+}
+
+// newEpoch returns a random epoch, or a timestamp if randomness is unavailable
+func newEpoch() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ObservedStorage wraps a MessageStorage and publishes every change to a Feed
+type ObservedStorage struct {
+	MessageStorage
+	feed  *Feed
+	mutex sync.Mutex // publishes changes in the order they were applied
+
+	pending *[]Event // events of an atomic batch, published once it commits
+}
+
+var (
+	_ MessageStorage = (*ObservedStorage)(nil)
+	_ Transactor     = (*ObservedStorage)(nil)
+)
+
+// Observe wraps s so its changes are published to feed
+func Observe(s MessageStorage, feed *Feed) *ObservedStorage {
+	return &ObservedStorage{MessageStorage: s, feed: feed}
+}
+
+// Feed returns the feed changes are published to
+func (obs *ObservedStorage) Feed() *Feed {
+	return obs.feed
+}
+
+// Create adds a new message and publishes it
+func (obs *ObservedStorage) Create(username, content string) (*models.Message, error) {
+	return obs.CreateReply(username, content, 0)
+}
+
+// CreateReply adds a new message to a thread and publishes it
+func (obs *ObservedStorage) CreateReply(username, content string, parentID int, attachments ...models.Attachment) (*models.Message, error) {
+	return obs.publish(EventCreated, func() (*models.Message, error) {
+		return obs.MessageStorage.CreateReply(username, content, parentID, attachments...)
+	})
+}
+
+// Update modifies a message and publishes the new version
+func (obs *ObservedStorage) Update(id int, content string) (*models.Message, error) {
+	return obs.publish(EventUpdated, func() (*models.Message, error) {
+		return obs.MessageStorage.Update(id, content)
+	})
+}
+
+// Delete replaces a message with a tombstone and publishes the tombstone
+func (obs *ObservedStorage) Delete(id int) error {
+	_, err := obs.publish(EventDeleted, func() (*models.Message, error) {
+		if err := obs.MessageStorage.Delete(id); err != nil {
+			return nil, err
+		}
+		return obs.MessageStorage.GetByID(id)
+	})
+	return err
+}
+
+// React adds a reaction and publishes the updated message
+func (obs *ObservedStorage) React(id int, username, emoji string) (*models.Message, error) {
+	return obs.publish(EventUpdated, func() (*models.Message, error) {
+		return obs.MessageStorage.React(id, username, emoji)
+	})
+}
+
+// Unreact removes a reaction and publishes the updated message
+func (obs *ObservedStorage) Unreact(id int, username, emoji string) (*models.Message, error) {
+	return obs.publish(EventUpdated, func() (*models.Message, error) {
+		return obs.MessageStorage.Unreact(id, username, emoji)
+	})
+}
+
+// Atomic runs fn in a transaction of the wrapped storage and publishes its
+// changes once it commits. Inside a transaction fn runs on the same view,
+// its changes commit or roll back with the enclosing ones. It returns
+// ErrNotAtomic if the wrapped storage does not implement Transactor.
+func (obs *ObservedStorage) Atomic(fn func(tx MessageStorage) error) error {
+	if obs.pending != nil {
+		return fn(obs)
+	}
+	transactor, ok := obs.MessageStorage.(Transactor)
+	if !ok {
+		return ErrNotAtomic
+	}
+
+	obs.mutex.Lock()
+	defer obs.mutex.Unlock()
+
+	var pending []Event
+	err := transactor.Atomic(func(tx MessageStorage) error {
+		return fn(&ObservedStorage{MessageStorage: tx, feed: obs.feed, pending: &pending})
+	})
+	if err != nil {
+		return err
+	}
+	for _, event := range pending {
+		obs.feed.Publish(event.Type, event.Message)
+	}
+	return nil
+}
+
+// publish applies a change and publishes its result if it succeeded
+func (obs *ObservedStorage) publish(eventType EventType, change func() (*models.Message, error)) (*models.Message, error) {
+	obs.mutex.Lock()
+	defer obs.mutex.Unlock()
+
+	msg, err := change()
+	if err != nil {
+		return nil, err
+	}
+	if obs.pending != nil {
+		*obs.pending = append(*obs.pending, Event{Type: eventType, Message: msg})
+	} else {
+		obs.feed.Publish(eventType, msg)
+	}
+	return msg, nil
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestFeedReplay(t *testing.T) {
+	feed := NewFeed(3)
+	s := Observe(NewMemoryStorage(), feed)
+
+	msg, _ := s.Create("alice", "hello")
+	s.Update(msg.ID, "hello!")
+	s.React(msg.ID, "bob", "👍")
+	s.Delete(msg.ID)
+	if _, err := s.Update(msg.ID, "again"); err != ErrMessageDeleted {
+		t.Fatalf("Expected ErrMessageDeleted, got %v", err)
+	}
+
+	// Events 2-4 are buffered, the failed update published nothing
+	missed, _, complete, cancel := feed.Subscribe(EventID{Epoch: feed.epoch, Seq: 2}, true)
+	cancel()
+	if !complete || len(missed) != 2 || missed[0].ID != (EventID{Epoch: feed.epoch, Seq: 3}) || missed[1].Type != EventDeleted || !missed[1].Message.Deleted {
+		t.Errorf("Unexpected replay %+v complete=%v", missed, complete)
+	}
+	if _, _, complete, cancel := feed.Subscribe(EventID{Epoch: feed.epoch}, true); complete {
+		t.Errorf("Expected an incomplete replay once event 1 is discarded")
+	} else {
+		cancel()
+	}
+	if _, _, complete, cancel := feed.Subscribe(EventID{Epoch: feed.epoch, Seq: 99}, true); complete {
+		t.Errorf("Expected an incomplete replay for an unknown event ID")
+	} else {
+		cancel()
+	}
+	// A restarted feed numbers its events from 1 again under a new epoch
+	restarted := NewFeed(3)
+	restarted.Publish(EventCreated, nil)
+	restarted.Publish(EventCreated, nil)
+	if _, _, complete, cancel := restarted.Subscribe(EventID{Epoch: feed.epoch, Seq: 1}, true); complete {
+		t.Errorf("Expected an incomplete replay for an ID from another epoch")
+	} else {
+		cancel()
+	}
+	missed, _, complete, cancel = feed.Subscribe(EventID{}, false)
+	cancel()
+	if !complete || len(missed) != 0 {
+		t.Errorf("Expected no replay without resume, got %+v", missed)
+	}
+}
+
+func TestParseEventID(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want EventID
+		err  error
+	}{
+		{"3f2a09bc-42", EventID{Epoch: "3f2a09bc", Seq: 42}, nil},
+		{"42", EventID{Seq: 42}, nil},
+		{"-42", EventID{}, ErrInvalidEventID},
+		{"3f2a09bc-", EventID{}, ErrInvalidEventID},
+		{"abc", EventID{}, ErrInvalidEventID},
+	} {
+		got, err := ParseEventID(tt.in)
+		if got != tt.want || err != tt.err {
+			t.Errorf("ParseEventID(%q) = %+v, %v; want %+v, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+	id := EventID{Epoch: "3f2a09bc", Seq: 7}
+	if got, _ := ParseEventID(id.String()); got != id {
+		t.Errorf("Round trip of %v gave %v", id, got)
+	}
+}
+
+func TestFeedDropsSlowSubscribers(t *testing.T) {
+	feed := NewFeed(0)
+	_, events, _, cancel := feed.Subscribe(EventID{}, false)
+	defer cancel()
+
+	for i := 0; i < subscriberBuffer+1; i++ {
+		feed.Publish(EventCreated, nil)
+	}
+	received := 0
+	for range events {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("Expected %d events before the channel closed, got %d", subscriberBuffer, received)
+	}
+}
+
+func TestObservedStoragePublishesCommittedBatches(t *testing.T) {
+	feed := NewFeed(10)
+	s := Observe(NewMemoryStorage(), feed)
+	_, events, _, cancel := feed.Subscribe(EventID{}, false)
+	defer cancel()
+
+	s.Atomic(func(tx MessageStorage) error {
+		tx.Create("alice", "discarded")
+		return ErrMessageNotFound
+	})
+	err := s.Atomic(func(tx MessageStorage) error {
+		msg, _ := tx.Create("alice", "one")
+		tx.Create("bob", "two")
+		if len(events) != 0 {
+			t.Errorf("Expected no events before the batch commits")
+		}
+		return tx.Delete(msg.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	for _, want := range []EventType{EventCreated, EventCreated, EventDeleted} {
+		if event := <-events; event.Type != want {
+			t.Errorf("Expected %s event, got %+v", want, event)
+		}
+	}
+
+	// A nested batch joins the enclosing one
+	err = s.Atomic(func(tx MessageStorage) error {
+		return tx.(Transactor).Atomic(func(tx MessageStorage) error {
+			_, err := tx.Create("carol", "nested")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := <-events; event.Type != EventCreated || event.Message.Content != "nested" {
+		t.Errorf("Expected the nested change once the batch commits, got %+v", event)
+	}
+
+	plain := Observe(struct{ MessageStorage }{NewMemoryStorage()}, feed)
+	if err := plain.Atomic(func(MessageStorage) error { return nil }); err != ErrNotAtomic {
+		t.Errorf("Expected ErrNotAtomic, got %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"lab03-backend/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SortField is the field listed messages are ordered by, ties are broken by ID
+type SortField string
+
+// Sort fields
+const (
+	SortByID        SortField = "id"
+	SortByTimestamp SortField = "timestamp"
+)
+
+// Page size limits
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+// ErrInvalidCursor is returned for cursors that were not produced by the same sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions selects and orders a page of messages
+type ListOptions struct {
+	Limit    int    // zero means DefaultListLimit, capped at MaxListLimit
+	Cursor   string // NextCursor of the previous page, empty for the first page
+	Sort     SortField
+	Desc     bool
+	Username string    // only messages by this user
+	Since    time.Time // inclusive, zero means unbounded
+	Until    time.Time // exclusive, zero means unbounded
+}
+
+// Page is one page of listed messages, NextCursor is empty on the last page
+type Page struct {
+	Messages   []*models.Message
+	NextCursor string
+}
+
+// cursor is the position after which the next page starts. It carries the
+// sort order so it cannot be replayed against a different one.
+type cursor struct {
+	sort SortField
+	desc bool
+	key  int64
+	id   int
+}
+
+// normalize fills in defaults, validates the options and decodes the cursor
+func (opts ListOptions) normalize() (ListOptions, *cursor, error) {
+	switch opts.Sort {
+	case "":
+		opts.Sort = SortByID
+	case SortByID, SortByTimestamp:
+	default:
+		return opts, nil, fmt.Errorf("cannot sort by %q", opts.Sort)
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultListLimit
+	}
+	if opts.Limit > MaxListLimit {
+		opts.Limit = MaxListLimit
+	}
+	if opts.Cursor == "" {
+		return opts, nil, nil
+	}
+	c, err := decodeCursor(opts.Cursor)
+	if err != nil || c.sort != opts.Sort || c.desc != opts.Desc {
+		return opts, nil, ErrInvalidCursor
+	}
+	return opts, c, nil
+}
+
+// matches reports whether a message passes the filters
+func (opts ListOptions) matches(msg *models.Message) bool {
+	if opts.Username != "" && msg.Username != opts.Username {
+		return false
+	}
+	if !opts.Since.IsZero() && msg.Timestamp.Before(opts.Since) {
+		return false
+	}
+	if !opts.Until.IsZero() && !msg.Timestamp.Before(opts.Until) {
+		return false
+	}
+	return true
+}
+
+// sortKey is the value of the sort field of a message
+func (opts ListOptions) sortKey(msg *models.Message) int64 {
+	if opts.Sort == SortByTimestamp {
+		return msg.Timestamp.UnixNano()
+	}
+	return int64(msg.ID)
+}
+
+// less reports whether a comes before b in the requested order
+func (opts ListOptions) less(a, b *models.Message) bool {
+	ka, kb := opts.sortKey(a), opts.sortKey(b)
+	if ka == kb {
+		return (a.ID < b.ID) != opts.Desc
+	}
+	return (ka < kb) != opts.Desc
+}
+
+// after reports whether a message comes after the cursor position
+func (opts ListOptions) after(msg *models.Message, c *cursor) bool {
+	key := opts.sortKey(msg)
+	if opts.Desc {
+		return key < c.key || key == c.key && msg.ID < c.id
+	}
+	return key > c.key || key == c.key && msg.ID > c.id
+}
+
+// topMessages keeps the first Limit+1 messages in the requested order, so a
+// page costs O(n log limit) rather than sorting every match
+type topMessages struct {
+	opts ListOptions
+	msgs []*models.Message // heap with the message that comes last at the root
+}
+
+func newTopMessages(opts ListOptions) *topMessages {
+	return &topMessages{opts: opts, msgs: make([]*models.Message, 0, opts.Limit+1)}
+}
+
+func (t *topMessages) Len() int           { return len(t.msgs) }
+func (t *topMessages) Less(i, j int) bool { return t.opts.less(t.msgs[j], t.msgs[i]) }
+func (t *topMessages) Swap(i, j int)      { t.msgs[i], t.msgs[j] = t.msgs[j], t.msgs[i] }
+func (t *topMessages) Push(x any)         { t.msgs = append(t.msgs, x.(*models.Message)) }
+func (t *topMessages) Pop() any {
+	last := t.msgs[len(t.msgs)-1]
+	t.msgs = t.msgs[:len(t.msgs)-1]
+	return last
+}
+
+// add keeps msg if it comes before the last message kept so far
+func (t *topMessages) add(msg *models.Message) {
+	if len(t.msgs) <= t.opts.Limit {
+		heap.Push(t, msg)
+		return
+	}
+	if t.opts.less(msg, t.msgs[0]) {
+		t.msgs[0] = msg
+		heap.Fix(t, 0)
+	}
+}
+
+// sorted returns the kept messages in the requested order
+func (t *topMessages) sorted() []*models.Message {
+	sort.Slice(t.msgs, func(i, j int) bool { return t.opts.less(t.msgs[i], t.msgs[j]) })
+	return t.msgs
+}
+
+// page cuts sorted messages down to the limit and sets NextCursor if there
+// are more. msgs may hold one more message than the limit to signal that.
+func (opts ListOptions) page(msgs []*models.Message) Page {
+	if len(msgs) <= opts.Limit {
+		return Page{Messages: msgs}
+	}
+	msgs = msgs[:opts.Limit]
+	last := msgs[len(msgs)-1]
+	next := cursor{sort: opts.Sort, desc: opts.Desc, key: opts.sortKey(last), id: last.ID}
+	return Page{Messages: msgs, NextCursor: next.encode()}
+}
+
+func (c cursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%t:%d:%d", c.sort, c.desc, c.key, c.id)))
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(string(data), ":")
+	if len(parts) != 4 {
+		return nil, ErrInvalidCursor
+	}
+	c := cursor{sort: SortField(parts[0])}
+	if c.desc, err = strconv.ParseBool(parts[1]); err != nil {
+		return nil, err
+	}
+	if c.key, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
+		return nil, err
+	}
+	if c.id, err = strconv.Atoi(parts[3]); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -1,10 +1,14 @@
 package storage
 
 import (
+	"maps"
+	"sort"
 	"sync"
+	"time"
 
-	"errors"
 	"lab03-backend/models"
+
+	"lab02/search"
 )
 
 // MemoryStorage implements in-memory storage for messages
@@ -15,8 +19,15 @@ type MemoryStorage struct {
 	mutex    sync.RWMutex
 	messages map[int]*models.Message
 	nextID   int
+	index    *search.Index
+	log      *writeAheadLog // nil unless opened with OpenMemoryStorage
 }
 
+var (
+	_ MessageStorage = (*MemoryStorage)(nil)
+	_ Transactor     = (*MemoryStorage)(nil)
+)
+
 // NewMemoryStorage creates a new in-memory storage instance
 func NewMemoryStorage() *MemoryStorage {
 	// TODO: Return a new MemoryStorage instance with initialized fields
@@ -25,10 +36,11 @@ func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
 		messages: make(map[int]*models.Message),
 		nextID:   1,
+		index:    search.NewIndex(),
 	}
 }
 
-// GetAll returns all messages
+// GetAll returns all messages ordered by ID
 func (ms *MemoryStorage) GetAll() []*models.Message {
 	// TODO: Implement GetAll method
 	// Use read lock for thread safety
@@ -41,9 +53,58 @@ func (ms *MemoryStorage) GetAll() []*models.Message {
 	for _, msg := range ms.messages {
 		result = append(result, msg)
 	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
 	return result
 }
 
+// List returns a page of messages matching the filters in the requested order
+func (ms *MemoryStorage) List(opts ListOptions) (Page, error) {
+	opts, after, err := opts.normalize()
+	if err != nil {
+		return Page{}, err
+	}
+
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	if opts.Sort == SortByID {
+		return opts.page(ms.listByIDLocked(opts, after)), nil
+	}
+	top := newTopMessages(opts)
+	for _, msg := range ms.messages {
+		if opts.matches(msg) && (after == nil || opts.after(msg, after)) {
+			top.add(msg)
+		}
+	}
+	return opts.page(top.sorted()), nil
+}
+
+// listByIDLocked walks the IDs from the cursor in the requested direction
+// and stops once it has one message more than the limit. The caller must
+// hold the read lock.
+func (ms *MemoryStorage) listByIDLocked(opts ListOptions, after *cursor) []*models.Message {
+	step, id := 1, 1
+	if opts.Desc {
+		step, id = -1, ms.nextID-1
+	}
+	if after != nil {
+		id = after.id + step
+		if opts.Desc {
+			id = min(id, ms.nextID-1)
+		} else {
+			id = max(id, 1)
+		}
+	}
+
+	matches := []*models.Message{}
+	for ; id > 0 && id < ms.nextID && len(matches) <= opts.Limit; id += step {
+		if msg, ok := ms.messages[id]; ok && opts.matches(msg) {
+			matches = append(matches, msg)
+		}
+	}
+	return matches
+}
+
 // GetByID returns a message by its ID
 func (ms *MemoryStorage) GetByID(id int) (*models.Message, error) {
 	// TODO: Implement GetByID method
@@ -69,16 +130,32 @@ func (ms *MemoryStorage) Create(username, content string) (*models.Message, erro
 	// Add message to map
 	// Increment nextID
 	// Return created message
+	return ms.CreateReply(username, content, 0)
+}
+
+// CreateReply adds a new message to the thread of parentID, zero starts a new thread
+func (ms *MemoryStorage) CreateReply(username, content string, parentID int, attachments ...models.Attachment) (*models.Message, error) {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
+	if parentID != 0 {
+		if _, exists := ms.messages[parentID]; !exists {
+			return nil, ErrParentNotFound
+		}
+	}
 	msg := models.NewMessage(ms.nextID, username, content)
+	msg.ParentID = parentID
+	msg.Attachments = attachments
 	ms.messages[ms.nextID] = msg
+	ms.index.Add(uint64(msg.ID), msg.Content, msg.Timestamp.Unix())
+	if err := ms.logLocked("create", msg.ID, nil); err != nil {
+		return nil, err
+	}
 	ms.nextID++
 	return msg, nil
 }
 
-// Update modifies an existing message
+// Update modifies an existing message, the previous content is kept in its edit history
 func (ms *MemoryStorage) Update(id int, content string) (*models.Message, error) {
 	// TODO: Implement Update method
 	// Use write lock for thread safety
@@ -88,16 +165,24 @@ func (ms *MemoryStorage) Update(id int, content string) (*models.Message, error)
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
-	msg, exists := ms.messages[id]
-	if !exists {
-		return nil, ErrMessageNotFound
+	previous := ms.messages[id]
+	msg, err := ms.modifyLocked(id)
+	if err != nil {
+		return nil, err
 	}
 
+	now := time.Now()
+	msg.Edits = append(msg.Edits[:len(msg.Edits):len(msg.Edits)], models.MessageEdit{Content: msg.Content, EditedAt: now})
 	msg.Content = content
+	msg.EditedAt = &now
+	ms.index.Add(uint64(msg.ID), msg.Content, msg.Timestamp.Unix())
+	if err := ms.logLocked("update", id, previous); err != nil {
+		return nil, err
+	}
 	return msg, nil
 }
 
-// Delete removes a message from storage
+// Delete replaces a message with a tombstone that stays visible in GetAll and threads
 func (ms *MemoryStorage) Delete(id int) error {
 	// TODO: Implement Delete method
 	// Use write lock for thread safety
@@ -107,26 +192,240 @@ func (ms *MemoryStorage) Delete(id int) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
-	_, exists := ms.messages[id]
-	if !exists {
-		return ErrMessageNotFound
+	previous := ms.messages[id]
+	msg, err := ms.modifyLocked(id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	msg.Content = ""
+	msg.Edits = nil
+	msg.EditedAt = nil
+	msg.Reactions = nil
+	msg.Attachments = nil
+	msg.Deleted = true
+	msg.DeletedAt = &now
+	ms.index.Remove(uint64(id))
+	return ms.logLocked("delete", id, previous)
+}
+
+// React adds a user's emoji reaction to a message, reacting twice has no effect
+func (ms *MemoryStorage) React(id int, username, emoji string) (*models.Message, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	previous := ms.messages[id]
+	msg, err := ms.modifyLocked(id)
+	if err != nil {
+		return nil, err
+	}
+
+	reactions := cloneReactions(msg.Reactions)
+	for i, r := range reactions {
+		if r.Emoji != emoji {
+			continue
+		}
+		for _, u := range r.Users {
+			if u == username {
+				return msg, nil
+			}
+		}
+		reactions[i].Users = append(r.Users, username)
+		reactions[i].Count = len(reactions[i].Users)
+		msg.Reactions = reactions
+		if err := ms.logLocked("react", id, previous); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+	msg.Reactions = append(reactions, models.Reaction{Emoji: emoji, Count: 1, Users: []string{username}})
+	if err := ms.logLocked("react", id, previous); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Unreact removes a user's emoji reaction from a message
+func (ms *MemoryStorage) Unreact(id int, username, emoji string) (*models.Message, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	previous := ms.messages[id]
+	msg, err := ms.modifyLocked(id)
+	if err != nil {
+		return nil, err
+	}
+
+	reactions := cloneReactions(msg.Reactions)
+	for i, r := range reactions {
+		if r.Emoji != emoji {
+			continue
+		}
+		users := r.Users[:0]
+		for _, u := range r.Users {
+			if u != username {
+				users = append(users, u)
+			}
+		}
+		if len(users) == 0 {
+			reactions = append(reactions[:i], reactions[i+1:]...)
+		} else {
+			reactions[i].Users = users
+			reactions[i].Count = len(users)
+		}
+		break
 	}
-	delete(ms.messages, id)
+	msg.Reactions = reactions
+	if err := ms.logLocked("unreact", id, previous); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Replies returns the messages replying to parentID, oldest first
+func (ms *MemoryStorage) Replies(parentID int) ([]*models.Message, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	if _, exists := ms.messages[parentID]; !exists {
+		return nil, ErrMessageNotFound
+	}
+	replies := []*models.Message{}
+	for _, msg := range ms.messages {
+		if msg.ParentID == parentID {
+			replies = append(replies, msg)
+		}
+	}
+	sort.Slice(replies, func(i, j int) bool { return replies[i].ID < replies[j].ID })
+	return replies, nil
+}
+
+// Atomic runs fn on a copy of the storage that replaces it if fn succeeds.
+// Other callers wait until fn returns.
+func (ms *MemoryStorage) Atomic(fn func(tx MessageStorage) error) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	// Messages are copied on write, so copying the map isolates the view
+	view := &MemoryStorage{messages: maps.Clone(ms.messages), nextID: ms.nextID, index: ms.index}
+	err := fn(view)
+	if err == nil && ms.log != nil {
+		// The whole batch is one record, so recovery applies all of it or none
+		var changed []*models.Message
+		for id, msg := range view.messages {
+			if ms.messages[id] != msg {
+				changed = append(changed, msg)
+			}
+		}
+		if len(changed) > 0 {
+			sort.Slice(changed, func(i, j int) bool { return changed[i].ID < changed[j].ID })
+			err = ms.log.append("batch", changed...)
+		}
+	}
+	if err != nil {
+		// The view shares the index, restore the entries of changed messages
+		for id, msg := range view.messages {
+			if old := ms.messages[id]; old != msg {
+				ms.restoreIndexLocked(id, old)
+			}
+		}
+		return err
+	}
+	ms.messages, ms.nextID = view.messages, view.nextID
 	return nil
 }
 
-// Count returns the total number of messages
+// logLocked appends the new version of message id to the write-ahead log,
+// if there is one. When that fails the change is undone: previous is the
+// version it replaced, nil for a new message. The caller must hold the
+// write lock.
+func (ms *MemoryStorage) logLocked(op string, id int, previous *models.Message) error {
+	if ms.log == nil {
+		return nil
+	}
+	if err := ms.log.append(op, ms.messages[id]); err != nil {
+		if previous == nil {
+			delete(ms.messages, id)
+		} else {
+			ms.messages[id] = previous
+		}
+		ms.restoreIndexLocked(id, previous)
+		return err
+	}
+	return nil
+}
+
+// restoreIndexLocked points the search index entry of id back at old, nil
+// if the message did not exist. The caller must hold the write lock.
+func (ms *MemoryStorage) restoreIndexLocked(id int, old *models.Message) {
+	if old != nil && !old.Deleted {
+		ms.index.Add(uint64(id), old.Content, old.Timestamp.Unix())
+	} else {
+		ms.index.Remove(uint64(id))
+	}
+}
+
+// modifyLocked replaces a live message with a copy and returns the copy to
+// change, so messages already handed to callers never change under them.
+// The caller must hold the write lock.
+func (ms *MemoryStorage) modifyLocked(id int) (*models.Message, error) {
+	msg, exists := ms.messages[id]
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+	if msg.Deleted {
+		return nil, ErrMessageDeleted
+	}
+	clone := *msg
+	ms.messages[id] = &clone
+	return &clone, nil
+}
+
+// cloneReactions copies reactions so the result can be modified
+func cloneReactions(reactions []models.Reaction) []models.Reaction {
+	clone := make([]models.Reaction, len(reactions))
+	for i, r := range reactions {
+		clone[i] = models.Reaction{Emoji: r.Emoji, Count: r.Count, Users: append([]string(nil), r.Users...)}
+	}
+	return clone
+}
+
+// Search returns messages matching a full-text query, most relevant first.
+// Words match case-insensitively, "word*" matches a prefix and quotes match a phrase.
+func (ms *MemoryStorage) Search(query string, limit int) []*models.MessageSearchResult {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	hits := ms.index.Search(query, search.Options{Limit: limit})
+	results := make([]*models.MessageSearchResult, 0, len(hits))
+	for _, hit := range hits {
+		msg, exists := ms.messages[int(hit.ID)]
+		if !exists {
+			continue
+		}
+		highlights := make([]models.Highlight, len(hit.Matches))
+		for i, span := range hit.Matches {
+			highlights[i] = models.Highlight{Start: span.Start, End: span.End}
+		}
+		results = append(results, &models.MessageSearchResult{Message: msg, Score: hit.Score, Highlights: highlights})
+	}
+	return results
+}
+
+// Count returns the number of messages that have not been deleted
 func (ms *MemoryStorage) Count() int {
 	// TODO: Implement Count method
 	// Use read lock for thread safety
 	// Return length of messages map
 	ms.mutex.RLock()
 	defer ms.mutex.RUnlock()
-	return len(ms.messages)
-}
 
-// Common errors
-var (
-	ErrMessageNotFound = errors.New("message not found")
-	ErrInvalidID       = errors.New("invalid message ID")
-)
+	count := 0
+	for _, msg := range ms.messages {
+		if !msg.Deleted {
+			count++
+		}
+	}
+	return count
+}
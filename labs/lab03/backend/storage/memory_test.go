@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"fmt"
 	"testing"
+	"time"
 )
 
 func TestNewMemoryStorage(t *testing.T) {
@@ -116,3 +118,139 @@ func TestMemoryStorageConcurrency(t *testing.T) {
 		t.Errorf("Expected 10 messages after concurrent writes, got %d", count)
 	}
 }
+
+func TestMemoryStorageSearch(t *testing.T) {
+	storage := NewMemoryStorage()
+	storage.Create("alice", "Meeting moved to Friday")
+	second, _ := storage.Create("bob", "friday works for me")
+	storage.Create("carol", "see you monday")
+
+	results := storage.Search("friday", 0)
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+
+	storage.Update(second.ID, "thursday works for me")
+	storage.Delete(1)
+	results = storage.Search("fri*", 0)
+	if len(results) != 0 {
+		t.Errorf("Expected no results after update and delete, got %d", len(results))
+	}
+
+	results = storage.Search("thursday", 0)
+	if len(results) != 1 || results[0].ID != second.ID {
+		t.Fatalf("Expected updated message, got %+v", results)
+	}
+	h := results[0].Highlights[0]
+	if got := results[0].Content[h.Start:h.End]; got != "thursday" {
+		t.Errorf("Expected highlight of thursday, got %q", got)
+	}
+}
+
+func TestMemoryStorageEditHistoryAndTombstones(t *testing.T) {
+	storage := NewMemoryStorage()
+	original, _ := storage.Create("alice", "helo")
+
+	updated, err := storage.Update(original.ID, "hello")
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if len(updated.Edits) != 1 || updated.Edits[0].Content != "helo" || updated.EditedAt == nil {
+		t.Errorf("Expected edit history, got %+v", updated)
+	}
+	if original.Content != "helo" {
+		t.Errorf("Previously returned message changed to %q", original.Content)
+	}
+
+	if err := storage.Delete(original.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	tombstone, err := storage.GetByID(original.ID)
+	if err != nil {
+		t.Fatalf("Expected tombstone, got %v", err)
+	}
+	if !tombstone.Deleted || tombstone.Content != "" || tombstone.Edits != nil || tombstone.DeletedAt == nil {
+		t.Errorf("Unexpected tombstone %+v", tombstone)
+	}
+	if len(storage.GetAll()) != 1 || storage.Count() != 0 {
+		t.Errorf("Expected tombstone listed but not counted")
+	}
+	if _, err := storage.Update(original.ID, "again"); err != ErrMessageDeleted {
+		t.Errorf("Expected ErrMessageDeleted, got %v", err)
+	}
+	if err := storage.Delete(original.ID); err != ErrMessageDeleted {
+		t.Errorf("Expected ErrMessageDeleted, got %v", err)
+	}
+}
+
+func TestMemoryStorageReactionsAndReplies(t *testing.T) {
+	storage := NewMemoryStorage()
+	root, _ := storage.Create("alice", "ship it?")
+
+	storage.React(root.ID, "bob", "👍")
+	storage.React(root.ID, "bob", "👍")
+	msg, err := storage.React(root.ID, "carol", "👍")
+	if err != nil {
+		t.Fatalf("React failed: %v", err)
+	}
+	if len(msg.Reactions) != 1 || msg.Reactions[0].Count != 2 {
+		t.Errorf("Expected 2 thumbs up, got %+v", msg.Reactions)
+	}
+	msg, _ = storage.Unreact(root.ID, "bob", "👍")
+	if msg.Reactions[0].Count != 1 || msg.Reactions[0].Users[0] != "carol" {
+		t.Errorf("Unexpected reactions after unreact %+v", msg.Reactions)
+	}
+
+	if _, err := storage.CreateReply("bob", "?", 99); err != ErrParentNotFound {
+		t.Errorf("Expected ErrParentNotFound, got %v", err)
+	}
+	storage.CreateReply("bob", "yes", root.ID)
+	storage.Create("carol", "unrelated")
+	storage.CreateReply("carol", "after lunch", root.ID)
+	replies, err := storage.Replies(root.ID)
+	if err != nil {
+		t.Fatalf("Replies failed: %v", err)
+	}
+	if len(replies) != 2 || replies[0].Content != "yes" || replies[1].Content != "after lunch" {
+		t.Errorf("Unexpected replies %+v", replies)
+	}
+}
+
+func TestMemoryStorageConformance(t *testing.T) {
+	testMessageStorage(t, func(t *testing.T) MessageStorage { return NewMemoryStorage() })
+}
+
+func TestMemoryStorageListOutOfOrderTimestamps(t *testing.T) {
+	storage := NewMemoryStorage()
+	base := time.Now()
+	// Timestamps need not follow IDs, e.g. after a clock adjustment
+	for _, offset := range []int{5, 1, 4, 2, 3, 0} {
+		msg, _ := storage.Create("alice", "message")
+		storage.messages[msg.ID].Timestamp = base.Add(time.Duration(offset) * time.Second)
+	}
+
+	var ids []int
+	opts := ListOptions{Limit: 4, Sort: SortByTimestamp}
+	for {
+		page, err := storage.List(opts)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		for _, msg := range page.Messages {
+			ids = append(ids, msg.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	if want := []int{6, 2, 4, 5, 3, 1}; fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, ids)
+	}
+
+	// The ID walk starts at the cursor even when it is out of range
+	page, _ := storage.List(ListOptions{Limit: 2, Desc: true, Cursor: cursor{sort: SortByID, desc: true, key: 99, id: 99}.encode()})
+	if len(page.Messages) != 2 || page.Messages[0].ID != 6 || page.Messages[1].ID != 5 {
+		t.Errorf("Expected messages 6 and 5, got %+v", page.Messages)
+	}
+}
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Times are stored as Unix nanoseconds
+CREATE TABLE messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL,
+    content TEXT NOT NULL,
+    timestamp INTEGER NOT NULL,
+    parent_id INTEGER NULL REFERENCES messages(id),
+    edited_at INTEGER NULL,
+    deleted BOOLEAN NOT NULL DEFAULT 0,
+    deleted_at INTEGER NULL
+);
+
+-- Create index for thread lookups
+CREATE INDEX idx_messages_parent_id ON messages(parent_id);
+
+-- Previous versions of edited messages, oldest first by id
+CREATE TABLE message_edits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER NOT NULL REFERENCES messages(id),
+    content TEXT NOT NULL,
+    edited_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_message_edits_message_id ON message_edits(message_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_message_edits_message_id;
+DROP TABLE message_edits;
+DROP INDEX IF EXISTS idx_messages_parent_id;
+DROP TABLE messages;
+-- +goose StatementEnd
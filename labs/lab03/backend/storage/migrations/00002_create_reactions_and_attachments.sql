@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- One row per user and emoji, the first row of an emoji orders it among the others
+CREATE TABLE reactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER NOT NULL REFERENCES messages(id),
+    emoji TEXT NOT NULL,
+    username TEXT NOT NULL,
+    UNIQUE (message_id, emoji, username)
+);
+
+-- Attachments are kept as the JSON array of their metadata
+ALTER TABLE messages ADD COLUMN attachments TEXT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE messages DROP COLUMN attachments;
+DROP TABLE reactions;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- List filters by username and pages in timestamp or id order, ties broken by id
+CREATE INDEX idx_messages_username_id ON messages(username, id);
+CREATE INDEX idx_messages_timestamp_id ON messages(timestamp, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_timestamp_id;
+DROP INDEX IF EXISTS idx_messages_username_id;
+-- +goose StatementEnd
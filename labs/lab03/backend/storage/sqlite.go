@@ -0,0 +1,541 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"lab03-backend/models"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pressly/goose/v3"
+
+	"lab02/search"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// messageColumns is the column list scanMessage expects
+const messageColumns = "id, username, content, timestamp, parent_id, edited_at, deleted, deleted_at, attachments"
+
+// SQLiteStorage keeps messages in a SQLite database so they survive restarts.
+// The full-text index is kept in memory and rebuilt when the database is opened.
+type SQLiteStorage struct {
+	mutex sync.Mutex // serializes writes so the index matches the database
+	db    *sql.DB
+	index *search.Index
+
+	tx      *sql.Tx      // transaction of an atomic batch, nil outside of one
+	changed map[int]bool // messages the batch changed in the index
+}
+
+var (
+	_ MessageStorage = (*SQLiteStorage)(nil)
+	_ Transactor     = (*SQLiteStorage)(nil)
+)
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// OpenSQLite opens or creates the database at path and applies pending migrations
+func OpenSQLite(path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	// A single connection serializes access, which SQLite needs for writes anyway
+	db.SetMaxOpenConns(1)
+
+	if err := RunMigrations(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	ss := &SQLiteStorage{db: db, index: search.NewIndex()}
+	if err := ss.rebuildIndex(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return ss, nil
+}
+
+// RunMigrations applies the embedded migrations that have not run yet
+func RunMigrations(db *sql.DB) error {
+	fsys, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return err
+	}
+	provider, err := goose.NewProvider(goose.DialectSQLite3, db, fsys)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %v", err)
+	}
+	if _, err := provider.Up(context.Background()); err != nil {
+		return fmt.Errorf("failed to run migrations: %v", err)
+	}
+	return nil
+}
+
+// Close closes the database
+func (ss *SQLiteStorage) Close() error {
+	return ss.db.Close()
+}
+
+// GetAll returns all messages ordered by ID, read errors are logged and
+// yield an empty list
+func (ss *SQLiteStorage) GetAll() []*models.Message {
+	msgs, err := ss.query(ss.conn(), "SELECT "+messageColumns+" FROM messages ORDER BY id")
+	if err != nil {
+		log.Printf("Failed to read messages: %v", err)
+		return []*models.Message{}
+	}
+	return msgs
+}
+
+// List returns a page of messages matching the filters in the requested order
+func (ss *SQLiteStorage) List(opts ListOptions) (Page, error) {
+	opts, after, err := opts.normalize()
+	if err != nil {
+		return Page{}, err
+	}
+
+	// Both sort fields are integer columns, so keys compare like sortKey
+	key := "id"
+	if opts.Sort == SortByTimestamp {
+		key = "timestamp"
+	}
+	where := []string{"1 = 1"}
+	args := []any{}
+	if opts.Username != "" {
+		where = append(where, "username = ?")
+		args = append(args, opts.Username)
+	}
+	if !opts.Since.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, opts.Since.UnixNano())
+	}
+	if !opts.Until.IsZero() {
+		where = append(where, "timestamp < ?")
+		args = append(args, opts.Until.UnixNano())
+	}
+	dir, cmp := "ASC", ">"
+	if opts.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if after != nil {
+		where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", key, cmp, key, cmp))
+		args = append(args, after.key, after.key, after.id)
+	}
+	args = append(args, opts.Limit+1)
+
+	msgs, err := ss.query(ss.conn(), fmt.Sprintf("SELECT %s FROM messages WHERE %s ORDER BY %s %s, id %s LIMIT ?",
+		messageColumns, strings.Join(where, " AND "), key, dir, dir), args...)
+	if err != nil {
+		return Page{}, err
+	}
+	return opts.page(msgs), nil
+}
+
+// GetByID returns a message by its ID
+func (ss *SQLiteStorage) GetByID(id int) (*models.Message, error) {
+	return ss.get(ss.conn(), id)
+}
+
+// Create adds a new message to storage
+func (ss *SQLiteStorage) Create(username, content string) (*models.Message, error) {
+	return ss.CreateReply(username, content, 0)
+}
+
+// CreateReply adds a new message to the thread of parentID, zero starts a new thread
+func (ss *SQLiteStorage) CreateReply(username, content string, parentID int, attachments ...models.Attachment) (*models.Message, error) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	var parent sql.NullInt64
+	if parentID != 0 {
+		if _, err := ss.get(ss.conn(), parentID); err == ErrMessageNotFound {
+			return nil, ErrParentNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		parent = sql.NullInt64{Int64: int64(parentID), Valid: true}
+	}
+	var attached sql.NullString
+	if len(attachments) > 0 {
+		data, err := json.Marshal(attachments)
+		if err != nil {
+			return nil, err
+		}
+		attached = sql.NullString{String: string(data), Valid: true}
+	}
+
+	msg := models.NewMessage(0, username, content)
+	res, err := ss.conn().Exec("INSERT INTO messages (username, content, timestamp, parent_id, attachments) VALUES (?, ?, ?, ?, ?)",
+		username, content, msg.Timestamp.UnixNano(), parent, attached)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	msg.ID = int(id)
+	msg.ParentID = parentID
+	msg.Attachments = attachments
+	ss.reindex(msg)
+	return msg, nil
+}
+
+// Update modifies an existing message, the previous content is kept in its edit history
+func (ss *SQLiteStorage) Update(id int, content string) (*models.Message, error) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	var msg *models.Message
+	err := ss.modify(id, func(tx *sql.Tx, current *models.Message) error {
+		now := time.Now().UnixNano()
+		if _, err := tx.Exec("INSERT INTO message_edits (message_id, content, edited_at) VALUES (?, ?, ?)", id, current.Content, now); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE messages SET content = ?, edited_at = ? WHERE id = ?", content, now, id); err != nil {
+			return err
+		}
+		var err error
+		msg, err = ss.get(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	ss.reindex(msg)
+	return msg, nil
+}
+
+// Delete replaces a message with a tombstone that stays visible in GetAll and threads
+func (ss *SQLiteStorage) Delete(id int) error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	err := ss.modify(id, func(tx *sql.Tx, _ *models.Message) error {
+		if _, err := tx.Exec("DELETE FROM message_edits WHERE message_id = ?", id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM reactions WHERE message_id = ?", id); err != nil {
+			return err
+		}
+		_, err := tx.Exec("UPDATE messages SET content = '', edited_at = NULL, attachments = NULL, deleted = 1, deleted_at = ? WHERE id = ?",
+			time.Now().UnixNano(), id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	ss.unindex(id)
+	return nil
+}
+
+// React adds a user's emoji reaction to a message, reacting twice has no effect
+func (ss *SQLiteStorage) React(id int, username, emoji string) (*models.Message, error) {
+	return ss.changeReaction(id, "INSERT OR IGNORE INTO reactions (message_id, emoji, username) VALUES (?, ?, ?)", username, emoji)
+}
+
+// Unreact removes a user's emoji reaction from a message
+func (ss *SQLiteStorage) Unreact(id int, username, emoji string) (*models.Message, error) {
+	return ss.changeReaction(id, "DELETE FROM reactions WHERE message_id = ? AND emoji = ? AND username = ?", username, emoji)
+}
+
+// changeReaction runs a reaction statement taking message ID, emoji and username
+func (ss *SQLiteStorage) changeReaction(id int, stmt, username, emoji string) (*models.Message, error) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	var msg *models.Message
+	err := ss.modify(id, func(tx *sql.Tx, _ *models.Message) error {
+		if _, err := tx.Exec(stmt, id, emoji, username); err != nil {
+			return err
+		}
+		var err error
+		msg, err = ss.get(tx, id)
+		return err
+	})
+	return msg, err
+}
+
+// Replies returns the messages replying to parentID, oldest first
+func (ss *SQLiteStorage) Replies(parentID int) ([]*models.Message, error) {
+	if _, err := ss.get(ss.conn(), parentID); err != nil {
+		return nil, err
+	}
+	return ss.query(ss.conn(), "SELECT "+messageColumns+" FROM messages WHERE parent_id = ? ORDER BY id", parentID)
+}
+
+// Search returns messages matching a full-text query, most relevant first.
+// Words match case-insensitively, "word*" matches a prefix and quotes match a phrase.
+func (ss *SQLiteStorage) Search(query string, limit int) []*models.MessageSearchResult {
+	hits := ss.index.Search(query, search.Options{Limit: limit})
+	results := make([]*models.MessageSearchResult, 0, len(hits))
+	for _, hit := range hits {
+		msg, err := ss.get(ss.conn(), int(hit.ID))
+		if err != nil {
+			continue
+		}
+		highlights := make([]models.Highlight, len(hit.Matches))
+		for i, span := range hit.Matches {
+			highlights[i] = models.Highlight{Start: span.Start, End: span.End}
+		}
+		results = append(results, &models.MessageSearchResult{Message: msg, Score: hit.Score, Highlights: highlights})
+	}
+	return results
+}
+
+// Count returns the number of messages that have not been deleted
+func (ss *SQLiteStorage) Count() int {
+	var count int
+	if err := ss.conn().QueryRow("SELECT COUNT(*) FROM messages WHERE deleted = 0").Scan(&count); err != nil {
+		log.Printf("Failed to count messages: %v", err)
+	}
+	return count
+}
+
+// modify runs change in a transaction if the message exists and is not deleted
+func (ss *SQLiteStorage) modify(id int, change func(tx *sql.Tx, current *models.Message) error) error {
+	if ss.tx != nil {
+		return ss.modifyIn(ss.tx, id, change)
+	}
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := ss.modifyIn(tx, id, change); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// modifyIn runs change within tx if the message exists and is not deleted
+func (ss *SQLiteStorage) modifyIn(tx *sql.Tx, id int, change func(tx *sql.Tx, current *models.Message) error) error {
+	current, err := ss.get(tx, id)
+	if err != nil {
+		return err
+	}
+	if current.Deleted {
+		return ErrMessageDeleted
+	}
+	return change(tx, current)
+}
+
+// Atomic runs fn on a view of the storage inside one transaction, which
+// commits if fn succeeds and rolls back otherwise. Other writers wait until
+// fn returns. On a view fn joins its transaction, a second one would wait
+// for the only connection forever.
+func (ss *SQLiteStorage) Atomic(fn func(tx MessageStorage) error) error {
+	if ss.tx != nil {
+		return fn(ss)
+	}
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	sqlTx, err := ss.db.Begin()
+	if err != nil {
+		return err
+	}
+	// The view shares the index, so a rollback restores what it changed
+	view := &SQLiteStorage{db: ss.db, index: ss.index, tx: sqlTx, changed: make(map[int]bool)}
+	if err = fn(view); err == nil {
+		err = sqlTx.Commit()
+	}
+	if err != nil {
+		sqlTx.Rollback()
+		for id := range view.changed {
+			if msg, getErr := ss.get(ss.db, id); getErr == nil && !msg.Deleted {
+				ss.index.Add(uint64(id), msg.Content, msg.Timestamp.Unix())
+			} else {
+				ss.index.Remove(uint64(id))
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// conn returns the transaction of an atomic batch or the database
+func (ss *SQLiteStorage) conn() querier {
+	if ss.tx != nil {
+		return ss.tx
+	}
+	return ss.db
+}
+
+// reindex adds the current content of a message to the index
+func (ss *SQLiteStorage) reindex(msg *models.Message) {
+	if ss.changed != nil {
+		ss.changed[msg.ID] = true
+	}
+	ss.index.Add(uint64(msg.ID), msg.Content, msg.Timestamp.Unix())
+}
+
+// unindex removes a deleted message from the index
+func (ss *SQLiteStorage) unindex(id int) {
+	if ss.changed != nil {
+		ss.changed[id] = true
+	}
+	ss.index.Remove(uint64(id))
+}
+
+// rebuildIndex adds every live message to the full-text index
+func (ss *SQLiteStorage) rebuildIndex() error {
+	rows, err := ss.db.Query("SELECT id, content, timestamp FROM messages WHERE deleted = 0")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, timestamp int64
+		var content string
+		if err := rows.Scan(&id, &content, &timestamp); err != nil {
+			return err
+		}
+		ss.index.Add(uint64(id), content, time.Unix(0, timestamp).Unix())
+	}
+	return rows.Err()
+}
+
+// get loads one message with its edits and reactions
+func (ss *SQLiteStorage) get(q querier, id int) (*models.Message, error) {
+	msgs, err := ss.query(q, "SELECT "+messageColumns+" FROM messages WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, ErrMessageNotFound
+	}
+	return msgs[0], nil
+}
+
+// query loads the messages selected by a query over messageColumns together
+// with their edits and reactions
+func (ss *SQLiteStorage) query(q querier, query string, args ...any) ([]*models.Message, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	msgs := []*models.Message{}
+	byID := map[int]*models.Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+		byID[msg.ID] = msg
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return msgs, nil
+	}
+
+	// Rows must be closed first, the database has a single connection
+	ids := make([]any, 0, len(msgs))
+	for _, msg := range msgs {
+		ids = append(ids, msg.ID)
+	}
+	in := "(" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ")"
+	if err := loadEdits(q, in, ids, byID); err != nil {
+		return nil, err
+	}
+	if err := loadReactions(q, in, ids, byID); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// loadEdits fills in the edit history of the given messages
+func loadEdits(q querier, in string, ids []any, byID map[int]*models.Message) error {
+	rows, err := q.Query("SELECT message_id, content, edited_at FROM message_edits WHERE message_id IN "+in+" ORDER BY id", ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var edit models.MessageEdit
+		var editedAt int64
+		if err := rows.Scan(&id, &edit.Content, &editedAt); err != nil {
+			return err
+		}
+		edit.EditedAt = time.Unix(0, editedAt)
+		byID[id].Edits = append(byID[id].Edits, edit)
+	}
+	return rows.Err()
+}
+
+// loadReactions fills in the reactions of the given messages, ordered by the
+// first reaction with each emoji like MemoryStorage
+func loadReactions(q querier, in string, ids []any, byID map[int]*models.Message) error {
+	rows, err := q.Query(`SELECT r.message_id, r.emoji, r.username FROM reactions r
+		JOIN (SELECT message_id, emoji, MIN(id) AS first FROM reactions GROUP BY message_id, emoji) f
+		ON f.message_id = r.message_id AND f.emoji = r.emoji
+		WHERE r.message_id IN `+in+` ORDER BY f.first, r.id`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var emoji, username string
+		if err := rows.Scan(&id, &emoji, &username); err != nil {
+			return err
+		}
+		msg := byID[id]
+		if n := len(msg.Reactions); n > 0 && msg.Reactions[n-1].Emoji == emoji {
+			msg.Reactions[n-1].Users = append(msg.Reactions[n-1].Users, username)
+			msg.Reactions[n-1].Count++
+			continue
+		}
+		msg.Reactions = append(msg.Reactions, models.Reaction{Emoji: emoji, Count: 1, Users: []string{username}})
+	}
+	return rows.Err()
+}
+
+// scanMessage reads a row of messageColumns
+func scanMessage(rows *sql.Rows) (*models.Message, error) {
+	var msg models.Message
+	var timestamp int64
+	var parentID, editedAt, deletedAt sql.NullInt64
+	var attachments sql.NullString
+	if err := rows.Scan(&msg.ID, &msg.Username, &msg.Content, &timestamp, &parentID, &editedAt, &msg.Deleted, &deletedAt, &attachments); err != nil {
+		return nil, err
+	}
+	msg.Timestamp = time.Unix(0, timestamp)
+	msg.ParentID = int(parentID.Int64)
+	if editedAt.Valid {
+		t := time.Unix(0, editedAt.Int64)
+		msg.EditedAt = &t
+	}
+	if deletedAt.Valid {
+		t := time.Unix(0, deletedAt.Int64)
+		msg.DeletedAt = &t
+	}
+	if attachments.Valid {
+		if err := json.Unmarshal([]byte(attachments.String), &msg.Attachments); err != nil {
+			return nil, err
+		}
+	}
+	return &msg, nil
+}
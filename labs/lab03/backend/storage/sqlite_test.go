@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestSQLite(t *testing.T, path string) *SQLiteStorage {
+	t.Helper()
+	s, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("OpenSQLite failed: %v", err)
+	}
+	return s
+}
+
+func TestSQLiteStorageConformance(t *testing.T) {
+	testMessageStorage(t, func(t *testing.T) MessageStorage {
+		s := openTestSQLite(t, filepath.Join(t.TempDir(), "messages.db"))
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestSQLiteStoragePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	s := openTestSQLite(t, path)
+	root, _ := s.Create("alice", "release notes are ready")
+	s.Update(root.ID, "release notes are done")
+	s.React(root.ID, "bob", "👍")
+	s.CreateReply("bob", "thanks", root.ID)
+	deleted, _ := s.Create("carol", "oops")
+	s.Delete(deleted.ID)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	s = openTestSQLite(t, path)
+	defer s.Close()
+	msg, err := s.GetByID(root.ID)
+	if err != nil {
+		t.Fatalf("GetByID after reopen failed: %v", err)
+	}
+	if msg.Content != "release notes are done" || len(msg.Edits) != 1 || len(msg.Reactions) != 1 {
+		t.Errorf("Unexpected message after reopen %+v", msg)
+	}
+	if s.Count() != 2 || len(s.GetAll()) != 3 {
+		t.Errorf("Expected 2 live messages and a tombstone, got %d and %d", s.Count(), len(s.GetAll()))
+	}
+	if results := s.Search("done", 0); len(results) != 1 || results[0].ID != root.ID {
+		t.Errorf("Expected search index to be rebuilt, got %+v", results)
+	}
+	if results := s.Search("oops", 0); len(results) != 0 {
+		t.Errorf("Expected deleted message to stay out of the index, got %+v", results)
+	}
+	if next, _ := s.Create("dave", "new"); next.ID != 4 {
+		t.Errorf("Expected IDs to continue at 4, got %d", next.ID)
+	}
+}
+
+func TestRunMigrationsIsIdempotent(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 2; i++ {
+		if err := RunMigrations(db); err != nil {
+			t.Fatalf("RunMigrations run %d failed: %v", i+1, err)
+		}
+	}
+	var version int
+	if err := db.QueryRow("SELECT MAX(version_id) FROM goose_db_version").Scan(&version); err != nil || version != 3 {
+		t.Errorf("Expected schema version 3, got %d (%v)", version, err)
+	}
+}
+
+func TestSQLiteListUsesIndexes(t *testing.T) {
+	s := openTestSQLite(t, filepath.Join(t.TempDir(), "messages.db"))
+	defer s.Close()
+
+	tests := []struct {
+		query string
+		index string
+	}{
+		{"SELECT id FROM messages WHERE username = ? AND id > ? ORDER BY id LIMIT 10", "idx_messages_username_id"},
+		{"SELECT id FROM messages WHERE timestamp > ? OR (timestamp = ? AND id > ?) ORDER BY timestamp, id LIMIT 10", "idx_messages_timestamp_id"},
+	}
+	for _, tt := range tests {
+		rows, err := s.db.Query("EXPLAIN QUERY PLAN "+tt.query, 1, 2, 3)
+		if err != nil {
+			t.Fatalf("EXPLAIN failed: %v", err)
+		}
+		var plan string
+		for rows.Next() {
+			var id, parent, unused int
+			var detail string
+			if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			plan += detail + "\n"
+		}
+		rows.Close()
+		if !strings.Contains(plan, tt.index) {
+			t.Errorf("Expected %q to use %s, plan:\n%s", tt.query, tt.index, plan)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"errors"
+	"lab03-backend/models"
+)
+
+// MessageStorage is implemented by every message backend the API can run on.
+// Returned messages belong to the caller and never change afterwards.
+type MessageStorage interface {
+	// GetAll returns every message including tombstones, ordered by ID
+	GetAll() []*models.Message
+	// List returns a page of messages including tombstones
+	List(opts ListOptions) (Page, error)
+	// GetByID returns a message or its tombstone
+	GetByID(id int) (*models.Message, error)
+	// Create adds a new message
+	Create(username, content string) (*models.Message, error)
+	// CreateReply adds a new message to the thread of parentID, zero starts a new thread
+	CreateReply(username, content string, parentID int, attachments ...models.Attachment) (*models.Message, error)
+	// Update replaces the content of a message, keeping the previous version in Edits
+	Update(id int, content string) (*models.Message, error)
+	// Delete replaces a message with a tombstone
+	Delete(id int) error
+	// React adds a user's emoji reaction, reacting twice has no effect
+	React(id int, username, emoji string) (*models.Message, error)
+	// Unreact removes a user's emoji reaction
+	Unreact(id int, username, emoji string) (*models.Message, error)
+	// Replies returns the messages replying to parentID, oldest first
+	Replies(parentID int) ([]*models.Message, error)
+	// Search returns messages matching a full-text query, most relevant first
+	Search(query string, limit int) []*models.MessageSearchResult
+	// Count returns the number of messages that have not been deleted
+	Count() int
+}
+
+// Transactor is implemented by storages that can apply several changes atomically
+type Transactor interface {
+	// Atomic runs fn on a view of the storage whose changes are all applied
+	// if fn returns nil and discarded otherwise
+	Atomic(fn func(tx MessageStorage) error) error
+}
+
+// Common errors
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	ErrInvalidID       = errors.New("invalid message ID")
+	ErrParentNotFound  = errors.New("parent message not found")
+	ErrMessageDeleted  = errors.New("message has been deleted")
+	ErrNotAtomic       = errors.New("storage does not support atomic changes")
+)
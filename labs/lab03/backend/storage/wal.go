@@ -0,0 +1,487 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lab03-backend/models"
+)
+
+// SyncPolicy decides when the write-ahead log is flushed to disk
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes before a change returns, nothing acknowledged is lost
+	SyncAlways SyncPolicy = iota
+	// SyncInterval flushes in the background, a crash loses at most one interval
+	SyncInterval
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+)
+
+// ParseSyncPolicy parses "always", "interval" or "never"
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "never":
+		return SyncNever, nil
+	}
+	return 0, fmt.Errorf("unknown sync policy %q", s)
+}
+
+// Default persistence settings
+const (
+	DefaultSyncInterval     = time.Second
+	DefaultSnapshotInterval = 5 * time.Minute
+)
+
+// PersistOptions configure a persistent MemoryStorage
+type PersistOptions struct {
+	Sync SyncPolicy
+	// SyncInterval is how often SyncInterval flushes, default DefaultSyncInterval
+	SyncInterval time.Duration
+	// SnapshotInterval is how often the log is compacted into a snapshot,
+	// zero disables periodic snapshots
+	SnapshotInterval time.Duration
+}
+
+// ErrCorruptLog is returned when a log record other than the last one is damaged
+var ErrCorruptLog = errors.New("write-ahead log is corrupt")
+
+const (
+	snapshotFile = "snapshot.json"
+	walPrefix    = "wal-"
+	walSuffix    = ".log"
+	// recordHeader is the length and CRC-32C of the payload, little endian
+	recordHeader = 8
+	// maxRecordSize guards against reading a damaged length
+	maxRecordSize = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walRecord is one change: the new versions of the messages it touched
+type walRecord struct {
+	Op       string            `json:"op"`
+	Messages []*models.Message `json:"messages"`
+}
+
+// snapshot is the state at the start of log generation Generation
+type snapshot struct {
+	Generation uint64            `json:"generation"`
+	NextID     int               `json:"next_id"`
+	Messages   []*models.Message `json:"messages"`
+}
+
+// writeAheadLog appends changes to numbered log files in dir. A snapshot
+// starts a new file, and files older than the latest snapshot are removed.
+type writeAheadLog struct {
+	dir  string
+	opts PersistOptions
+
+	mu         sync.Mutex // guards the fields below against the background flush
+	file       *os.File
+	generation uint64
+	size       int64 // bytes of whole records in file
+	records    int   // records since the last snapshot
+	dirty      bool  // written since the last flush
+	err        error // set when a failed write could not be undone
+
+	snapshotMu sync.Mutex // one snapshot at a time
+	stop       chan struct{}
+	stopOnce   sync.Once
+	wg         sync.WaitGroup
+}
+
+// OpenMemoryStorage opens a MemoryStorage that logs every change to dir and
+// restores the snapshot and log found there. A record cut short by a crash
+// at the end of the log is dropped.
+func OpenMemoryStorage(dir string, opts PersistOptions) (*MemoryStorage, error) {
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	ms := NewMemoryStorage()
+	wal := &writeAheadLog{dir: dir, opts: opts, stop: make(chan struct{})}
+
+	snap, err := readSnapshot(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range snap.Messages {
+		ms.messages[msg.ID] = msg
+	}
+	ms.nextID = max(ms.nextID, snap.NextID)
+
+	generations, err := wal.generations()
+	if err != nil {
+		return nil, err
+	}
+	wal.generation = snap.Generation
+	for _, gen := range generations {
+		if gen < snap.Generation {
+			// Already part of the snapshot
+			os.Remove(wal.path(gen))
+			continue
+		}
+		records, size, err := replayLog(wal.path(gen), ms.messages)
+		if err != nil {
+			return nil, err
+		}
+		wal.generation, wal.size, wal.records = gen, size, wal.records+records
+	}
+
+	for id, msg := range ms.messages {
+		ms.nextID = max(ms.nextID, id+1)
+		if !msg.Deleted {
+			ms.index.Add(uint64(id), msg.Content, msg.Timestamp.Unix())
+		}
+	}
+
+	wal.file, err = os.OpenFile(wal.path(wal.generation), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := wal.file.Seek(wal.size, io.SeekStart); err != nil {
+		wal.file.Close()
+		return nil, err
+	}
+	if err := syncDir(dir); err != nil {
+		wal.file.Close()
+		return nil, err
+	}
+	ms.log = wal
+
+	if opts.Sync == SyncInterval {
+		wal.every(opts.SyncInterval, func() {
+			if err := wal.flush(); err != nil {
+				log.Printf("Failed to flush the write-ahead log: %v", err)
+			}
+		})
+	}
+	if opts.SnapshotInterval > 0 {
+		wal.every(opts.SnapshotInterval, func() {
+			if err := ms.Snapshot(); err != nil {
+				log.Printf("Failed to write a snapshot: %v", err)
+			}
+		})
+	}
+	return ms, nil
+}
+
+// Snapshot writes all messages to a snapshot and drops the log it replaces,
+// so recovery replays only changes made afterwards. Writers are blocked only
+// while the log moves to a new file.
+func (ms *MemoryStorage) Snapshot() error {
+	wal := ms.log
+	if wal == nil {
+		return nil
+	}
+	wal.snapshotMu.Lock()
+	defer wal.snapshotMu.Unlock()
+
+	ms.mutex.Lock()
+	if wal.records == 0 {
+		ms.mutex.Unlock()
+		return nil
+	}
+	// Messages are copied on write, so the collected ones stay as they are now
+	snap := snapshot{NextID: ms.nextID, Messages: make([]*models.Message, 0, len(ms.messages))}
+	for _, msg := range ms.messages {
+		snap.Messages = append(snap.Messages, msg)
+	}
+	gen, err := wal.rotate()
+	ms.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(snap.Messages, func(i, j int) bool { return snap.Messages[i].ID < snap.Messages[j].ID })
+	snap.Generation = gen
+	if err := writeSnapshot(wal.dir, snap); err != nil {
+		return err
+	}
+	generations, err := wal.generations()
+	if err != nil {
+		return err
+	}
+	for _, old := range generations {
+		if old < gen {
+			os.Remove(wal.path(old))
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the log of a MemoryStorage opened with
+// OpenMemoryStorage, later changes fail
+func (ms *MemoryStorage) Close() error {
+	wal := ms.log
+	if wal == nil {
+		return nil
+	}
+	wal.stopOnce.Do(func() { close(wal.stop) })
+	wal.wg.Wait()
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if wal.err == os.ErrClosed {
+		return nil
+	}
+	err := wal.file.Sync()
+	if closeErr := wal.file.Close(); err == nil {
+		err = closeErr
+	}
+	wal.err = os.ErrClosed
+	return err
+}
+
+// append writes one record, undoing a partial write when it fails
+func (wal *writeAheadLog) append(op string, messages ...*models.Message) error {
+	payload, err := json.Marshal(walRecord{Op: op, Messages: messages})
+	if err != nil {
+		return err
+	}
+	record := make([]byte, recordHeader+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[recordHeader:], payload)
+
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if wal.err != nil {
+		return wal.err
+	}
+	if _, err := wal.file.Write(record); err != nil {
+		wal.undo()
+		return err
+	}
+	if wal.opts.Sync == SyncAlways {
+		if err := wal.file.Sync(); err != nil {
+			wal.undo()
+			return err
+		}
+	}
+	wal.size += int64(len(record))
+	wal.records++
+	wal.dirty = true
+	return nil
+}
+
+// undo cuts the file back to the last whole record, or stops further
+// writes when even that fails. The caller must hold mu.
+func (wal *writeAheadLog) undo() {
+	if err := wal.file.Truncate(wal.size); err != nil {
+		wal.err = fmt.Errorf("write-ahead log is unusable: %w", err)
+		return
+	}
+	if _, err := wal.file.Seek(wal.size, io.SeekStart); err != nil {
+		wal.err = fmt.Errorf("write-ahead log is unusable: %w", err)
+	}
+}
+
+// flush syncs records written since the last flush
+func (wal *writeAheadLog) flush() error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if wal.err != nil || !wal.dirty {
+		return nil
+	}
+	wal.dirty = false
+	return wal.file.Sync()
+}
+
+// rotate closes the current file and starts the next generation, which it
+// returns. The caller must hold the storage's write lock.
+func (wal *writeAheadLog) rotate() (uint64, error) {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if wal.err != nil {
+		return 0, wal.err
+	}
+	next := wal.generation + 1
+	file, err := os.OpenFile(wal.path(next), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	if err := wal.file.Sync(); err != nil {
+		file.Close()
+		return 0, err
+	}
+	wal.file.Close()
+	wal.file, wal.generation, wal.size, wal.records, wal.dirty = file, next, 0, 0, false
+	return next, syncDir(wal.dir)
+}
+
+// every runs fn periodically until the storage is closed
+func (wal *writeAheadLog) every(interval time.Duration, fn func()) {
+	wal.wg.Add(1)
+	go func() {
+		defer wal.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-wal.stop:
+				return
+			}
+		}
+	}()
+}
+
+// path returns the file of a log generation
+func (wal *writeAheadLog) path(gen uint64) string {
+	return filepath.Join(wal.dir, fmt.Sprintf("%s%06d%s", walPrefix, gen, walSuffix))
+}
+
+// generations lists the log files in dir, oldest first
+func (wal *writeAheadLog) generations() ([]uint64, error) {
+	entries, err := os.ReadDir(wal.dir)
+	if err != nil {
+		return nil, err
+	}
+	var generations []uint64
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), walPrefix)
+		if !ok || !strings.HasSuffix(name, walSuffix) {
+			continue
+		}
+		if gen, err := strconv.ParseUint(strings.TrimSuffix(name, walSuffix), 10, 64); err == nil {
+			generations = append(generations, gen)
+		}
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i] < generations[j] })
+	return generations, nil
+}
+
+// replayLog applies the records of a log file to messages and returns how
+// many there were and the size they take. A damaged record at the end is
+// what a crash during a write leaves behind, the file is cut before it.
+func replayLog(path string, messages map[int]*models.Message) (int, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	records := 0
+	offset := 0
+	for offset < len(data) {
+		record, next, ok := decodeRecord(data, offset)
+		if !ok {
+			if !zeroed(data[next:]) {
+				return 0, 0, fmt.Errorf("%w: %s at offset %d", ErrCorruptLog, filepath.Base(path), offset)
+			}
+			if err := os.Truncate(path, int64(offset)); err != nil {
+				return 0, 0, err
+			}
+			break
+		}
+		for _, msg := range record.Messages {
+			messages[msg.ID] = msg
+		}
+		records++
+		offset = next
+	}
+	return records, int64(offset), nil
+}
+
+// zeroed reports whether data holds only zero bytes, which is how a file
+// system may leave space it allocated for a write that never completed
+func zeroed(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeRecord reads the record at offset and where the next one starts.
+// A damaged record reports where it claims to end, or the end of data.
+func decodeRecord(data []byte, offset int) (walRecord, int, bool) {
+	var record walRecord
+	if len(data)-offset < recordHeader {
+		return record, len(data), false
+	}
+	length := int(binary.LittleEndian.Uint32(data[offset:]))
+	sum := binary.LittleEndian.Uint32(data[offset+4:])
+	start := offset + recordHeader
+	if length > maxRecordSize || length > len(data)-start {
+		return record, len(data), false
+	}
+	payload := data[start : start+length]
+	if crc32.Checksum(payload, crcTable) != sum || json.Unmarshal(payload, &record) != nil {
+		return record, start + length, false
+	}
+	return record, start + length, true
+}
+
+// readSnapshot reads a snapshot, an empty one if there is none
+func readSnapshot(path string) (snapshot, error) {
+	var snap snapshot
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return snap, nil
+	}
+	if err != nil {
+		return snap, err
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("reading snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+// writeSnapshot replaces the snapshot in dir atomically
+func writeSnapshot(dir string, snap snapshot) error {
+	tmp, err := os.CreateTemp(dir, snapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := json.NewEncoder(tmp).Encode(snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, snapshotFile)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes created, renamed and removed files in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestMemory(t *testing.T, dir string, opts PersistOptions) *MemoryStorage {
+	t.Helper()
+	s, err := OpenMemoryStorage(dir, opts)
+	if err != nil {
+		t.Fatalf("OpenMemoryStorage failed: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// walFiles returns the log files in dir, oldest first
+func walFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// dump encodes every message so two storages can be compared
+func dump(t *testing.T, s MessageStorage) string {
+	t.Helper()
+	data, err := json.Marshal(s.GetAll())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPersistentMemoryStorageConformance(t *testing.T) {
+	testMessageStorage(t, func(t *testing.T) MessageStorage {
+		return openTestMemory(t, t.TempDir(), PersistOptions{})
+	})
+}
+
+func TestMemoryStoragePersists(t *testing.T) {
+	for name, policy := range map[string]SyncPolicy{"always": SyncAlways, "interval": SyncInterval, "never": SyncNever} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openTestMemory(t, dir, PersistOptions{Sync: policy, SyncInterval: 10 * time.Millisecond})
+			root, _ := s.Create("alice", "release notes are ready")
+			s.Update(root.ID, "release notes are done")
+			s.React(root.ID, "bob", "👍")
+			s.React(root.ID, "carol", "🎉")
+			s.Unreact(root.ID, "carol", "🎉")
+			s.CreateReply("bob", "thanks", root.ID)
+			deleted, _ := s.Create("carol", "oops")
+			s.Delete(deleted.ID)
+			s.Atomic(func(tx MessageStorage) error {
+				tx.Create("dave", "first of a batch")
+				_, err := tx.Update(root.ID, "release notes are final")
+				return err
+			})
+			s.Atomic(func(tx MessageStorage) error {
+				tx.Create("eve", "rolled back")
+				return errors.New("abort")
+			})
+			want := dump(t, s)
+			if err := s.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+			if _, err := s.Create("alice", "after close"); err == nil {
+				t.Errorf("Expected changes after Close to fail")
+			}
+
+			s = openTestMemory(t, dir, PersistOptions{Sync: policy})
+			if got := dump(t, s); got != want {
+				t.Errorf("Recovered %s, want %s", got, want)
+			}
+			if results := s.Search("final", 10); len(results) != 1 || results[0].ID != root.ID {
+				t.Errorf("Expected the index to be rebuilt, got %v", results)
+			}
+			if results := s.Search("oops", 10); len(results) != 0 {
+				t.Errorf("Expected deleted messages to stay out of the index, got %v", results)
+			}
+			next, _ := s.Create("frank", "after restart")
+			if next.ID != 5 {
+				t.Errorf("Expected ID 5 after restart, got %d", next.ID)
+			}
+		})
+	}
+}
+
+func TestMemoryStorageRecoversTruncatedRecord(t *testing.T) {
+	// damage spoils the last record, which starts at start and ends at end
+	for name, damage := range map[string]func(path string, start, end int64) error{
+		"cut short": func(path string, start, end int64) error {
+			return os.Truncate(path, end-5)
+		},
+		"header only": func(path string, start, end int64) error {
+			return os.Truncate(path, start+recordHeader/2)
+		},
+		"zero filled": func(path string, start, end int64) error {
+			f, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = f.WriteAt(make([]byte, 64), start+recordHeader+2)
+			return err
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openTestMemory(t, dir, PersistOptions{})
+			first, _ := s.Create("alice", "kept")
+			s.Create("bob", "also kept")
+			want := dump(t, s)
+			path := walFiles(t, dir)[0]
+			before, _ := os.Stat(path)
+			s.Update(first.ID, "lost in the crash")
+			after, _ := os.Stat(path)
+
+			// Crash: the last record is only partly on disk
+			if err := damage(path, before.Size(), after.Size()); err != nil {
+				t.Fatal(err)
+			}
+
+			s = openTestMemory(t, dir, PersistOptions{})
+			if got := dump(t, s); got != want {
+				t.Errorf("Recovered %s, want %s", got, want)
+			}
+			// The damaged tail is cut off, so new records follow whole ones
+			s.Update(first.ID, "written after recovery")
+			third, _ := s.Create("carol", "new")
+			if third.ID != 3 {
+				t.Errorf("Expected ID 3, got %d", third.ID)
+			}
+			want = dump(t, s)
+			s.Close()
+
+			s = openTestMemory(t, dir, PersistOptions{})
+			if got := dump(t, s); got != want {
+				t.Errorf("Recovered %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestMemoryStorageRejectsCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestMemory(t, dir, PersistOptions{})
+	s.Create("alice", "one")
+	s.Create("bob", "two")
+	s.Close()
+
+	// Damage the first record, a whole one follows it
+	path := walFiles(t, dir)[0]
+	data, _ := os.ReadFile(path)
+	data[recordHeader+2] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+
+	if _, err := OpenMemoryStorage(dir, PersistOptions{}); !errors.Is(err, ErrCorruptLog) {
+		t.Errorf("Expected ErrCorruptLog, got %v", err)
+	}
+}
+
+func TestMemoryStorageSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestMemory(t, dir, PersistOptions{})
+	root, _ := s.Create("alice", "hello")
+	s.React(root.ID, "bob", "👍")
+	if err := s.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if files := walFiles(t, dir); len(files) != 1 || filepath.Base(files[0]) != "wal-000001.log" {
+		t.Errorf("Expected the log to move to generation 1, got %v", files)
+	}
+	s.Update(root.ID, "hello again")
+	s.Create("bob", "after the snapshot")
+	want := dump(t, s)
+	s.Close()
+
+	s = openTestMemory(t, dir, PersistOptions{})
+	if got := dump(t, s); got != want {
+		t.Errorf("Recovered %s, want %s", got, want)
+	}
+
+	// Periodic snapshots compact the log in the background
+	s.Close()
+	s = openTestMemory(t, dir, PersistOptions{SnapshotInterval: 10 * time.Millisecond})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if files := walFiles(t, dir); len(files) == 1 && filepath.Base(files[0]) == "wal-000002.log" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a periodic snapshot, got %v", walFiles(t, dir))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Close()
+	snap, err := readSnapshot(filepath.Join(dir, snapshotFile))
+	if err != nil || snap.Generation != 2 || snap.NextID != 3 || len(snap.Messages) != 2 {
+		t.Errorf("Unexpected snapshot %+v %v", snap, err)
+	}
+	s = openTestMemory(t, dir, PersistOptions{})
+	if got := dump(t, s); got != want {
+		t.Errorf("Recovered %s, want %s", got, want)
+	}
+}
+
+func TestMemoryStorageRecoversInterruptedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestMemory(t, dir, PersistOptions{})
+	s.Create("alice", "before")
+	s.Snapshot()
+	s.Create("bob", "between")
+	// A crash after the log moved on but before the snapshot was written
+	// leaves the older log and snapshot in place
+	s.mutex.Lock()
+	_, err := s.log.rotate()
+	s.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Create("carol", "after")
+	want := dump(t, s)
+	s.Close()
+
+	s = openTestMemory(t, dir, PersistOptions{})
+	if got := dump(t, s); got != want {
+		t.Errorf("Recovered %s, want %s", got, want)
+	}
+}
@@ -1,8 +1,6 @@
 module lab06-backend
 
-go 1.23.0
-
-toolchain go1.23.1
+go 1.24
 
 // Protocol buffer generation:
 // protoc --go_out=. --go-grpc_out=. proto/calculator.proto
@@ -20,3 +18,9 @@ require (
 	golang.org/x/text v0.23.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250324211829-b45e905df463 // indirect
 )
+
+require lab02 v0.0.0
+
+// Content moderation is shared with the lab02 chat backend. lab02 declares
+// go 1.24, so this module and its CI need at least that version too.
+replace lab02 => ../../lab02/backend
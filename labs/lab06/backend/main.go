@@ -1,10 +1,13 @@
 package main
 
 import (
+	"crypto/rand"
 	"log"
 	"net"
 	"net/http"
+	"os"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc"
 
@@ -12,6 +15,9 @@ import (
 	"lab06-backend/gateway"
 	pb "lab06-backend/proto"
 	wsService "lab06-backend/websocket"
+
+	"lab02/blob"
+	"lab02/moderation"
 )
 
 func main() {
@@ -85,17 +91,27 @@ func startGatewayService() {
 // startWebSocketService starts the WebSocket service
 func startWebSocketService() {
 	wsServiceInstance := wsService.NewService()
+	wsServiceInstance.SetModerator(moderation.NewPipeline(
+		moderation.MaxLength(1000),
+		moderation.NewSpamFilter(time.Minute, 30, 3),
+	), os.Getenv("ADMIN_TOKEN"))
+	blobs, signer := newBlobStore()
+	wsServiceInstance.SetAttachments(blobs, signer, blob.DefaultLimits)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", wsServiceInstance.GetHandler())
 	mux.HandleFunc("/stats", wsServiceInstance.GetStatsHandler())
+	mux.HandleFunc("/presence", wsServiceInstance.GetPresenceHandler())
+	mux.HandleFunc("/moderation/reviews", wsServiceInstance.GetModerationHandler())
+	mux.HandleFunc("/attachments", wsServiceInstance.GetAttachmentHandler())
+	mux.HandleFunc("/attachments/", wsServiceInstance.GetAttachmentHandler())
 
 	// Add CORS middleware
 	corsHandler := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 			if r.Method == "OPTIONS" {
 				w.WriteHeader(http.StatusOK)
@@ -116,3 +132,25 @@ func startWebSocketService() {
 		log.Fatalf("Failed to serve WebSocket service: %v", err)
 	}
 }
+
+// newBlobStore opens the attachment store in ATTACHMENTS_DIR (default
+// "attachments"). Download URLs are signed with ATTACHMENT_KEY, or with a
+// random key that invalidates old links on restart.
+func newBlobStore() (*blob.Store, *blob.Signer) {
+	dir := os.Getenv("ATTACHMENTS_DIR")
+	if dir == "" {
+		dir = "attachments"
+	}
+	store, err := blob.Open(dir, blob.DefaultLimits)
+	if err != nil {
+		log.Fatalf("Failed to open attachment store: %v", err)
+	}
+	key := []byte(os.Getenv("ATTACHMENT_KEY"))
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("Failed to generate attachment key: %v", err)
+		}
+	}
+	return store, blob.NewSigner(key)
+}
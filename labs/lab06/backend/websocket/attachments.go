@@ -0,0 +1,154 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"lab02/blob"
+)
+
+// attachmentsPath is where GetAttachmentHandler is mounted, signed URLs point below it
+const attachmentsPath = "/attachments"
+
+// maxAttachments is the most attachments a single chat message may carry
+const maxAttachments = 10
+
+// Attachment is a file attached to a chat message. Clients send only the ID
+// returned by the upload endpoint, the hub fills in the rest including
+// signed download URLs that stop working after URLExpiresAt.
+type Attachment struct {
+	blob.Attachment
+	URL          string     `json:"url,omitempty"`
+	ThumbnailURL string     `json:"thumbnail_url,omitempty"`
+	URLExpiresAt *time.Time `json:"url_expires_at,omitempty"`
+}
+
+// SetAttachments enables file uploads and attachments on chat messages.
+// It must be called before clients connect.
+func (s *Service) SetAttachments(store *blob.Store, signer *blob.Signer, limits blob.Limits) {
+	s.hub.blobs = store
+	s.hub.signer = signer
+	s.blobLimits = limits
+}
+
+// GetAttachmentHandler returns handler for attachments, to be mounted at
+// /attachments and /attachments/: POST uploads a file in the multipart field "file" and GET
+// /attachments/{id}?expires=&sig= downloads one through a signed URL
+func (s *Service) GetAttachmentHandler() http.HandlerFunc {
+	return s.handleAttachments
+}
+
+// handleAttachments serves uploads and signed downloads
+func (s *Service) handleAttachments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeError := func(status int, message string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": message})
+	}
+
+	if s.hub.blobs == nil {
+		writeError(http.StatusNotFound, "attachments are not enabled")
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		att, err := s.upload(w, r)
+		if err != nil {
+			status := http.StatusBadRequest
+			var maxBytes *http.MaxBytesError
+			switch {
+			case errors.Is(err, blob.ErrTooLarge), errors.As(err, &maxBytes):
+				status = http.StatusRequestEntityTooLarge
+			case errors.Is(err, blob.ErrTypeNotAllowed):
+				status = http.StatusUnsupportedMediaType
+			}
+			writeError(status, err.Error())
+			return
+		}
+		log.Printf("📎 Attachment %s uploaded (%s, %d bytes)", att.ID, att.MIMEType, att.Size)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(s.hub.link(Attachment{Attachment: att}))
+	case http.MethodGet:
+		id := strings.TrimPrefix(r.URL.Path, attachmentsPath+"/")
+		if err := s.hub.signer.Verify(id, r.URL.Query()); err != nil {
+			writeError(http.StatusForbidden, err.Error())
+			return
+		}
+		if err := s.hub.blobs.Serve(w, r, id); err != nil {
+			if err == blob.ErrNotFound {
+				writeError(http.StatusNotFound, err.Error())
+				return
+			}
+			writeError(http.StatusInternalServerError, "failed to read attachment")
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// upload stores the multipart field "file" of the request
+func (s *Service) upload(w http.ResponseWriter, r *http.Request) (blob.Attachment, error) {
+	if s.blobLimits.MaxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.blobLimits.MaxSize+64<<10)
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return blob.Attachment{}, errors.New("expected a multipart/form-data body")
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return blob.Attachment{}, errors.New("file is required")
+		}
+		if err != nil {
+			return blob.Attachment{}, err
+		}
+		if part.FormName() == "file" {
+			defer part.Close()
+			return s.hub.blobs.Put(part, part.FileName())
+		}
+		part.Close()
+	}
+}
+
+// attach replaces the attachment IDs sent by a client with the stored
+// attachments and their download URLs
+func (h *Hub) attach(message *Message) error {
+	if len(message.Attachments) == 0 {
+		return nil
+	}
+	if h.blobs == nil {
+		return errors.New("attachments are not enabled")
+	}
+	if len(message.Attachments) > maxAttachments {
+		return errors.New("too many attachments")
+	}
+	attachments := make([]Attachment, len(message.Attachments))
+	for i, ref := range message.Attachments {
+		att, err := h.blobs.Attachment(ref.ID)
+		if err != nil {
+			return errors.New("attachment " + ref.ID + " not found")
+		}
+		attachments[i] = h.link(Attachment{Attachment: att})
+	}
+	message.Attachments = attachments
+	return nil
+}
+
+// link adds signed download URLs to an attachment
+func (h *Hub) link(att Attachment) Attachment {
+	var expires time.Time
+	att.URL, expires = h.signer.URL(attachmentsPath, att.ID)
+	att.URLExpiresAt = &expires
+	if att.ThumbnailID != "" {
+		att.ThumbnailURL, _ = h.signer.URL(attachmentsPath, att.ThumbnailID)
+	}
+	return att
+}
@@ -0,0 +1,99 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"lab02/blob"
+)
+
+func TestWebSocket_Attachments(t *testing.T) {
+	store, err := blob.Open(t.TempDir(), blob.DefaultLimits)
+	if err != nil {
+		t.Fatalf("blob.Open failed: %v", err)
+	}
+	service := NewService()
+	service.SetAttachments(store, blob.NewSigner([]byte("secret")), blob.DefaultLimits)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", service.GetHandler())
+	mux.HandleFunc("/attachments", service.GetAttachmentHandler())
+	mux.HandleFunc("/attachments/", service.GetAttachmentHandler())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "notes.txt")
+	fw.Write([]byte("meeting notes"))
+	mw.Close()
+	resp, err := http.Post(server.URL+"/attachments", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	var uploaded Attachment
+	json.NewDecoder(resp.Body).Decode(&uploaded)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || uploaded.ID == "" || uploaded.URL == "" {
+		t.Fatalf("Unexpected upload %d %+v", resp.StatusCode, uploaded)
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?user_id=alice"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	defer conn.Close()
+	readType := func(msgType string) Message {
+		t.Helper()
+		for {
+			var msg Message
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("Did not receive a %s message: %v", msgType, err)
+			}
+			if msg.Type == msgType {
+				return msg
+			}
+		}
+	}
+
+	conn.WriteJSON(map[string]interface{}{"type": "message", "attachments": []map[string]string{{"id": "missing"}}})
+	if msg := readType("error"); !strings.Contains(msg.Content, "not found") {
+		t.Errorf("Unexpected error %+v", msg)
+	}
+
+	conn.WriteJSON(map[string]interface{}{"type": "message", "content": "see file", "attachments": []map[string]string{{"id": uploaded.ID}}})
+	msg := readType("message")
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Name != "notes.txt" || msg.Attachments[0].URL == "" {
+		t.Fatalf("Unexpected attachments %+v", msg.Attachments)
+	}
+
+	resp, err = http.Get(server.URL + msg.Attachments[0].URL)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "meeting notes" {
+		t.Errorf("Unexpected download %d %q", resp.StatusCode, data)
+	}
+
+	resp, err = http.Get(server.URL + "/attachments/" + uploaded.ID)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status 403 without a signature, got %d", resp.StatusCode)
+	}
+}
@@ -1,13 +1,20 @@
 package websocket
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"lab02/blob"
+	"lab02/moderation"
 )
 
 var upgrader = websocket.Upgrader{
@@ -18,11 +25,48 @@ var upgrader = websocket.Upgrader{
 
 // Message represents a WebSocket message
 type Message struct {
-	Type      string    `json:"type"`
-	Content   string    `json:"content"`
-	User      string    `json:"user"`
-	Timestamp time.Time `json:"timestamp"`
-	Delay     int       `json:"delay,omitempty"` // Delay in milliseconds for testing
+	Type      string              `json:"type"`
+	Content   string              `json:"content"`
+	User      string              `json:"user"`
+	Timestamp time.Time           `json:"timestamp"`
+	Delay     int                 `json:"delay,omitempty"`  // Delay in milliseconds for testing
+	ID        string              `json:"id,omitempty"`     // Assigned by the hub to chat messages
+	RefID     string              `json:"ref_id,omitempty"` // Message a read receipt, edit, delete or reaction refers to
+	Status    string              `json:"status,omitempty"` // Presence status: online, away or offline
+	LastSeen  *time.Time          `json:"last_seen,omitempty"`
+	ParentID  string              `json:"parent_id,omitempty"` // Message this one replies to
+	EditedAt  *time.Time          `json:"edited_at,omitempty"`
+	Deleted   bool                `json:"deleted,omitempty"`
+	Reactions map[string][]string `json:"reactions,omitempty"` // Emoji to the users that reacted with it
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	flagged   []string // moderation reasons, the hub queues the message or edit for review once it is applied
+	moderated bool     // deletion requested by a moderator rather than the author
+}
+
+// Presence statuses
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+// maxTrackedMessages bounds the number of chat messages kept for edits,
+// reactions and read receipts
+const maxTrackedMessages = 1000
+
+// Presence is the last known state of a user
+type Presence struct {
+	User     string    `json:"user"`
+	Status   string    `json:"status"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// ReadReceipt records that a user has read a message
+type ReadReceipt struct {
+	User   string    `json:"user"`
+	ReadAt time.Time `json:"read_at"`
 }
 
 // Client represents a WebSocket client connection
@@ -42,11 +86,25 @@ type Hub struct {
 	register   chan *Client
 	unregister chan *Client
 	mutex      sync.RWMutex
+
+	// Presence, recent messages and read receipts, created when the hub starts
+	presence     map[string]Presence
+	messages     map[string]*Message
+	receipts     map[string][]ReadReceipt
+	messageOrder []string // oldest tracked message first
+	lastID       uint64
+
+	moderator *moderation.Pipeline // nil disables moderation
+
+	blobs  *blob.Store // nil disables attachments
+	signer *blob.Signer
 }
 
 // Service represents the WebSocket service
 type Service struct {
-	hub *Hub
+	hub        *Hub
+	adminToken string
+	blobLimits blob.Limits
 }
 
 // NewService creates a new WebSocket service
@@ -74,9 +132,38 @@ func (s *Service) GetStatsHandler() http.HandlerFunc {
 	return s.handleStats
 }
 
+// SetModerator runs chat messages and edits through the pipeline and enables
+// the moderation handler, which requires "Authorization: Bearer <adminToken>".
+// It must be called before clients connect.
+func (s *Service) SetModerator(p *moderation.Pipeline, adminToken string) {
+	s.hub.moderator = p
+	s.adminToken = adminToken
+}
+
+// GetModerationHandler returns handler for the review queue: GET lists flagged
+// messages (optionally ?status=), POST ?id= resolves one with a JSON body of
+// {"status": "approved"|"removed", "reviewer": "..."}
+func (s *Service) GetModerationHandler() http.HandlerFunc {
+	return s.handleModeration
+}
+
+// GetPresenceHandler returns handler listing user presence, optionally for a
+// single user_id
+func (s *Service) GetPresenceHandler() http.HandlerFunc {
+	return s.handlePresence
+}
+
 // run starts the hub's main event loop
 func (h *Hub) run() {
 	log.Printf("🏢 Hub event loop started")
+	h.mutex.Lock()
+	if h.presence == nil {
+		h.presence = make(map[string]Presence)
+		h.messages = make(map[string]*Message)
+		h.receipts = make(map[string][]ReadReceipt)
+	}
+	h.mutex.Unlock()
+
 	for {
 		select {
 		case client := <-h.register:
@@ -115,6 +202,7 @@ func (h *Hub) run() {
 			}
 			log.Printf("📢 Notifying other clients that %s joined", client.userID)
 			h.broadcastToOthers(notification, client)
+			h.broadcastToOthers(h.setPresence(client.userID, StatusOnline), client)
 
 		case client := <-h.unregister:
 			h.mutex.Lock()
@@ -135,12 +223,19 @@ func (h *Hub) run() {
 				}
 				log.Printf("📢 Notifying other clients that %s left", client.userID)
 				h.broadcastToOthers(notification, client)
+				if !h.isConnected(client.userID) {
+					h.broadcastToOthers(h.setPresence(client.userID, StatusOffline), client)
+				}
 			} else {
 				h.mutex.Unlock()
 				log.Printf("⚠️ Attempted to unregister unknown client: %s", client.userID)
 			}
 
 		case message := <-h.broadcast:
+			if !h.prepare(&message) {
+				continue
+			}
+
 			h.mutex.RLock()
 			clientCount := len(h.clients)
 			h.mutex.RUnlock()
@@ -149,6 +244,11 @@ func (h *Hub) run() {
 
 			h.mutex.RLock()
 			for client := range h.clients {
+				// Status events are only for the other users
+				if (message.Type == "typing" || message.Type == "presence") && client.userID == message.User {
+					continue
+				}
+
 				// Apply artificial delay if specified
 				if message.Delay > 0 {
 					log.Printf("⏱️ Applying %dms delay for message to %s", message.Delay, client.userID)
@@ -181,6 +281,182 @@ func (h *Hub) run() {
 	}
 }
 
+// prepare updates hub state for a message about to be broadcast, it returns
+// false when the message should be dropped
+func (h *Hub) prepare(message *Message) bool {
+	switch message.Type {
+	case "typing":
+		return true
+	case "presence":
+		if message.Status != StatusOnline && message.Status != StatusAway {
+			log.Printf("⚠️ Invalid presence status from %s: %q", message.User, message.Status)
+			return false
+		}
+		*message = h.setPresence(message.User, message.Status)
+		return true
+	case "read":
+		return h.recordReceipt(message.RefID, message.User, message.Timestamp)
+	case "edit", "delete", "react":
+		return h.changeMessage(message)
+	default:
+		return h.trackMessage(message)
+	}
+}
+
+// trackMessage assigns a chat message its ID and remembers it for later
+// edits and reactions, replies to unknown messages are dropped
+func (h *Hub) trackMessage(message *Message) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if message.ParentID != "" {
+		if _, ok := h.messages[message.ParentID]; !ok {
+			log.Printf("⚠️ Reply from %s to unknown message %s", message.User, message.ParentID)
+			return false
+		}
+	}
+	h.lastID++
+	message.ID = strconv.FormatUint(h.lastID, 10)
+	message.EditedAt, message.Deleted, message.Reactions = nil, false, nil
+	if message.flagged != nil {
+		h.moderator.Queue().Add(message.ID, message.User, message.Content, message.flagged)
+	}
+
+	tracked := *message
+	h.messages[message.ID] = &tracked
+	h.messageOrder = append(h.messageOrder, message.ID)
+	if len(h.messageOrder) > maxTrackedMessages {
+		delete(h.messages, h.messageOrder[0])
+		delete(h.receipts, h.messageOrder[0])
+		h.messageOrder = h.messageOrder[1:]
+	}
+	return true
+}
+
+// changeMessage applies an edit, delete or reaction to a tracked message and
+// turns the request into the event broadcast to clients. Only the author can
+// edit or delete, and deleted messages cannot change.
+func (h *Hub) changeMessage(message *Message) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	tracked, ok := h.messages[message.RefID]
+	if !ok || tracked.Deleted {
+		log.Printf("⚠️ %s from %s for unknown message %s", message.Type, message.User, message.RefID)
+		return false
+	}
+
+	// Replace rather than modify the tracked copy, earlier events may still be queued
+	updated := *tracked
+	switch message.Type {
+	case "edit":
+		if tracked.User != message.User || message.Content == "" {
+			return false
+		}
+		now := message.Timestamp
+		updated.Content = message.Content
+		updated.EditedAt = &now
+		message.EditedAt = &now
+		if message.flagged != nil {
+			h.moderator.Queue().Add(message.RefID, message.User, message.Content, message.flagged)
+		}
+	case "delete":
+		if tracked.User != message.User && !message.moderated {
+			return false
+		}
+		updated.Content = ""
+		updated.Deleted = true
+		updated.Reactions = nil
+		updated.Attachments = nil
+		message.Content = ""
+		message.Deleted = true
+	case "react":
+		if message.Content == "" {
+			return false
+		}
+		updated.Reactions = toggleReaction(tracked.Reactions, message.Content, message.User)
+		message.Reactions = updated.Reactions
+	}
+	h.messages[message.RefID] = &updated
+	return true
+}
+
+// toggleReaction adds the user's reaction, or removes it if already present,
+// and returns a new map
+func toggleReaction(reactions map[string][]string, emoji, userID string) map[string][]string {
+	result := make(map[string][]string, len(reactions)+1)
+	for e, users := range reactions {
+		result[e] = users
+	}
+
+	users := make([]string, 0, len(result[emoji])+1)
+	removed := false
+	for _, u := range result[emoji] {
+		if u == userID {
+			removed = true
+			continue
+		}
+		users = append(users, u)
+	}
+	if !removed {
+		users = append(users, userID)
+	}
+	if len(users) == 0 {
+		delete(result, emoji)
+	} else {
+		result[emoji] = users
+	}
+	return result
+}
+
+// setPresence stores a status change and returns the event announcing it
+func (h *Hub) setPresence(userID, status string) Message {
+	now := time.Now()
+	h.mutex.Lock()
+	h.presence[userID] = Presence{User: userID, Status: status, LastSeen: now}
+	h.mutex.Unlock()
+
+	return Message{
+		Type:      "presence",
+		User:      userID,
+		Status:    status,
+		Timestamp: now,
+		LastSeen:  &now,
+	}
+}
+
+// isConnected reports whether the user still has an open connection
+func (h *Hub) isConnected(userID string) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for client := range h.clients {
+		if client.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// recordReceipt stores a read receipt once per reader, it returns false for
+// messages the hub does not know
+func (h *Hub) recordReceipt(messageID, userID string, at time.Time) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.messages[messageID]; !ok {
+		return false
+	}
+	receipts := h.receipts[messageID]
+	for _, r := range receipts {
+		if r.User == userID {
+			return true
+		}
+	}
+	h.receipts[messageID] = append(receipts, ReadReceipt{User: userID, ReadAt: at})
+	return true
+}
+
 // broadcastToOthers sends a message to all clients except the specified one
 func (h *Hub) broadcastToOthers(message Message, sender *Client) {
 	h.mutex.RLock()
@@ -263,6 +539,77 @@ func (s *Service) handleStats(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stats)
 }
 
+// handleModeration serves the review queue to moderators
+func (s *Service) handleModeration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if s.hub.moderator == nil || s.adminToken == "" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "moderation is not enabled"})
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid admin token"})
+		return
+	}
+
+	queue := s.hub.moderator.Queue()
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(queue.List(moderation.ReviewStatus(r.URL.Query().Get("status"))))
+	case http.MethodPost:
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		var decision struct {
+			Status   string `json:"status"`
+			Reviewer string `json:"reviewer"`
+		}
+		if err == nil {
+			err = json.NewDecoder(r.Body).Decode(&decision)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid review decision"})
+			return
+		}
+
+		item, err := queue.Resolve(id, moderation.ReviewStatus(decision.Status), decision.Reviewer)
+		if err != nil {
+			status := http.StatusBadRequest
+			switch err {
+			case moderation.ErrReviewNotFound:
+				status = http.StatusNotFound
+			case moderation.ErrAlreadyReviewed:
+				status = http.StatusConflict
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if item.Status == moderation.StatusRemoved {
+			log.Printf("🧹 Moderator %s removed message %s", item.Reviewer, item.Ref)
+			s.hub.broadcast <- Message{Type: "delete", User: "system", RefID: item.Ref, Timestamp: time.Now(), moderated: true}
+		}
+		json.NewEncoder(w).Encode(item)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePresence returns the presence of all known users or of one user
+func (s *Service) handlePresence(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		json.NewEncoder(w).Encode(s.GetPresence(userID))
+		return
+	}
+	json.NewEncoder(w).Encode(s.PresenceList())
+}
+
 // readPump reads messages from the WebSocket connection
 func (c *Client) readPump() {
 	log.Printf("📖 ReadPump started for client: %s", c.userID)
@@ -303,6 +650,24 @@ func (c *Client) readPump() {
 			message.Type = "message"
 		}
 
+		// Moderate anything that carries chat content
+		if (message.Type == "message" || message.Type == "edit") && !c.moderate(&message) {
+			continue
+		}
+
+		// Only chat messages carry attachments, resolved from their uploaded IDs
+		if message.Type != "message" {
+			message.Attachments = nil
+		}
+		if err := c.hub.attach(&message); err != nil {
+			log.Printf("⚠️ Attachments from %s refused: %v", c.userID, err)
+			select {
+			case c.send <- Message{Type: "error", Content: err.Error(), User: "system", Timestamp: time.Now()}:
+			default:
+			}
+			continue
+		}
+
 		// Handle different message types
 		switch message.Type {
 		case "ping":
@@ -329,6 +694,29 @@ func (c *Client) readPump() {
 	}
 }
 
+// moderate runs a chat message or edit through the hub's moderation pipeline.
+// Rejected messages are answered with an error message and return false.
+func (c *Client) moderate(message *Message) bool {
+	if c.hub.moderator == nil {
+		return true
+	}
+	verdict := c.hub.moderator.Check(moderation.Message{UserID: c.userID, Content: message.Content, At: message.Timestamp})
+	switch verdict.Action {
+	case moderation.Reject:
+		reason := (&moderation.RejectedError{Reasons: verdict.Reasons}).Error()
+		log.Printf("🚫 Message from %s rejected: %s", c.userID, reason)
+		select {
+		case c.send <- Message{Type: "error", Content: reason, User: "system", Timestamp: time.Now()}:
+		default:
+		}
+		return false
+	case moderation.Flag:
+		message.flagged = verdict.Reasons
+	}
+	message.Content = verdict.Content
+	return true
+}
+
 // writePump writes messages to the WebSocket connection
 func (c *Client) writePump() {
 	log.Printf("✍️ WritePump started for client: %s", c.userID)
@@ -377,6 +765,48 @@ func (s *Service) GetConnectedClients() int {
 	return len(s.hub.clients)
 }
 
+// GetPresence returns the presence of a user, unknown users are offline
+func (s *Service) GetPresence(userID string) Presence {
+	s.hub.mutex.RLock()
+	defer s.hub.mutex.RUnlock()
+
+	if p, ok := s.hub.presence[userID]; ok {
+		return p
+	}
+	return Presence{User: userID, Status: StatusOffline}
+}
+
+// PresenceList returns the presence of every known user sorted by name
+func (s *Service) PresenceList() []Presence {
+	s.hub.mutex.RLock()
+	defer s.hub.mutex.RUnlock()
+
+	list := make([]Presence, 0, len(s.hub.presence))
+	for _, p := range s.hub.presence {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].User < list[j].User })
+	return list
+}
+
+// GetMessage returns the current state of a recent chat message
+func (s *Service) GetMessage(messageID string) (Message, bool) {
+	s.hub.mutex.RLock()
+	defer s.hub.mutex.RUnlock()
+
+	if msg, ok := s.hub.messages[messageID]; ok {
+		return *msg, true
+	}
+	return Message{}, false
+}
+
+// ReadReceipts lists who has read a message, in the order they read it
+func (s *Service) ReadReceipts(messageID string) []ReadReceipt {
+	s.hub.mutex.RLock()
+	defer s.hub.mutex.RUnlock()
+	return append([]ReadReceipt(nil), s.hub.receipts[messageID]...)
+}
+
 // BroadcastMessage sends a message to all connected clients
 func (s *Service) BroadcastMessage(message Message) {
 	message.Timestamp = time.Now()
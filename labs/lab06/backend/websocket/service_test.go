@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"lab02/moderation"
 )
 
 func TestService_NewService(t *testing.T) {
@@ -355,3 +357,233 @@ func TestWebSocket_PingPong(t *testing.T) {
 		t.Error("Did not receive pong response to ping")
 	}
 }
+
+func TestHub_PresenceTypingAndReceipts(t *testing.T) {
+	hub := &Hub{
+		clients:    make(map[*Client]bool),
+		broadcast:  make(chan Message),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+	}
+	service := &Service{hub: hub}
+
+	go hub.run()
+
+	alice := &Client{send: make(chan Message, 10), hub: hub, userID: "alice"}
+	bob := &Client{send: make(chan Message, 10), hub: hub, userID: "bob"}
+	hub.register <- alice
+	hub.register <- bob
+	time.Sleep(50 * time.Millisecond)
+
+	next := func(c *Client, msgType string) Message {
+		t.Helper()
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case msg := <-c.send:
+				if msg.Type == msgType {
+					return msg
+				}
+			case <-timeout:
+				t.Fatalf("%s did not receive a %s message", c.userID, msgType)
+				return Message{}
+			}
+		}
+	}
+
+	if msg := next(alice, "presence"); msg.User != "bob" || msg.Status != StatusOnline {
+		t.Errorf("Expected bob online, got %+v", msg)
+	}
+	if p := service.GetPresence("alice"); p.Status != StatusOnline {
+		t.Errorf("Expected alice online, got %+v", p)
+	}
+
+	// Typing goes to everyone but the typist
+	hub.broadcast <- Message{Type: "typing", User: "alice", Timestamp: time.Now()}
+	if msg := next(bob, "typing"); msg.User != "alice" {
+		t.Errorf("Expected alice typing, got %+v", msg)
+	}
+
+	hub.broadcast <- Message{Type: "presence", User: "alice", Status: "busy"}
+	hub.broadcast <- Message{Type: "presence", User: "alice", Status: StatusAway}
+	if msg := next(bob, "presence"); msg.Status != StatusAway || msg.LastSeen == nil {
+		t.Errorf("Expected alice away, got %+v", msg)
+	}
+
+	// Chat messages get an ID that read receipts refer to
+	hub.broadcast <- Message{Type: "message", User: "alice", Content: "hi", Timestamp: time.Now()}
+	chat := next(bob, "message")
+	if chat.ID == "" {
+		t.Fatal("Chat message has no ID")
+	}
+	hub.broadcast <- Message{Type: "read", User: "bob", RefID: chat.ID, Timestamp: time.Now()}
+	hub.broadcast <- Message{Type: "read", User: "bob", RefID: chat.ID, Timestamp: time.Now()}
+	if msg := next(alice, "read"); msg.RefID != chat.ID || msg.User != "bob" {
+		t.Errorf("Unexpected receipt %+v", msg)
+	}
+	next(alice, "read")
+	if receipts := service.ReadReceipts(chat.ID); len(receipts) != 1 || receipts[0].User != "bob" {
+		t.Errorf("Unexpected receipts %+v", receipts)
+	}
+
+	hub.unregister <- bob
+	if msg := next(alice, "presence"); msg.User != "bob" || msg.Status != StatusOffline {
+		t.Errorf("Expected bob offline, got %+v", msg)
+	}
+
+	req := httptest.NewRequest("GET", "/presence", nil)
+	rr := httptest.NewRecorder()
+	service.GetPresenceHandler().ServeHTTP(rr, req)
+	var list []Presence
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode presence: %v", err)
+	}
+	if len(list) != 2 || list[0].User != "alice" || list[0].Status != StatusAway || list[1].Status != StatusOffline {
+		t.Errorf("Unexpected presence list %+v", list)
+	}
+}
+
+func TestHub_EditDeleteReactAndReply(t *testing.T) {
+	hub := &Hub{
+		clients:    make(map[*Client]bool),
+		broadcast:  make(chan Message),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+	}
+	service := &Service{hub: hub}
+
+	go hub.run()
+
+	client := &Client{send: make(chan Message, 10), hub: hub, userID: "alice"}
+	hub.register <- client
+
+	next := func(msgType string) Message {
+		t.Helper()
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case msg := <-client.send:
+				if msg.Type == msgType {
+					return msg
+				}
+			case <-timeout:
+				t.Fatalf("Did not receive a %s message", msgType)
+				return Message{}
+			}
+		}
+	}
+
+	hub.broadcast <- Message{Type: "message", User: "alice", Content: "helo", Timestamp: time.Now()}
+	original := next("message")
+
+	// Only the author may edit
+	hub.broadcast <- Message{Type: "edit", User: "bob", RefID: original.ID, Content: "hijacked", Timestamp: time.Now()}
+	hub.broadcast <- Message{Type: "edit", User: "alice", RefID: original.ID, Content: "hello", Timestamp: time.Now()}
+	if msg := next("edit"); msg.Content != "hello" || msg.EditedAt == nil {
+		t.Errorf("Unexpected edit event %+v", msg)
+	}
+
+	hub.broadcast <- Message{Type: "react", User: "bob", RefID: original.ID, Content: "👍", Timestamp: time.Now()}
+	if msg := next("react"); len(msg.Reactions["👍"]) != 1 {
+		t.Errorf("Unexpected reaction event %+v", msg)
+	}
+	hub.broadcast <- Message{Type: "react", User: "bob", RefID: original.ID, Content: "👍", Timestamp: time.Now()}
+	if msg := next("react"); len(msg.Reactions) != 0 {
+		t.Errorf("Expected reaction to be toggled off, got %+v", msg.Reactions)
+	}
+
+	hub.broadcast <- Message{Type: "message", User: "bob", Content: "yes", ParentID: original.ID, Timestamp: time.Now()}
+	if msg := next("message"); msg.ParentID != original.ID {
+		t.Errorf("Expected reply to %s, got %+v", original.ID, msg)
+	}
+
+	hub.broadcast <- Message{Type: "delete", User: "alice", RefID: original.ID, Timestamp: time.Now()}
+	if msg := next("delete"); !msg.Deleted || msg.RefID != original.ID {
+		t.Errorf("Unexpected delete event %+v", msg)
+	}
+	if msg, ok := service.GetMessage(original.ID); !ok || !msg.Deleted || msg.Content != "" {
+		t.Errorf("Expected tombstone, got %+v", msg)
+	}
+}
+
+func TestWebSocket_Moderation(t *testing.T) {
+	service := NewService()
+	service.SetModerator(moderation.NewPipeline(
+		moderation.WordList(moderation.Reject, "spam"),
+		moderation.WordList(moderation.Flag, "refund"),
+	), "secret")
+
+	server := httptest.NewServer(http.HandlerFunc(service.handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "?user_id=alice"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	defer conn.Close()
+
+	readType := func(msgType string) Message {
+		t.Helper()
+		for {
+			var msg Message
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("Did not receive a %s message: %v", msgType, err)
+			}
+			if msg.Type == msgType {
+				return msg
+			}
+		}
+	}
+
+	conn.WriteJSON(Message{Type: "message", Content: "buy spam now"})
+	if msg := readType("error"); !strings.Contains(msg.Content, "blocked word") {
+		t.Errorf("Unexpected rejection %+v", msg)
+	}
+
+	conn.WriteJSON(Message{Type: "message", Content: "refund please"})
+	flagged := readType("message")
+	pending := service.hub.moderator.Queue().List(moderation.StatusPending)
+	if len(pending) != 1 || pending[0].Ref != flagged.ID {
+		t.Fatalf("Expected message %s in review queue, got %+v", flagged.ID, pending)
+	}
+
+	handler := service.GetModerationHandler()
+	req := httptest.NewRequest("POST", "/moderation/reviews?id=1", strings.NewReader(`{"status":"removed","reviewer":"mod"}`))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without token, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/moderation/reviews?id=1", strings.NewReader(`{"status":"removed","reviewer":"mod"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if msg := readType("delete"); msg.RefID != flagged.ID {
+		t.Errorf("Expected removal of %s, got %+v", flagged.ID, msg)
+	}
+
+	// Flagged edits are queued only once the hub has applied them
+	bob, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?user_id=bob", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	defer bob.Close()
+	conn.WriteJSON(Message{Type: "message", Content: "hello"})
+	original := readType("message")
+	bob.WriteJSON(Message{Type: "edit", RefID: original.ID, Content: "refund me"})
+	bob.WriteJSON(Message{Type: "edit", RefID: "999", Content: "refund me"})
+	bob.WriteJSON(Message{Type: "typing"})
+	readType("typing") // bob's edits have reached the hub
+	conn.WriteJSON(Message{Type: "edit", RefID: original.ID, Content: "refund soon"})
+	readType("edit")
+	pending = service.hub.moderator.Queue().List(moderation.StatusPending)
+	if len(pending) != 1 || pending[0].Ref != original.ID || pending[0].UserID != "alice" {
+		t.Errorf("Expected only alice's edit in review queue, got %+v", pending)
+	}
+}